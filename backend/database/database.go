@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 
 	_ "modernc.org/sqlite"
@@ -38,6 +39,27 @@ func Close() {
 	}
 }
 
+// workoutAnalysesColumns define workout_analyses. Cada análisis de un workout es una versión
+// nueva; las preguntas de seguimiento (kind follow_up) cuelgan del análisis al que responden.
+const workoutAnalysesColumns = `(
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			workout_id INTEGER REFERENCES workouts(id),
+			extraction_id INTEGER REFERENCES workout_extractions(id),
+			parent_id INTEGER REFERENCES workout_analyses(id),
+			kind TEXT NOT NULL DEFAULT 'analysis',
+			source TEXT NOT NULL DEFAULT 'workout',
+			version INTEGER NOT NULL DEFAULT 1,
+			question TEXT,
+			analysis TEXT NOT NULL,
+			recommendations TEXT,
+			model TEXT,
+			prompt_version TEXT,
+			input_snapshot TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`
+
 // createTables crea las tablas necesarias
 func createTables() error {
 	queries := []string{
@@ -46,6 +68,10 @@ func createTables() error {
 			name TEXT NOT NULL,
 			email TEXT UNIQUE NOT NULL,
 			password_hash TEXT NOT NULL,
+			locale TEXT,
+			email_verified_at DATETIME,
+			role TEXT NOT NULL DEFAULT 'user',
+			disabled_at DATETIME,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		)`,
@@ -61,10 +87,31 @@ func createTables() error {
 			race_goal_date DATE,
 			training_level TEXT DEFAULT 'intermediate',
 			fitness_level TEXT,
+			sex TEXT,
+			resting_hr INTEGER,
+			max_hr INTEGER,
+			lthr INTEGER,
+			threshold_pace TEXT,
+			threshold_power INTEGER,
+			hr_zones TEXT,
+			goals TEXT,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 		)`,
+		`CREATE TABLE IF NOT EXISTS runner_profile_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			weight REAL,
+			resting_hr INTEGER,
+			max_hr INTEGER,
+			lthr INTEGER,
+			vo2max REAL,
+			threshold_pace TEXT,
+			threshold_power INTEGER,
+			recorded_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
 		`CREATE TABLE IF NOT EXISTS workouts (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			user_id INTEGER NOT NULL,
@@ -82,8 +129,9 @@ func createTables() error {
 			feeling TEXT,
 			strava_activity_id INTEGER UNIQUE,
 			strava_data TEXT,
+			gear_id INTEGER REFERENCES gear(id),
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (user_id) REFERENCES users(id)
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 		)`,
 		`CREATE TABLE IF NOT EXISTS training_plans (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -93,25 +141,24 @@ func createTables() error {
 			end_date DATETIME NOT NULL,
 			plan TEXT NOT NULL,
 			status TEXT DEFAULT 'active',
+			race_id INTEGER REFERENCES races(id),
+			blocks TEXT,
+			model TEXT,
+			prompt_version TEXT,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (user_id) REFERENCES users(id)
-		)`,
-		`CREATE TABLE IF NOT EXISTS workout_analyses (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			workout_id INTEGER NOT NULL,
-			analysis TEXT NOT NULL,
-			recommendations TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (workout_id) REFERENCES workouts(id)
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 		)`,
+		"CREATE TABLE IF NOT EXISTS workout_analyses " + workoutAnalysesColumns,
 		`CREATE TABLE IF NOT EXISTS progress_reports (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			user_id INTEGER NOT NULL,
 			period_start DATETIME NOT NULL,
 			period_end DATETIME NOT NULL,
 			report TEXT NOT NULL,
+			model TEXT,
+			prompt_version TEXT,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (user_id) REFERENCES users(id)
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 		)`,
 		`CREATE TABLE IF NOT EXISTS strava_tokens (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -121,23 +168,291 @@ func createTables() error {
 			expires_at INTEGER NOT NULL,
 			athlete_id INTEGER,
 			last_sync DATETIME,
+			last_sync_error TEXT,
+			last_sync_error_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS workout_intervals (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			workout_id INTEGER NOT NULL,
+			rep_index INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			source TEXT NOT NULL,
+			start_offset INTEGER,
+			distance REAL,
+			duration INTEGER,
+			avg_pace TEXT,
+			avg_heart_rate INTEGER,
+			max_heart_rate INTEGER,
+			avg_power INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (workout_id) REFERENCES workouts(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS gear (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			brand TEXT NOT NULL,
+			model TEXT NOT NULL,
+			nickname TEXT,
+			start_date DATE NOT NULL,
+			retirement_km REAL NOT NULL DEFAULT 700,
+			initial_km REAL NOT NULL DEFAULT 0,
+			strava_gear_id TEXT,
+			retired INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, strava_gear_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS races (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			race_date DATE NOT NULL,
+			distance_km REAL NOT NULL,
+			priority TEXT NOT NULL DEFAULT 'B',
+			target_time TEXT,
+			course_profile TEXT,
+			location TEXT,
+			status TEXT NOT NULL DEFAULT 'upcoming',
+			result_time TEXT,
+			workout_id INTEGER,
+			notes TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (workout_id) REFERENCES workouts(id) ON DELETE SET NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS workout_streams (
+			workout_id INTEGER PRIMARY KEY,
+			data TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (workout_id) REFERENCES workouts(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS plan_change_proposals (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			plan_id INTEGER,
+			summary TEXT NOT NULL,
+			changes TEXT NOT NULL,
+			effective_date TEXT,
+			status TEXT NOT NULL DEFAULT 'pending',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			resolved_at DATETIME,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (plan_id) REFERENCES training_plans(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS workout_extractions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			data TEXT NOT NULL,
+			notes TEXT,
+			workout_id INTEGER REFERENCES workouts(id),
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS llm_tool_calls (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			step INTEGER NOT NULL,
+			tool TEXT NOT NULL,
+			arguments TEXT,
+			success BOOLEAN NOT NULL,
+			error TEXT,
+			result_chars INTEGER,
+			duration_ms INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			refresh_hash TEXT NOT NULL UNIQUE,
+			previous_hash TEXT,
+			user_agent TEXT,
+			ip TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME NOT NULL,
+			revoked_at DATETIME,
+			impersonator_id INTEGER REFERENCES users(id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS llm_usage (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			operation TEXT NOT NULL,
+			model TEXT NOT NULL,
+			prompt_tokens INTEGER NOT NULL DEFAULT 0,
+			completion_tokens INTEGER NOT NULL DEFAULT 0,
+			total_tokens INTEGER NOT NULL DEFAULT 0,
+			latency_ms INTEGER,
+			error TEXT,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS coach_conversations (
+			user_id INTEGER PRIMARY KEY,
+			summary TEXT,
+			messages TEXT NOT NULL DEFAULT '[]',
+			summarized_turns INTEGER NOT NULL DEFAULT 0,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (user_id) REFERENCES users(id)
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS email_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			purpose TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			email TEXT NOT NULL,
+			expires_at DATETIME NOT NULL,
+			used_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS login_attempts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			email TEXT NOT NULL,
+			user_id INTEGER,
+			ip TEXT,
+			user_agent TEXT,
+			success INTEGER NOT NULL,
+			reason TEXT,
+			created_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_totp (
+			user_id INTEGER PRIMARY KEY,
+			secret TEXT NOT NULL,
+			enabled_at DATETIME,
+			last_step INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS recovery_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			code_hash TEXT NOT NULL,
+			used_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			key_hash TEXT NOT NULL UNIQUE,
+			prefix TEXT NOT NULL,
+			scopes TEXT NOT NULL,
+			expires_at DATETIME,
+			last_used_at DATETIME,
+			last_used_ip TEXT,
+			revoked_at DATETIME,
+			created_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS admin_audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			admin_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			target_user_id INTEGER,
+			details TEXT,
+			ip TEXT,
+			created_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			result TEXT,
+			size INTEGER,
+			error TEXT,
+			created_at DATETIME NOT NULL,
+			started_at DATETIME,
+			finished_at DATETIME,
+			expires_at DATETIME,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_identities (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			email TEXT,
+			created_at DATETIME NOT NULL,
+			last_login_at DATETIME,
+			UNIQUE(provider, subject),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS workout_images (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			workout_id INTEGER REFERENCES workouts(id) ON DELETE CASCADE,
+			extraction_id INTEGER REFERENCES workout_extractions(id),
+			filename TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 		)`,
 	}
 
+	var hasIdentities int
+	if err := DB.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'user_identities'`).Scan(&hasIdentities); err != nil {
+		return err
+	}
+
 	for _, query := range queries {
 		if _, err := DB.Exec(query); err != nil {
 			return err
 		}
 	}
 
+	// Añadir columnas nuevas a bases de datos existentes
+	if err := migrateColumns(); err != nil {
+		return err
+	}
+	if err := migrateWorkoutAnalyses(); err != nil {
+		return err
+	}
+	if hasIdentities == 0 {
+		if err := migrateStravaIdentities(); err != nil {
+			return err
+		}
+	}
+
 	// Crear índices para optimización
 	indexes := []string{
 		`CREATE INDEX IF NOT EXISTS idx_workouts_user_date ON workouts(user_id, date DESC)`,
 		`CREATE INDEX IF NOT EXISTS idx_workouts_strava_id ON workouts(strava_activity_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_users_email ON users(email)`,
+		`CREATE INDEX IF NOT EXISTS idx_workout_intervals_workout ON workout_intervals(workout_id, rep_index)`,
+		`CREATE INDEX IF NOT EXISTS idx_workouts_gear ON workouts(gear_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_gear_user ON gear(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_races_user_date ON races(user_id, race_date)`,
+		`CREATE INDEX IF NOT EXISTS idx_profile_history_user ON runner_profile_history(user_id, recorded_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_plan_proposals_user ON plan_change_proposals(user_id, status)`,
+		`CREATE INDEX IF NOT EXISTS idx_llm_tool_calls_user ON llm_tool_calls(user_id, created_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id, revoked_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_previous_hash ON sessions(previous_hash)`,
+		`CREATE INDEX IF NOT EXISTS idx_email_tokens_user ON email_tokens(user_id, purpose)`,
+		`CREATE INDEX IF NOT EXISTS idx_login_attempts_email ON login_attempts(email, id DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts(ip, created_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes(user_id, code_hash)`,
+		`CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_admin_audit_log_target ON admin_audit_log(target_user_id, id DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_user ON jobs(user_id, id DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs(status, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_llm_usage_user ON llm_usage(user_id, created_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_workout_images_workout ON workout_images(workout_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_workout_images_extraction ON workout_images(extraction_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_workout_analyses_workout ON workout_analyses(workout_id, version DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_workout_analyses_user ON workout_analyses(user_id, created_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_progress_reports_user ON progress_reports(user_id, created_at DESC)`,
 	}
 
 	for _, query := range indexes {
@@ -150,3 +465,113 @@ func createTables() error {
 
 	return nil
 }
+
+// migrateColumns añade las columnas que CREATE TABLE IF NOT EXISTS no crea en bases de datos antiguas
+func migrateColumns() error {
+	columns := []struct {
+		table, column, definition string
+	}{
+		{"workouts", "gear_id", "INTEGER REFERENCES gear(id)"},
+		{"users", "locale", "TEXT"},
+		{"users", "email_verified_at", "DATETIME"},
+		{"users", "role", "TEXT NOT NULL DEFAULT 'user'"},
+		{"users", "disabled_at", "DATETIME"},
+		{"sessions", "impersonator_id", "INTEGER REFERENCES users(id)"},
+		{"strava_tokens", "last_sync_error", "TEXT"},
+		{"strava_tokens", "last_sync_error_at", "DATETIME"},
+		{"training_plans", "race_id", "INTEGER REFERENCES races(id)"},
+		{"training_plans", "blocks", "TEXT"},
+		{"training_plans", "model", "TEXT"},
+		{"training_plans", "prompt_version", "TEXT"},
+		{"runner_profiles", "sex", "TEXT"},
+		{"runner_profiles", "resting_hr", "INTEGER"},
+		{"runner_profiles", "max_hr", "INTEGER"},
+		{"runner_profiles", "lthr", "INTEGER"},
+		{"runner_profiles", "threshold_pace", "TEXT"},
+		{"runner_profiles", "threshold_power", "INTEGER"},
+		{"runner_profiles", "hr_zones", "TEXT"},
+		{"runner_profiles", "goals", "TEXT"},
+		{"progress_reports", "model", "TEXT"},
+		{"progress_reports", "prompt_version", "TEXT"},
+	}
+
+	for _, c := range columns {
+		var count int
+		err := DB.QueryRow(`
+			SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, c.table, c.column).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			continue
+		}
+
+		if _, err := DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition)); err != nil {
+			return err
+		}
+		log.Printf("✅ Columna %s.%s añadida", c.table, c.column)
+	}
+
+	return nil
+}
+
+// migrateWorkoutAnalyses reconstruye la tabla workout_analyses antigua (sin usuario ni versiones).
+// Los análisis existentes se numeran por fecha dentro de cada workout; los de workouts que ya no
+// existen se descartan porque no se puede saber de quién son.
+func migrateWorkoutAnalyses() error {
+	var count int
+	if err := DB.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('workout_analyses') WHERE name = 'user_id'`).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		"CREATE TABLE workout_analyses_new " + workoutAnalysesColumns,
+		`INSERT INTO workout_analyses_new (id, user_id, workout_id, kind, source, version, analysis, recommendations, created_at)
+		 SELECT a.id, w.user_id, a.workout_id, 'analysis', 'workout',
+		        ROW_NUMBER() OVER (PARTITION BY a.workout_id ORDER BY a.created_at, a.id),
+		        a.analysis, a.recommendations, a.created_at
+		 FROM workout_analyses a
+		 JOIN workouts w ON w.id = a.workout_id`,
+		`DROP TABLE workout_analyses`,
+		`ALTER TABLE workout_analyses_new RENAME TO workout_analyses`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Println("✅ Tabla workout_analyses migrada a análisis versionados")
+	return nil
+}
+
+// migrateStravaIdentities vincula como identidad de inicio de sesión el atleta de las conexiones
+// con Strava que ya había al crear user_identities. Solo se hace entonces: después, quien
+// desvincula Strava del inicio de sesión no vuelve a tenerlo vinculado al reiniciar.
+func migrateStravaIdentities() error {
+	result, err := DB.Exec(`
+		INSERT OR IGNORE INTO user_identities (user_id, provider, subject, created_at)
+		SELECT user_id, 'strava', CAST(athlete_id AS TEXT), CURRENT_TIMESTAMP
+		FROM strava_tokens WHERE athlete_id IS NOT NULL AND athlete_id != 0`)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("✅ %d conexiones de Strava vinculadas para iniciar sesión", n)
+	}
+	return nil
+}
@@ -0,0 +1,453 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"trainapp/database"
+	"trainapp/services"
+)
+
+// dataExportTables son los ficheros de la exportación de datos (nombre.json y nombre.csv) y la
+// consulta de cada uno. No se exportan secretos: contraseña, tokens de Strava, hashes de los
+// tokens de sesión ni de las claves de API, ni el secreto del 2FA. De las sesiones de soporte
+// solo consta que lo fueron, no qué administrador las abrió.
+var dataExportTables = []struct {
+	name, query string
+}{
+	{"account", `SELECT id, name, email, locale, role, email_verified_at, created_at, updated_at FROM users WHERE id = ?`},
+	{"runner_profile", `SELECT * FROM runner_profiles WHERE user_id = ?`},
+	{"runner_profile_history", `SELECT * FROM runner_profile_history WHERE user_id = ? ORDER BY id`},
+	{"workouts", `SELECT * FROM workouts WHERE user_id = ? ORDER BY date, id`},
+	{"workout_intervals", `
+		SELECT i.* FROM workout_intervals i JOIN workouts w ON w.id = i.workout_id
+		WHERE w.user_id = ? ORDER BY i.workout_id, i.rep_index`},
+	{"workout_analyses", `SELECT * FROM workout_analyses WHERE user_id = ? ORDER BY id`},
+	{"workout_extractions", `SELECT * FROM workout_extractions WHERE user_id = ? ORDER BY id`},
+	{"workout_images", `
+		SELECT id, workout_id, extraction_id, filename, content_type, size, created_at
+		FROM workout_images WHERE user_id = ? ORDER BY id`},
+	{"progress_reports", `SELECT * FROM progress_reports WHERE user_id = ? ORDER BY id`},
+	{"training_plans", `SELECT * FROM training_plans WHERE user_id = ? ORDER BY id`},
+	{"plan_change_proposals", `SELECT * FROM plan_change_proposals WHERE user_id = ? ORDER BY id`},
+	{"races", `SELECT * FROM races WHERE user_id = ? ORDER BY race_date, id`},
+	{"gear", `SELECT * FROM gear WHERE user_id = ? ORDER BY id`},
+	{"coach_conversation", `SELECT * FROM coach_conversations WHERE user_id = ?`},
+	{"llm_usage", `SELECT * FROM llm_usage WHERE user_id = ? ORDER BY id`},
+	{"llm_tool_calls", `SELECT * FROM llm_tool_calls WHERE user_id = ? ORDER BY id`},
+	{"strava_connection", `SELECT athlete_id, last_sync, created_at FROM strava_tokens WHERE user_id = ?`},
+	{"identities", `
+		SELECT provider, subject, email, created_at, last_login_at
+		FROM user_identities WHERE user_id = ? ORDER BY id`},
+	{"sessions", `
+		SELECT id, user_agent, ip, created_at, last_used_at, expires_at, revoked_at,
+		       impersonator_id IS NOT NULL AS impersonated
+		FROM sessions WHERE user_id = ? ORDER BY id`},
+	{"login_attempts", `
+		SELECT email, ip, user_agent, success, reason, created_at
+		FROM login_attempts WHERE user_id = ? ORDER BY id`},
+	{"api_keys", `
+		SELECT id, name, prefix, scopes, expires_at, last_used_at, last_used_ip, revoked_at, created_at
+		FROM api_keys WHERE user_id = ? ORDER BY id`},
+}
+
+// userDataDeletes borran todas las filas de un usuario (?1). SQLite no aplica los ON DELETE
+// CASCADE sin PRAGMA foreign_keys, así que se borra tabla a tabla, de las hijas a users.
+// admin_audit_log se conserva como registro de lo que hicieron los administradores.
+var userDataDeletes = []string{
+	`DELETE FROM workout_intervals WHERE workout_id IN (SELECT id FROM workouts WHERE user_id = ?1)`,
+	`DELETE FROM workout_streams WHERE workout_id IN (SELECT id FROM workouts WHERE user_id = ?1)`,
+	`DELETE FROM workout_images WHERE user_id = ?1`,
+	`DELETE FROM workout_analyses WHERE user_id = ?1`,
+	`DELETE FROM workout_extractions WHERE user_id = ?1`,
+	`DELETE FROM plan_change_proposals WHERE user_id = ?1`,
+	`DELETE FROM races WHERE user_id = ?1`,
+	`DELETE FROM workouts WHERE user_id = ?1`,
+	`DELETE FROM gear WHERE user_id = ?1`,
+	`DELETE FROM training_plans WHERE user_id = ?1`,
+	`DELETE FROM progress_reports WHERE user_id = ?1`,
+	`DELETE FROM runner_profile_history WHERE user_id = ?1`,
+	`DELETE FROM runner_profiles WHERE user_id = ?1`,
+	`DELETE FROM coach_conversations WHERE user_id = ?1`,
+	`DELETE FROM llm_tool_calls WHERE user_id = ?1`,
+	`DELETE FROM llm_usage WHERE user_id = ?1`,
+	`DELETE FROM strava_tokens WHERE user_id = ?1`,
+	`DELETE FROM user_identities WHERE user_id = ?1`,
+	`DELETE FROM sessions WHERE user_id = ?1 OR impersonator_id = ?1`,
+	`DELETE FROM email_tokens WHERE user_id = ?1`,
+	`DELETE FROM login_attempts WHERE user_id = ?1 OR email = (SELECT email FROM users WHERE id = ?1) COLLATE NOCASE`,
+	`DELETE FROM user_totp WHERE user_id = ?1`,
+	`DELETE FROM recovery_codes WHERE user_id = ?1`,
+	`DELETE FROM api_keys WHERE user_id = ?1`,
+	`DELETE FROM jobs WHERE user_id = ?1`,
+	`DELETE FROM users WHERE id = ?1`,
+}
+
+// exportsDir devuelve el directorio donde se guardan las exportaciones de datos (EXPORTS_PATH)
+func exportsDir() string {
+	if dir := os.Getenv("EXPORTS_PATH"); dir != "" {
+		return dir
+	}
+	return "./exports"
+}
+
+// queryTable ejecuta una consulta de un usuario y devuelve sus columnas y filas
+func queryTable(query string, userID int) ([]string, [][]interface{}, error) {
+	rows, err := database.DB.Query(query, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	table := [][]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, nil, err
+		}
+		for i, value := range values {
+			if b, ok := value.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		table = append(table, values)
+	}
+	return columns, table, rows.Err()
+}
+
+// writeDataExport escribe en el ZIP todos los datos del usuario: una tabla por fichero, los
+// streams de cada entreno en streams/ y las capturas en images/
+func writeDataExport(export *services.ExportWriter, userID int) error {
+	for _, table := range dataExportTables {
+		columns, rows, err := queryTable(table.query, userID)
+		if err != nil {
+			return fmt.Errorf("%s: %v", table.name, err)
+		}
+		if err := export.WriteTable(table.name, columns, rows); err != nil {
+			return err
+		}
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT s.workout_id, s.data FROM workout_streams s JOIN workouts w ON w.id = s.workout_id
+		WHERE w.user_id = ? ORDER BY s.workout_id`, userID)
+	if err != nil {
+		return fmt.Errorf("streams: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var workoutID int
+		var data string
+		if err := rows.Scan(&workoutID, &data); err != nil {
+			return err
+		}
+		if err := export.WriteJSON(fmt.Sprintf("streams/%d.json", workoutID), json.RawMessage(data)); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	images, err := database.DB.Query(`SELECT filename FROM workout_images WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return fmt.Errorf("images: %v", err)
+	}
+	defer images.Close()
+	for images.Next() {
+		var filename string
+		if err := images.Scan(&filename); err != nil {
+			return err
+		}
+		err := export.WriteFile("images/"+filepath.Base(filename), filepath.Join(uploadsDir(), filename))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return images.Err()
+}
+
+// runDataExport genera el ZIP con los datos del usuario del trabajo
+func runDataExport(job *Job) (string, int64, time.Time, error) {
+	dir := filepath.Join(exportsDir(), strconv.Itoa(job.userID))
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", 0, time.Time{}, err
+	}
+	name := make([]byte, 16)
+	if _, err := rand.Read(name); err != nil {
+		return "", 0, time.Time{}, err
+	}
+	path := filepath.Join(dir, hex.EncodeToString(name)+".zip")
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return "", 0, time.Time{}, err
+	}
+	export := services.NewExportWriter(f)
+	err = writeDataExport(export, job.userID)
+	if err == nil {
+		err = export.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", 0, time.Time{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, time.Time{}, err
+	}
+	return path, info.Size(), time.Now().Add(services.ExportTTL), nil
+}
+
+// withDownloadURL añade a una exportación terminada su enlace de descarga firmado
+func withDownloadURL(r *http.Request, job *Job) *Job {
+	if job.Status == jobDone {
+		userID := r.Context().Value("userID").(int)
+		sessionID, _ := r.Context().Value("sessionID").(int64)
+		job.DownloadURL = services.GetAuthService().SignURL(services.PurposeDataExport,
+			fmt.Sprintf("/api/account/exports/%d/download", job.ID), userID, sessionID, services.DataExportURLTTL)
+	}
+	return job
+}
+
+// DataExportsHandler maneja GET (listar) y POST (pedir) las exportaciones de datos del usuario.
+// La exportación se genera en segundo plano; al terminar tiene un enlace de descarga.
+func DataExportsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value("userID").(int)
+
+	switch r.Method {
+	case "GET":
+		rows, err := database.DB.Query(`
+			SELECT `+jobColumns+` FROM jobs
+			WHERE user_id = ? AND kind = ?
+			ORDER BY id DESC
+			LIMIT 10`, userID, jobDataExport)
+		if err != nil {
+			log.Printf("Error obteniendo exportaciones: %v", err)
+			httpError(w, r, "Error obteniendo exportaciones", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		jobs := []*Job{}
+		for rows.Next() {
+			job, err := scanJob(rows)
+			if err != nil {
+				log.Printf("Error escaneando exportación: %v", err)
+				continue
+			}
+			jobs = append(jobs, withDownloadURL(r, job))
+		}
+
+		json.NewEncoder(w).Encode(jobs)
+	case "POST":
+		var inProgress int
+		database.DB.QueryRow(`
+			SELECT COUNT(*) FROM jobs WHERE user_id = ? AND kind = ? AND status IN (?, ?)`,
+			userID, jobDataExport, jobPending, jobRunning).Scan(&inProgress)
+		if inProgress > 0 {
+			httpError(w, r, "Ya hay una exportación en curso", http.StatusConflict)
+			return
+		}
+
+		job, err := enqueueJob(userID, jobDataExport)
+		if err != nil {
+			log.Printf("Error encolando exportación: %v", err)
+			httpError(w, r, "Error creando la exportación", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("📦 Exportación de datos pedida: usuario %d", userID)
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+	default:
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// DataExportDetailHandler maneja GET /api/account/exports/:id (estado) y
+// GET /api/account/exports/:id/download (el ZIP, también con URL firmada)
+func DataExportDetailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/account/exports/"), "/"), "/")
+
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) > 2 || (len(parts) == 2 && parts[1] != "download") {
+		httpError(w, r, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	job, err := scanJob(database.DB.QueryRow(`
+		SELECT `+jobColumns+` FROM jobs WHERE id = ? AND user_id = ? AND kind = ?`, id, userID, jobDataExport))
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Exportación no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "Error obteniendo exportaciones", http.StatusInternalServerError)
+		return
+	}
+
+	if len(parts) == 1 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(withDownloadURL(r, job))
+		return
+	}
+
+	if job.Status != jobDone || job.result == "" {
+		httpError(w, r, "La exportación no está disponible", http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="trainapp-export-%s.zip"`, job.CreatedAt.Format("2006-01-02")))
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.ServeFile(w, r, job.result)
+}
+
+// revokeStravaAccess revoca en Strava el acceso de la app a la cuenta del usuario, refrescando
+// antes el token si ha caducado
+func revokeStravaAccess(userID int) error {
+	var accessToken, refreshToken string
+	var expiresAt int64
+	err := database.DB.QueryRow(`
+		SELECT access_token, refresh_token, expires_at FROM strava_tokens WHERE user_id = ?`,
+		userID).Scan(&accessToken, &refreshToken, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	client := services.GetStravaClient()
+	if time.Now().Unix() >= expiresAt {
+		tokenResp, err := client.RefreshAccessToken(refreshToken)
+		if err != nil {
+			return err
+		}
+		accessToken = tokenResp.AccessToken
+	}
+	return client.Deauthorize(accessToken)
+}
+
+// deleteUserData borra en una transacción todas las filas del usuario
+func deleteUserData(userID int) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range userDataDeletes {
+		if _, err := tx.Exec(query, userID); err != nil {
+			return fmt.Errorf("%s: %v", query, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// AccountHandler maneja DELETE /api/account: borra la cuenta y todos sus datos. Se confirma con
+// la contraseña actual y, si el 2FA está activado, un código. Revoca el acceso a Strava, borra
+// todas las filas del usuario, sus capturas y sus exportaciones, y cierra la sesión.
+func AccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		ReauthToken     string `json:"reauth_token"`
+		Code            string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+	if !checkCurrentPassword(w, r, userID, req.CurrentPassword, req.ReauthToken) {
+		return
+	}
+	enabled, err := twoFactorEnabled(userID)
+	if err == nil && enabled {
+		var ok bool
+		if ok, err = checkSecondFactor(userID, req.Code); err == nil && !ok {
+			httpError(w, r, "Código de verificación incorrecto", http.StatusForbidden)
+			return
+		}
+	}
+	if err != nil {
+		log.Printf("Error comprobando código 2FA: %v", err)
+		httpError(w, r, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := loadUserProfile(userID)
+	if err != nil {
+		httpError(w, r, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	locale := emailLocale(r, userID)
+
+	// Un administrador no puede dejar el servicio sin administradores
+	if user.Role == services.AccountRoleAdmin {
+		var admins int
+		database.DB.QueryRow(`
+			SELECT COUNT(*) FROM users WHERE role = ? AND disabled_at IS NULL`, services.AccountRoleAdmin).Scan(&admins)
+		if admins <= 1 {
+			httpError(w, r, "Eres el único administrador: da el rol a otro usuario antes de borrar tu cuenta", http.StatusConflict)
+			return
+		}
+	}
+
+	// Si Strava no responde la cuenta se borra igualmente: sin los tokens la app ya no tiene acceso
+	if err := revokeStravaAccess(userID); err != nil {
+		log.Printf("⚠️  Error revocando el acceso a Strava del usuario %d: %v", userID, err)
+	}
+
+	if err := deleteUserData(userID); err != nil {
+		log.Printf("Error borrando la cuenta %d: %v", userID, err)
+		httpError(w, r, "Error borrando la cuenta", http.StatusInternalServerError)
+		return
+	}
+	for _, dir := range []string{uploadsDir(), exportsDir()} {
+		if err := os.RemoveAll(filepath.Join(dir, strconv.Itoa(userID))); err != nil {
+			log.Printf("⚠️  Error borrando los ficheros del usuario %d en %s: %v", userID, dir, err)
+		}
+	}
+	log.Printf("🗑️  Cuenta borrada: usuario %d", userID)
+
+	sendNotice(services.AccountDeletedNotice(locale, user.Email, user.Name))
+	clearSessionCookies(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,514 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"trainapp/database"
+	"trainapp/services"
+)
+
+// errInvalidEmailToken indica un enlace de email inexistente, caducado o ya usado
+var errInvalidEmailToken = errors.New("enlace inválido o caducado")
+
+// appBaseURL devuelve la URL pública del frontend: BASE_URL o la del propio request
+func appBaseURL(r *http.Request) string {
+	if baseURL := strings.TrimRight(os.Getenv("BASE_URL"), "/"); baseURL != "" {
+		return baseURL
+	}
+	scheme := "https"
+	if r.TLS == nil {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host
+}
+
+// emailLink devuelve el enlace del frontend para un token enviado por email. El token va en el
+// fragmento para que no llegue a los logs del servidor ni a la cabecera Referer.
+func emailLink(r *http.Request, action, token string) string {
+	return appBaseURL(r) + "/login.html#" + action + "=" + url.QueryEscape(token)
+}
+
+// emailLocale devuelve el idioma de los emails de un usuario: el guardado o el de la petición
+func emailLocale(r *http.Request, userID int) string {
+	if locale := userLocale(userID); locale != "" {
+		return locale
+	}
+	return services.LocaleFromAcceptLanguage(r.Header.Get("Accept-Language"))
+}
+
+// createEmailToken crea un token de un solo uso para purpose y anula los pendientes del mismo
+// propósito, de modo que solo vale el último enlace enviado
+func createEmailToken(userID int, purpose, email string, ttl time.Duration) (string, error) {
+	token, hash, err := services.NewOneTimeToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	if _, err := database.DB.Exec(`
+		UPDATE email_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL`,
+		now, userID, purpose); err != nil {
+		return "", err
+	}
+	if _, err := database.DB.Exec(`
+		INSERT INTO email_tokens (user_id, purpose, token_hash, email, expires_at)
+		VALUES (?, ?, ?, ?, ?)`, userID, purpose, hash, email, now.Add(ttl)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeEmailToken marca como usado un token válido de alguno de los propósitos y devuelve su
+// usuario, su propósito y el email al que se envió
+func consumeEmailToken(token string, purposes ...string) (userID int, purpose, email string, err error) {
+	var id int64
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+	err = database.DB.QueryRow(`
+		SELECT id, user_id, purpose, email, expires_at, used_at FROM email_tokens WHERE token_hash = ?`,
+		services.HashOneTimeToken(token)).Scan(&id, &userID, &purpose, &email, &expiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return 0, "", "", errInvalidEmailToken
+	}
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	valid := false
+	for _, p := range purposes {
+		valid = valid || p == purpose
+	}
+	if !valid || usedAt.Valid || time.Now().After(expiresAt) {
+		return 0, "", "", errInvalidEmailToken
+	}
+
+	// Solo una petición puede usar el token
+	result, err := database.DB.Exec(`
+		UPDATE email_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL`, time.Now(), id)
+	if err != nil {
+		return 0, "", "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, "", "", errInvalidEmailToken
+	}
+	return userID, purpose, email, nil
+}
+
+// sendVerificationEmail envía al usuario el enlace para confirmar su email
+func sendVerificationEmail(r *http.Request, user UserProfile) error {
+	token, err := createEmailToken(user.ID, services.TokenVerifyEmail, user.Email, services.VerifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	return services.GetMailer().Send(services.VerificationEmail(
+		emailLocale(r, user.ID), user.Email, user.Name, emailLink(r, "verify", token)))
+}
+
+// sendNotice envía un aviso sin enlace; un fallo solo se anota en el log
+func sendNotice(msg services.Email) {
+	if err := services.GetMailer().Send(msg); err != nil {
+		log.Printf("⚠️  Error enviando aviso a %s: %v", msg.To, err)
+	}
+}
+
+// revokeOtherSessions cierra todas las sesiones del usuario salvo keep
+func revokeOtherSessions(userID int, keep int64) error {
+	_, err := database.DB.Exec(`
+		UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND id != ? AND revoked_at IS NULL`,
+		time.Now(), userID, keep)
+	return err
+}
+
+// loadUserProfile obtiene el perfil público del usuario
+func loadUserProfile(userID int) (UserProfile, error) {
+	user := UserProfile{ID: userID}
+	var verifiedAt sql.NullTime
+	err := database.DB.QueryRow(`
+		SELECT name, email, COALESCE(locale, ''), email_verified_at, role FROM users WHERE id = ?`,
+		userID).Scan(&user.Name, &user.Email, &user.Locale, &verifiedAt, &user.Role)
+	user.EmailVerified = verifiedAt.Valid
+	return user, err
+}
+
+// ResendVerificationHandler envía de nuevo el enlace de verificación del email del usuario
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	user, err := loadUserProfile(userID)
+	if err != nil {
+		httpError(w, r, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if user.EmailVerified {
+		httpError(w, r, "El email ya está verificado", http.StatusConflict)
+		return
+	}
+
+	if err := sendVerificationEmail(r, user); err != nil {
+		log.Printf("Error enviando verificación a %s: %v", user.Email, err)
+		httpError(w, r, "Error enviando email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// VerifyEmailHandler confirma el email con el token del enlace enviado: el de una cuenta nueva
+// o la dirección nueva de un cambio de email
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+
+	userID, purpose, email, err := consumeEmailToken(req.Token, services.TokenVerifyEmail, services.TokenChangeEmail)
+	if err == errInvalidEmailToken {
+		httpError(w, r, "Enlace inválido o caducado", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error verificando email: %v", err)
+		httpError(w, r, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+
+	previous, err := loadUserProfile(userID)
+	if err != nil {
+		httpError(w, r, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	if purpose == services.TokenChangeEmail {
+		_, err = database.DB.Exec(`
+			UPDATE users SET email = ?, email_verified_at = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+			email, now, userID)
+		if err != nil && strings.Contains(err.Error(), "UNIQUE") {
+			httpError(w, r, "El email ya está registrado", http.StatusConflict)
+			return
+		}
+	} else {
+		// Solo si el enlace es de la dirección actual (no ha cambiado desde que se envió)
+		_, err = database.DB.Exec(`
+			UPDATE users SET email_verified_at = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND email = ?`,
+			now, userID, email)
+	}
+	if err != nil {
+		log.Printf("Error verificando email: %v", err)
+		httpError(w, r, "Error actualizando usuario", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := loadUserProfile(userID)
+	if err != nil {
+		httpError(w, r, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if purpose == services.TokenChangeEmail {
+		sendNotice(services.EmailChangedNotice(emailLocale(r, userID), previous.Email, user.Name, user.Email))
+		log.Printf("✅ Email cambiado: %s → %s", previous.Email, user.Email)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// ForgotPasswordHandler envía el enlace para restablecer la contraseña. Responde lo mismo exista
+// o no la cuenta, para no revelar qué emails están registrados.
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+
+	var userID int
+	var name, email string
+	err := database.DB.QueryRow(`
+		SELECT id, name, email FROM users WHERE email = ? COLLATE NOCASE`,
+		strings.TrimSpace(req.Email)).Scan(&userID, &name, &email)
+	if err == nil {
+		token, err := createEmailToken(userID, services.TokenResetPassword, email, services.ResetPasswordTTL)
+		if err == nil {
+			err = services.GetMailer().Send(services.PasswordResetEmail(
+				emailLocale(r, userID), email, name, emailLink(r, "reset", token)))
+		}
+		if err != nil {
+			log.Printf("Error enviando recuperación de contraseña a %s: %v", email, err)
+		}
+	} else if err != sql.ErrNoRows {
+		log.Printf("Error buscando usuario: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": services.T(requestLocale(r), "Si el email está registrado, recibirás un enlace para restablecer la contraseña"),
+	})
+}
+
+// ResetPasswordHandler cambia la contraseña con el token del enlace de recuperación y cierra
+// todas las sesiones abiertas
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+
+	// Se valida la contraseña antes de gastar el token
+	passwordHash, err := services.GetAuthService().HashPassword(req.Password)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, _, email, err := consumeEmailToken(req.Token, services.TokenResetPassword)
+	if err == errInvalidEmailToken {
+		httpError(w, r, "Enlace inválido o caducado", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error restableciendo contraseña: %v", err)
+		httpError(w, r, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+
+	// Abrir el enlace demuestra que el email es suyo
+	if _, err := database.DB.Exec(`
+		UPDATE users
+		SET password_hash = ?,
+			email_verified_at = CASE WHEN email = ? THEN COALESCE(email_verified_at, ?) ELSE email_verified_at END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, passwordHash, email, time.Now(), userID); err != nil {
+		log.Printf("Error actualizando contraseña: %v", err)
+		httpError(w, r, "Error actualizando usuario", http.StatusInternalServerError)
+		return
+	}
+	if err := revokeUserSessions(userID); err != nil {
+		log.Printf("⚠️  Error cerrando sesiones tras restablecer la contraseña: %v", err)
+	}
+
+	if user, err := loadUserProfile(userID); err == nil {
+		sendNotice(services.PasswordChangedNotice(emailLocale(r, userID), user.Email, user.Name))
+	}
+	log.Printf("✅ Contraseña restablecida: usuario %d", userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangePasswordHandler cambia la contraseña del usuario con la actual y cierra sus demás sesiones
+func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+	sessionID, _ := r.Context().Value("sessionID").(int64)
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		ReauthToken     string `json:"reauth_token"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+	if !checkCurrentPassword(w, r, userID, req.CurrentPassword, req.ReauthToken) {
+		return
+	}
+
+	passwordHash, err := services.GetAuthService().HashPassword(req.NewPassword)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := database.DB.Exec(`
+		UPDATE users SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		passwordHash, userID); err != nil {
+		log.Printf("Error actualizando contraseña: %v", err)
+		httpError(w, r, "Error actualizando usuario", http.StatusInternalServerError)
+		return
+	}
+	if err := revokeOtherSessions(userID, sessionID); err != nil {
+		log.Printf("⚠️  Error cerrando sesiones tras cambiar la contraseña: %v", err)
+	}
+
+	if user, err := loadUserProfile(userID); err == nil {
+		sendNotice(services.PasswordChangedNotice(emailLocale(r, userID), user.Email, user.Name))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangeEmailHandler pide el cambio de email con la contraseña actual. El email no cambia hasta
+// que se abre el enlace enviado a la dirección nueva.
+func ChangeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		ReauthToken     string `json:"reauth_token"`
+		NewEmail        string `json:"new_email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+	if !checkCurrentPassword(w, r, userID, req.CurrentPassword, req.ReauthToken) {
+		return
+	}
+
+	email, err := services.NormalizeEmail(req.NewEmail)
+	if err != nil {
+		httpError(w, r, "Email inválido", http.StatusBadRequest)
+		return
+	}
+	user, err := loadUserProfile(userID)
+	if err != nil {
+		httpError(w, r, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if strings.EqualFold(email, user.Email) {
+		httpError(w, r, "El nuevo email es igual al actual", http.StatusBadRequest)
+		return
+	}
+	var exists int
+	if err := database.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ? COLLATE NOCASE", email).Scan(&exists); err != nil {
+		log.Printf("Error verificando email: %v", err)
+		httpError(w, r, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+	if exists > 0 {
+		httpError(w, r, "El email ya está registrado", http.StatusConflict)
+		return
+	}
+
+	token, err := createEmailToken(userID, services.TokenChangeEmail, email, services.ChangeEmailTTL)
+	if err == nil {
+		err = services.GetMailer().Send(services.ChangeEmailEmail(
+			emailLocale(r, userID), email, user.Name, emailLink(r, "verify", token)))
+	}
+	if err != nil {
+		log.Printf("Error enviando confirmación de cambio de email a %s: %v", email, err)
+		httpError(w, r, "Error enviando email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": services.T(requestLocale(r), "Te hemos enviado un enlace para confirmar el nuevo email"),
+	})
+}
+
+// checkCurrentPassword comprueba la contraseña actual del usuario; si no coincide responde 403
+// y devuelve false. Las cuentas sin contraseña (creadas con un proveedor externo) se confirman
+// con el código de un solo uso que envía ReauthHandler.
+func checkCurrentPassword(w http.ResponseWriter, r *http.Request, userID int, password, reauthToken string) bool {
+	var passwordHash string
+	if err := database.DB.QueryRow("SELECT password_hash FROM users WHERE id = ?", userID).Scan(&passwordHash); err != nil {
+		httpError(w, r, "Usuario no encontrado", http.StatusNotFound)
+		return false
+	}
+	if passwordHash == "" {
+		if reauthToken == "" {
+			httpError(w, r, "Tu cuenta no tiene contraseña: pide un código de confirmación por email o crea una contraseña con «¿Has olvidado tu contraseña?»", http.StatusForbidden)
+			return false
+		}
+		tokenUserID, _, _, err := consumeEmailToken(reauthToken, services.TokenReauth)
+		if err != nil && err != errInvalidEmailToken {
+			log.Printf("Error comprobando código de confirmación: %v", err)
+			httpError(w, r, "Error interno del servidor", http.StatusInternalServerError)
+			return false
+		}
+		if err != nil || tokenUserID != userID {
+			httpError(w, r, "El código de confirmación no es válido o ha caducado", http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+	if password == "" || !services.GetAuthService().VerifyPassword(password, passwordHash) {
+		httpError(w, r, "La contraseña actual no es correcta", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// ReauthHandler envía por email un código de un solo uso con el que una cuenta sin contraseña
+// confirma las operaciones que a las demás se les piden con la contraseña actual (reauth_token)
+func ReauthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+	if hasPassword(userID) {
+		httpError(w, r, "Tu cuenta tiene contraseña: confirma la operación con ella", http.StatusConflict)
+		return
+	}
+	user, err := loadUserProfile(userID)
+	if err != nil {
+		httpError(w, r, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+
+	token, err := createEmailToken(userID, services.TokenReauth, user.Email, services.ReauthTTL)
+	if err == nil {
+		err = services.GetMailer().Send(services.ReauthEmail(emailLocale(r, userID), user.Email, user.Name, token))
+	}
+	if err != nil {
+		log.Printf("Error enviando código de confirmación a %s: %v", user.Email, err)
+		httpError(w, r, "Error enviando email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": services.T(requestLocale(r), "Te hemos enviado un código de confirmación por email"),
+	})
+}
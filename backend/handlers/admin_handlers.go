@@ -0,0 +1,669 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trainapp/database"
+	"trainapp/services"
+)
+
+// Acciones de los administradores que quedan en admin_audit_log
+const (
+	auditRoleChange  = "role_change"
+	auditDisable     = "disable"
+	auditEnable      = "enable"
+	auditImpersonate = "impersonate"
+)
+
+// Sin sincronizar desde hace más de esto, una conexión con Strava se considera parada
+const staleStravaSync = 7 * 24 * time.Hour
+
+// AdminUser es un usuario tal como lo ve un administrador
+type AdminUser struct {
+	ID              int        `json:"id"`
+	Name            string     `json:"name"`
+	Email           string     `json:"email"`
+	Role            string     `json:"role"`
+	EmailVerified   bool       `json:"email_verified"`
+	Disabled        bool       `json:"disabled"`
+	DisabledAt      *time.Time `json:"disabled_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	LastLoginAt     *time.Time `json:"last_login_at"`
+	Workouts        int        `json:"workouts"`
+	StravaConnected bool       `json:"strava_connected"`
+	TwoFactor       bool       `json:"two_factor"`
+}
+
+// AdminAuditEntry es una acción de un administrador
+type AdminAuditEntry struct {
+	ID           int64     `json:"id"`
+	AdminID      int       `json:"admin_id"`
+	Action       string    `json:"action"`
+	TargetUserID *int      `json:"target_user_id"`
+	Details      string    `json:"details,omitempty"`
+	IP           string    `json:"ip"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// adminUserRequest contiene los campos que un administrador puede cambiar de un usuario; los
+// campos nil no se modifican
+type adminUserRequest struct {
+	Role     *string `json:"role"`
+	Disabled *bool   `json:"disabled"`
+}
+
+// apply copia los campos presentes en la petición sobre u y valida el resultado
+func (req adminUserRequest) apply(u *AdminUser) string {
+	if req.Role != nil {
+		u.Role = strings.ToLower(strings.TrimSpace(*req.Role))
+	}
+	if req.Disabled != nil {
+		u.Disabled = *req.Disabled
+	}
+
+	if !services.ValidRole(u.Role) {
+		return "Rol inválido (user, admin)"
+	}
+	return ""
+}
+
+// UserRole devuelve el rol de un usuario activo; se usa en AdminMiddleware en cada petición
+func UserRole(userID int) (string, error) {
+	var role string
+	err := database.DB.QueryRow(`
+		SELECT role FROM users WHERE id = ? AND disabled_at IS NULL`, userID).Scan(&role)
+	return role, err
+}
+
+// PromoteAdmins da el rol de administrador a los usuarios con los emails indicados (ADMIN_EMAILS)
+func PromoteAdmins(emails []string) error {
+	for _, email := range emails {
+		result, err := database.DB.Exec(`
+			UPDATE users SET role = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE email = ? COLLATE NOCASE AND role != ?`,
+			services.AccountRoleAdmin, email, services.AccountRoleAdmin)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			log.Printf("👑 %s es ahora administrador (ADMIN_EMAILS)", email)
+		}
+	}
+	return nil
+}
+
+// accountDisabled indica si un administrador ha desactivado la cuenta del usuario
+func accountDisabled(userID int) bool {
+	var disabledAt sql.NullTime
+	database.DB.QueryRow("SELECT disabled_at FROM users WHERE id = ?", userID).Scan(&disabledAt)
+	return disabledAt.Valid
+}
+
+// recordAdminAction guarda una acción de un administrador sobre un usuario
+func recordAdminAction(r *http.Request, action string, targetUserID int, details string) {
+	adminID := r.Context().Value("userID").(int)
+	log.Printf("👑 Administrador %d: %s usuario %d %s", adminID, action, targetUserID, details)
+	if _, err := database.DB.Exec(`
+		INSERT INTO admin_audit_log (admin_id, action, target_user_id, details, ip, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		adminID, action, nullIfZero(targetUserID), nullIfEmpty(details), clientIP(r), time.Now()); err != nil {
+		log.Printf("⚠️  Error guardando acción de administrador: %v", err)
+	}
+}
+
+// adminUserColumns son las columnas de AdminUser, salvo el último inicio de sesión
+const adminUserColumns = `
+	u.id, u.name, u.email, u.role, u.email_verified_at, u.disabled_at, u.created_at,
+	(SELECT COUNT(*) FROM workouts w WHERE w.user_id = u.id),
+	EXISTS (SELECT 1 FROM strava_tokens st WHERE st.user_id = u.id),
+	EXISTS (SELECT 1 FROM user_totp t WHERE t.user_id = u.id AND t.enabled_at IS NOT NULL)`
+
+// scanAdminUser lee un usuario de una fila con adminUserColumns
+func scanAdminUser(row interface{ Scan(...interface{}) error }) (*AdminUser, error) {
+	u := &AdminUser{}
+	var verifiedAt, disabledAt sql.NullTime
+	if err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Role, &verifiedAt, &disabledAt, &u.CreatedAt,
+		&u.Workouts, &u.StravaConnected, &u.TwoFactor); err != nil {
+		return nil, err
+	}
+	u.EmailVerified = verifiedAt.Valid
+	if disabledAt.Valid {
+		u.Disabled = true
+		u.DisabledAt = &disabledAt.Time
+	}
+	return u, nil
+}
+
+// fillLastLogins completa el último inicio de sesión correcto de los usuarios
+func fillLastLogins(users []*AdminUser) error {
+	if len(users) == 0 {
+		return nil
+	}
+	byID := map[int]*AdminUser{}
+	placeholders := make([]string, 0, len(users))
+	params := make([]interface{}, 0, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
+		placeholders = append(placeholders, "?")
+		params = append(params, u.ID)
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT user_id, created_at FROM login_attempts
+		WHERE id IN (
+			SELECT MAX(id) FROM login_attempts
+			WHERE success = 1 AND user_id IN (`+strings.Join(placeholders, ",")+`)
+			GROUP BY user_id
+		)`, params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID int
+		var at time.Time
+		if err := rows.Scan(&userID, &at); err != nil {
+			return err
+		}
+		if u, ok := byID[userID]; ok {
+			u.LastLoginAt = &at
+		}
+	}
+	return rows.Err()
+}
+
+// AdminUsersHandler lista los usuarios (?q= en nombre o email, ?role=, ?disabled=true|false,
+// ?limit= hasta 500, ?offset=)
+func AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	conditions := []string{"1 = 1"}
+	params := []interface{}{}
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		conditions = append(conditions, "(u.name LIKE ? OR u.email LIKE ?)")
+		params = append(params, "%"+q+"%", "%"+q+"%")
+	}
+	if role := query.Get("role"); role != "" {
+		conditions = append(conditions, "u.role = ?")
+		params = append(params, role)
+	}
+	switch query.Get("disabled") {
+	case "true":
+		conditions = append(conditions, "u.disabled_at IS NOT NULL")
+	case "false":
+		conditions = append(conditions, "u.disabled_at IS NULL")
+	}
+	limit, offset := 100, 0
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 500 {
+			httpError(w, r, "limit debe estar entre 1 y 500", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if value := query.Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			httpError(w, r, "offset inválido", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+	params = append(params, limit, offset)
+
+	rows, err := database.DB.Query(`
+		SELECT `+adminUserColumns+`
+		FROM users u
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY u.id
+		LIMIT ? OFFSET ?`, params...)
+	if err != nil {
+		log.Printf("Error obteniendo usuarios: %v", err)
+		httpError(w, r, "Error obteniendo usuarios", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	users := []*AdminUser{}
+	for rows.Next() {
+		u, err := scanAdminUser(rows)
+		if err != nil {
+			log.Printf("Error escaneando usuario: %v", err)
+			continue
+		}
+		users = append(users, u)
+	}
+	if err := fillLastLogins(users); err != nil {
+		log.Printf("⚠️  Error obteniendo últimos inicios de sesión: %v", err)
+	}
+
+	json.NewEncoder(w).Encode(users)
+}
+
+// AdminUserDetailHandler maneja GET y PATCH (rol, desactivar) de /api/admin/users/:id y
+// POST /api/admin/users/:id/impersonate
+func AdminUserDetailHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	adminID := r.Context().Value("userID").(int)
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/users/"), "/")
+	parts := strings.Split(path, "/")
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		httpError(w, r, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	u, err := scanAdminUser(database.DB.QueryRow(`
+		SELECT `+adminUserColumns+` FROM users u WHERE u.id = ?`, id))
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo usuario: %v", err)
+		httpError(w, r, "Error obteniendo usuarios", http.StatusInternalServerError)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "impersonate" {
+		impersonateUser(w, r, u)
+		return
+	}
+	if len(parts) > 1 {
+		httpError(w, r, "Ruta no encontrada", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		if err := fillLastLogins([]*AdminUser{u}); err != nil {
+			log.Printf("⚠️  Error obteniendo último inicio de sesión: %v", err)
+		}
+		var activeSessions, apiKeys int
+		now := time.Now()
+		if rows, err := database.DB.Query(`
+			SELECT expires_at FROM sessions WHERE user_id = ? AND revoked_at IS NULL`, id); err == nil {
+			for rows.Next() {
+				var expiresAt time.Time
+				if rows.Scan(&expiresAt) == nil && now.Before(expiresAt) {
+					activeSessions++
+				}
+			}
+			rows.Close()
+		}
+		database.DB.QueryRow(`
+			SELECT COUNT(*) FROM api_keys WHERE user_id = ? AND revoked_at IS NULL`, id).Scan(&apiKeys)
+
+		audit := []AdminAuditEntry{}
+		if rows, err := database.DB.Query(`
+			SELECT `+auditColumns+` FROM admin_audit_log
+			WHERE target_user_id = ? ORDER BY id DESC LIMIT 20`, id); err == nil {
+			audit = scanAuditEntries(rows)
+			rows.Close()
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"user":            u,
+			"active_sessions": activeSessions,
+			"api_keys":        apiKeys,
+			"audit":           audit,
+		})
+	case "PATCH":
+		var req adminUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+			return
+		}
+		previous := *u
+		if msg := req.apply(u); msg != "" {
+			httpError(w, r, msg, http.StatusBadRequest)
+			return
+		}
+		if id == adminID && (u.Role != services.AccountRoleAdmin || u.Disabled) {
+			httpError(w, r, "No puedes quitarte el rol de administrador ni desactivar tu propia cuenta", http.StatusBadRequest)
+			return
+		}
+
+		var disabledAt interface{}
+		if u.Disabled {
+			at := time.Now()
+			if previous.DisabledAt != nil {
+				at = *previous.DisabledAt
+			}
+			u.DisabledAt = &at
+			disabledAt = at
+		} else {
+			u.DisabledAt = nil
+		}
+		if _, err := database.DB.Exec(`
+			UPDATE users SET role = ?, disabled_at = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+			u.Role, disabledAt, id); err != nil {
+			log.Printf("Error actualizando usuario: %v", err)
+			httpError(w, r, "Error actualizando usuario", http.StatusInternalServerError)
+			return
+		}
+
+		if u.Role != previous.Role {
+			recordAdminAction(r, auditRoleChange, id, previous.Role+" → "+u.Role)
+		}
+		if u.Disabled && !previous.Disabled {
+			// Desactivar la cuenta cierra todas sus sesiones; sus claves de API dejan de valer
+			// mientras siga desactivada
+			if err := revokeUserSessions(id); err != nil {
+				log.Printf("⚠️  Error cerrando sesiones del usuario %d: %v", id, err)
+			}
+			recordAdminAction(r, auditDisable, id, "")
+		}
+		if !u.Disabled && previous.Disabled {
+			recordAdminAction(r, auditEnable, id, "")
+		}
+
+		json.NewEncoder(w).Encode(u)
+	default:
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// impersonateUser abre una sesión de soporte en la cuenta del usuario: un token de acceso de corta
+// duración, sin token de refresco, que lleva el administrador en el claim imp. Se exige un motivo,
+// que queda en admin_audit_log, y las peticiones de la sesión se anotan en el log.
+func impersonateUser(w http.ResponseWriter, r *http.Request, u *AdminUser) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	adminID := r.Context().Value("userID").(int)
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" || len(req.Reason) > 500 {
+		httpError(w, r, "Indica el motivo del acceso (máximo 500 caracteres)", http.StatusBadRequest)
+		return
+	}
+	if u.ID == adminID || u.Role == services.AccountRoleAdmin || u.Disabled {
+		httpError(w, r, "No se puede acceder a la cuenta de un administrador ni a una cuenta desactivada", http.StatusForbidden)
+		return
+	}
+
+	// La sesión no tiene token de refresco utilizable: se guarda el hash de uno que se descarta
+	_, hash, err := services.NewRefreshToken()
+	if err != nil {
+		httpError(w, r, "Error generando token", http.StatusInternalServerError)
+		return
+	}
+	result, err := database.DB.Exec(`
+		INSERT INTO sessions (user_id, refresh_hash, user_agent, ip, expires_at, impersonator_id)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		u.ID, hash, r.UserAgent(), clientIP(r), time.Now().Add(services.AccessTokenTTL()), adminID)
+	if err != nil {
+		log.Printf("Error creando sesión de soporte: %v", err)
+		httpError(w, r, "Error creando sesión", http.StatusInternalServerError)
+		return
+	}
+	sessionID, _ := result.LastInsertId()
+
+	token, err := services.GetAuthService().GenerateImpersonationToken(u.ID, u.Email, u.Name, sessionID, adminID)
+	if err != nil {
+		httpError(w, r, "Error generando token", http.StatusInternalServerError)
+		return
+	}
+	recordAdminAction(r, auditImpersonate, u.ID, req.Reason)
+
+	user, _ := loadUserProfile(u.ID)
+	json.NewEncoder(w).Encode(AuthResponse{
+		Token:     token,
+		ExpiresIn: int(services.AccessTokenTTL().Seconds()),
+		User:      user,
+	})
+}
+
+// AdminAuditLogHandler lista las acciones de los administradores más recientes (?admin_id=,
+// ?user_id=, ?action=, ?limit= hasta 500)
+func AdminAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	conditions := []string{"1 = 1"}
+	params := []interface{}{}
+	for param, column := range map[string]string{"admin_id": "admin_id", "user_id": "target_user_id"} {
+		if value := query.Get(param); value != "" {
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				httpError(w, r, param+" inválido", http.StatusBadRequest)
+				return
+			}
+			conditions = append(conditions, column+" = ?")
+			params = append(params, id)
+		}
+	}
+	if action := query.Get("action"); action != "" {
+		conditions = append(conditions, "action = ?")
+		params = append(params, action)
+	}
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 500 {
+			httpError(w, r, "limit debe estar entre 1 y 500", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	params = append(params, limit)
+
+	rows, err := database.DB.Query(`
+		SELECT `+auditColumns+`
+		FROM admin_audit_log
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY id DESC
+		LIMIT ?`, params...)
+	if err != nil {
+		log.Printf("Error obteniendo registro de administración: %v", err)
+		httpError(w, r, "Error obteniendo el registro de administración", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	json.NewEncoder(w).Encode(scanAuditEntries(rows))
+}
+
+const auditColumns = `id, admin_id, action, target_user_id, COALESCE(details, ''), COALESCE(ip, ''), created_at`
+
+// scanAuditEntries lee las acciones de administrador de unas filas con auditColumns
+func scanAuditEntries(rows *sql.Rows) []AdminAuditEntry {
+	entries := []AdminAuditEntry{}
+	for rows.Next() {
+		var e AdminAuditEntry
+		if err := rows.Scan(&e.ID, &e.AdminID, &e.Action, &e.TargetUserID, &e.Details, &e.IP, &e.CreatedAt); err != nil {
+			log.Printf("Error escaneando acción de administrador: %v", err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// StravaSyncError es el último error de sincronización de un usuario
+type StravaSyncError struct {
+	UserID int        `json:"user_id"`
+	Email  string     `json:"email"`
+	Error  string     `json:"error"`
+	At     *time.Time `json:"at"`
+}
+
+// AdminHealthHandler resume el estado del servicio: base de datos, usuarios, sesiones, conexiones
+// con Strava y sus errores de sincronización, trabajos en segundo plano, llamadas al modelo e
+// inicios de sesión de las últimas 24 horas
+func AdminHealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := database.DB.Ping(); err != nil {
+		log.Printf("❌ Base de datos no disponible: %v", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"database": "error"})
+		return
+	}
+
+	now := time.Now()
+	since := now.Add(-24 * time.Hour)
+
+	// Usuarios
+	users := map[string]int{}
+	var total, admins, disabled int
+	database.DB.QueryRow(`
+		SELECT COUNT(*),
+		       COALESCE(SUM(role = 'admin'), 0),
+		       COALESCE(SUM(disabled_at IS NOT NULL), 0)
+		FROM users`).Scan(&total, &admins, &disabled)
+	users["total"], users["admins"], users["disabled"] = total, admins, disabled
+
+	// Sesiones abiertas (las fechas se escriben desde Go y se comparan aquí)
+	activeSessions := 0
+	if rows, err := database.DB.Query(`SELECT expires_at FROM sessions WHERE revoked_at IS NULL`); err == nil {
+		for rows.Next() {
+			var expiresAt time.Time
+			if rows.Scan(&expiresAt) == nil && now.Before(expiresAt) {
+				activeSessions++
+			}
+		}
+		rows.Close()
+	}
+
+	// Strava: last_sync se guarda con CURRENT_TIMESTAMP y se compara en SQL
+	var connected, synced24h, stale int
+	database.DB.QueryRow(`
+		SELECT COUNT(*),
+		       COALESCE(SUM(last_sync >= datetime('now', '-1 day')), 0),
+		       COALESCE(SUM(last_sync IS NULL OR last_sync < datetime('now', ?)), 0)
+		FROM strava_tokens`, "-"+strconv.Itoa(int(staleStravaSync.Hours()))+" hours").Scan(&connected, &synced24h, &stale)
+	syncErrors := []StravaSyncError{}
+	if rows, err := database.DB.Query(`
+		SELECT st.user_id, u.email, st.last_sync_error, st.last_sync_error_at
+		FROM strava_tokens st
+		JOIN users u ON u.id = st.user_id
+		WHERE st.last_sync_error IS NOT NULL
+		ORDER BY st.updated_at DESC
+		LIMIT 20`); err == nil {
+		for rows.Next() {
+			var e StravaSyncError
+			var at sql.NullTime
+			if rows.Scan(&e.UserID, &e.Email, &e.Error, &at) == nil {
+				if at.Valid {
+					e.At = &at.Time
+				}
+				syncErrors = append(syncErrors, e)
+			}
+		}
+		rows.Close()
+	}
+
+	// Modelo en las últimas 24 horas
+	var llmCalls, llmErrors int
+	var llmLatency float64
+	database.DB.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(error IS NOT NULL), 0), COALESCE(AVG(latency_ms), 0)
+		FROM llm_usage
+		WHERE created_at >= datetime('now', '-1 day')`).Scan(&llmCalls, &llmErrors, &llmLatency)
+	errorRate := 0.0
+	if llmCalls > 0 {
+		errorRate = float64(llmErrors) / float64(llmCalls)
+	}
+
+	// Inicios de sesión en las últimas 24 horas, del más reciente hacia atrás
+	logins := map[string]int{"succeeded": 0, "failed": 0, "locked": 0}
+	if rows, err := database.DB.Query(`
+		SELECT success, COALESCE(reason, ''), created_at FROM login_attempts ORDER BY id DESC LIMIT 10000`); err == nil {
+		for rows.Next() {
+			var success bool
+			var reason string
+			var createdAt time.Time
+			if rows.Scan(&success, &reason, &createdAt) != nil {
+				continue
+			}
+			if createdAt.Before(since) {
+				break
+			}
+			switch {
+			case success:
+				logins["succeeded"]++
+			case reason == loginLocked:
+				logins["locked"]++
+			default:
+				logins["failed"]++
+			}
+		}
+		rows.Close()
+	}
+
+	// Trabajos en segundo plano: en cola, en marcha y fallidos en las últimas 24 horas
+	jobs := map[string]int{"pending": 0, "running": 0, "failed_24h": 0}
+	if rows, err := database.DB.Query(`
+		SELECT status, finished_at FROM jobs WHERE status IN (?, ?, ?)`, jobPending, jobRunning, jobFailed); err == nil {
+		for rows.Next() {
+			var status string
+			var finishedAt sql.NullTime
+			if rows.Scan(&status, &finishedAt) != nil {
+				continue
+			}
+			switch {
+			case status != jobFailed:
+				jobs[status]++
+			case finishedAt.Valid && finishedAt.Time.After(since):
+				jobs["failed_24h"]++
+			}
+		}
+		rows.Close()
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"database": "ok",
+		"jobs":     jobs,
+		"users":    users,
+		"sessions": map[string]int{"active": activeSessions},
+		"strava": map[string]interface{}{
+			"connected":   connected,
+			"synced_24h":  synced24h,
+			"stale":       stale,
+			"sync_errors": syncErrors,
+		},
+		"llm_24h": map[string]interface{}{
+			"calls":          llmCalls,
+			"errors":         llmErrors,
+			"error_rate":     errorRate,
+			"avg_latency_ms": int(llmLatency),
+		},
+		"logins_24h": logins,
+	})
+}
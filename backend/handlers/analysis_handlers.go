@@ -0,0 +1,492 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trainapp/database"
+	"trainapp/models"
+	"trainapp/services"
+)
+
+// analysisSelectQuery selecciona las columnas que lee scanWorkoutAnalysis
+const analysisSelectQuery = `
+	SELECT id, user_id, workout_id, extraction_id, parent_id, kind, source, version,
+	       COALESCE(question, ''), analysis, recommendations,
+	       COALESCE(model, ''), COALESCE(prompt_version, ''), input_snapshot, created_at
+	FROM workout_analyses`
+
+func scanWorkoutAnalysis(row interface{ Scan(...interface{}) error }) (models.WorkoutAnalysis, error) {
+	var a models.WorkoutAnalysis
+	var workoutID, extractionID, parentID sql.NullInt64
+	var recommendations, snapshot sql.NullString
+	err := row.Scan(&a.ID, &a.UserID, &workoutID, &extractionID, &parentID, &a.Kind, &a.Source, &a.Version,
+		&a.Question, &a.Analysis, &recommendations, &a.Model, &a.PromptVersion, &snapshot, &a.CreatedAt)
+	if err != nil {
+		return a, err
+	}
+
+	a.WorkoutID = nullableInt(workoutID)
+	a.ExtractionID = nullableInt(extractionID)
+	a.ParentID = nullableInt(parentID)
+	if snapshot.Valid && snapshot.String != "" {
+		a.InputSnapshot = json.RawMessage(snapshot.String)
+	}
+	// Los análisis anteriores a las recomendaciones estructuradas guardan una cadena vacía
+	if recommendations.Valid && strings.HasPrefix(recommendations.String, "{") {
+		var r models.Recommendations
+		if err := json.Unmarshal([]byte(recommendations.String), &r); err == nil {
+			a.Recommendations = &r
+		}
+	}
+	return a, nil
+}
+
+func nullableInt(value sql.NullInt64) *int {
+	if !value.Valid {
+		return nil
+	}
+	n := int(value.Int64)
+	return &n
+}
+
+// newWorkoutAnalysis prepara un análisis con el modelo actual y los datos enviados como snapshot
+func newWorkoutAnalysis(userID int, source, promptVersion string, input interface{}, analysis string) *models.WorkoutAnalysis {
+	a := &models.WorkoutAnalysis{
+		UserID:        userID,
+		Kind:          "analysis",
+		Source:        source,
+		Analysis:      analysis,
+		Model:         services.CoachModel,
+		PromptVersion: promptVersion,
+	}
+	if snapshot, err := json.Marshal(input); err == nil && input != nil {
+		a.InputSnapshot = snapshot
+	}
+	return a
+}
+
+// attachRecommendations extrae las recomendaciones estructuradas del texto del análisis en el idioma
+// del corredor; si falla, el análisis se guarda igualmente sin ellas
+func attachRecommendations(r *http.Request, a *models.WorkoutAnalysis) {
+	recommendations, err := services.ExtractRecommendations(llmSession(r), a.Analysis)
+	if err != nil {
+		log.Printf("⚠️  Error extrayendo recomendaciones: %v", err)
+		return
+	}
+	a.Recommendations = recommendations
+}
+
+// saveWorkoutAnalysis guarda el análisis como siguiente versión de su workout (la 1 si no tiene)
+// y completa ID, versión y fecha
+func saveWorkoutAnalysis(a *models.WorkoutAnalysis) error {
+	var snapshot, recommendations interface{}
+	if len(a.InputSnapshot) > 0 {
+		snapshot = string(a.InputSnapshot)
+	}
+	if a.Recommendations != nil {
+		data, err := json.Marshal(a.Recommendations)
+		if err != nil {
+			return err
+		}
+		recommendations = string(data)
+	}
+
+	version := `(SELECT COALESCE(MAX(version), 0) + 1 FROM workout_analyses WHERE workout_id = ? AND kind = 'analysis')`
+	versionArgs := []interface{}{a.WorkoutID}
+	if a.Kind == "follow_up" {
+		version = "?"
+		versionArgs = []interface{}{a.Version}
+	}
+
+	args := append([]interface{}{a.UserID, a.WorkoutID, a.ExtractionID, a.ParentID, a.Kind, a.Source}, versionArgs...)
+	args = append(args, a.Question, a.Analysis, recommendations, a.Model, a.PromptVersion, snapshot)
+
+	result, err := database.DB.Exec(`
+		INSERT INTO workout_analyses (user_id, workout_id, extraction_id, parent_id, kind, source, version,
+		                              question, analysis, recommendations, model, prompt_version, input_snapshot)
+		VALUES (?, ?, ?, ?, ?, ?, `+version+`, ?, ?, ?, ?, ?, ?)`, args...)
+	if err != nil {
+		return err
+	}
+
+	id, _ := result.LastInsertId()
+	a.ID = int(id)
+	return database.DB.QueryRow(`SELECT version, created_at FROM workout_analyses WHERE id = ?`, a.ID).
+		Scan(&a.Version, &a.CreatedAt)
+}
+
+// followUpParent busca el análisis al que responde una pregunta: el indicado en analysisID
+// (o su análisis principal si es a su vez un seguimiento) o, si no, el último del workout
+func followUpParent(userID int, analysisID *int, workoutID int) *models.WorkoutAnalysis {
+	if analysisID != nil {
+		parent, err := loadWorkoutAnalysis(userID, *analysisID)
+		if err != nil {
+			return nil
+		}
+		if parent.ParentID != nil {
+			if root, err := loadWorkoutAnalysis(userID, *parent.ParentID); err == nil {
+				return root
+			}
+		}
+		return parent
+	}
+
+	if workoutID > 0 {
+		analyses, err := loadWorkoutAnalyses(userID, "workout_id = ? AND kind = 'analysis'", 1, workoutID)
+		if err == nil && len(analyses) > 0 {
+			return &analyses[0]
+		}
+	}
+
+	return nil
+}
+
+// saveFollowUp guarda una pregunta de seguimiento y su respuesta. Si se conoce el análisis al que
+// responde, hereda su workout, extracción y versión. Los errores solo se registran.
+func saveFollowUp(userID int, parent *models.WorkoutAnalysis, source, question, answer string) *models.WorkoutAnalysis {
+	followUp := &models.WorkoutAnalysis{
+		UserID:   userID,
+		Kind:     "follow_up",
+		Source:   source,
+		Version:  1,
+		Question: question,
+		Analysis: answer,
+		Model:    services.CoachModel,
+	}
+	if parent != nil {
+		followUp.WorkoutID = parent.WorkoutID
+		followUp.ExtractionID = parent.ExtractionID
+		followUp.ParentID = &parent.ID
+		followUp.Source = parent.Source
+		followUp.Version = parent.Version
+	}
+
+	if err := saveWorkoutAnalysis(followUp); err != nil {
+		log.Printf("⚠️  Error guardando pregunta de seguimiento: %v", err)
+		return nil
+	}
+	return followUp
+}
+
+// loadWorkoutAnalysis obtiene un análisis del usuario con sus preguntas de seguimiento
+func loadWorkoutAnalysis(userID, id int) (*models.WorkoutAnalysis, error) {
+	a, err := scanWorkoutAnalysis(database.DB.QueryRow(analysisSelectQuery+`
+		WHERE id = ? AND user_id = ?`, id, userID))
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Kind == "analysis" {
+		followUps, err := loadWorkoutAnalyses(userID, "parent_id = ?", 0, a.ID)
+		if err != nil {
+			return nil, err
+		}
+		a.FollowUps = followUps
+	}
+
+	return &a, nil
+}
+
+// loadWorkoutAnalyses obtiene los análisis del usuario que cumplen where, de más reciente a más
+// antiguo (los seguimientos en orden cronológico); limit 0 significa sin límite
+func loadWorkoutAnalyses(userID int, where string, limit int, args ...interface{}) ([]models.WorkoutAnalysis, error) {
+	order := "created_at DESC, id DESC"
+	if strings.Contains(where, "parent_id") {
+		order = "created_at, id"
+	}
+
+	query := analysisSelectQuery + ` WHERE user_id = ?`
+	if where != "" {
+		query += " AND " + where
+	}
+	query += " ORDER BY " + order
+	if limit > 0 {
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
+
+	rows, err := database.DB.Query(query, append([]interface{}{userID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	analyses := []models.WorkoutAnalysis{}
+	for rows.Next() {
+		a, err := scanWorkoutAnalysis(rows)
+		if err != nil {
+			return nil, err
+		}
+		analyses = append(analyses, a)
+	}
+
+	return analyses, rows.Err()
+}
+
+// workoutAnalysisHistory devuelve el último análisis del workout (con sus seguimientos) y las
+// versiones anteriores
+func workoutAnalysisHistory(userID, workoutID int) (*models.WorkoutAnalysis, []models.WorkoutAnalysis, error) {
+	analyses, err := loadWorkoutAnalyses(userID, "workout_id = ? AND kind = 'analysis'", 0, workoutID)
+	if err != nil || len(analyses) == 0 {
+		return nil, []models.WorkoutAnalysis{}, err
+	}
+
+	latest, err := loadWorkoutAnalysis(userID, analyses[0].ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	history := analyses[1:]
+	for i := range history {
+		history[i].InputSnapshot = nil
+	}
+	return latest, history, nil
+}
+
+// AnalysesHandler lista los análisis del usuario (GET /api/analyses?workout_id=&limit=)
+func AnalysesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	limit, ok := listLimit(w, r)
+	if !ok {
+		return
+	}
+
+	where := "kind = 'analysis'"
+	var args []interface{}
+	if value := r.URL.Query().Get("workout_id"); value != "" {
+		workoutID, err := strconv.Atoi(value)
+		if err != nil {
+			httpError(w, r, "ID de workout inválido", http.StatusBadRequest)
+			return
+		}
+		where += " AND workout_id = ?"
+		args = append(args, workoutID)
+	}
+
+	analyses, err := loadWorkoutAnalyses(userID, where, limit, args...)
+	if err != nil {
+		log.Printf("Error obteniendo análisis: %v", err)
+		httpError(w, r, "Error obteniendo análisis", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(analyses)
+}
+
+// AnalysisDetailHandler maneja GET y DELETE /api/analyses/:id. Al borrar un análisis se
+// borran también sus preguntas de seguimiento.
+func AnalysisDetailHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value("userID").(int)
+
+	id, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/analyses/"), "/"))
+	if err != nil {
+		httpError(w, r, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	analysis, err := loadWorkoutAnalysis(userID, id)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Análisis no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo análisis %d: %v", id, err)
+		httpError(w, r, "Error obteniendo análisis", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(analysis)
+	case "DELETE":
+		if _, err := database.DB.Exec(`
+			DELETE FROM workout_analyses WHERE user_id = ? AND (id = ? OR parent_id = ?)`,
+			userID, id, id); err != nil {
+			log.Printf("Error borrando análisis %d: %v", id, err)
+			httpError(w, r, "Error borrando análisis", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// AdviceHandler devuelve los consejos vigentes del usuario (GET /api/advice): acciones de las
+// próximas horas, recuperación y alertas, agregadas a partir de los últimos análisis de cada entreno
+func AdviceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+	now := time.Now()
+
+	// Solo cuenta la última versión del análisis de cada entreno
+	analyses, err := loadWorkoutAnalyses(userID, `kind = 'analysis' AND recommendations LIKE '{%' AND created_at >= ?
+		AND (workout_id IS NULL OR version = (
+			SELECT MAX(version) FROM workout_analyses latest
+			WHERE latest.workout_id = workout_analyses.workout_id AND latest.kind = 'analysis'))`,
+		0, now.Add(-services.AdviceFlagWindow).UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		log.Printf("Error obteniendo consejos: %v", err)
+		httpError(w, r, "Error obteniendo consejos", http.StatusInternalServerError)
+		return
+	}
+
+	sources := make([]services.AdviceSource, 0, len(analyses))
+	for _, a := range analyses {
+		if a.Recommendations == nil {
+			continue
+		}
+		sources = append(sources, services.AdviceSource{
+			AnalysisID:      a.ID,
+			WorkoutID:       a.WorkoutID,
+			CreatedAt:       a.CreatedAt,
+			Recommendations: *a.Recommendations,
+		})
+	}
+
+	json.NewEncoder(w).Encode(services.BuildAdviceFeed(sources, now))
+}
+
+// progressReportSelectQuery selecciona las columnas que lee scanProgressReport
+const progressReportSelectQuery = `
+	SELECT id, user_id, period_start, period_end, report,
+	       COALESCE(model, ''), COALESCE(prompt_version, ''), created_at
+	FROM progress_reports`
+
+func scanProgressReport(row interface{ Scan(...interface{}) error }) (models.ProgressReport, error) {
+	var p models.ProgressReport
+	err := row.Scan(&p.ID, &p.UserID, &p.PeriodStart, &p.PeriodEnd, &p.Report,
+		&p.Model, &p.PromptVersion, &p.CreatedAt)
+	return p, err
+}
+
+// ProgressReportsHandler lista los informes de progreso del usuario (GET /api/progress-reports?limit=)
+func ProgressReportsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	limit, ok := listLimit(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := database.DB.Query(progressReportSelectQuery+`
+		WHERE user_id = ?
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?`, userID, limit)
+	if err != nil {
+		log.Printf("Error obteniendo informes: %v", err)
+		httpError(w, r, "Error obteniendo informes", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	reports := []models.ProgressReport{}
+	for rows.Next() {
+		report, err := scanProgressReport(rows)
+		if err != nil {
+			log.Printf("Error escaneando informe: %v", err)
+			continue
+		}
+		reports = append(reports, report)
+	}
+
+	json.NewEncoder(w).Encode(reports)
+}
+
+// ProgressReportDetailHandler maneja GET y DELETE /api/progress-reports/:id
+func ProgressReportDetailHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value("userID").(int)
+
+	id, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/progress-reports/"), "/"))
+	if err != nil {
+		httpError(w, r, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	report, err := scanProgressReport(database.DB.QueryRow(progressReportSelectQuery+`
+		WHERE id = ? AND user_id = ?`, id, userID))
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Informe no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo informe %d: %v", id, err)
+		httpError(w, r, "Error obteniendo informe", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(report)
+	case "DELETE":
+		if _, err := database.DB.Exec(`DELETE FROM progress_reports WHERE id = ? AND user_id = ?`, id, userID); err != nil {
+			log.Printf("Error borrando informe %d: %v", id, err)
+			httpError(w, r, "Error borrando informe", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// saveProgressReport guarda un informe generado con el modelo y prompt actuales
+func saveProgressReport(userID int, periodStart, periodEnd time.Time, report string) (int, error) {
+	result, err := database.DB.Exec(`
+		INSERT INTO progress_reports (user_id, period_start, period_end, report, model, prompt_version)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, periodStart, periodEnd, report, services.CoachModel, services.ActivePromptVersion(services.PromptProgressReport))
+	if err != nil {
+		return 0, err
+	}
+
+	id, _ := result.LastInsertId()
+	return int(id), nil
+}
+
+// listLimit lee el parámetro ?limit (20 por defecto, máximo 100)
+func listLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			httpError(w, r, "Límite inválido", http.StatusBadRequest)
+			return 0, false
+		}
+		limit = n
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	return limit, true
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trainapp/database"
+	"trainapp/services"
+)
+
+// Claves de API activas que puede tener un usuario y caducidad máxima en días
+const (
+	maxAPIKeysPerUser = 20
+	maxAPIKeyDays     = 365
+)
+
+// APIKeyInfo es una clave de API personal tal como se lista: la clave completa solo se muestra
+// al crearla
+type APIKeyInfo struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Key        string     `json:"key,omitempty"` // solo en la respuesta de creación
+}
+
+// apiKeyRequest contiene los campos editables de una clave; los campos nil no se modifican
+type apiKeyRequest struct {
+	Name          *string   `json:"name"`
+	Scopes        *[]string `json:"scopes"`
+	ExpiresInDays *int      `json:"expires_in_days"` // desde ahora; 0 para que no caduque
+}
+
+// apply copia los campos presentes en la petición sobre k y valida el resultado
+func (req apiKeyRequest) apply(k *APIKeyInfo) string {
+	if req.Name != nil {
+		k.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Scopes != nil {
+		scopes, err := services.NormalizeScopes(*req.Scopes)
+		if err != nil {
+			return "Scopes inválidos (workouts:read, workouts:write, coach, strava:sync)"
+		}
+		k.Scopes = scopes
+	}
+	if req.ExpiresInDays != nil {
+		days := *req.ExpiresInDays
+		if days < 0 || days > maxAPIKeyDays {
+			return "expires_in_days debe estar entre 0 y 365"
+		}
+		k.ExpiresAt = nil
+		if days > 0 {
+			expiresAt := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+			k.ExpiresAt = &expiresAt
+		}
+	}
+
+	if k.Name == "" || len(k.Name) > 100 {
+		return "El nombre es requerido (máximo 100 caracteres)"
+	}
+	if len(k.Scopes) == 0 {
+		return "Scopes inválidos (workouts:read, workouts:write, coach, strava:sync)"
+	}
+	return ""
+}
+
+// LookupAPIKey busca una clave de API no revocada por su hash; se usa al autenticar cada
+// petición con clave y de paso registra su último uso
+func LookupAPIKey(hash, ip string) (*services.APIKey, error) {
+	key := &services.APIKey{}
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := database.DB.QueryRow(`
+		SELECT k.id, k.user_id, u.email, u.name, k.scopes, k.expires_at, k.last_used_at
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = ? AND k.revoked_at IS NULL AND u.disabled_at IS NULL`, hash).Scan(
+		&key.ID, &key.UserID, &key.Email, &key.Name, &scopes, &expiresAt, &lastUsedAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("⚠️  Error buscando clave de API: %v", err)
+		}
+		return nil, err
+	}
+	key.Scopes = strings.Split(scopes, ",")
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+
+	now := time.Now()
+	if !lastUsedAt.Valid || now.Sub(lastUsedAt.Time) > sessionTouchInterval {
+		database.DB.Exec("UPDATE api_keys SET last_used_at = ?, last_used_ip = ? WHERE id = ?", now, ip, key.ID)
+	}
+	return key, nil
+}
+
+// scanAPIKey lee una clave de API de una fila de api_keys
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKeyInfo, error) {
+	k := &APIKeyInfo{}
+	var scopes, lastUsedIP sql.NullString
+	var expiresAt, lastUsedAt sql.NullTime
+	if err := row.Scan(&k.ID, &k.Name, &k.Prefix, &scopes, &expiresAt, &lastUsedAt, &lastUsedIP, &k.CreatedAt); err != nil {
+		return nil, err
+	}
+	k.Scopes = strings.Split(scopes.String, ",")
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	k.LastUsedIP = lastUsedIP.String
+	return k, nil
+}
+
+const apiKeyColumns = `id, name, prefix, scopes, expires_at, last_used_at, last_used_ip, created_at`
+
+// APIKeysHandler maneja GET (listar) y POST (crear) las claves de API del usuario
+func APIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value("userID").(int)
+
+	switch r.Method {
+	case "GET":
+		rows, err := database.DB.Query(`
+			SELECT `+apiKeyColumns+`
+			FROM api_keys
+			WHERE user_id = ? AND revoked_at IS NULL
+			ORDER BY created_at DESC`, userID)
+		if err != nil {
+			log.Printf("Error obteniendo claves de API: %v", err)
+			httpError(w, r, "Error obteniendo claves de API", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		keys := []*APIKeyInfo{}
+		for rows.Next() {
+			k, err := scanAPIKey(rows)
+			if err != nil {
+				log.Printf("Error escaneando clave de API: %v", err)
+				continue
+			}
+			keys = append(keys, k)
+		}
+
+		json.NewEncoder(w).Encode(keys)
+	case "POST":
+		var req apiKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+			return
+		}
+
+		k := &APIKeyInfo{CreatedAt: time.Now()}
+		if msg := req.apply(k); msg != "" {
+			httpError(w, r, msg, http.StatusBadRequest)
+			return
+		}
+
+		var count int
+		database.DB.QueryRow(`
+			SELECT COUNT(*) FROM api_keys WHERE user_id = ? AND revoked_at IS NULL`, userID).Scan(&count)
+		if count >= maxAPIKeysPerUser {
+			httpError(w, r, "Has alcanzado el máximo de claves de API", http.StatusConflict)
+			return
+		}
+
+		key, hash, prefix, err := services.NewAPIKey()
+		if err != nil {
+			httpError(w, r, "Error generando token", http.StatusInternalServerError)
+			return
+		}
+		result, err := database.DB.Exec(`
+			INSERT INTO api_keys (user_id, name, key_hash, prefix, scopes, expires_at, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			userID, k.Name, hash, prefix, strings.Join(k.Scopes, ","), k.ExpiresAt, k.CreatedAt)
+		if err != nil {
+			log.Printf("Error creando clave de API: %v", err)
+			httpError(w, r, "Error creando clave de API", http.StatusInternalServerError)
+			return
+		}
+
+		k.ID, _ = result.LastInsertId()
+		k.Prefix = prefix
+		k.Key = key
+		log.Printf("🔑 Clave de API creada: usuario %d (%s)", userID, prefix)
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(k)
+	default:
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// APIKeyDetailHandler maneja PUT (nombre, scopes y caducidad) y DELETE (revocar) de
+// /api/api-keys/:id
+func APIKeyDetailHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value("userID").(int)
+
+	id, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/api-keys/"), "/"), 10, 64)
+	if err != nil {
+		httpError(w, r, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	k, err := scanAPIKey(database.DB.QueryRow(`
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE id = ? AND user_id = ? AND revoked_at IS NULL`, id, userID))
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Clave de API no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "Error obteniendo claves de API", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		var req apiKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+			return
+		}
+		if msg := req.apply(k); msg != "" {
+			httpError(w, r, msg, http.StatusBadRequest)
+			return
+		}
+
+		if _, err := database.DB.Exec(`
+			UPDATE api_keys SET name = ?, scopes = ?, expires_at = ? WHERE id = ? AND user_id = ?`,
+			k.Name, strings.Join(k.Scopes, ","), k.ExpiresAt, id, userID); err != nil {
+			log.Printf("Error actualizando clave de API: %v", err)
+			httpError(w, r, "Error actualizando clave de API", http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(k)
+	case "DELETE":
+		if _, err := database.DB.Exec(`
+			UPDATE api_keys SET revoked_at = ? WHERE id = ? AND user_id = ?`, time.Now(), id, userID); err != nil {
+			httpError(w, r, "Error revocando clave de API", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("🔑 Clave de API revocada: usuario %d (%s)", userID, k.Prefix)
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
@@ -16,6 +16,7 @@ type RegisterRequest struct {
 	Name     string `json:"name"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Locale   string `json:"locale"` // opcional; por defecto el idioma de Accept-Language
 }
 
 // LoginRequest representa una solicitud de login
@@ -24,54 +25,65 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
-// AuthResponse representa la respuesta de autenticación
-type AuthResponse struct {
-	Token string      `json:"token"`
-	User  UserProfile `json:"user"`
-}
-
 // UserProfile representa el perfil público del usuario
 type UserProfile struct {
-	ID    int    `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Locale        string `json:"locale"`
+	Role          string `json:"role"`
 }
 
+// dummyPasswordHash es un hash bcrypt con el que se compara la contraseña cuando el email no
+// existe, para que el tiempo de respuesta no revele qué cuentas hay
+const dummyPasswordHash = "$2a$10$UAEUaaQlPozVV3wK9rKGL.uwbcUFEQ9bKHv5ttBeprJnYf/w1S4z6"
+
 // RegisterHandler maneja el registro de nuevos usuarios
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
 		return
 	}
 
 	var req RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Datos inválidos", http.StatusBadRequest)
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
 		return
 	}
 
 	// Validaciones
 	if req.Name == "" || req.Email == "" || req.Password == "" {
-		http.Error(w, "Todos los campos son requeridos", http.StatusBadRequest)
+		httpError(w, r, "Todos los campos son requeridos", http.StatusBadRequest)
 		return
 	}
 
-	if !strings.Contains(req.Email, "@") {
-		http.Error(w, "Email inválido", http.StatusBadRequest)
+	email, err := services.NormalizeEmail(req.Email)
+	if err != nil {
+		httpError(w, r, "Email inválido", http.StatusBadRequest)
 		return
 	}
+	req.Email = email
+
+	locale := services.LocaleFromAcceptLanguage(r.Header.Get("Accept-Language"))
+	if req.Locale != "" {
+		if locale = services.NormalizeLocale(req.Locale); locale == "" {
+			httpError(w, r, "Idioma no soportado (es, en)", http.StatusBadRequest)
+			return
+		}
+	}
 
 	// Verificar si el email ya existe
 	var exists int
-	err := database.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", req.Email).Scan(&exists)
+	err = database.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ? COLLATE NOCASE", req.Email).Scan(&exists)
 	if err != nil {
 		log.Printf("Error verificando email: %v", err)
-		http.Error(w, "Error interno del servidor", http.StatusInternalServerError)
+		httpError(w, r, "Error interno del servidor", http.StatusInternalServerError)
 		return
 	}
 
 	if exists > 0 {
-		http.Error(w, "El email ya está registrado", http.StatusConflict)
+		httpError(w, r, "El email ya está registrado", http.StatusConflict)
 		return
 	}
 
@@ -79,19 +91,19 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	authService := services.GetAuthService()
 	passwordHash, err := authService.HashPassword(req.Password)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Insertar usuario
 	result, err := database.DB.Exec(`
-		INSERT INTO users (name, email, password_hash)
-		VALUES (?, ?, ?)
-	`, req.Name, req.Email, passwordHash)
+		INSERT INTO users (name, email, password_hash, locale)
+		VALUES (?, ?, ?, ?)
+	`, req.Name, req.Email, passwordHash, locale)
 
 	if err != nil {
 		log.Printf("Error creando usuario: %v", err)
-		http.Error(w, "Error creando usuario", http.StatusInternalServerError)
+		httpError(w, r, "Error creando usuario", http.StatusInternalServerError)
 		return
 	}
 
@@ -108,23 +120,21 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		// No es crítico, continuamos
 	}
 
-	// Generar token
-	token, err := authService.GenerateToken(int(userID), req.Email, req.Name)
-	if err != nil {
-		http.Error(w, "Error generando token", http.StatusInternalServerError)
-		return
+	user := UserProfile{
+		ID:     int(userID),
+		Name:   req.Name,
+		Email:  req.Email,
+		Locale: locale,
+		Role:   services.AccountRoleUser,
 	}
 
-	// Respuesta
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(AuthResponse{
-		Token: token,
-		User: UserProfile{
-			ID:    int(userID),
-			Name:  req.Name,
-			Email: req.Email,
-		},
-	})
+	// Enviar el enlace de verificación; si falla se puede pedir otro más tarde
+	if err := sendVerificationEmail(r, user); err != nil {
+		log.Printf("⚠️  Error enviando verificación a %s: %v", user.Email, err)
+	}
+
+	// Abrir la sesión y responder con sus tokens
+	writeAuthResponse(w, r, user)
 
 	log.Printf("✅ Usuario registrado: %s (%s)", req.Name, req.Email)
 }
@@ -132,60 +142,96 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 // LoginHandler maneja el inicio de sesión
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
 		return
 	}
 
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Datos inválidos", http.StatusBadRequest)
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+
+	// Los intentos se cuentan por email, exista o no la cuenta, para no revelar cuáles existen
+	attemptEmail := strings.ToLower(strings.TrimSpace(req.Email))
+	if attemptEmail == "" {
+		httpError(w, r, "Credenciales inválidas", http.StatusUnauthorized)
+		return
+	}
+
+	// Cuenta bloqueada temporalmente por intentos fallidos
+	wait, err := loginLockedFor(attemptEmail)
+	if err != nil {
+		log.Printf("⚠️  Error comprobando bloqueo de %s: %v", attemptEmail, err)
+	}
+	if wait > 0 {
+		recordLoginAttempt(r, attemptEmail, 0, false, loginLocked)
+		writeLockedOut(w, r, wait)
 		return
 	}
 
 	// Buscar usuario por email
 	var userID int
-	var name, email, passwordHash string
+	var name, email, passwordHash, locale, role string
+	var verifiedAt, disabledAt sql.NullTime
 
-	err := database.DB.QueryRow(`
-		SELECT id, name, email, password_hash
+	err = database.DB.QueryRow(`
+		SELECT id, name, email, password_hash, COALESCE(locale, ''), email_verified_at, role, disabled_at
 		FROM users
-		WHERE email = ?
-	`, req.Email).Scan(&userID, &name, &email, &passwordHash)
+		WHERE email = ? COLLATE NOCASE
+	`, attemptEmail).Scan(&userID, &name, &email, &passwordHash, &locale, &verifiedAt, &role, &disabledAt)
 
+	authService := services.GetAuthService()
 	if err == sql.ErrNoRows {
-		http.Error(w, "Credenciales inválidas", http.StatusUnauthorized)
+		// Se compara igualmente una contraseña para que la respuesta tarde lo mismo
+		authService.VerifyPassword(req.Password, dummyPasswordHash)
+		recordLoginAttempt(r, attemptEmail, 0, false, loginUnknownUser)
+		httpError(w, r, "Credenciales inválidas", http.StatusUnauthorized)
 		return
 	}
 
 	if err != nil {
 		log.Printf("Error buscando usuario: %v", err)
-		http.Error(w, "Error interno del servidor", http.StatusInternalServerError)
+		httpError(w, r, "Error interno del servidor", http.StatusInternalServerError)
 		return
 	}
 
 	// Verificar contraseña
-	authService := services.GetAuthService()
 	if !authService.VerifyPassword(req.Password, passwordHash) {
-		http.Error(w, "Credenciales inválidas", http.StatusUnauthorized)
+		recordLoginAttempt(r, attemptEmail, userID, false, loginWrongPassword)
+		httpError(w, r, "Credenciales inválidas", http.StatusUnauthorized)
 		return
 	}
 
-	// Generar token
-	token, err := authService.GenerateToken(userID, email, name)
+	// Las cuentas desactivadas por un administrador no pueden iniciar sesión
+	if disabledAt.Valid {
+		recordLoginAttempt(r, attemptEmail, userID, false, loginDisabled)
+		httpError(w, r, "Cuenta desactivada", http.StatusForbidden)
+		return
+	}
+
+	// Con 2FA la sesión no se abre hasta comprobar el código; el intento se registra entonces
+	mfa, err := twoFactorEnabled(userID)
 	if err != nil {
-		http.Error(w, "Error generando token", http.StatusInternalServerError)
+		log.Printf("Error comprobando 2FA: %v", err)
+		httpError(w, r, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+	if mfa {
+		writeMFAChallenge(w, r, userID, attemptEmail)
+		log.Printf("🔐 Login pendiente del segundo factor: %s", email)
 		return
 	}
+	recordLoginAttempt(r, attemptEmail, userID, true, "")
 
-	// Respuesta
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(AuthResponse{
-		Token: token,
-		User: UserProfile{
-			ID:    userID,
-			Name:  name,
-			Email: email,
-		},
+	// Abrir la sesión y responder con sus tokens
+	writeAuthResponse(w, r, UserProfile{
+		ID:            userID,
+		Name:          name,
+		Email:         email,
+		EmailVerified: verifiedAt.Valid,
+		Locale:        locale,
+		Role:          role,
 	})
 
 	log.Printf("✅ Login exitoso: %s", email)
@@ -196,20 +242,12 @@ func MeHandler(w http.ResponseWriter, r *http.Request) {
 	// Obtener userID del contexto (añadido por el middleware)
 	userID := r.Context().Value("userID").(int)
 
-	var name, email string
-	err := database.DB.QueryRow(`
-		SELECT name, email FROM users WHERE id = ?
-	`, userID).Scan(&name, &email)
-
+	user, err := loadUserProfile(userID)
 	if err != nil {
-		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		httpError(w, r, "Usuario no encontrado", http.StatusNotFound)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(UserProfile{
-		ID:    userID,
-		Name:  name,
-		Email: email,
-	})
+	json.NewEncoder(w).Encode(user)
 }
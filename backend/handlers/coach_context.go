@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"trainapp/database"
+	"trainapp/models"
+	"trainapp/services"
+)
+
+// workoutSelectQuery selecciona los campos de workouts en el orden que espera scanWorkout
+const workoutSelectQuery = `
+	SELECT id, user_id, date, type, COALESCE(distance, 0), COALESCE(duration, 0), COALESCE(avg_pace, ''),
+	       COALESCE(avg_heart_rate, 0), COALESCE(avg_power, 0), COALESCE(cadence, 0),
+	       COALESCE(elevation_gain, 0), COALESCE(calories, 0), COALESCE(notes, ''),
+	       COALESCE(feeling, ''), gear_id, created_at
+	FROM workouts`
+
+// coachContext genera la ficha del corredor que se inyecta en cada llamada al coach.
+// Los errores parciales se registran y la ficha se construye con los datos disponibles.
+func coachContext(userID int) string {
+	now := time.Now().UTC()
+	dossier := services.RunnerDossier{}
+
+	profile, err := loadRunnerProfile(userID)
+	if err != nil {
+		log.Printf("⚠️  Error cargando perfil para el coach (usuario %d): %v", userID, err)
+	}
+	dossier.Profile = profile
+
+	if races, err := loadUserRaces(userID, "upcoming"); err == nil {
+		dossier.UpcomingRaces = races
+	} else {
+		log.Printf("⚠️  Error cargando carreras para el coach (usuario %d): %v", userID, err)
+	}
+
+	if plan, err := loadActivePlan(userID); err == nil {
+		dossier.ActivePlan = plan
+	} else {
+		log.Printf("⚠️  Error cargando plan activo para el coach (usuario %d): %v", userID, err)
+	}
+
+	recent, err := loadUserWorkouts(userID, `date >= ?`, now.AddDate(0, 0, -28))
+	if err != nil {
+		log.Printf("⚠️  Error cargando entrenos para el coach (usuario %d): %v", userID, err)
+	}
+	dossier.RecentWorkouts = recent
+	dossier.Load = services.ComputeLoadMetrics(recent, now)
+
+	dossier.Records, err = personalRecords(userID)
+	if err != nil {
+		log.Printf("⚠️  Error calculando marcas para el coach (usuario %d): %v", userID, err)
+	}
+
+	return dossier.Format(now, services.ContextTokenBudget())
+}
+
+// personalRecords calcula las mejores marcas del usuario a partir de entrenos y resultados de carrera
+func personalRecords(userID int) ([]services.PersonalRecord, error) {
+	candidates, err := loadUserWorkouts(userID, `distance >= 5 AND distance <= 45`)
+	if err != nil {
+		return nil, err
+	}
+
+	races, err := loadUserRaces(userID, "past")
+	if err != nil {
+		return nil, err
+	}
+
+	return services.ComputePersonalRecords(candidates, races), nil
+}
+
+// loadUserWorkouts obtiene los workouts del usuario que cumplen la condición, más recientes primero
+func loadUserWorkouts(userID int, where string, args ...interface{}) ([]models.Workout, error) {
+	query := workoutSelectQuery + ` WHERE user_id = ?`
+	if where != "" {
+		query += ` AND ` + where
+	}
+	query += ` ORDER BY date DESC`
+
+	rows, err := database.DB.Query(query, append([]interface{}{userID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workouts := []models.Workout{}
+	for rows.Next() {
+		workout, err := scanWorkout(rows)
+		if err != nil {
+			return nil, err
+		}
+		workouts = append(workouts, *workout)
+	}
+
+	return workouts, rows.Err()
+}
+
+// scanWorkout lee un workout de una fila obtenida con workoutSelectQuery
+func scanWorkout(row interface{ Scan(...interface{}) error }) (*models.Workout, error) {
+	var w models.Workout
+	var gearID sql.NullInt64
+	if err := row.Scan(&w.ID, &w.UserID, &w.Date, &w.Type, &w.Distance,
+		&w.Duration, &w.AvgPace, &w.AvgHeartRate, &w.AvgPower, &w.Cadence,
+		&w.ElevationGain, &w.Calories, &w.Notes, &w.Feeling, &gearID, &w.CreatedAt); err != nil {
+		return nil, err
+	}
+	if gearID.Valid {
+		id := int(gearID.Int64)
+		w.GearID = &id
+	}
+	return &w, nil
+}
+
+// loadActivePlan devuelve el plan activo del usuario, o nil si no tiene
+func loadActivePlan(userID int) (*models.TrainingPlan, error) {
+	var plan models.TrainingPlan
+	var raceID sql.NullInt64
+	var blocks sql.NullString
+	err := database.DB.QueryRow(`
+		SELECT id, user_id, goal, start_date, end_date, plan, status, race_id, blocks,
+		       COALESCE(model, ''), COALESCE(prompt_version, ''), created_at
+		FROM training_plans
+		WHERE user_id = ? AND status = 'active'
+		ORDER BY created_at DESC, id DESC LIMIT 1`, userID).Scan(
+		&plan.ID, &plan.UserID, &plan.Goal, &plan.StartDate, &plan.EndDate, &plan.Plan,
+		&plan.Status, &raceID, &blocks, &plan.Model, &plan.PromptVersion, &plan.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if raceID.Valid {
+		id := int(raceID.Int64)
+		plan.RaceID = &id
+	}
+	if blocks.Valid && blocks.String != "" {
+		if err := json.Unmarshal([]byte(blocks.String), &plan.Blocks); err != nil {
+			log.Printf("Error parsing bloques del plan %d: %v", plan.ID, err)
+		}
+	}
+
+	return &plan, nil
+}
@@ -0,0 +1,460 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trainapp/database"
+	"trainapp/models"
+	"trainapp/services"
+)
+
+// Límite de entrenos que devuelve list_workouts en una llamada
+const maxToolWorkouts = 100
+
+// coachSession prepara la sesión del coach para el usuario autenticado: su ficha,
+// las herramientas con acceso a sus datos, la auditoría de las llamadas y el registro de consumo
+func coachSession(r *http.Request) services.CoachSession {
+	session := llmSession(r)
+	session.RunnerContext = coachContext(session.UserID)
+	session.Tools = coachTools(session.UserID)
+	session.Audit = auditToolCall
+	return session
+}
+
+// coachTools devuelve las herramientas del coach limitadas a los datos de userID
+func coachTools(userID int) []services.CoachTool {
+	noParams := map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+
+	return []services.CoachTool{
+		{
+			Name:        "list_workouts",
+			Description: "Lista los entrenos del corredor entre dos fechas (incluidas), más recientes primero.",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"from":  map[string]interface{}{"type": "string", "description": "Fecha inicial YYYY-MM-DD"},
+					"to":    map[string]interface{}{"type": "string", "description": "Fecha final YYYY-MM-DD"},
+					"type":  map[string]interface{}{"type": "string", "description": "Filtrar por tipo: easy, interval, tempo, long_run, race"},
+					"limit": map[string]interface{}{"type": "integer", "description": "Máximo de entrenos (por defecto y como máximo 100)"},
+				},
+				"required": []string{"from", "to"},
+			},
+			Run: func(args json.RawMessage) (interface{}, error) {
+				var req struct {
+					From  string `json:"from"`
+					To    string `json:"to"`
+					Type  string `json:"type"`
+					Limit int    `json:"limit"`
+				}
+				if err := json.Unmarshal(args, &req); err != nil {
+					return nil, errors.New("argumentos inválidos")
+				}
+
+				from, err := time.Parse("2006-01-02", req.From)
+				if err != nil {
+					return nil, errors.New("from debe tener formato YYYY-MM-DD")
+				}
+				to, err := time.Parse("2006-01-02", req.To)
+				if err != nil {
+					return nil, errors.New("to debe tener formato YYYY-MM-DD")
+				}
+				if to.Before(from) {
+					return nil, errors.New("to debe ser posterior a from")
+				}
+
+				where := `date >= ? AND date < ?`
+				params := []interface{}{from, to.AddDate(0, 0, 1)}
+				if req.Type != "" {
+					where += ` AND type = ?`
+					params = append(params, req.Type)
+				}
+
+				workouts, err := loadUserWorkouts(userID, where, params...)
+				if err != nil {
+					return nil, err
+				}
+
+				limit := maxToolWorkouts
+				if req.Limit > 0 && req.Limit < limit {
+					limit = req.Limit
+				}
+				total := len(workouts)
+				if total > limit {
+					workouts = workouts[:limit]
+				}
+
+				return map[string]interface{}{"total": total, "workouts": workouts}, nil
+			},
+		},
+		{
+			Name:        "get_workout",
+			Description: "Devuelve el detalle de un entreno: métricas, series detectadas y vueltas de Strava si las hay.",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"workout_id": map[string]interface{}{"type": "integer"},
+				},
+				"required": []string{"workout_id"},
+			},
+			Run: func(args json.RawMessage) (interface{}, error) {
+				var req struct {
+					WorkoutID int `json:"workout_id"`
+				}
+				if err := json.Unmarshal(args, &req); err != nil || req.WorkoutID <= 0 {
+					return nil, errors.New("workout_id es requerido")
+				}
+				return workoutToolDetail(userID, req.WorkoutID)
+			},
+		},
+		{
+			Name:        "get_load_metrics",
+			Description: "Calcula la carga de entrenamiento (7 y 28 días, ACWR, km por semana) a una fecha dada.",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"date": map[string]interface{}{"type": "string", "description": "Fecha de referencia YYYY-MM-DD (por defecto hoy)"},
+				},
+			},
+			Run: func(args json.RawMessage) (interface{}, error) {
+				var req struct {
+					Date string `json:"date"`
+				}
+				json.Unmarshal(args, &req)
+
+				date := time.Now().UTC()
+				if req.Date != "" {
+					parsed, err := time.Parse("2006-01-02", req.Date)
+					if err != nil {
+						return nil, errors.New("date debe tener formato YYYY-MM-DD")
+					}
+					date = parsed
+				}
+
+				day := date.Truncate(24 * time.Hour)
+				workouts, err := loadUserWorkouts(userID, `date >= ? AND date < ?`, day.AddDate(0, 0, -27), day.AddDate(0, 0, 1))
+				if err != nil {
+					return nil, err
+				}
+
+				return services.ComputeLoadMetrics(workouts, date), nil
+			},
+		},
+		{
+			Name:        "get_personal_records",
+			Description: "Devuelve las mejores marcas del corredor en 5K, 10K, media maratón y maratón.",
+			Parameters:  noParams,
+			Run: func(args json.RawMessage) (interface{}, error) {
+				return personalRecords(userID)
+			},
+		},
+		{
+			Name:        "get_active_plan",
+			Description: "Devuelve el plan de entrenamiento activo con sus bloques de periodización y los cambios propuestos pendientes.",
+			Parameters:  noParams,
+			Run: func(args json.RawMessage) (interface{}, error) {
+				plan, err := loadActivePlan(userID)
+				if err != nil {
+					return nil, err
+				}
+				if plan == nil {
+					return map[string]interface{}{"plan": nil, "message": "El corredor no tiene un plan activo"}, nil
+				}
+
+				proposals, err := loadPlanProposals(userID, "pending")
+				if err != nil {
+					return nil, err
+				}
+
+				return map[string]interface{}{"plan": plan, "pending_proposals": proposals}, nil
+			},
+		},
+		{
+			Name: "propose_plan_change",
+			Description: "Propone un cambio en el plan activo. El cambio no se aplica hasta que el corredor lo acepte; " +
+				"úsalo solo cuando recomiendes modificar sesiones concretas del plan.",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"summary":        map[string]interface{}{"type": "string", "description": "Resumen en una frase"},
+					"changes":        map[string]interface{}{"type": "string", "description": "Sesiones afectadas y cómo quedan"},
+					"effective_date": map[string]interface{}{"type": "string", "description": "Fecha desde la que aplica, YYYY-MM-DD"},
+				},
+				"required": []string{"summary", "changes"},
+			},
+			Run: func(args json.RawMessage) (interface{}, error) {
+				var req struct {
+					Summary       string `json:"summary"`
+					Changes       string `json:"changes"`
+					EffectiveDate string `json:"effective_date"`
+				}
+				if err := json.Unmarshal(args, &req); err != nil {
+					return nil, errors.New("argumentos inválidos")
+				}
+				return createPlanProposal(userID, req.Summary, req.Changes, req.EffectiveDate)
+			},
+		},
+	}
+}
+
+// workoutToolDetail devuelve el detalle de un workout para las herramientas del coach
+func workoutToolDetail(userID, workoutID int) (interface{}, error) {
+	workout, err := scanWorkout(database.DB.QueryRow(workoutSelectQuery+`
+		WHERE id = ? AND user_id = ?`, workoutID, userID))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("workout no encontrado")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	detail := map[string]interface{}{"workout": workout}
+
+	if reps, err := loadWorkoutIntervals(workoutID); err == nil && len(reps) > 0 {
+		detail["intervals"] = reps
+	}
+
+	// Solo las vueltas de Strava: el JSON completo (mapa, streams, segmentos) no cabe en el contexto
+	var stravaDataJSON sql.NullString
+	database.DB.QueryRow(`SELECT strava_data FROM workouts WHERE id = ?`, workoutID).Scan(&stravaDataJSON)
+	if stravaDataJSON.Valid && stravaDataJSON.String != "" {
+		var stravaData map[string]interface{}
+		if json.Unmarshal([]byte(stravaDataJSON.String), &stravaData) == nil {
+			if laps, ok := stravaData["laps"].([]interface{}); ok {
+				compact := make([]map[string]interface{}, 0, len(laps))
+				for _, lap := range laps {
+					if l, ok := lap.(map[string]interface{}); ok {
+						compact = append(compact, map[string]interface{}{
+							"distance":          l["distance"],
+							"moving_time":       l["moving_time"],
+							"average_speed":     l["average_speed"],
+							"average_heartrate": l["average_heartrate"],
+						})
+					}
+				}
+				detail["laps"] = compact
+			}
+		}
+	}
+
+	return detail, nil
+}
+
+// createPlanProposal guarda un cambio de plan propuesto por el coach
+func createPlanProposal(userID int, summary, changes, effectiveDate string) (*models.PlanChangeProposal, error) {
+	summary = strings.TrimSpace(summary)
+	changes = strings.TrimSpace(changes)
+	if summary == "" || changes == "" {
+		return nil, errors.New("summary y changes son requeridos")
+	}
+	if effectiveDate != "" {
+		if _, err := time.Parse("2006-01-02", effectiveDate); err != nil {
+			return nil, errors.New("effective_date debe tener formato YYYY-MM-DD")
+		}
+	}
+
+	plan, err := loadActivePlan(userID)
+	if err != nil {
+		return nil, err
+	}
+	if plan == nil {
+		return nil, errors.New("el corredor no tiene un plan activo")
+	}
+
+	result, err := database.DB.Exec(`
+		INSERT INTO plan_change_proposals (user_id, plan_id, summary, changes, effective_date)
+		VALUES (?, ?, ?, ?, ?)`,
+		userID, plan.ID, summary, changes, nullIfEmpty(effectiveDate))
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := result.LastInsertId()
+	return loadPlanProposal(userID, int(id))
+}
+
+// auditToolCall guarda en llm_tool_calls cada invocación de herramienta del coach
+func auditToolCall(audit services.ToolCallAudit) {
+	if _, err := database.DB.Exec(`
+		INSERT INTO llm_tool_calls (user_id, step, tool, arguments, success, error, result_chars, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		audit.UserID, audit.Step, audit.Tool, audit.Arguments, audit.Success,
+		nullIfEmpty(audit.Error), audit.ResultChars, audit.Duration.Milliseconds()); err != nil {
+		log.Printf("⚠️  Error guardando auditoría de herramienta %s: %v", audit.Tool, err)
+	}
+}
+
+// PlanProposalsHandler lista los cambios de plan propuestos por el coach (?status=pending|accepted|rejected)
+func PlanProposalsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	status := r.URL.Query().Get("status")
+	if status != "" && !services.ValidOption(status, []string{"pending", "accepted", "rejected"}) {
+		httpError(w, r, "Estado inválido (pending, accepted, rejected)", http.StatusBadRequest)
+		return
+	}
+
+	proposals, err := loadPlanProposals(userID, status)
+	if err != nil {
+		log.Printf("Error obteniendo propuestas: %v", err)
+		httpError(w, r, "Error obteniendo propuestas", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(proposals)
+}
+
+// PlanProposalDetailHandler maneja POST /api/training-plan/proposals/:id/accept y /:id/reject.
+// Al aceptar, el cambio se añade al texto del plan al que pertenece.
+func PlanProposalDetailHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/training-plan/proposals/"), "/"), "/")
+	if len(parts) != 2 || (parts[1] != "accept" && parts[1] != "reject") {
+		httpError(w, r, "Recurso no encontrado", http.StatusNotFound)
+		return
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		httpError(w, r, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	proposal, err := loadPlanProposal(userID, id)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Propuesta no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "Error obteniendo propuesta", http.StatusInternalServerError)
+		return
+	}
+	if proposal.Status != "pending" {
+		httpError(w, r, "La propuesta ya fue resuelta", http.StatusConflict)
+		return
+	}
+
+	status := "rejected"
+	if parts[1] == "accept" {
+		status = "accepted"
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		httpError(w, r, "Error actualizando propuesta", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		UPDATE plan_change_proposals SET status = ?, resolved_at = ? WHERE id = ? AND user_id = ?`,
+		status, time.Now(), id, userID); err != nil {
+		httpError(w, r, "Error actualizando propuesta", http.StatusInternalServerError)
+		return
+	}
+
+	if status == "accepted" && proposal.PlanID != nil {
+		note := "\n\n---\nCambio aceptado el " + time.Now().Format("2006-01-02")
+		if proposal.EffectiveDate != "" {
+			note += " (desde " + proposal.EffectiveDate + ")"
+		}
+		note += ": " + proposal.Summary + "\n" + proposal.Changes
+
+		if _, err := tx.Exec(`
+			UPDATE training_plans SET plan = plan || ? WHERE id = ? AND user_id = ?`,
+			note, *proposal.PlanID, userID); err != nil {
+			httpError(w, r, "Error actualizando plan", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		httpError(w, r, "Error actualizando propuesta", http.StatusInternalServerError)
+		return
+	}
+
+	proposal, err = loadPlanProposal(userID, id)
+	if err != nil {
+		httpError(w, r, "Error obteniendo propuesta", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(proposal)
+}
+
+// loadPlanProposals obtiene las propuestas de cambio del usuario, filtradas por estado si se indica
+func loadPlanProposals(userID int, status string) ([]models.PlanChangeProposal, error) {
+	query := planProposalSelectQuery + ` WHERE user_id = ?`
+	args := []interface{}{userID}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY created_at DESC, id DESC`
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	proposals := []models.PlanChangeProposal{}
+	for rows.Next() {
+		proposal, err := scanPlanProposal(rows)
+		if err != nil {
+			return nil, err
+		}
+		proposals = append(proposals, *proposal)
+	}
+
+	return proposals, rows.Err()
+}
+
+// loadPlanProposal obtiene una propuesta de cambio del usuario
+func loadPlanProposal(userID, id int) (*models.PlanChangeProposal, error) {
+	return scanPlanProposal(database.DB.QueryRow(planProposalSelectQuery+`
+		WHERE id = ? AND user_id = ?`, id, userID))
+}
+
+const planProposalSelectQuery = `
+	SELECT id, user_id, plan_id, summary, changes, COALESCE(effective_date, ''), status, created_at, resolved_at
+	FROM plan_change_proposals`
+
+func scanPlanProposal(row interface{ Scan(...interface{}) error }) (*models.PlanChangeProposal, error) {
+	var p models.PlanChangeProposal
+	var planID sql.NullInt64
+	var resolvedAt sql.NullTime
+	if err := row.Scan(&p.ID, &p.UserID, &planID, &p.Summary, &p.Changes, &p.EffectiveDate,
+		&p.Status, &p.CreatedAt, &resolvedAt); err != nil {
+		return nil, err
+	}
+	if planID.Valid {
+		id := int(planID.Int64)
+		p.PlanID = &id
+	}
+	if resolvedAt.Valid {
+		p.ResolvedAt = &resolvedAt.Time
+	}
+	return &p, nil
+}
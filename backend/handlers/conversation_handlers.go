@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"trainapp/database"
+	"trainapp/services"
+)
+
+// conversationStore guarda la conversación de cada usuario con el coach en coach_conversations
+type conversationStore struct{}
+
+func (conversationStore) LoadConversation(userID int) (*services.Conversation, error) {
+	c := &services.Conversation{UserID: userID}
+
+	var summary sql.NullString
+	var messages string
+	var updatedAt sql.NullTime
+	err := database.DB.QueryRow(`
+		SELECT summary, messages, summarized_turns, updated_at
+		FROM coach_conversations WHERE user_id = ?`, userID).Scan(&summary, &messages, &c.SummarizedTurns, &updatedAt)
+	if err == sql.ErrNoRows {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.Summary = summary.String
+	c.UpdatedAt = updatedAt.Time
+	if err := json.Unmarshal([]byte(messages), &c.Turns); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (conversationStore) SaveConversation(c *services.Conversation) error {
+	messages, err := json.Marshal(c.Turns)
+	if err != nil {
+		return err
+	}
+	c.UpdatedAt = time.Now()
+
+	_, err = database.DB.Exec(`
+		INSERT INTO coach_conversations (user_id, summary, messages, summarized_turns, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			summary = excluded.summary,
+			messages = excluded.messages,
+			summarized_turns = excluded.summarized_turns,
+			updated_at = excluded.updated_at`,
+		c.UserID, nullIfEmpty(c.Summary), string(messages), c.SummarizedTurns, c.UpdatedAt)
+	return err
+}
+
+// ConversationHandler maneja GET y DELETE /api/conversation: el historial del usuario con el
+// coach (resumen y mensajes recientes) y su borrado para empezar de cero
+func ConversationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value("userID").(int)
+
+	switch r.Method {
+	case "GET":
+		conversation, err := conversationStore{}.LoadConversation(userID)
+		if err != nil {
+			log.Printf("Error obteniendo conversación: %v", err)
+			httpError(w, r, "Error obteniendo conversación", http.StatusInternalServerError)
+			return
+		}
+		if conversation.Turns == nil {
+			conversation.Turns = []services.ConversationTurn{}
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"conversation": conversation,
+			"tokens":       conversation.Tokens(),
+			"budget":       services.HistoryTokenBudget(),
+		})
+
+	case "DELETE":
+		if _, err := database.DB.Exec("DELETE FROM coach_conversations WHERE user_id = ?", userID); err != nil {
+			log.Printf("Error borrando conversación: %v", err)
+			httpError(w, r, "Error borrando conversación", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trainapp/database"
+	"trainapp/models"
+	"trainapp/services"
+)
+
+// extractionSaveRequest permite corregir los datos extraídos antes de guardar el workout;
+// los campos nil mantienen el valor extraído
+type extractionSaveRequest struct {
+	Date          *string  `json:"date"` // YYYY-MM-DD o RFC3339
+	Type          *string  `json:"type"`
+	Distance      *float64 `json:"distance"`
+	Duration      *int     `json:"duration"` // en minutos
+	AvgPace       *string  `json:"avg_pace"`
+	AvgHeartRate  *int     `json:"avg_heart_rate"`
+	AvgPower      *int     `json:"avg_power"`
+	Cadence       *int     `json:"cadence"`
+	ElevationGain *int     `json:"elevation_gain"`
+	Calories      *int     `json:"calories"`
+	Feeling       *string  `json:"feeling"`
+	Notes         *string  `json:"notes"`
+	GearID        *int     `json:"gear_id"`
+}
+
+// apply copia los campos presentes en la petición sobre workout y valida el resultado
+func (req extractionSaveRequest) apply(workout *models.Workout) string {
+	if req.Date != nil {
+		date, err := parseWorkoutDate(*req.Date)
+		if err != nil {
+			return "Fecha inválida (formato YYYY-MM-DD)"
+		}
+		workout.Date = date
+	}
+	if req.Type != nil {
+		workout.Type = *req.Type
+	}
+	if req.Distance != nil {
+		workout.Distance = *req.Distance
+	}
+	if req.Duration != nil {
+		workout.Duration = *req.Duration
+	}
+	if req.AvgPace != nil {
+		workout.AvgPace = strings.TrimSpace(*req.AvgPace)
+	}
+	if req.AvgHeartRate != nil {
+		workout.AvgHeartRate = *req.AvgHeartRate
+	}
+	if req.AvgPower != nil {
+		workout.AvgPower = *req.AvgPower
+	}
+	if req.Cadence != nil {
+		workout.Cadence = *req.Cadence
+	}
+	if req.ElevationGain != nil {
+		workout.ElevationGain = *req.ElevationGain
+	}
+	if req.Calories != nil {
+		workout.Calories = *req.Calories
+	}
+	if req.Feeling != nil {
+		workout.Feeling = *req.Feeling
+	}
+	if req.Notes != nil {
+		workout.Notes = *req.Notes
+	}
+	if req.GearID != nil {
+		workout.GearID = req.GearID
+	}
+
+	switch {
+	case !services.ValidOption(workout.Type, services.WorkoutTypes):
+		return "Tipo de entreno inválido (easy, interval, tempo, long_run, race)"
+	case workout.Distance <= 0:
+		return "Falta la distancia: no se pudo extraer de las capturas, indícala"
+	case workout.Duration <= 0:
+		return "Falta la duración: no se pudo extraer de las capturas, indícala"
+	case workout.Date.After(time.Now()):
+		return "La fecha no puede ser futura"
+	}
+
+	return ""
+}
+
+// WorkoutExtractionDetailHandler maneja GET /api/workout-extractions/:id y
+// POST /api/workout-extractions/:id/save, que crea el workout a partir de los datos extraídos
+func WorkoutExtractionDetailHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value("userID").(int)
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/workout-extractions/"), "/"), "/")
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		httpError(w, r, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	extraction, notes, workoutID, err := loadWorkoutExtraction(userID, id)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Extracción no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo extracción %d: %v", id, err)
+		httpError(w, r, "Error obteniendo extracción", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == "GET":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":           id,
+			"workout_id":   workoutID,
+			"workout_data": extraction,
+		})
+	case len(parts) == 2 && parts[1] == "save" && r.Method == "POST":
+		if workoutID != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":      "Esta extracción ya se guardó como entreno",
+				"workout_id": *workoutID,
+			})
+			return
+		}
+
+		var req extractionSaveRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err.Error() != "EOF" {
+			httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+			return
+		}
+
+		workout := workoutFromExtraction(userID, extraction, notes)
+		if msg := req.apply(&workout); msg != "" {
+			httpError(w, r, msg, http.StatusBadRequest)
+			return
+		}
+
+		if workout.GearID != nil {
+			if _, err := loadGear(userID, *workout.GearID); err != nil {
+				httpError(w, r, "Zapatillas no encontradas", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if err := insertWorkout(&workout); err != nil {
+			log.Printf("Error creando workout desde extracción %d: %v", id, err)
+			httpError(w, r, "Error creando workout", http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := database.DB.Exec(`
+			UPDATE workout_extractions SET workout_id = ? WHERE id = ? AND user_id = ?`,
+			workout.ID, id, userID); err != nil {
+			log.Printf("Error vinculando extracción %d: %v", id, err)
+		}
+		if _, err := database.DB.Exec(`
+			UPDATE workout_images SET workout_id = ? WHERE extraction_id = ? AND user_id = ?`,
+			workout.ID, id, userID); err != nil {
+			log.Printf("Error adjuntando capturas de la extracción %d: %v", id, err)
+		}
+		if _, err := database.DB.Exec(`
+			UPDATE workout_analyses SET workout_id = ? WHERE extraction_id = ? AND user_id = ?`,
+			workout.ID, id, userID); err != nil {
+			log.Printf("Error vinculando análisis de la extracción %d: %v", id, err)
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(workout)
+	case len(parts) > 2 || (len(parts) == 2 && parts[1] != "save"):
+		httpError(w, r, "Recurso no encontrado", http.StatusNotFound)
+	default:
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// workoutFromExtraction construye el workout con los datos extraídos y valores por defecto
+// para lo que no aparece en las capturas
+func workoutFromExtraction(userID int, e *services.WorkoutExtraction, notes string) models.Workout {
+	workout := models.Workout{
+		UserID:  userID,
+		Date:    time.Now(),
+		Type:    "easy",
+		Feeling: "good",
+		Notes:   "Entreno importado desde captura del Apple Watch",
+	}
+	if notes != "" {
+		workout.Notes = notes
+	}
+
+	if e.Date != nil {
+		if date, err := parseWorkoutDate(*e.Date); err == nil {
+			workout.Date = date
+		}
+	}
+	if e.Type != nil {
+		workout.Type = *e.Type
+	}
+	if e.Distance != nil {
+		workout.Distance = *e.Distance
+	}
+	if e.DurationSeconds != nil {
+		workout.Duration = (*e.DurationSeconds + 30) / 60
+	}
+	if e.AvgPace != nil {
+		workout.AvgPace = *e.AvgPace
+	}
+	if e.AvgHeartRate != nil {
+		workout.AvgHeartRate = *e.AvgHeartRate
+	}
+	if e.AvgPower != nil {
+		workout.AvgPower = *e.AvgPower
+	}
+	if e.Cadence != nil {
+		workout.Cadence = *e.Cadence
+	}
+	if e.ElevationGain != nil {
+		workout.ElevationGain = *e.ElevationGain
+	}
+	if e.Calories != nil {
+		workout.Calories = *e.Calories
+	}
+
+	return workout
+}
+
+// parseWorkoutDate acepta YYYY-MM-DD (a mediodía, para no cambiar de día por la zona horaria) o RFC3339
+func parseWorkoutDate(value string) (time.Time, error) {
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date.Add(12 * time.Hour), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// saveWorkoutExtraction guarda el resultado de una extracción para poder convertirlo en workout
+func saveWorkoutExtraction(userID int, extraction *services.WorkoutExtraction, notes string) (int, error) {
+	data, err := json.Marshal(extraction)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := database.DB.Exec(`
+		INSERT INTO workout_extractions (user_id, data, notes) VALUES (?, ?, ?)`,
+		userID, string(data), notes)
+	if err != nil {
+		return 0, err
+	}
+
+	id, _ := result.LastInsertId()
+	return int(id), nil
+}
+
+// loadWorkoutExtraction obtiene una extracción del usuario, sus notas y el workout creado (si existe)
+func loadWorkoutExtraction(userID, id int) (*services.WorkoutExtraction, string, *int, error) {
+	var data string
+	var notes sql.NullString
+	var workoutID sql.NullInt64
+	if err := database.DB.QueryRow(`
+		SELECT data, notes, workout_id FROM workout_extractions WHERE id = ? AND user_id = ?`,
+		id, userID).Scan(&data, &notes, &workoutID); err != nil {
+		return nil, "", nil, err
+	}
+
+	var extraction services.WorkoutExtraction
+	if err := json.Unmarshal([]byte(data), &extraction); err != nil {
+		return nil, "", nil, err
+	}
+
+	var linked *int
+	if workoutID.Valid {
+		wid := int(workoutID.Int64)
+		linked = &wid
+	}
+
+	return &extraction, notes.String, linked, nil
+}
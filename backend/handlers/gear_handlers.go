@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trainapp/database"
+	"trainapp/models"
+	"trainapp/services"
+)
+
+// gearRequest contiene los campos editables de unas zapatillas; los campos nil no se modifican
+type gearRequest struct {
+	Brand        *string  `json:"brand"`
+	Model        *string  `json:"model"`
+	Nickname     *string  `json:"nickname"`
+	StartDate    *string  `json:"start_date"` // YYYY-MM-DD
+	RetirementKm *float64 `json:"retirement_km"`
+	InitialKm    *float64 `json:"initial_km"`
+	StravaGearID *string  `json:"strava_gear_id"`
+	Retired      *bool    `json:"retired"`
+}
+
+// apply copia los campos presentes en la petición sobre g y valida el resultado
+func (req gearRequest) apply(g *models.Gear) string {
+	if req.Brand != nil {
+		g.Brand = strings.TrimSpace(*req.Brand)
+	}
+	if req.Model != nil {
+		g.Model = strings.TrimSpace(*req.Model)
+	}
+	if req.Nickname != nil {
+		g.Nickname = strings.TrimSpace(*req.Nickname)
+	}
+	if req.StartDate != nil {
+		startDate, err := time.Parse("2006-01-02", *req.StartDate)
+		if err != nil {
+			return "Fecha de estreno inválida (formato YYYY-MM-DD)"
+		}
+		g.StartDate = startDate
+	}
+	if req.RetirementKm != nil {
+		g.RetirementKm = *req.RetirementKm
+	}
+	if req.InitialKm != nil {
+		g.InitialKm = *req.InitialKm
+	}
+	if req.StravaGearID != nil {
+		g.StravaGearID = strings.TrimSpace(*req.StravaGearID)
+	}
+	if req.Retired != nil {
+		g.Retired = *req.Retired
+	}
+
+	if g.Model == "" {
+		return "El modelo es requerido"
+	}
+	if g.RetirementKm <= 0 {
+		return "El umbral de retirada debe ser mayor que 0"
+	}
+	if g.InitialKm < 0 {
+		return "Los km iniciales no pueden ser negativos"
+	}
+
+	return ""
+}
+
+// GearHandler maneja GET (listar) y POST (crear) zapatillas
+func GearHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value("userID").(int)
+
+	switch r.Method {
+	case "GET":
+		includeRetired := r.URL.Query().Get("include_retired") == "true"
+		gear, err := loadUserGear(userID, includeRetired)
+		if err != nil {
+			log.Printf("Error obteniendo zapatillas: %v", err)
+			httpError(w, r, "Error obteniendo zapatillas", http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(gear)
+	case "POST":
+		var req gearRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+			return
+		}
+
+		g := models.Gear{
+			UserID:       userID,
+			StartDate:    time.Now().UTC().Truncate(24 * time.Hour),
+			RetirementKm: services.DefaultGearRetirementKm,
+		}
+		if msg := req.apply(&g); msg != "" {
+			httpError(w, r, msg, http.StatusBadRequest)
+			return
+		}
+
+		result, err := database.DB.Exec(`
+			INSERT INTO gear (user_id, brand, model, nickname, start_date, retirement_km, initial_km, strava_gear_id, retired)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			g.UserID, g.Brand, g.Model, g.Nickname, g.StartDate, g.RetirementKm, g.InitialKm,
+			nullIfEmpty(g.StravaGearID), g.Retired)
+		if err != nil {
+			log.Printf("Error creando zapatillas: %v", err)
+			httpError(w, r, "Error creando zapatillas", http.StatusInternalServerError)
+			return
+		}
+
+		id, _ := result.LastInsertId()
+		g.ID = int(id)
+		g.TotalKm = g.InitialKm
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(g)
+	default:
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// GearDetailHandler maneja /api/gear/alerts y GET, PUT, DELETE de /api/gear/:id
+func GearDetailHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value("userID").(int)
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/gear/"), "/")
+
+	if path == "alerts" {
+		if r.Method != "GET" {
+			httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+			return
+		}
+
+		alerts, err := gearAlertsForUser(userID, requestLocale(r))
+		if err != nil {
+			httpError(w, r, "Error obteniendo avisos", http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(alerts)
+		return
+	}
+
+	id, err := strconv.Atoi(path)
+	if err != nil {
+		httpError(w, r, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	g, err := loadGear(userID, id)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Zapatillas no encontradas", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "Error obteniendo zapatillas", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"gear":  g,
+			"alert": services.CheckGearMileage(*g, requestLocale(r)),
+		})
+	case "PUT":
+		var req gearRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+			return
+		}
+
+		if msg := req.apply(g); msg != "" {
+			httpError(w, r, msg, http.StatusBadRequest)
+			return
+		}
+
+		_, err := database.DB.Exec(`
+			UPDATE gear
+			SET brand = ?, model = ?, nickname = ?, start_date = ?, retirement_km = ?, initial_km = ?,
+			    strava_gear_id = ?, retired = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND user_id = ?`,
+			g.Brand, g.Model, g.Nickname, g.StartDate, g.RetirementKm, g.InitialKm,
+			nullIfEmpty(g.StravaGearID), g.Retired, id, userID)
+		if err != nil {
+			log.Printf("Error actualizando zapatillas: %v", err)
+			httpError(w, r, "Error actualizando zapatillas", http.StatusInternalServerError)
+			return
+		}
+
+		g, _ = loadGear(userID, id)
+		json.NewEncoder(w).Encode(g)
+	case "DELETE":
+		// Desvincular los workouts antes de borrar (SQLite no aplica ON DELETE sin foreign_keys)
+		if _, err := database.DB.Exec(`UPDATE workouts SET gear_id = NULL WHERE gear_id = ? AND user_id = ?`, id, userID); err != nil {
+			httpError(w, r, "Error desvinculando workouts", http.StatusInternalServerError)
+			return
+		}
+		if _, err := database.DB.Exec(`DELETE FROM gear WHERE id = ? AND user_id = ?`, id, userID); err != nil {
+			httpError(w, r, "Error eliminando zapatillas", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// workoutGear vincula (PUT) unas zapatillas a un workout; gear_id null lo desvincula
+func workoutGear(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != "PUT" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	var req struct {
+		GearID *int `json:"gear_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+
+	var g *models.Gear
+	if req.GearID != nil {
+		var err error
+		g, err = loadGear(userID, *req.GearID)
+		if err != nil {
+			httpError(w, r, "Zapatillas no encontradas", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE workouts SET gear_id = ? WHERE id = ? AND user_id = ?`, req.GearID, id, userID)
+	if err != nil {
+		httpError(w, r, "Error vinculando zapatillas", http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		httpError(w, r, "Workout no encontrado", http.StatusNotFound)
+		return
+	}
+
+	response := map[string]interface{}{
+		"workout_id": id,
+		"gear_id":    req.GearID,
+	}
+
+	// Recalcular el kilometraje con el nuevo workout y avisar si se acerca al límite
+	if g != nil {
+		if g, err = loadGear(userID, g.ID); err == nil {
+			response["gear"] = g
+			response["alert"] = services.CheckGearMileage(*g, requestLocale(r))
+		}
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// gearSelectQuery selecciona las zapatillas con su kilometraje acumulado: los km iniciales más
+// los entrenos desde el estreno. Las fechas se comparan por el día (YYYY-MM-DD) porque los
+// entrenos de Strava y los manuales no se guardan con el mismo formato.
+const gearSelectQuery = `
+	SELECT g.id, g.user_id, g.brand, g.model, COALESCE(g.nickname, ''), g.start_date,
+	       g.retirement_km, g.initial_km, COALESCE(g.strava_gear_id, ''), g.retired, g.created_at,
+	       g.initial_km + COALESCE((
+	           SELECT SUM(w.distance) FROM workouts w
+	           WHERE w.gear_id = g.id AND substr(w.date, 1, 10) >= substr(g.start_date, 1, 10)), 0)
+	FROM gear g`
+
+func scanGear(row interface{ Scan(...interface{}) error }) (*models.Gear, error) {
+	var g models.Gear
+	err := row.Scan(&g.ID, &g.UserID, &g.Brand, &g.Model, &g.Nickname, &g.StartDate,
+		&g.RetirementKm, &g.InitialKm, &g.StravaGearID, &g.Retired, &g.CreatedAt, &g.TotalKm)
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// loadGear obtiene unas zapatillas del usuario con su kilometraje
+func loadGear(userID, gearID int) (*models.Gear, error) {
+	return scanGear(database.DB.QueryRow(gearSelectQuery+` WHERE g.id = ? AND g.user_id = ?`, gearID, userID))
+}
+
+// loadUserGear obtiene las zapatillas del usuario, más recientes primero
+func loadUserGear(userID int, includeRetired bool) ([]models.Gear, error) {
+	query := gearSelectQuery + ` WHERE g.user_id = ?`
+	if !includeRetired {
+		query += ` AND g.retired = 0`
+	}
+	query += ` ORDER BY g.retired, g.start_date DESC`
+
+	rows, err := database.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	gear := []models.Gear{}
+	for rows.Next() {
+		g, err := scanGear(rows)
+		if err != nil {
+			return nil, err
+		}
+		gear = append(gear, *g)
+	}
+
+	return gear, rows.Err()
+}
+
+// gearAlertsForUser devuelve los avisos de kilometraje de las zapatillas activas del usuario
+func gearAlertsForUser(userID int, locale string) ([]services.GearAlert, error) {
+	gear, err := loadUserGear(userID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	alerts := []services.GearAlert{}
+	for _, g := range gear {
+		if alert := services.CheckGearMileage(g, locale); alert != nil {
+			alerts = append(alerts, *alert)
+		}
+	}
+
+	return alerts, nil
+}
+
+// resolveStravaGear devuelve el id local de las zapatillas de Strava, creándolas si no existen.
+// gearDetail es el objeto "gear" del detalle de la actividad (puede ser nil). Como Strava no da
+// la fecha de estreno, se usa la de la actividad más antigua vinculada (date) para que cuente
+// en el kilometraje.
+func resolveStravaGear(userID int, stravaGearID string, gearDetail map[string]interface{}, date time.Time) (*int, error) {
+	if stravaGearID == "" {
+		return nil, nil
+	}
+	startDate := date.UTC().Truncate(24 * time.Hour)
+
+	var id int
+	err := database.DB.QueryRow(`
+		SELECT id FROM gear WHERE user_id = ? AND strava_gear_id = ?`, userID, stravaGearID).Scan(&id)
+	if err == nil {
+		if _, err := database.DB.Exec(`
+			UPDATE gear SET start_date = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND substr(start_date, 1, 10) > ?`, startDate, id, startDate.Format("2006-01-02")); err != nil {
+			log.Printf("⚠️  Error actualizando la fecha de estreno de las zapatillas %d: %v", id, err)
+		}
+		return &id, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	name := stravaGearID
+	retired := false
+	if gearDetail != nil {
+		if n, ok := gearDetail["name"].(string); ok && n != "" {
+			name = n
+		}
+		retired, _ = gearDetail["retired"].(bool)
+	}
+	brand, model := services.SplitStravaGearName(name)
+
+	result, err := database.DB.Exec(`
+		INSERT INTO gear (user_id, brand, model, start_date, retirement_km, strava_gear_id, retired)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userID, brand, model, startDate, services.DefaultGearRetirementKm, stravaGearID, retired)
+	if err != nil {
+		return nil, err
+	}
+
+	newID, _ := result.LastInsertId()
+	id = int(newID)
+	log.Printf("👟 Zapatillas de Strava registradas: %s (%s)", name, stravaGearID)
+
+	return &id, nil
+}
+
+// nullIfEmpty convierte un string vacío en NULL para la base de datos
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -25,104 +25,157 @@ func WorkoutsHandler(w http.ResponseWriter, r *http.Request) {
 	case "POST":
 		createWorkout(w, r)
 	default:
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
 	}
 }
 
-// WorkoutDetailHandler maneja GET (detalle) de un workout específico
+// WorkoutDetailHandler maneja las rutas de un workout específico:
+// /api/workouts/:id, /api/workouts/:id/detail, /api/workouts/:id/intervals y /api/workouts/:id/gear
 func WorkoutDetailHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// Extraer ID del path
-	path := strings.TrimPrefix(r.URL.Path, "/api/workouts/")
+	// Extraer ID y sub-recurso del path
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/workouts/"), "/")
+	parts := strings.SplitN(path, "/", 2)
 
-	// Check if requesting detail with /detail suffix
-	if strings.HasSuffix(path, "/detail") {
-		path = strings.TrimSuffix(path, "/detail")
-	}
-
-	id, err := strconv.Atoi(path)
+	id, err := strconv.Atoi(parts[0])
 	if err != nil {
-		http.Error(w, "ID inválido", http.StatusBadRequest)
+		httpError(w, r, "ID inválido", http.StatusBadRequest)
 		return
 	}
 
-	if r.Method == "GET" {
-		// Check if requesting detailed view with Strava data
-		if strings.HasSuffix(r.URL.Path, "/detail") {
-			getWorkoutDetailWithStrava(w, r, id)
-		} else {
-			getWorkoutDetail(w, r, id)
+	subresource := ""
+	if len(parts) == 2 {
+		subresource = parts[1]
+	}
+
+	switch subresource {
+	case "":
+		if r.Method != "GET" {
+			httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+			return
 		}
-	} else {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		getWorkoutDetail(w, r, id)
+	case "detail":
+		// Vista detallada con datos de Strava
+		if r.Method != "GET" {
+			httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+			return
+		}
+		getWorkoutDetailWithStrava(w, r, id)
+	case "intervals":
+		workoutIntervals(w, r, id)
+	case "gear":
+		workoutGear(w, r, id)
+	case "images":
+		workoutImages(w, r, id, "")
+	default:
+		if imageID, found := strings.CutPrefix(subresource, "images/"); found {
+			workoutImages(w, r, id, imageID)
+			return
+		}
+		httpError(w, r, "Recurso no encontrado", http.StatusNotFound)
 	}
 }
 
-// TrainingPlanHandler maneja la creación de planes de entrenamiento
+// TrainingPlanHandler maneja la creación de planes de entrenamiento.
+// Si se indica race_id (o el usuario tiene una carrera A próxima) el plan se periodiza hacia esa carrera.
 func TrainingPlanHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != "POST" {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
 		return
 	}
 
+	if !checkLLMQuota(w, r) {
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
 	var req struct {
-		UserID int    `json:"user_id"`
 		Goal   string `json:"goal"`
+		RaceID int    `json:"race_id"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Datos inválidos", http.StatusBadRequest)
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
 		return
 	}
 
-	// Obtener información del usuario
-	var user models.User
-	err := database.DB.QueryRow(`
-		SELECT id, name, age, weight, height, fitness_level 
-		FROM users WHERE id = ?`, req.UserID).Scan(
-		&user.ID, &user.Name, &user.Age, &user.Weight, &user.Height, &user.FitnessLevel)
-	if err != nil {
-		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+	// Carrera objetivo: la indicada o la próxima carrera A
+	var race *models.Race
+	var err error
+	if req.RaceID > 0 {
+		race, err = loadRace(userID, req.RaceID)
+		if err != nil {
+			httpError(w, r, "Carrera no encontrada", http.StatusNotFound)
+			return
+		}
+	} else {
+		race, err = nextARace(userID)
+		if err != nil {
+			httpError(w, r, "Error obteniendo carreras", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.Goal == "" && race != nil {
+		req.Goal = race.Name
+	}
+	if req.Goal == "" {
+		httpError(w, r, "Se requiere un objetivo o una carrera", http.StatusBadRequest)
 		return
 	}
 
-	// Crear mapa con info del usuario
-	userInfo := map[string]interface{}{
-		"name":          user.Name,
-		"age":           user.Age,
-		"weight":        user.Weight,
-		"height":        user.Height,
-		"fitness_level": user.FitnessLevel,
+	now := time.Now()
+	endDate := now.AddDate(0, 3, 0) // Plan de 3 meses por defecto
+
+	var blocks []models.TrainingBlock
+	var calendar []models.Race
+	var raceID *int
+	if race != nil {
+		blocks = services.BuildPeriodization(now, race.Date, race.DistanceKm)
+		calendar, _ = loadUserRaces(userID, "upcoming")
+		endDate = race.Date
+		raceID = &race.ID
 	}
 
 	// Solicitar plan al agente
-	plan, err := services.CreateTrainingPlan(userInfo, req.Goal)
+	plan, err := services.CreateTrainingPlan(coachSession(r), req.Goal, race, calendar, blocks)
 	if err != nil {
-		http.Error(w, "Error generando plan: "+err.Error(), http.StatusInternalServerError)
+		coachError(w, r, "Error generando plan", err)
 		return
 	}
 
-	// Guardar en la base de datos
-	now := time.Now()
-	endDate := now.AddDate(0, 3, 0) // Plan de 3 meses por defecto
+	blocksJSON, _ := json.Marshal(blocks)
+
+	// El nuevo plan sustituye al plan activo anterior
+	if _, err := database.DB.Exec(`
+		UPDATE training_plans SET status = 'cancelled' WHERE user_id = ? AND status = 'active'`, userID); err != nil {
+		log.Printf("Error cancelando planes anteriores: %v", err)
+	}
 
+	// Guardar en la base de datos
 	result, err := database.DB.Exec(`
-		INSERT INTO training_plans (user_id, goal, start_date, end_date, plan, status)
-		VALUES (?, ?, ?, ?, ?, ?)`,
-		req.UserID, req.Goal, now, endDate, plan, "active")
+		INSERT INTO training_plans (user_id, goal, start_date, end_date, plan, status, race_id, blocks, model, prompt_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, req.Goal, now, endDate, plan, "active", raceID, string(blocksJSON),
+		services.CoachModel, services.ActivePromptVersion(services.PromptTrainingPlan))
 	if err != nil {
-		http.Error(w, "Error guardando plan", http.StatusInternalServerError)
+		httpError(w, r, "Error guardando plan", http.StatusInternalServerError)
 		return
 	}
 
 	planID, _ := result.LastInsertId()
 
 	response := map[string]interface{}{
-		"id":   planID,
-		"plan": plan,
+		"id":             planID,
+		"plan":           plan,
+		"race":           race,
+		"blocks":         blocks,
+		"prompt_version": services.ActivePromptVersion(services.PromptTrainingPlan),
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -133,7 +186,11 @@ func WeeklyPlanHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != "POST" {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkLLMQuota(w, r) {
 		return
 	}
 
@@ -143,7 +200,7 @@ func WeeklyPlanHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err.Error() != "EOF" {
-		http.Error(w, "Error leyendo petición", http.StatusBadRequest)
+		httpError(w, r, "Error leyendo petición", http.StatusBadRequest)
 		return
 	}
 
@@ -152,14 +209,14 @@ func WeeklyPlanHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Si hay una pregunta, es una conversación continua
 	if req.Question != "" {
-		plan, err = services.ContinueConversation(req.Question)
+		plan, err = services.ContinueConversation(coachSession(r), req.Question)
 	} else {
 		// Generar plan semanal inicial
-		plan, err = services.CreateWeeklyPlan()
+		plan, err = services.CreateWeeklyPlan(coachSession(r))
 	}
 
 	if err != nil {
-		http.Error(w, "Error generando respuesta: "+err.Error(), http.StatusInternalServerError)
+		coachError(w, r, "Error generando respuesta", err)
 		return
 	}
 
@@ -175,85 +232,109 @@ func WorkoutAnalysisHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != "POST" {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkLLMQuota(w, r) {
 		return
 	}
 
+	userID := r.Context().Value("userID").(int)
+
 	var req struct {
-		WorkoutID int    `json:"workout_id"`
-		Question  string `json:"question"`
+		WorkoutID  int    `json:"workout_id"`
+		AnalysisID *int   `json:"analysis_id"` // análisis al que se refiere la pregunta (por defecto el último del workout)
+		Question   string `json:"question"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Datos inválidos", http.StatusBadRequest)
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
 		return
 	}
 
-	var analysis string
-	var err error
-
-	// Si hay una pregunta, es una conversación continua
+	// Si hay una pregunta, es una conversación continua: se guarda como seguimiento del análisis
 	if req.Question != "" {
-		analysis, err = services.ContinueConversation(req.Question)
+		answer, err := services.ContinueConversation(coachSession(r), req.Question)
 		if err != nil {
-			http.Error(w, "Error procesando pregunta: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-	} else {
-		// Obtener workout y generar análisis inicial
-		var workout models.Workout
-		err = database.DB.QueryRow(`
-			SELECT id, user_id, date, type, distance, duration, avg_pace, 
-			       avg_heart_rate, avg_power, cadence, elevation_gain, calories, notes, feeling
-			FROM workouts WHERE id = ?`, req.WorkoutID).Scan(
-			&workout.ID, &workout.UserID, &workout.Date, &workout.Type,
-			&workout.Distance, &workout.Duration, &workout.AvgPace,
-			&workout.AvgHeartRate, &workout.AvgPower, &workout.Cadence,
-			&workout.ElevationGain, &workout.Calories, &workout.Notes, &workout.Feeling)
-		if err != nil {
-			http.Error(w, "Workout no encontrado", http.StatusNotFound)
+			coachError(w, r, "Error procesando pregunta", err)
 			return
 		}
 
-		// Preparar datos para el agente
-		workoutData := map[string]interface{}{
-			"date":           workout.Date,
-			"type":           workout.Type,
-			"distance":       workout.Distance,
-			"duration":       workout.Duration,
-			"avg_pace":       workout.AvgPace,
-			"avg_heart_rate": workout.AvgHeartRate,
-			"avg_power":      workout.AvgPower,
-			"cadence":        workout.Cadence,
-			"elevation_gain": workout.ElevationGain,
-			"calories":       workout.Calories,
-			"notes":          workout.Notes,
-			"feeling":        workout.Feeling,
-		}
+		writeFollowUp(w, saveFollowUp(userID, followUpParent(userID, req.AnalysisID, req.WorkoutID), "workout", req.Question, answer), answer)
+		return
+	}
 
-		// Solicitar análisis al agente
-		analysis, err = services.AnalyzeWorkout(workoutData)
-		if err != nil {
-			http.Error(w, "Error analizando workout: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+	// Obtener workout y generar análisis inicial
+	var workout models.Workout
+	err := database.DB.QueryRow(`
+		SELECT id, user_id, date, type, distance, duration, avg_pace, 
+		       avg_heart_rate, avg_power, cadence, elevation_gain, calories, notes, feeling
+		FROM workouts WHERE id = ? AND user_id = ?`, req.WorkoutID, userID).Scan(
+		&workout.ID, &workout.UserID, &workout.Date, &workout.Type,
+		&workout.Distance, &workout.Duration, &workout.AvgPace,
+		&workout.AvgHeartRate, &workout.AvgPower, &workout.Cadence,
+		&workout.ElevationGain, &workout.Calories, &workout.Notes, &workout.Feeling)
+	if err != nil {
+		httpError(w, r, "Workout no encontrado", http.StatusNotFound)
+		return
+	}
 
-		// Guardar análisis
-		result, err := database.DB.Exec(`
-			INSERT INTO workout_analyses (workout_id, analysis, recommendations)
-			VALUES (?, ?, ?)`,
-			req.WorkoutID, analysis, "")
-		if err != nil {
-			http.Error(w, "Error guardando análisis", http.StatusInternalServerError)
-			return
-		}
+	// Preparar datos para el agente
+	workoutData := map[string]interface{}{
+		"date":           workout.Date,
+		"type":           workout.Type,
+		"distance":       workout.Distance,
+		"duration":       workout.Duration,
+		"avg_pace":       workout.AvgPace,
+		"avg_heart_rate": workout.AvgHeartRate,
+		"avg_power":      workout.AvgPower,
+		"cadence":        workout.Cadence,
+		"elevation_gain": workout.ElevationGain,
+		"calories":       workout.Calories,
+		"notes":          workout.Notes,
+		"feeling":        workout.Feeling,
+	}
 
-		_ = result // analysisID no se usa en la respuesta
+	// Incluir el desglose de series si se detectaron
+	if reps, err := loadWorkoutIntervals(workout.ID); err == nil && len(reps) > 0 {
+		workoutData["intervals"] = reps
 	}
 
+	// Solicitar análisis al agente
+	analysis, err := services.AnalyzeWorkout(coachSession(r), workoutData)
+	if err != nil {
+		coachError(w, r, "Error analizando workout", err)
+		return
+	}
+
+	// Guardar análisis como nueva versión del workout, con sus recomendaciones estructuradas
+	saved := newWorkoutAnalysis(userID, "workout", services.ActivePromptVersion(services.PromptWorkoutAnalysis), workoutData, analysis)
+	saved.WorkoutID = &workout.ID
+	attachRecommendations(r, saved)
+	if err := saveWorkoutAnalysis(saved); err != nil {
+		log.Printf("Error guardando análisis: %v", err)
+		httpError(w, r, "Error guardando análisis", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":              req.WorkoutID,
+		"analysis_id":     saved.ID,
+		"version":         saved.Version,
+		"analysis":        analysis,
+		"recommendations": saved.Recommendations,
+	})
+}
+
+// writeFollowUp responde a una pregunta de seguimiento con el ID con el que se guardó
+func writeFollowUp(w http.ResponseWriter, followUp *models.WorkoutAnalysis, answer string) {
 	response := map[string]interface{}{
-		"id":       req.WorkoutID,
-		"analysis": analysis,
+		"analysis": answer,
+	}
+	if followUp != nil {
+		response["follow_up_id"] = followUp.ID
+		response["analysis_id"] = followUp.ParentID
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -264,145 +345,83 @@ func WorkoutAnalysisImageHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != "POST" {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var req struct {
-		ImageURLs []string `json:"image_urls"`
-		Notes     string   `json:"notes"`
-		Question  string   `json:"question"`
+	if !checkLLMQuota(w, r) {
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Datos inválidos", http.StatusBadRequest)
+	userID := r.Context().Value("userID").(int)
+
+	req, msg, status := parseImageAnalysisRequest(w, r)
+	if msg != "" {
+		httpError(w, r, msg, status)
 		return
 	}
 
-	var analysis string
-	var err error
-
-	// Si hay una pregunta, es una conversación continua
+	// Si hay una pregunta, es una conversación continua: se guarda como seguimiento del análisis
 	if req.Question != "" {
-		analysis, err = services.ContinueConversation(req.Question)
+		answer, err := services.ContinueConversation(coachSession(r), req.Question)
 		if err != nil {
-			http.Error(w, "Error procesando pregunta: "+err.Error(), http.StatusInternalServerError)
+			coachError(w, r, "Error procesando pregunta", err)
 			return
 		}
 
-		response := map[string]interface{}{
-			"analysis": analysis,
-		}
-		json.NewEncoder(w).Encode(response)
+		writeFollowUp(w, saveFollowUp(userID, followUpParent(userID, req.AnalysisID, 0), "image", req.Question, answer), answer)
 		return
 	}
 
 	// Análisis inicial con imágenes
-	if len(req.ImageURLs) == 0 {
-		http.Error(w, "Se requiere al menos una imagen", http.StatusBadRequest)
+	if len(req.Images) == 0 {
+		httpError(w, r, "Se requiere al menos una imagen", http.StatusBadRequest)
 		return
 	}
+	imageURLs := imageDataURLs(req.Images)
 
-	// Solicitar análisis al agente con imágenes incluyendo petición de extracción de datos
-	prompt := req.Notes
-	if prompt == "" {
-		prompt = "Analiza este entreno y extrae los datos principales."
-	}
-
-	// Añadir instrucción para extraer datos estructurados
-	analysisPrompt := prompt + `
-
-IMPORTANTE: Al final de tu análisis, incluye una sección con el formato exacto:
-
---- DATOS EXTRAÍDOS ---
-Fecha: [YYYY-MM-DD]
-Tipo: [easy/interval/tempo/long_run/race]
-Distancia: [número en km]
-Duración: [número en minutos]
-Ritmo medio: [formato MM:SS]
-FC media: [número]
-Potencia media: [número]
-Cadencia: [número]
-Desnivel: [número]
-Sensación: [great/good/ok/tired]
----`
-
-	analysis, err = services.AnalyzeWorkoutWithImages(req.ImageURLs, analysisPrompt)
+	analysis, err := services.AnalyzeWorkoutWithImages(coachSession(r), imageURLs, req.Notes)
 	if err != nil {
-		http.Error(w, "Error analizando workout: "+err.Error(), http.StatusInternalServerError)
+		coachError(w, r, "Error analizando workout", err)
 		return
 	}
 
-	// Intentar extraer datos estructurados del análisis
-	workoutData := extractWorkoutData(analysis)
-
 	response := map[string]interface{}{
-		"analysis":     analysis,
-		"workout_data": workoutData,
+		"analysis": analysis,
 	}
+	saved := newWorkoutAnalysis(userID, "image", services.ActivePromptVersion(services.PromptImageAnalysis), map[string]interface{}{
+		"notes":  req.Notes,
+		"images": len(req.Images),
+	}, analysis)
 
-	json.NewEncoder(w).Encode(response)
-}
-
-// extractWorkoutData intenta extraer datos estructurados del análisis
-func extractWorkoutData(analysis string) map[string]interface{} {
-	data := make(map[string]interface{})
-
-	// Buscar la sección de datos extraídos
-	lines := strings.Split(analysis, "\n")
-	inDataSection := false
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-
-		if strings.Contains(line, "--- DATOS EXTRAÍDOS ---") || strings.Contains(line, "DATOS EXTRAÍDOS") {
-			inDataSection = true
-			continue
-		}
+	// Extraer los datos estructurados con una llamada restringida al esquema; si falla se
+	// devuelve igualmente el análisis
+	extraction, err := services.ExtractWorkoutFromImages(llmSession(r), imageURLs, req.Notes)
+	if err != nil {
+		log.Printf("⚠️  Error extrayendo datos de las capturas: %v", err)
+	} else if extractionID, err := saveWorkoutExtraction(userID, extraction, req.Notes); err != nil {
+		log.Printf("⚠️  Error guardando extracción: %v", err)
+	} else {
+		response["extraction_id"] = extractionID
+		response["workout_data"] = extraction
+		saved.ExtractionID = &extractionID
 
-		if strings.HasPrefix(line, "---") && inDataSection {
-			break
-		}
-
-		if inDataSection && strings.Contains(line, ":") {
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				key := strings.TrimSpace(strings.ToLower(parts[0]))
-				value := strings.TrimSpace(parts[1])
-
-				// Mapear los campos
-				switch {
-				case strings.Contains(key, "fecha"):
-					data["date"] = value
-				case strings.Contains(key, "tipo"):
-					data["type"] = value
-				case strings.Contains(key, "distancia"):
-					data["distance"] = value
-				case strings.Contains(key, "duración") || strings.Contains(key, "duracion"):
-					data["duration"] = value
-				case strings.Contains(key, "ritmo"):
-					data["avg_pace"] = value
-				case strings.Contains(key, "fc"):
-					data["avg_heart_rate"] = value
-				case strings.Contains(key, "potencia"):
-					data["avg_power"] = value
-				case strings.Contains(key, "cadencia"):
-					data["cadence"] = value
-				case strings.Contains(key, "desnivel"):
-					data["elevation_gain"] = value
-				case strings.Contains(key, "sensación") || strings.Contains(key, "sensacion"):
-					data["feeling"] = value
-				}
-			}
+		// Las capturas se guardan con la extracción y se adjuntan al workout al guardarla
+		if err := storeExtractionImages(userID, extractionID, req.Images); err != nil {
+			log.Printf("⚠️  Error guardando capturas: %v", err)
 		}
 	}
 
-	// Si no se encontraron datos estructurados, devolver nil
-	if len(data) == 0 {
-		return nil
+	// Guardar el análisis; al guardar la extracción como entreno queda vinculado al workout
+	attachRecommendations(r, saved)
+	response["recommendations"] = saved.Recommendations
+	if err := saveWorkoutAnalysis(saved); err != nil {
+		log.Printf("⚠️  Error guardando análisis: %v", err)
+	} else {
+		response["analysis_id"] = saved.ID
 	}
 
-	return data
+	json.NewEncoder(w).Encode(response)
 }
 
 // WorkoutAnalysisFormHandler analiza un workout ingresado por formulario
@@ -410,10 +429,16 @@ func WorkoutAnalysisFormHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != "POST" {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkLLMQuota(w, r) {
 		return
 	}
 
+	userID := r.Context().Value("userID").(int)
+
 	var req struct {
 		UserID        int     `json:"user_id"`
 		Date          string  `json:"date"`
@@ -429,52 +454,63 @@ func WorkoutAnalysisFormHandler(w http.ResponseWriter, r *http.Request) {
 		Feeling       string  `json:"feeling"`
 		Notes         string  `json:"notes"`
 		Question      string  `json:"question"`
+		AnalysisID    *int    `json:"analysis_id"` // análisis al que se refiere la pregunta
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Datos inválidos", http.StatusBadRequest)
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
 		return
 	}
 
-	var analysis string
-	var err error
-
-	// Si hay una pregunta, es una conversación continua
+	// Si hay una pregunta, es una conversación continua: se guarda como seguimiento del análisis
 	if req.Question != "" {
-		analysis, err = services.ContinueConversation(req.Question)
+		answer, err := services.ContinueConversation(coachSession(r), req.Question)
 		if err != nil {
-			http.Error(w, "Error procesando pregunta: "+err.Error(), http.StatusInternalServerError)
+			coachError(w, r, "Error procesando pregunta", err)
 			return
 		}
-	} else {
-		// Preparar datos para el agente
-		workoutData := map[string]interface{}{
-			"date":           req.Date,
-			"type":           req.Type,
-			"distance":       req.Distance,
-			"duration":       req.Duration,
-			"avg_pace":       req.AvgPace,
-			"avg_heart_rate": req.AvgHeartRate,
-			"avg_power":      req.AvgPower,
-			"cadence":        req.Cadence,
-			"elevation_gain": req.ElevationGain,
-			"calories":       req.Calories,
-			"notes":          req.Notes,
-			"feeling":        req.Feeling,
-		}
 
-		// Solicitar análisis al agente
-		analysis, err = services.AnalyzeWorkout(workoutData)
-		if err != nil {
-			http.Error(w, "Error analizando workout: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+		writeFollowUp(w, saveFollowUp(userID, followUpParent(userID, req.AnalysisID, 0), "form", req.Question, answer), answer)
+		return
+	}
+
+	// Preparar datos para el agente
+	workoutData := map[string]interface{}{
+		"date":           req.Date,
+		"type":           req.Type,
+		"distance":       req.Distance,
+		"duration":       req.Duration,
+		"avg_pace":       req.AvgPace,
+		"avg_heart_rate": req.AvgHeartRate,
+		"avg_power":      req.AvgPower,
+		"cadence":        req.Cadence,
+		"elevation_gain": req.ElevationGain,
+		"calories":       req.Calories,
+		"notes":          req.Notes,
+		"feeling":        req.Feeling,
+	}
+
+	// Solicitar análisis al agente
+	analysis, err := services.AnalyzeWorkout(coachSession(r), workoutData)
+	if err != nil {
+		coachError(w, r, "Error analizando workout", err)
+		return
 	}
 
 	response := map[string]interface{}{
 		"analysis": analysis,
 	}
 
+	// Guardar el análisis aunque el entreno no esté en el historial
+	saved := newWorkoutAnalysis(userID, "form", services.ActivePromptVersion(services.PromptWorkoutAnalysis), workoutData, analysis)
+	attachRecommendations(r, saved)
+	response["recommendations"] = saved.Recommendations
+	if err := saveWorkoutAnalysis(saved); err != nil {
+		log.Printf("⚠️  Error guardando análisis: %v", err)
+	} else {
+		response["analysis_id"] = saved.ID
+	}
+
 	json.NewEncoder(w).Encode(response)
 }
 
@@ -483,80 +519,51 @@ func ProgressReportHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != "POST" {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkLLMQuota(w, r) {
 		return
 	}
 
+	userID := r.Context().Value("userID").(int)
+
 	var req struct {
-		UserID      int    `json:"user_id"`
 		PeriodStart string `json:"period_start"`
 		PeriodEnd   string `json:"period_end"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Datos inválidos", http.StatusBadRequest)
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
 		return
 	}
 
-	// Obtener workouts del período
-	rows, err := database.DB.Query(`
-		SELECT date, type, distance, duration, avg_pace, avg_heart_rate, avg_power, cadence, elevation_gain, calories, feeling
-		FROM workouts 
-		WHERE user_id = ? AND date BETWEEN ? AND ?
-		ORDER BY date`, req.UserID, req.PeriodStart, req.PeriodEnd)
+	startDate, err := time.Parse("2006-01-02", req.PeriodStart)
 	if err != nil {
-		http.Error(w, "Error obteniendo workouts", http.StatusInternalServerError)
+		httpError(w, r, "Fecha de inicio inválida (formato YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	endDate, err := time.Parse("2006-01-02", req.PeriodEnd)
+	if err != nil || endDate.Before(startDate) {
+		httpError(w, r, "Fecha de fin inválida (formato YYYY-MM-DD, posterior al inicio)", http.StatusBadRequest)
 		return
 	}
-	defer rows.Close()
 
-	workouts := []map[string]interface{}{}
-	for rows.Next() {
-		var date time.Time
-		var workoutType, avgPace, feeling string
-		var distance float64
-		var duration, avgHR, avgPower, cadence, elevationGain, calories int
-
-		rows.Scan(&date, &workoutType, &distance, &duration, &avgPace, &avgHR, &avgPower, &cadence, &elevationGain, &calories, &feeling)
-
-		workouts = append(workouts, map[string]interface{}{
-			"date":           date,
-			"type":           workoutType,
-			"distance":       distance,
-			"duration":       duration,
-			"avg_pace":       avgPace,
-			"avg_heart_rate": avgHR,
-			"avg_power":      avgPower,
-			"cadence":        cadence,
-			"elevation_gain": elevationGain,
-			"calories":       calories,
-			"feeling":        feeling,
-		})
-	}
-
-	// Generar reporte con el agente
-	period := req.PeriodStart + " a " + req.PeriodEnd
-	report, err := services.GenerateProgressReport(workouts, period)
+	// Generar reporte con el agente (consulta los entrenos del período con sus herramientas)
+	report, err := services.GenerateProgressReport(coachSession(r), req.PeriodStart, req.PeriodEnd)
 	if err != nil {
-		http.Error(w, "Error generando reporte: "+err.Error(), http.StatusInternalServerError)
+		coachError(w, r, "Error generando reporte", err)
 		return
 	}
 
 	// Guardar reporte
-	startDate, _ := time.Parse("2006-01-02", req.PeriodStart)
-	endDate, _ := time.Parse("2006-01-02", req.PeriodEnd)
-
-	result, err := database.DB.Exec(`
-		INSERT INTO progress_reports (user_id, period_start, period_end, report)
-		VALUES (?, ?, ?, ?)`,
-		req.UserID, startDate, endDate, report)
+	reportID, err := saveProgressReport(userID, startDate, endDate, report)
 	if err != nil {
-		http.Error(w, "Error guardando reporte", http.StatusInternalServerError)
+		httpError(w, r, "Error guardando reporte", http.StatusInternalServerError)
 		return
 	}
 
-	reportID, _ := result.LastInsertId()
-
 	response := map[string]interface{}{
 		"id":     reportID,
 		"report": report,
@@ -565,28 +572,72 @@ func ProgressReportHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// UserHandler obtiene información del usuario
+// UserHandler obtiene (GET) o actualiza (PATCH, p. ej. el idioma) el usuario autenticado junto con su perfil de corredor
 func UserHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if r.Method != "GET" {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	userID := r.Context().Value("userID").(int)
+
+	switch r.Method {
+	case "GET":
+	case "PATCH":
+		var req userUpdateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+			return
+		}
+		if msg := req.validate(); msg != "" {
+			httpError(w, r, msg, http.StatusBadRequest)
+			return
+		}
+		if req.Locale != nil {
+			if _, err := database.DB.Exec(`
+				UPDATE users SET locale = NULLIF(?, ''), updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+				services.NormalizeLocale(*req.Locale), userID); err != nil {
+				log.Printf("Error actualizando usuario: %v", err)
+				httpError(w, r, "Error actualizando usuario", http.StatusInternalServerError)
+				return
+			}
+		}
+	default:
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Por ahora devolver el primer usuario (demo)
 	var user models.User
+	var verifiedAt sql.NullTime
 	err := database.DB.QueryRow(`
-		SELECT id, name, email, age, weight, height, fitness_level
-		FROM users LIMIT 1`).Scan(
-		&user.ID, &user.Name, &user.Email, &user.Age,
-		&user.Weight, &user.Height, &user.FitnessLevel)
+		SELECT id, name, email, email_verified_at, COALESCE(locale, ''), role, created_at, updated_at
+		FROM users WHERE id = ?`, userID).Scan(
+		&user.ID, &user.Name, &user.Email, &verifiedAt, &user.Locale, &user.Role, &user.CreatedAt, &user.UpdatedAt)
+	user.EmailVerified = verifiedAt.Valid
+	if err != nil {
+		httpError(w, r, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+
+	profile, err := loadRunnerProfile(userID)
 	if err != nil {
-		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		httpError(w, r, "Error obteniendo perfil", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user":    user,
+		"profile": withDefaultZones(*profile),
+	})
+}
+
+// userUpdateRequest es la petición de PATCH /api/user; los campos ausentes no se modifican
+type userUpdateRequest struct {
+	Locale *string `json:"locale"` // "" vuelve a usar el idioma del navegador
+}
+
+func (req userUpdateRequest) validate() string {
+	if req.Locale != nil && *req.Locale != "" && services.NormalizeLocale(*req.Locale) == "" {
+		return "Idioma no soportado (es, en)"
+	}
+	return ""
 }
 
 // Helpers
@@ -597,12 +648,12 @@ func listWorkouts(w http.ResponseWriter, r *http.Request) {
 
 	rows, err := database.DB.Query(`
 		SELECT id, user_id, date, type, distance, duration, avg_pace, 
-		       avg_heart_rate, avg_power, cadence, elevation_gain, calories, notes, feeling, created_at
+		       avg_heart_rate, avg_power, cadence, elevation_gain, calories, notes, feeling, gear_id, created_at
 		FROM workouts 
 		WHERE user_id = ?
 		ORDER BY date DESC`, userID)
 	if err != nil {
-		http.Error(w, "Error obteniendo workouts", http.StatusInternalServerError)
+		httpError(w, r, "Error obteniendo workouts", http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
@@ -612,7 +663,7 @@ func listWorkouts(w http.ResponseWriter, r *http.Request) {
 		var w models.Workout
 		rows.Scan(&w.ID, &w.UserID, &w.Date, &w.Type, &w.Distance,
 			&w.Duration, &w.AvgPace, &w.AvgHeartRate, &w.AvgPower, &w.Cadence,
-			&w.ElevationGain, &w.Calories, &w.Notes, &w.Feeling, &w.CreatedAt)
+			&w.ElevationGain, &w.Calories, &w.Notes, &w.Feeling, &w.GearID, &w.CreatedAt)
 		workouts = append(workouts, w)
 	}
 
@@ -626,30 +677,46 @@ func createWorkout(w http.ResponseWriter, r *http.Request) {
 	var workout models.Workout
 	if err := json.NewDecoder(r.Body).Decode(&workout); err != nil {
 		log.Printf("Error decodificando workout: %v", err)
-		http.Error(w, fmt.Sprintf("Datos inválidos: %v", err), http.StatusBadRequest)
+		httpError(w, r, fmt.Sprintf("Datos inválidos: %v", err), http.StatusBadRequest)
 		return
 	}
 
 	// Forzar user_id del usuario autenticado (ignorar el del body)
 	workout.UserID = userID
 
+	// Las zapatillas deben pertenecer al usuario
+	if workout.GearID != nil {
+		if _, err := loadGear(userID, *workout.GearID); err != nil {
+			httpError(w, r, "Zapatillas no encontradas", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := insertWorkout(&workout); err != nil {
+		httpError(w, r, "Error creando workout", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(workout)
+}
+
+// insertWorkout guarda un workout introducido manualmente y asigna su ID
+func insertWorkout(workout *models.Workout) error {
 	result, err := database.DB.Exec(`
 		INSERT INTO workouts (user_id, date, type, distance, duration, avg_pace, 
-		                      avg_heart_rate, avg_power, cadence, elevation_gain, calories, notes, feeling)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		                      avg_heart_rate, avg_power, cadence, elevation_gain, calories, notes, feeling, gear_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		workout.UserID, workout.Date, workout.Type, workout.Distance,
 		workout.Duration, workout.AvgPace, workout.AvgHeartRate, workout.AvgPower,
-		workout.Cadence, workout.ElevationGain, workout.Calories, workout.Notes, workout.Feeling)
+		workout.Cadence, workout.ElevationGain, workout.Calories, workout.Notes, workout.Feeling, workout.GearID)
 	if err != nil {
-		http.Error(w, "Error creando workout", http.StatusInternalServerError)
-		return
+		return err
 	}
 
 	id, _ := result.LastInsertId()
 	workout.ID = int(id)
-
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(workout)
+	return nil
 }
 
 func getWorkoutDetail(w http.ResponseWriter, r *http.Request, id int) {
@@ -659,36 +726,36 @@ func getWorkoutDetail(w http.ResponseWriter, r *http.Request, id int) {
 	var workout models.Workout
 	err := database.DB.QueryRow(`
 		SELECT id, user_id, date, type, distance, duration, avg_pace, 
-		       avg_heart_rate, avg_power, cadence, elevation_gain, calories, notes, feeling, created_at
+		       avg_heart_rate, avg_power, cadence, elevation_gain, calories, notes, feeling, gear_id, created_at
 		FROM workouts WHERE id = ? AND user_id = ?`, id, userID).Scan(
 		&workout.ID, &workout.UserID, &workout.Date, &workout.Type,
 		&workout.Distance, &workout.Duration, &workout.AvgPace,
 		&workout.AvgHeartRate, &workout.AvgPower, &workout.Cadence,
 		&workout.ElevationGain, &workout.Calories, &workout.Notes,
-		&workout.Feeling, &workout.CreatedAt)
+		&workout.Feeling, &workout.GearID, &workout.CreatedAt)
 	if err == sql.ErrNoRows {
-		http.Error(w, "Workout no encontrado", http.StatusNotFound)
+		httpError(w, r, "Workout no encontrado", http.StatusNotFound)
 		return
 	}
 	if err != nil {
-		http.Error(w, "Error obteniendo workout", http.StatusInternalServerError)
+		httpError(w, r, "Error obteniendo workout", http.StatusInternalServerError)
 		return
 	}
 
-	// Obtener análisis si existe
-	var analysis models.WorkoutAnalysis
-	err = database.DB.QueryRow(`
-		SELECT id, workout_id, analysis, recommendations, created_at
-		FROM workout_analyses WHERE workout_id = ?`, id).Scan(
-		&analysis.ID, &analysis.WorkoutID, &analysis.Analysis,
-		&analysis.Recommendations, &analysis.CreatedAt)
-
 	response := map[string]interface{}{
 		"workout": workout,
 	}
 
-	if err == nil {
-		response["analysis"] = analysis
+	// Último análisis y versiones anteriores
+	if latest, history, err := workoutAnalysisHistory(userID, id); err == nil {
+		if latest != nil {
+			response["analysis"] = latest
+		}
+		response["analysis_history"] = history
+	}
+
+	if reps, err := loadWorkoutIntervals(id); err == nil {
+		response["intervals"] = reps
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -706,21 +773,21 @@ func getWorkoutDetailWithStrava(w http.ResponseWriter, r *http.Request, id int)
 	err := database.DB.QueryRow(`
 		SELECT id, user_id, date, type, distance, duration, avg_pace, 
 		       avg_heart_rate, avg_power, cadence, elevation_gain, calories, notes, feeling, 
-		       strava_activity_id, strava_data, created_at
+		       strava_activity_id, strava_data, gear_id, created_at
 		FROM workouts WHERE id = ? AND user_id = ?`, id, userID).Scan(
 		&workout.ID, &workout.UserID, &workout.Date, &workout.Type,
 		&workout.Distance, &workout.Duration, &workout.AvgPace,
 		&workout.AvgHeartRate, &workout.AvgPower, &workout.Cadence,
 		&workout.ElevationGain, &workout.Calories, &workout.Notes,
-		&workout.Feeling, &stravaActivityID, &stravaDataJSON, &workout.CreatedAt)
+		&workout.Feeling, &stravaActivityID, &stravaDataJSON, &workout.GearID, &workout.CreatedAt)
 
 	if err == sql.ErrNoRows {
-		http.Error(w, "Workout no encontrado", http.StatusNotFound)
+		httpError(w, r, "Workout no encontrado", http.StatusNotFound)
 		return
 	}
 	if err != nil {
 		log.Printf("Error obteniendo workout: %v", err)
-		http.Error(w, "Error obteniendo workout", http.StatusInternalServerError)
+		httpError(w, r, "Error obteniendo workout", http.StatusInternalServerError)
 		return
 	}
 
@@ -763,7 +830,7 @@ func getWorkoutDetailWithStrava(w http.ResponseWriter, r *http.Request, id int)
 	response := map[string]interface{}{
 		"id":                   workout.ID,
 		"user_id":              workout.UserID,
-		"name":                 fmt.Sprintf("Entreno del %s", workout.Date.Format("02/01/2006")),
+		"name":                 services.T(requestLocale(r), "Entreno del %s", workout.Date.Format("02/01/2006")),
 		"start_date":           workout.Date,
 		"type":                 workout.Type,
 		"distance":             workout.Distance * 1000, // Convert to meters for consistency with Strava
@@ -779,6 +846,21 @@ func getWorkoutDetailWithStrava(w http.ResponseWriter, r *http.Request, id int)
 		"calories":             float64(workout.Calories),
 		"perceived_exertion":   workout.Feeling,
 		"notes":                workout.Notes,
+		"gear_id":              workout.GearID,
+	}
+
+	// Zapatillas registradas localmente (Strava sobrescribe "gear" con sus datos si los tiene)
+	if workout.GearID != nil {
+		if g, err := loadGear(userID, *workout.GearID); err == nil {
+			response["gear"] = map[string]interface{}{
+				"id":                 g.ID,
+				"name":               services.GearDisplayName(*g),
+				"distance":           g.TotalKm * 1000,
+				"converted_distance": g.TotalKm,
+				"retired":            g.Retired,
+			}
+			response["local_gear"] = g
+		}
 	}
 
 	// Calculate average speed from distance and time
@@ -807,5 +889,18 @@ func getWorkoutDetailWithStrava(w http.ResponseWriter, r *http.Request, id int)
 		}
 	}
 
+	// Series detectadas (trabajo/recuperación)
+	if reps, err := loadWorkoutIntervals(id); err == nil {
+		response["intervals"] = reps
+	}
+
+	// Último análisis del coach y versiones anteriores
+	if latest, history, err := workoutAnalysisHistory(userID, id); err == nil {
+		if latest != nil {
+			response["analysis"] = latest
+		}
+		response["analysis_history"] = history
+	}
+
 	json.NewEncoder(w).Encode(response)
 }
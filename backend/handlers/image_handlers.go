@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"trainapp/database"
+	"trainapp/models"
+	"trainapp/services"
+)
+
+// uploadedImage es una captura ya validada y sin metadatos
+type uploadedImage struct {
+	ContentType string
+	Data        []byte
+}
+
+// imageAnalysisRequest es la petición de análisis por capturas, en JSON o multipart
+type imageAnalysisRequest struct {
+	Images     []uploadedImage
+	Notes      string
+	Question   string
+	AnalysisID *int // análisis al que se refiere la pregunta
+}
+
+// parseImageAnalysisRequest lee las capturas de un formulario multipart (campo "images")
+// o de un JSON con data URLs en "image_urls". Devuelve un mensaje y código de error si no es válida.
+func parseImageAnalysisRequest(w http.ResponseWriter, r *http.Request) (*imageAnalysisRequest, string, int) {
+	r.Body = http.MaxBytesReader(w, r.Body, services.MaxImagesPerRequest*services.MaxImageBytes*4/3+(1<<20))
+
+	req := &imageAnalysisRequest{}
+	var raw [][]byte
+	locale := requestLocale(r)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(8 << 20); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return nil, "La petición supera el tamaño máximo", http.StatusRequestEntityTooLarge
+			}
+			return nil, "Formulario inválido", http.StatusBadRequest
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		req.Notes = r.FormValue("notes")
+		req.Question = r.FormValue("question")
+		if value := r.FormValue("analysis_id"); value != "" {
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, "ID de análisis inválido", http.StatusBadRequest
+			}
+			req.AnalysisID = &id
+		}
+
+		files := r.MultipartForm.File["images"]
+		if len(files) > services.MaxImagesPerRequest {
+			return nil, services.T(locale, "Máximo %d imágenes por análisis", services.MaxImagesPerRequest), http.StatusBadRequest
+		}
+		for _, header := range files {
+			if header.Size > services.MaxImageBytes {
+				return nil, services.T(locale, "%s supera el máximo de %d MB", header.Filename, services.MaxImageBytes>>20), http.StatusRequestEntityTooLarge
+			}
+			file, err := header.Open()
+			if err != nil {
+				return nil, "Error leyendo imagen", http.StatusBadRequest
+			}
+			data, err := io.ReadAll(io.LimitReader(file, services.MaxImageBytes+1))
+			file.Close()
+			if err != nil {
+				return nil, "Error leyendo imagen", http.StatusBadRequest
+			}
+			raw = append(raw, data)
+		}
+	} else {
+		var body struct {
+			ImageURLs  []string `json:"image_urls"`
+			Notes      string   `json:"notes"`
+			Question   string   `json:"question"`
+			AnalysisID *int     `json:"analysis_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return nil, "La petición supera el tamaño máximo", http.StatusRequestEntityTooLarge
+			}
+			return nil, "Datos inválidos", http.StatusBadRequest
+		}
+
+		req.Notes = body.Notes
+		req.Question = body.Question
+		req.AnalysisID = body.AnalysisID
+
+		if len(body.ImageURLs) > services.MaxImagesPerRequest {
+			return nil, services.T(locale, "Máximo %d imágenes por análisis", services.MaxImagesPerRequest), http.StatusBadRequest
+		}
+		for _, url := range body.ImageURLs {
+			if !strings.HasPrefix(url, "data:") {
+				return nil, "Sube las imágenes directamente (multipart o data URL); no se aceptan URLs externas", http.StatusBadRequest
+			}
+			data, err := services.ParseImageDataURL(url)
+			if err != nil {
+				return nil, "Imagen inválida: " + err.Error(), http.StatusBadRequest
+			}
+			raw = append(raw, data)
+		}
+	}
+
+	for i, data := range raw {
+		clean, contentType, err := services.SanitizeImage(data)
+		if err != nil {
+			return nil, services.T(locale, "Imagen %d", i+1) + ": " + services.T(locale, err.Error()), http.StatusBadRequest
+		}
+		req.Images = append(req.Images, uploadedImage{ContentType: contentType, Data: clean})
+	}
+
+	return req, "", 0
+}
+
+// imageDataURLs codifica las capturas como data URLs para enviarlas al proveedor
+func imageDataURLs(images []uploadedImage) []string {
+	urls := make([]string, len(images))
+	for i, img := range images {
+		urls[i] = services.ImageDataURL(img.ContentType, img.Data)
+	}
+	return urls
+}
+
+// uploadsDir devuelve el directorio donde se guardan las capturas (UPLOADS_PATH)
+func uploadsDir() string {
+	if dir := os.Getenv("UPLOADS_PATH"); dir != "" {
+		return dir
+	}
+	return "./uploads"
+}
+
+// storeExtractionImages guarda las capturas en disco vinculadas a la extracción; al guardar la
+// extracción como entreno se vinculan también al workout
+func storeExtractionImages(userID, extractionID int, images []uploadedImage) error {
+	dir := filepath.Join(uploadsDir(), strconv.Itoa(userID))
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+
+	for _, img := range images {
+		name := make([]byte, 16)
+		if _, err := rand.Read(name); err != nil {
+			return err
+		}
+		filename := filepath.Join(strconv.Itoa(userID), hex.EncodeToString(name)+services.ImageExtension(img.ContentType))
+
+		if err := os.WriteFile(filepath.Join(uploadsDir(), filename), img.Data, 0o640); err != nil {
+			return err
+		}
+
+		if _, err := database.DB.Exec(`
+			INSERT INTO workout_images (user_id, extraction_id, filename, content_type, size)
+			VALUES (?, ?, ?, ?, ?)`,
+			userID, extractionID, filename, img.ContentType, len(img.Data)); err != nil {
+			os.Remove(filepath.Join(uploadsDir(), filename))
+			return err
+		}
+	}
+
+	return nil
+}
+
+// workoutImages maneja GET /api/workouts/:id/images (listar) y GET /api/workouts/:id/images/:imageId (descargar)
+func workoutImages(w http.ResponseWriter, r *http.Request, workoutID int, imageID string) {
+	userID := r.Context().Value("userID").(int)
+
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if imageID == "" {
+		images, err := loadWorkoutImages(userID, workoutID)
+		if err != nil {
+			log.Printf("Error obteniendo imágenes: %v", err)
+			httpError(w, r, "Error obteniendo imágenes", http.StatusInternalServerError)
+			return
+		}
+
+		// Enlaces firmados para poder mostrarlas con <img> o descargarlas sin cabecera Authorization
+		sessionID, _ := r.Context().Value("sessionID").(int64)
+		for i := range images {
+			images[i].URL = services.GetAuthService().SignURL(services.PurposeWorkoutImage, images[i].URL, userID, sessionID, services.WorkoutImageURLTTL)
+		}
+
+		json.NewEncoder(w).Encode(images)
+		return
+	}
+
+	id, err := strconv.Atoi(imageID)
+	if err != nil {
+		httpError(w, r, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	var filename, contentType string
+	err = database.DB.QueryRow(`
+		SELECT filename, content_type FROM workout_images
+		WHERE id = ? AND workout_id = ? AND user_id = ?`, id, workoutID, userID).Scan(&filename, &contentType)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Imagen no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "Error obteniendo imagen", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeFile(w, r, filepath.Join(uploadsDir(), filename))
+}
+
+// loadWorkoutImages obtiene las capturas vinculadas a un workout del usuario
+func loadWorkoutImages(userID, workoutID int) ([]models.WorkoutImage, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, user_id, workout_id, extraction_id, content_type, size, created_at
+		FROM workout_images
+		WHERE workout_id = ? AND user_id = ?
+		ORDER BY id`, workoutID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []models.WorkoutImage{}
+	for rows.Next() {
+		var img models.WorkoutImage
+		var linkedWorkout, extractionID sql.NullInt64
+		if err := rows.Scan(&img.ID, &img.UserID, &linkedWorkout, &extractionID,
+			&img.ContentType, &img.Size, &img.CreatedAt); err != nil {
+			return nil, err
+		}
+		if linkedWorkout.Valid {
+			id := int(linkedWorkout.Int64)
+			img.WorkoutID = &id
+		}
+		if extractionID.Valid {
+			id := int(extractionID.Int64)
+			img.ExtractionID = &id
+		}
+		img.URL = fmt.Sprintf("/api/workouts/%d/images/%d", workoutID, img.ID)
+		images = append(images, img)
+	}
+
+	return images, rows.Err()
+}
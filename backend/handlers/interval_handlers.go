@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"trainapp/database"
+	"trainapp/models"
+	"trainapp/services"
+)
+
+// workoutIntervals maneja GET (listar) y POST (volver a detectar) las series de un workout
+func workoutIntervals(w http.ResponseWriter, r *http.Request, id int) {
+	userID := r.Context().Value("userID").(int)
+
+	// Verificar que el workout pertenece al usuario y obtener sus datos de Strava
+	var stravaActivityID sql.NullInt64
+	var stravaDataJSON sql.NullString
+	err := database.DB.QueryRow(`
+		SELECT strava_activity_id, strava_data
+		FROM workouts WHERE id = ? AND user_id = ?`, id, userID).Scan(&stravaActivityID, &stravaDataJSON)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Workout no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "Error obteniendo workout", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		reps, err := loadWorkoutIntervals(id)
+		if err != nil {
+			httpError(w, r, "Error obteniendo series", http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"workout_id": id,
+			"intervals":  reps,
+		})
+	case "POST":
+		var stravaData map[string]interface{}
+		if stravaDataJSON.Valid && stravaDataJSON.String != "" {
+			json.Unmarshal([]byte(stravaDataJSON.String), &stravaData)
+		}
+
+		// Si las vueltas no bastan se descargan los streams de Strava (si el usuario está conectado)
+		var stravaService *services.StravaService
+		var accessToken string
+		if stravaActivityID.Valid && database.DB.QueryRow(`
+			SELECT access_token FROM strava_tokens WHERE user_id = ?`, userID).Scan(&accessToken) == nil {
+			stravaService = services.NewStravaService(accessToken)
+		}
+
+		reps, source, err := refreshWorkoutIntervals(id, stravaActivityID.Int64, stravaData, stravaService)
+		if err != nil {
+			log.Printf("Error detectando series del workout %d: %v", id, err)
+			httpError(w, r, "Error detectando series", http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"workout_id": id,
+			"source":     source,
+			"intervals":  reps,
+		})
+	default:
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// refreshWorkoutIntervals detecta las series de un workout y reemplaza las guardadas.
+// Primero prueba con las vueltas de stravaData; si no son concluyentes usa los streams
+// cacheados o, si stravaService no es nil, los descarga de Strava.
+func refreshWorkoutIntervals(workoutID int, stravaActivityID int64, stravaData map[string]interface{}, stravaService *services.StravaService) ([]models.WorkoutInterval, string, error) {
+	reps, source := services.DetectIntervals(stravaData, nil)
+
+	if reps == nil {
+		streams := loadWorkoutStreams(workoutID)
+		if streams == nil && stravaService != nil && stravaActivityID > 0 {
+			fetched, err := stravaService.GetActivityStreams(int(stravaActivityID))
+			if err != nil {
+				log.Printf("⚠️  Error obteniendo streams de actividad %d: %v", stravaActivityID, err)
+			} else {
+				streams = fetched
+				if err := saveWorkoutStreams(workoutID, streams); err != nil {
+					log.Printf("⚠️  Error guardando streams del workout %d: %v", workoutID, err)
+				}
+			}
+		}
+
+		if streams != nil {
+			reps, source = services.DetectIntervals(nil, streams)
+		}
+	}
+
+	if err := saveWorkoutIntervals(workoutID, reps); err != nil {
+		return nil, "", err
+	}
+
+	if reps == nil {
+		reps = []models.WorkoutInterval{}
+	}
+
+	return reps, source, nil
+}
+
+// loadWorkoutIntervals obtiene las series guardadas de un workout en orden
+func loadWorkoutIntervals(workoutID int) ([]models.WorkoutInterval, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, workout_id, rep_index, kind, source, start_offset, distance, duration,
+		       avg_pace, avg_heart_rate, max_heart_rate, avg_power, created_at
+		FROM workout_intervals
+		WHERE workout_id = ?
+		ORDER BY rep_index`, workoutID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reps := []models.WorkoutInterval{}
+	for rows.Next() {
+		var rep models.WorkoutInterval
+		if err := rows.Scan(&rep.ID, &rep.WorkoutID, &rep.RepIndex, &rep.Kind, &rep.Source,
+			&rep.StartOffset, &rep.Distance, &rep.Duration, &rep.AvgPace,
+			&rep.AvgHeartRate, &rep.MaxHeartRate, &rep.AvgPower, &rep.CreatedAt); err != nil {
+			return nil, err
+		}
+		reps = append(reps, rep)
+	}
+
+	return reps, rows.Err()
+}
+
+// saveWorkoutIntervals reemplaza las series guardadas de un workout
+func saveWorkoutIntervals(workoutID int, reps []models.WorkoutInterval) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM workout_intervals WHERE workout_id = ?`, workoutID); err != nil {
+		return err
+	}
+
+	for _, rep := range reps {
+		if _, err := tx.Exec(`
+			INSERT INTO workout_intervals (workout_id, rep_index, kind, source, start_offset, distance,
+			                               duration, avg_pace, avg_heart_rate, max_heart_rate, avg_power)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			workoutID, rep.RepIndex, rep.Kind, rep.Source, rep.StartOffset, rep.Distance,
+			rep.Duration, rep.AvgPace, rep.AvgHeartRate, rep.MaxHeartRate, rep.AvgPower); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// loadWorkoutStreams devuelve los streams cacheados de un workout o nil si no hay
+func loadWorkoutStreams(workoutID int) *services.ActivityStreams {
+	var data string
+	if err := database.DB.QueryRow(`
+		SELECT data FROM workout_streams WHERE workout_id = ?`, workoutID).Scan(&data); err != nil {
+		return nil
+	}
+
+	var streams services.ActivityStreams
+	if err := json.Unmarshal([]byte(data), &streams); err != nil {
+		log.Printf("Error parsing cached streams: %v", err)
+		return nil
+	}
+
+	return &streams
+}
+
+// saveWorkoutStreams cachea los streams de un workout
+func saveWorkoutStreams(workoutID int, streams *services.ActivityStreams) error {
+	data, err := json.Marshal(streams)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO workout_streams (workout_id, data)
+		VALUES (?, ?)
+		ON CONFLICT(workout_id) DO UPDATE SET data = excluded.data`,
+		workoutID, string(data))
+	return err
+}
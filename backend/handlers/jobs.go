@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"trainapp/database"
+)
+
+// Estados de un trabajo en segundo plano
+const (
+	jobPending = "pending"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+	jobExpired = "expired" // terminado, pero su fichero ya se ha borrado
+)
+
+// Tipos de trabajo
+const jobDataExport = "data_export"
+
+// Cada cuánto revisa el worker la cola si nadie lo despierta y borra los resultados caducados
+const jobPollInterval = time.Minute
+
+// Job es un trabajo en segundo plano de un usuario
+type Job struct {
+	ID          int64      `json:"id"`
+	Kind        string     `json:"kind"`
+	Status      string     `json:"status"`
+	Size        int64      `json:"size,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+
+	userID int
+	result string // fichero generado
+}
+
+// jobRunner ejecuta un trabajo y devuelve el fichero generado, su tamaño y cuándo caduca
+type jobRunner func(job *Job) (result string, size int64, expiresAt time.Time, err error)
+
+// jobRunners son los ejecutores de cada tipo de trabajo
+var jobRunners = map[string]jobRunner{
+	jobDataExport: runDataExport,
+}
+
+// jobWake despierta al worker cuando se encola un trabajo
+var jobWake = make(chan struct{}, 1)
+
+const jobColumns = `id, user_id, kind, status, COALESCE(result, ''), COALESCE(size, 0), COALESCE(error, ''),
+	created_at, started_at, finished_at, expires_at`
+
+// scanJob lee un trabajo de una fila con jobColumns
+func scanJob(row interface{ Scan(...interface{}) error }) (*Job, error) {
+	job := &Job{}
+	var startedAt, finishedAt, expiresAt sql.NullTime
+	if err := row.Scan(&job.ID, &job.userID, &job.Kind, &job.Status, &job.result, &job.Size, &job.Error,
+		&job.CreatedAt, &startedAt, &finishedAt, &expiresAt); err != nil {
+		return nil, err
+	}
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	if expiresAt.Valid {
+		job.ExpiresAt = &expiresAt.Time
+	}
+	return job, nil
+}
+
+// StartJobs arranca el worker de los trabajos en segundo plano. Los trabajos que estaban en
+// marcha cuando se paró el servidor vuelven a la cola.
+func StartJobs() error {
+	if _, err := database.DB.Exec(`UPDATE jobs SET status = ?, started_at = NULL WHERE status = ?`,
+		jobPending, jobRunning); err != nil {
+		return err
+	}
+	go jobWorker()
+	return nil
+}
+
+// enqueueJob encola un trabajo para el usuario y despierta al worker
+func enqueueJob(userID int, kind string) (*Job, error) {
+	job := &Job{Kind: kind, Status: jobPending, CreatedAt: time.Now(), userID: userID}
+	result, err := database.DB.Exec(`
+		INSERT INTO jobs (user_id, kind, status, created_at) VALUES (?, ?, ?, ?)`,
+		userID, kind, jobPending, job.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	job.ID, _ = result.LastInsertId()
+
+	select {
+	case jobWake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// jobWorker ejecuta los trabajos pendientes de uno en uno, por orden de llegada
+func jobWorker() {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		for runNextJob() {
+		}
+		removeExpiredJobResults()
+
+		select {
+		case <-jobWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// runNextJob ejecuta el trabajo pendiente más antiguo; devuelve false si no había ninguno
+func runNextJob() bool {
+	job, err := scanJob(database.DB.QueryRow(`
+		SELECT `+jobColumns+` FROM jobs WHERE status = ? ORDER BY id LIMIT 1`, jobPending))
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		log.Printf("⚠️  Error obteniendo trabajos pendientes: %v", err)
+		return false
+	}
+
+	startedAt := time.Now()
+	if _, err := database.DB.Exec(`UPDATE jobs SET status = ?, started_at = ? WHERE id = ?`,
+		jobRunning, startedAt, job.ID); err != nil {
+		log.Printf("⚠️  Error iniciando trabajo %d: %v", job.ID, err)
+		return false
+	}
+
+	result, size, expiresAt, err := runJob(job)
+	if err != nil {
+		log.Printf("❌ Trabajo %d (%s, usuario %d) fallido: %v", job.ID, job.Kind, job.userID, err)
+		database.DB.Exec(`UPDATE jobs SET status = ?, error = ?, finished_at = ? WHERE id = ?`,
+			jobFailed, err.Error(), time.Now(), job.ID)
+		return true
+	}
+
+	updated, err := database.DB.Exec(`
+		UPDATE jobs SET status = ?, result = ?, size = ?, finished_at = ?, expires_at = ? WHERE id = ?`,
+		jobDone, result, size, time.Now(), expiresAt, job.ID)
+	if err != nil {
+		log.Printf("⚠️  Error guardando el resultado del trabajo %d: %v", job.ID, err)
+		os.Remove(result)
+		return true
+	}
+	if n, _ := updated.RowsAffected(); n == 0 {
+		// El usuario borró su cuenta mientras se generaba: el resultado no debe quedarse en disco
+		os.Remove(result)
+		return true
+	}
+	log.Printf("✅ Trabajo %d (%s, usuario %d) terminado en %s", job.ID, job.Kind, job.userID, time.Since(startedAt).Round(time.Millisecond))
+	return true
+}
+
+// runJob ejecuta un trabajo con su ejecutor; un panic se convierte en error
+func runJob(job *Job) (result string, size int64, expiresAt time.Time, err error) {
+	runner, ok := jobRunners[job.Kind]
+	if !ok {
+		return "", 0, time.Time{}, fmt.Errorf("tipo de trabajo desconocido: %s", job.Kind)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return runner(job)
+}
+
+// removeExpiredJobResults borra los ficheros de los trabajos caducados
+func removeExpiredJobResults() {
+	rows, err := database.DB.Query(`
+		SELECT id, result, expires_at FROM jobs WHERE status = ?`, jobDone)
+	if err != nil {
+		log.Printf("⚠️  Error obteniendo trabajos caducados: %v", err)
+		return
+	}
+	now := time.Now()
+	expired := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var result string
+		var expiresAt sql.NullTime
+		if rows.Scan(&id, &result, &expiresAt) == nil && expiresAt.Valid && now.After(expiresAt.Time) {
+			expired[id] = result
+		}
+	}
+	rows.Close()
+
+	for id, result := range expired {
+		if err := os.Remove(result); err != nil && !os.IsNotExist(err) {
+			log.Printf("⚠️  Error borrando %s: %v", result, err)
+			continue
+		}
+		database.DB.Exec(`UPDATE jobs SET status = ?, result = NULL WHERE id = ?`, jobExpired, id)
+	}
+}
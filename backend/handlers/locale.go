@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"trainapp/database"
+	"trainapp/services"
+)
+
+// requestLocale devuelve el idioma de la respuesta: el guardado por el usuario autenticado o,
+// si no ha elegido ninguno, el de la cabecera Accept-Language
+func requestLocale(r *http.Request) string {
+	if userID, ok := r.Context().Value("userID").(int); ok {
+		if locale := userLocale(userID); locale != "" {
+			return locale
+		}
+	}
+	return services.LocaleFromAcceptLanguage(r.Header.Get("Accept-Language"))
+}
+
+// userLocale devuelve el idioma guardado por el usuario, o "" si no ha elegido ninguno
+func userLocale(userID int) string {
+	var locale sql.NullString
+	if err := database.DB.QueryRow("SELECT locale FROM users WHERE id = ?", userID).Scan(&locale); err != nil {
+		return ""
+	}
+	return services.NormalizeLocale(locale.String)
+}
+
+// httpError responde con el mensaje de error traducido al idioma de la petición
+func httpError(w http.ResponseWriter, r *http.Request, msg string, code int) {
+	http.Error(w, services.T(requestLocale(r), msg), code)
+}
+
+// coachError responde con el error de una llamada al coach: 504 si se agotó el tiempo de la
+// petición o de los intentos y 500 en el resto de casos
+func coachError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	code := http.StatusInternalServerError
+	if errors.Is(err, context.DeadlineExceeded) {
+		code = http.StatusGatewayTimeout
+	}
+	httpError(w, r, msg+": "+err.Error(), code)
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trainapp/database"
+	"trainapp/services"
+)
+
+// Motivos de los intentos de inicio de sesión fallidos
+const (
+	loginUnknownUser   = "unknown_user"
+	loginWrongPassword = "wrong_password"
+	loginLocked        = "locked"
+	loginDisabled      = "disabled"
+)
+
+// Intentos que se miran como máximo para calcular el bloqueo (más fallos seguidos ya dan el
+// bloqueo máximo) y antigüedad máxima de esos intentos
+const (
+	lockoutLookback       = 50
+	lockoutLookbackWindow = 24 * time.Hour
+)
+
+// LoginAttempt es un intento de inicio de sesión registrado para auditoría
+type LoginAttempt struct {
+	ID        int64     `json:"id"`
+	Email     string    `json:"email"`
+	UserID    *int      `json:"user_id"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// recordLoginAttempt guarda un intento de inicio de sesión; los fallidos se anotan también en el log
+func recordLoginAttempt(r *http.Request, email string, userID int, success bool, reason string) {
+	if !success {
+		log.Printf("⚠️  Login fallido: %s desde %s (%s)", email, clientIP(r), reason)
+	}
+	if _, err := database.DB.Exec(`
+		INSERT INTO login_attempts (email, user_id, ip, user_agent, success, reason, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		email, nullIfZero(userID), clientIP(r), r.UserAgent(), success, nullIfEmpty(reason), time.Now()); err != nil {
+		log.Printf("⚠️  Error guardando intento de login: %v", err)
+	}
+}
+
+// nullIfZero convierte un ID 0 en NULL
+func nullIfZero(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// loginLockedFor devuelve cuánto falta para que se desbloquee la cuenta del email, según sus
+// intentos fallidos seguidos desde el último inicio de sesión correcto (0 si no está bloqueada).
+// Los intentos rechazados durante el bloqueo no lo alargan.
+func loginLockedFor(email string) (time.Duration, error) {
+	now := time.Now()
+	rows, err := database.DB.Query(`
+		SELECT success, COALESCE(reason, ''), created_at
+		FROM login_attempts
+		WHERE email = ?
+		ORDER BY id DESC
+		LIMIT ?`, email, lockoutLookback)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	failures := 0
+	var lastFailure time.Time
+	for rows.Next() {
+		var success bool
+		var reason string
+		var createdAt time.Time
+		if err := rows.Scan(&success, &reason, &createdAt); err != nil {
+			return 0, err
+		}
+		if success || now.Sub(createdAt) > lockoutLookbackWindow {
+			break
+		}
+		if reason == loginLocked {
+			continue
+		}
+		if failures == 0 {
+			lastFailure = createdAt
+		}
+		failures++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	remaining := lastFailure.Add(services.LoginLockout(failures)).Sub(now)
+	if failures == 0 || remaining <= 0 {
+		return 0, nil
+	}
+	return remaining, nil
+}
+
+// writeLockedOut responde 429 a un inicio de sesión de una cuenta bloqueada
+func writeLockedOut(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	httpError(w, r, "Demasiados intentos fallidos. Inténtalo de nuevo más tarde", http.StatusTooManyRequests)
+}
+
+// AdminLoginAttemptsHandler lista los intentos de inicio de sesión más recientes
+// (?email=, ?ip=, ?failed=true para ver solo los fallidos, ?limit= hasta 500)
+func AdminLoginAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	conditions := []string{"1 = 1"}
+	params := []interface{}{}
+	if email := strings.TrimSpace(query.Get("email")); email != "" {
+		conditions = append(conditions, "email = ? COLLATE NOCASE")
+		params = append(params, email)
+	}
+	if ip := strings.TrimSpace(query.Get("ip")); ip != "" {
+		conditions = append(conditions, "ip = ?")
+		params = append(params, ip)
+	}
+	if query.Get("failed") == "true" {
+		conditions = append(conditions, "success = 0")
+	}
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 500 {
+			httpError(w, r, "limit debe estar entre 1 y 500", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	params = append(params, limit)
+
+	rows, err := database.DB.Query(`
+		SELECT id, email, user_id, COALESCE(ip, ''), COALESCE(user_agent, ''), success, COALESCE(reason, ''), created_at
+		FROM login_attempts
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY id DESC
+		LIMIT ?`, params...)
+	if err != nil {
+		log.Printf("Error obteniendo intentos de login: %v", err)
+		httpError(w, r, "Error obteniendo intentos de inicio de sesión", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	attempts := []LoginAttempt{}
+	for rows.Next() {
+		var a LoginAttempt
+		var userID *int
+		if err := rows.Scan(&a.ID, &a.Email, &userID, &a.IP, &a.UserAgent, &a.Success, &a.Reason, &a.CreatedAt); err != nil {
+			log.Printf("Error escaneando intento de login: %v", err)
+			continue
+		}
+		a.UserID = userID
+		attempts = append(attempts, a)
+	}
+
+	json.NewEncoder(w).Encode(attempts)
+}
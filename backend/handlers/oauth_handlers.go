@@ -0,0 +1,661 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"trainapp/database"
+	"trainapp/services"
+)
+
+// oauthStateCookie guarda el state del flujo OAuth en el navegador que lo empezó, para que la
+// vuelta del proveedor solo valga en ese navegador (evita que alguien inicie sesión a otro con
+// su cuenta). SameSite=Lax porque la vuelta es una navegación desde el proveedor.
+const oauthStateCookie = "trainapp_oauth"
+
+// errIdentityTaken indica que la identidad externa ya está vinculada a otra cuenta
+var errIdentityTaken = errors.New("identidad vinculada a otra cuenta")
+
+// OAuthProvider es un proveedor con el que se puede iniciar sesión
+type OAuthProvider struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Identity es una identidad externa vinculada a la cuenta
+type Identity struct {
+	ID          int64      `json:"id"`
+	Provider    string     `json:"provider"`
+	Email       string     `json:"email,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
+
+// oauthProviders devuelve los proveedores configurados
+func oauthProviders() []OAuthProvider {
+	providers := []OAuthProvider{}
+	if client := services.GetStravaClient(); client != nil && client.ClientID != "" {
+		providers = append(providers, OAuthProvider{ID: services.ProviderStrava, Name: "Strava"})
+	}
+	if oidc := services.GetOIDCProvider(); oidc != nil {
+		providers = append(providers, OAuthProvider{ID: services.ProviderOIDC, Name: oidc.Name})
+	}
+	return providers
+}
+
+// oauthEnabled indica si provider está configurado
+func oauthEnabled(provider string) bool {
+	for _, p := range oauthProviders() {
+		if p.ID == provider {
+			return true
+		}
+	}
+	return false
+}
+
+// oauthRedirectURI es la URL de vuelta del proveedor: STRAVA_LOGIN_REDIRECT_URI u
+// OIDC_REDIRECT_URI, o /api/auth/oauth/{provider}/callback en la URL pública
+func oauthRedirectURI(r *http.Request, provider string) string {
+	configured := os.Getenv("STRAVA_LOGIN_REDIRECT_URI")
+	if provider == services.ProviderOIDC {
+		configured = services.GetOIDCProvider().RedirectURI
+	}
+	if configured != "" {
+		return configured
+	}
+	return appBaseURL(r) + "/api/auth/oauth/" + provider + "/callback"
+}
+
+// setOAuthStateCookie guarda el state del flujo en el navegador (ver oauthStateCookie)
+func setOAuthStateCookie(w http.ResponseWriter, r *http.Request, state string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     "/api",
+		MaxAge:   int(services.OAuthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// checkOAuthState comprueba que el state de la vuelta del proveedor es el que se guardó en este
+// navegador y es válido para el flujo, y borra la cookie
+func checkOAuthState(w http.ResponseWriter, r *http.Request, flow string) (*services.TokenClaims, error) {
+	state := r.URL.Query().Get("state")
+	cookie, err := r.Cookie(oauthStateCookie)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Path:     "/api",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	if err != nil || state == "" || cookie.Value != state {
+		return nil, errors.New("state inválido")
+	}
+	return services.GetAuthService().ValidateOAuthState(state, flow)
+}
+
+// startOAuth envía al usuario al proveedor; userID es el usuario que vincula su cuenta (0 al
+// iniciar sesión)
+func startOAuth(w http.ResponseWriter, r *http.Request, provider string, userID int) {
+	auth := services.GetAuthService()
+	state, err := auth.NewOAuthState(provider, userID)
+	if err != nil {
+		httpError(w, r, "Error generando token", http.StatusInternalServerError)
+		return
+	}
+
+	var authURL string
+	switch provider {
+	case services.ProviderStrava:
+		authURL = services.GetStravaClient().GetAuthorizationURL(state, oauthRedirectURI(r, provider))
+	case services.ProviderOIDC:
+		// El ID del state sirve de nonce y de él se deriva el code_verifier de PKCE
+		claims, _ := auth.ValidateOAuthState(state, provider)
+		authURL, err = services.GetOIDCProvider().AuthorizationURL(state, claims.ID,
+			services.PKCEChallenge(auth.PKCEVerifier(state)), oauthRedirectURI(r, provider))
+		if err != nil {
+			log.Printf("⚠️  Error con el proveedor OIDC: %v", err)
+			httpError(w, r, "El proveedor de identidad no está disponible", http.StatusBadGateway)
+			return
+		}
+	}
+
+	setOAuthStateCookie(w, r, state)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OAuthProvidersHandler maneja GET /api/auth/oauth/providers: los proveedores con los que se
+// puede iniciar sesión
+func OAuthProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"providers": oauthProviders()})
+}
+
+// OAuthHandler maneja /api/auth/oauth/{provider}/login, que empieza el inicio de sesión con el
+// proveedor, y /api/auth/oauth/{provider}/callback, la vuelta del proveedor
+func OAuthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/auth/oauth/"), "/"), "/")
+	if len(parts) != 2 || !oauthEnabled(parts[0]) {
+		httpError(w, r, "Proveedor no disponible", http.StatusNotFound)
+		return
+	}
+
+	switch parts[1] {
+	case "login":
+		startOAuth(w, r, parts[0], 0)
+	case "callback":
+		oauthCallback(w, r, parts[0])
+	default:
+		httpError(w, r, "Ruta no encontrada", http.StatusNotFound)
+	}
+}
+
+// OAuthLinkURLHandler maneja GET /api/auth/oauth/link-url?provider=: un enlace firmado de corta
+// duración para vincular el proveedor a la cuenta desde el navegador
+func OAuthLinkURLHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	provider := r.URL.Query().Get("provider")
+	if !oauthEnabled(provider) {
+		httpError(w, r, "Proveedor no disponible", http.StatusNotFound)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+	sessionID, _ := r.Context().Value("sessionID").(int64)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":        services.GetAuthService().SignURL(services.PurposeOAuthLink, "/api/auth/oauth/link/"+provider, userID, sessionID, services.OAuthLinkURLTTL),
+		"expires_in": int(services.OAuthLinkURLTTL.Seconds()),
+	})
+}
+
+// OAuthLinkHandler maneja GET /api/auth/oauth/link/{provider}: envía al usuario al proveedor
+// para vincular la identidad a su cuenta
+func OAuthLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	provider := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/auth/oauth/link/"), "/")
+	if !oauthEnabled(provider) {
+		httpError(w, r, "Proveedor no disponible", http.StatusNotFound)
+		return
+	}
+	startOAuth(w, r, provider, r.Context().Value("userID").(int))
+}
+
+// exchangeOAuthCode canjea el código de la vuelta del proveedor por la identidad del usuario.
+// Con Strava devuelve también los tokens, que sirven para sincronizar.
+func exchangeOAuthCode(r *http.Request, provider string, claims *services.TokenClaims) (*services.ExternalIdentity, *services.StravaTokenResponse, error) {
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		return nil, nil, errors.New("código de autorización no proporcionado")
+	}
+
+	if provider == services.ProviderStrava {
+		tokens, err := services.GetStravaClient().ExchangeToken(code)
+		if err != nil {
+			return nil, nil, err
+		}
+		if tokens.Athlete.ID == 0 {
+			return nil, nil, errors.New("respuesta de Strava sin atleta")
+		}
+		identity := tokens.Identity()
+		return &identity, tokens, nil
+	}
+
+	state := r.URL.Query().Get("state")
+	identity, err := services.GetOIDCProvider().Exchange(code, services.GetAuthService().PKCEVerifier(state),
+		oauthRedirectURI(r, provider), claims.ID)
+	return identity, nil, err
+}
+
+// oauthCallback termina el flujo: vincula la identidad a la cuenta del usuario o inicia sesión
+// con ella (creando la cuenta si no hay ninguna). Como es una navegación, responde siempre con
+// una redirección al frontend.
+func oauthCallback(w http.ResponseWriter, r *http.Request, provider string) {
+	locale := requestLocale(r)
+	fail := func(target, msg string) {
+		http.Redirect(w, r, appBaseURL(r)+target+"oauth_error="+url.QueryEscape(services.T(locale, msg)), http.StatusFound)
+	}
+
+	claims, err := checkOAuthState(w, r, provider)
+	if err != nil {
+		fail("/login.html#", "El inicio de sesión ha caducado o no se empezó en este navegador: inténtalo de nuevo")
+		return
+	}
+	target := "/login.html#"
+	if claims.UserID != 0 {
+		target = "/?"
+	}
+	if r.URL.Query().Get("error") != "" {
+		fail(target, "Autorización cancelada en el proveedor")
+		return
+	}
+
+	identity, tokens, err := exchangeOAuthCode(r, provider, claims)
+	if err != nil {
+		log.Printf("⚠️  Error canjeando el código de %s: %v", provider, err)
+		fail(target, "No se ha podido completar la autorización con el proveedor")
+		return
+	}
+
+	// Vincular la identidad a la cuenta del usuario
+	if claims.UserID != 0 {
+		if accountDisabled(claims.UserID) {
+			fail(target, "Cuenta desactivada")
+			return
+		}
+		err := linkIdentity(claims.UserID, identity)
+		if errors.Is(err, errIdentityTaken) {
+			fail(target, "Esa cuenta del proveedor ya está vinculada a otro usuario")
+			return
+		}
+		if err != nil {
+			log.Printf("Error vinculando identidad: %v", err)
+			fail(target, "Error vinculando la cuenta")
+			return
+		}
+		if tokens != nil {
+			saveStravaLoginTokens(claims.UserID, tokens)
+		}
+		log.Printf("🔗 Identidad de %s vinculada: usuario %d", provider, claims.UserID)
+		http.Redirect(w, r, appBaseURL(r)+"/?linked="+provider, http.StatusFound)
+		return
+	}
+
+	// Iniciar sesión: la identidad ya vinculada o una cuenta nueva
+	userID, err := identityOwner(identity)
+	if err != nil {
+		log.Printf("Error buscando identidad: %v", err)
+		fail(target, "Error interno del servidor")
+		return
+	}
+	if userID == 0 {
+		// Sin email (Strava no lo da) el usuario lo indica para completar el alta
+		if identity.Email == "" {
+			token, err := services.GetAuthService().GenerateOAuthSignupToken(*identity)
+			if err != nil {
+				fail(target, "Error generando token")
+				return
+			}
+			http.Redirect(w, r, appBaseURL(r)+"/login.html#oauth_signup="+url.QueryEscape(token), http.StatusFound)
+			return
+		}
+
+		// Una cuenta existente con ese email solo se vincula con su contraseña, desde el perfil:
+		// el proveedor no demuestra que el usuario sea el dueño de la cuenta
+		var exists int
+		database.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ? COLLATE NOCASE", identity.Email).Scan(&exists)
+		if exists > 0 {
+			fail(target, "Ya hay una cuenta con ese email: inicia sesión con tu contraseña y vincula el proveedor desde tu perfil")
+			return
+		}
+
+		user, err := createOAuthUser(r, identity, identity.Email)
+		if err != nil {
+			log.Printf("Error creando usuario: %v", err)
+			fail(target, "Error creando usuario")
+			return
+		}
+		userID = user.ID
+	}
+
+	user, err := loadUserProfile(userID)
+	if err != nil {
+		fail(target, "Usuario no encontrado")
+		return
+	}
+	if accountDisabled(userID) {
+		recordLoginAttempt(r, user.Email, userID, false, loginDisabled)
+		fail(target, "Cuenta desactivada")
+		return
+	}
+	if tokens != nil {
+		saveStravaLoginTokens(userID, tokens)
+	}
+	database.DB.Exec(`
+		UPDATE user_identities SET last_login_at = ?, email = COALESCE(?, email)
+		WHERE provider = ? AND subject = ?`,
+		time.Now(), nullIfEmpty(identity.Email), identity.Provider, identity.Subject)
+
+	// El frontend canjea el código por la sesión (POST /api/auth/oauth/exchange)
+	code, err := createEmailToken(userID, services.TokenOAuthLogin, user.Email, services.OAuthLoginCodeTTL)
+	if err != nil {
+		log.Printf("Error creando código de inicio de sesión: %v", err)
+		fail(target, "Error interno del servidor")
+		return
+	}
+	log.Printf("🔐 Inicio de sesión con %s: usuario %d", provider, userID)
+	http.Redirect(w, r, appBaseURL(r)+"/login.html#oauth="+url.QueryEscape(code), http.StatusFound)
+}
+
+// identityOwner devuelve el usuario al que está vinculada la identidad (0 si no lo está)
+func identityOwner(identity *services.ExternalIdentity) (int, error) {
+	var userID int
+	err := database.DB.QueryRow(`
+		SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?`,
+		identity.Provider, identity.Subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return userID, err
+}
+
+// linkIdentity vincula la identidad a la cuenta. Cada cuenta tiene como mucho una identidad de
+// cada proveedor; vincular otra la sustituye.
+func linkIdentity(userID int, identity *services.ExternalIdentity) error {
+	owner, err := identityOwner(identity)
+	if err != nil {
+		return err
+	}
+	if owner == userID {
+		return nil
+	}
+	if owner != 0 {
+		return errIdentityTaken
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`
+		DELETE FROM user_identities WHERE user_id = ? AND provider = ?`, userID, identity.Provider); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO user_identities (user_id, provider, subject, email, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		userID, identity.Provider, identity.Subject, nullIfEmpty(identity.Email), time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// saveStravaLoginTokens guarda los tokens de Strava del inicio de sesión para sincronizar, salvo
+// si el usuario ya tiene conectado otro atleta
+func saveStravaLoginTokens(userID int, tokens *services.StravaTokenResponse) {
+	if _, err := database.DB.Exec(`
+		INSERT INTO strava_tokens (user_id, access_token, refresh_token, expires_at, athlete_id)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			access_token = excluded.access_token,
+			refresh_token = excluded.refresh_token,
+			expires_at = excluded.expires_at,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE strava_tokens.athlete_id = excluded.athlete_id OR strava_tokens.athlete_id IS NULL
+	`, userID, tokens.AccessToken, tokens.RefreshToken, tokens.ExpiresAt, tokens.Athlete.ID); err != nil {
+		log.Printf("⚠️  Error guardando tokens de Strava del usuario %d: %v", userID, err)
+	}
+}
+
+// createOAuthUser crea una cuenta sin contraseña para una identidad externa (se puede crear una
+// después con "¿Has olvidado tu contraseña?") y se la vincula. El email queda verificado si el
+// proveedor lo garantiza; si no, se envía el enlace de verificación.
+func createOAuthUser(r *http.Request, identity *services.ExternalIdentity, email string) (UserProfile, error) {
+	name := identity.Name
+	if name == "" {
+		name = strings.SplitN(email, "@", 2)[0]
+	}
+	user := UserProfile{
+		Name:          name,
+		Email:         email,
+		EmailVerified: identity.EmailVerified && strings.EqualFold(identity.Email, email),
+		Locale:        services.LocaleFromAcceptLanguage(r.Header.Get("Accept-Language")),
+		Role:          services.AccountRoleUser,
+	}
+	var verifiedAt interface{}
+	if user.EmailVerified {
+		verifiedAt = time.Now()
+	}
+
+	result, err := database.DB.Exec(`
+		INSERT INTO users (name, email, password_hash, locale, email_verified_at)
+		VALUES (?, ?, '', ?, ?)`, user.Name, user.Email, user.Locale, verifiedAt)
+	if err != nil {
+		return user, err
+	}
+	id, _ := result.LastInsertId()
+	user.ID = int(id)
+
+	if _, err := database.DB.Exec(`
+		INSERT INTO runner_profiles (user_id, training_level) VALUES (?, ?)`, user.ID, "intermediate"); err != nil {
+		log.Printf("Error creando perfil: %v", err)
+	}
+	if err := linkIdentity(user.ID, identity); err != nil {
+		return user, err
+	}
+	if !user.EmailVerified {
+		if err := sendVerificationEmail(r, user); err != nil {
+			log.Printf("⚠️  Error enviando verificación a %s: %v", user.Email, err)
+		}
+	}
+	log.Printf("✅ Usuario registrado con %s: %s (%s)", identity.Provider, user.Name, user.Email)
+	return user, nil
+}
+
+// OAuthExchangeHandler maneja POST /api/auth/oauth/exchange: canjea el código de un solo uso de
+// la vuelta del proveedor por la sesión (o por el reto del 2FA)
+func OAuthExchangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+
+	userID, _, email, err := consumeEmailToken(req.Code, services.TokenOAuthLogin)
+	if err != nil {
+		httpError(w, r, "El código no es válido o ha caducado", http.StatusUnauthorized)
+		return
+	}
+	if accountDisabled(userID) {
+		recordLoginAttempt(r, email, userID, false, loginDisabled)
+		httpError(w, r, "Cuenta desactivada", http.StatusForbidden)
+		return
+	}
+
+	// El proveedor no sustituye al segundo factor
+	mfa, err := twoFactorEnabled(userID)
+	if err != nil {
+		log.Printf("Error comprobando 2FA: %v", err)
+		httpError(w, r, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+	if mfa {
+		writeMFAChallenge(w, r, userID, email)
+		return
+	}
+
+	user, err := loadUserProfile(userID)
+	if err != nil {
+		httpError(w, r, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	recordLoginAttempt(r, email, userID, true, "")
+	writeAuthResponse(w, r, user)
+}
+
+// OAuthSignupHandler maneja POST /api/auth/oauth/signup: completa el alta de una identidad
+// externa sin email con el token de la vuelta del proveedor y el email que indica el usuario
+func OAuthSignupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Token string `json:"token"`
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := services.GetAuthService().ValidateOAuthSignupToken(req.Token)
+	if err != nil {
+		httpError(w, r, "El registro ha caducado: vuelve a iniciar sesión con el proveedor", http.StatusUnauthorized)
+		return
+	}
+	email, err := services.NormalizeEmail(req.Email)
+	if err != nil {
+		httpError(w, r, "Email inválido", http.StatusBadRequest)
+		return
+	}
+
+	if owner, err := identityOwner(identity); err != nil || owner != 0 {
+		httpError(w, r, "Esa cuenta del proveedor ya está vinculada a otro usuario", http.StatusConflict)
+		return
+	}
+	var exists int
+	if err := database.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ? COLLATE NOCASE", email).Scan(&exists); err != nil {
+		httpError(w, r, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+	if exists > 0 {
+		httpError(w, r, "El email ya está registrado", http.StatusConflict)
+		return
+	}
+
+	user, err := createOAuthUser(r, identity, email)
+	if err != nil {
+		log.Printf("Error creando usuario: %v", err)
+		httpError(w, r, "Error creando usuario", http.StatusInternalServerError)
+		return
+	}
+	recordLoginAttempt(r, email, user.ID, true, "")
+	writeAuthResponse(w, r, user)
+}
+
+// IdentitiesHandler maneja GET /api/auth/identities: las identidades externas vinculadas a la
+// cuenta, si tiene contraseña y los proveedores disponibles
+func IdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+	identities, err := loadIdentities(userID)
+	if err != nil {
+		httpError(w, r, "Error obteniendo identidades", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"has_password": hasPassword(userID),
+		"identities":   identities,
+		"providers":    oauthProviders(),
+	})
+}
+
+// IdentityDetailHandler maneja DELETE /api/auth/identities/:id: desvincula la identidad. No se
+// puede desvincular la única forma de entrar de una cuenta sin contraseña.
+func IdentityDetailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+	id, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/auth/identities/"), "/"), 10, 64)
+	if err != nil {
+		httpError(w, r, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	identities, err := loadIdentities(userID)
+	if err != nil {
+		httpError(w, r, "Error obteniendo identidades", http.StatusInternalServerError)
+		return
+	}
+	var identity *Identity
+	for i := range identities {
+		if identities[i].ID == id {
+			identity = &identities[i]
+		}
+	}
+	if identity == nil {
+		httpError(w, r, "Identidad no encontrada", http.StatusNotFound)
+		return
+	}
+	if len(identities) == 1 && !hasPassword(userID) {
+		httpError(w, r, "Es tu única forma de entrar: crea una contraseña con «¿Has olvidado tu contraseña?» antes de desvincularla", http.StatusConflict)
+		return
+	}
+
+	if _, err := database.DB.Exec(`DELETE FROM user_identities WHERE id = ? AND user_id = ?`, id, userID); err != nil {
+		httpError(w, r, "Error desvinculando la identidad", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("🔗 Identidad de %s desvinculada: usuario %d", identity.Provider, userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadIdentities obtiene las identidades externas vinculadas a la cuenta
+func loadIdentities(userID int) ([]Identity, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, provider, COALESCE(email, ''), created_at, last_login_at
+		FROM user_identities WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		var identity Identity
+		var lastLogin sql.NullTime
+		if err := rows.Scan(&identity.ID, &identity.Provider, &identity.Email, &identity.CreatedAt, &lastLogin); err != nil {
+			return nil, err
+		}
+		if lastLogin.Valid {
+			identity.LastLoginAt = &lastLogin.Time
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// hasPassword indica si la cuenta tiene contraseña (las creadas con un proveedor no la tienen)
+func hasPassword(userID int) bool {
+	var passwordHash string
+	database.DB.QueryRow("SELECT password_hash FROM users WHERE id = ?", userID).Scan(&passwordHash)
+	return passwordHash != ""
+}
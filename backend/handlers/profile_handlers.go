@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trainapp/database"
+	"trainapp/models"
+	"trainapp/services"
+)
+
+// profileRequest contiene los campos editables del perfil de corredor; los campos nil no se modifican.
+// Los campos numéricos aceptan 0 para borrar el valor.
+type profileRequest struct {
+	Age            *int             `json:"age"`
+	Sex            *string          `json:"sex"`
+	Weight         *float64         `json:"weight"`
+	Height         *float64         `json:"height"`
+	RestingHR      *int             `json:"resting_hr"`
+	MaxHR          *int             `json:"max_hr"`
+	LTHR           *int             `json:"lthr"`
+	VO2max         *float64         `json:"vo2max"`
+	ThresholdPace  *string          `json:"threshold_pace"`
+	ThresholdPower *int             `json:"threshold_power"`
+	WeeklyKmTarget *float64         `json:"weekly_km_target"`
+	TrainingLevel  *string          `json:"training_level"`
+	HRZones        *[]models.HRZone `json:"hr_zones"` // [] vuelve a las zonas calculadas
+	RaceGoal       *string          `json:"race_goal"`
+	RaceGoalDate   *string          `json:"race_goal_date"` // YYYY-MM-DD, "" para borrar
+	Goals          *string          `json:"goals"`
+}
+
+// apply copia los campos presentes en la petición sobre p y valida el resultado
+func (req profileRequest) apply(p *models.RunnerProfile) string {
+	if req.Age != nil {
+		p.Age = *req.Age
+	}
+	if req.Sex != nil {
+		p.Sex = strings.TrimSpace(*req.Sex)
+	}
+	if req.Weight != nil {
+		p.Weight = *req.Weight
+	}
+	if req.Height != nil {
+		p.Height = *req.Height
+	}
+	if req.RestingHR != nil {
+		p.RestingHR = *req.RestingHR
+	}
+	if req.MaxHR != nil {
+		p.MaxHR = *req.MaxHR
+	}
+	if req.LTHR != nil {
+		p.LTHR = *req.LTHR
+	}
+	if req.VO2max != nil {
+		p.VO2max = *req.VO2max
+	}
+	if req.ThresholdPace != nil {
+		p.ThresholdPace = strings.TrimSpace(*req.ThresholdPace)
+	}
+	if req.ThresholdPower != nil {
+		p.ThresholdPower = *req.ThresholdPower
+	}
+	if req.WeeklyKmTarget != nil {
+		p.WeeklyKmTarget = *req.WeeklyKmTarget
+	}
+	if req.TrainingLevel != nil {
+		p.TrainingLevel = strings.TrimSpace(*req.TrainingLevel)
+	}
+	if req.HRZones != nil {
+		p.HRZones = *req.HRZones
+	}
+	if req.RaceGoal != nil {
+		p.RaceGoal = strings.TrimSpace(*req.RaceGoal)
+	}
+	if req.RaceGoalDate != nil {
+		p.RaceGoalDate = strings.TrimSpace(*req.RaceGoalDate)
+	}
+	if req.Goals != nil {
+		p.Goals = strings.TrimSpace(*req.Goals)
+	}
+
+	switch {
+	case p.Age != 0 && (p.Age < 10 || p.Age > 100):
+		return "La edad debe estar entre 10 y 100 años"
+	case p.Sex != "" && !services.ValidOption(p.Sex, services.RunnerSexes):
+		return "Sexo inválido (male, female, other)"
+	case p.Weight != 0 && (p.Weight < 30 || p.Weight > 200):
+		return "El peso debe estar entre 30 y 200 kg"
+	case p.Height != 0 && (p.Height < 120 || p.Height > 230):
+		return "La altura debe estar entre 120 y 230 cm"
+	case p.RestingHR != 0 && (p.RestingHR < 30 || p.RestingHR > 100):
+		return "La FC en reposo debe estar entre 30 y 100 ppm"
+	case p.MaxHR != 0 && (p.MaxHR < 120 || p.MaxHR > 230):
+		return "La FC máxima debe estar entre 120 y 230 ppm"
+	case p.LTHR != 0 && (p.LTHR < 100 || p.LTHR > 220):
+		return "La FC umbral debe estar entre 100 y 220 ppm"
+	case p.LTHR != 0 && p.MaxHR != 0 && p.LTHR >= p.MaxHR:
+		return "La FC umbral debe ser menor que la FC máxima"
+	case p.RestingHR != 0 && p.MaxHR != 0 && p.RestingHR >= p.MaxHR:
+		return "La FC en reposo debe ser menor que la FC máxima"
+	case p.VO2max != 0 && (p.VO2max < 20 || p.VO2max > 90):
+		return "El VO2max debe estar entre 20 y 90 ml/kg/min"
+	case p.ThresholdPower < 0 || p.ThresholdPower > 800:
+		return "La potencia umbral debe estar entre 0 y 800 W"
+	case p.WeeklyKmTarget < 0 || p.WeeklyKmTarget > 300:
+		return "El objetivo semanal debe estar entre 0 y 300 km"
+	case p.TrainingLevel != "" && !services.ValidOption(p.TrainingLevel, services.TrainingLevels):
+		return "Nivel de entrenamiento inválido (beginner, intermediate, advanced, elite)"
+	case len(p.Goals) > 2000:
+		return "Los objetivos no pueden superar 2000 caracteres"
+	}
+
+	if p.ThresholdPace != "" {
+		if _, err := services.ParsePace(p.ThresholdPace); err != nil {
+			return "Ritmo umbral inválido: " + err.Error()
+		}
+	}
+	if p.RaceGoalDate != "" {
+		if _, err := time.Parse("2006-01-02", p.RaceGoalDate); err != nil {
+			return "Fecha del objetivo inválida (formato YYYY-MM-DD)"
+		}
+	}
+	if err := services.ValidateHRZones(p.HRZones); err != nil {
+		return "Zonas de FC inválidas: " + err.Error()
+	}
+
+	return ""
+}
+
+// ProfileHandler maneja GET y PUT del perfil de corredor del usuario autenticado
+func ProfileHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value("userID").(int)
+
+	switch r.Method {
+	case "GET":
+		profile, err := loadRunnerProfile(userID)
+		if err != nil {
+			log.Printf("Error obteniendo perfil: %v", err)
+			httpError(w, r, "Error obteniendo perfil", http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(withDefaultZones(*profile))
+	case "PUT":
+		var req profileRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+			return
+		}
+
+		before, err := loadRunnerProfile(userID)
+		if err != nil {
+			log.Printf("Error obteniendo perfil: %v", err)
+			httpError(w, r, "Error obteniendo perfil", http.StatusInternalServerError)
+			return
+		}
+
+		profile := *before
+		if msg := req.apply(&profile); msg != "" {
+			httpError(w, r, msg, http.StatusBadRequest)
+			return
+		}
+
+		if err := saveRunnerProfile(*before, profile); err != nil {
+			log.Printf("Error actualizando perfil: %v", err)
+			httpError(w, r, "Error actualizando perfil", http.StatusInternalServerError)
+			return
+		}
+
+		updated, err := loadRunnerProfile(userID)
+		if err != nil {
+			httpError(w, r, "Error obteniendo perfil", http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(withDefaultZones(*updated))
+	default:
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// ProfileHistoryHandler devuelve la evolución de los datos biométricos (GET /api/profile/history)
+func ProfileHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			httpError(w, r, "Límite inválido", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, user_id, COALESCE(weight, 0), COALESCE(resting_hr, 0), COALESCE(max_hr, 0),
+		       COALESCE(lthr, 0), COALESCE(vo2max, 0), COALESCE(threshold_pace, ''),
+		       COALESCE(threshold_power, 0), recorded_at
+		FROM runner_profile_history
+		WHERE user_id = ?
+		ORDER BY recorded_at DESC, id DESC
+		LIMIT ?`, userID, limit)
+	if err != nil {
+		log.Printf("Error obteniendo historial del perfil: %v", err)
+		httpError(w, r, "Error obteniendo historial", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	history := []models.BiometricSnapshot{}
+	for rows.Next() {
+		var s models.BiometricSnapshot
+		if err := rows.Scan(&s.ID, &s.UserID, &s.Weight, &s.RestingHR, &s.MaxHR,
+			&s.LTHR, &s.VO2max, &s.ThresholdPace, &s.ThresholdPower, &s.RecordedAt); err != nil {
+			log.Printf("Error escaneando historial: %v", err)
+			continue
+		}
+		history = append(history, s)
+	}
+
+	json.NewEncoder(w).Encode(history)
+}
+
+// loadRunnerProfile obtiene el perfil de corredor de un usuario, creándolo vacío si no existe
+func loadRunnerProfile(userID int) (*models.RunnerProfile, error) {
+	if _, err := database.DB.Exec(`
+		INSERT OR IGNORE INTO runner_profiles (user_id, training_level)
+		VALUES (?, 'intermediate')`, userID); err != nil {
+		return nil, err
+	}
+
+	var p models.RunnerProfile
+	var age, restingHR, maxHR, lthr, thresholdPower sql.NullInt64
+	var weight, height, vo2max, weeklyKm sql.NullFloat64
+	var sex, thresholdPace, trainingLevel, hrZones, raceGoal, goals sql.NullString
+	var raceGoalDate sql.NullTime
+	var updatedAt sql.NullTime
+
+	err := database.DB.QueryRow(`
+		SELECT u.id, u.name, u.email, p.age, p.sex, p.weight, p.height, p.resting_hr, p.max_hr,
+		       p.lthr, p.vo2max, p.threshold_pace, p.threshold_power, p.weekly_km_target,
+		       p.training_level, p.hr_zones, p.race_goal, p.race_goal_date, p.goals, p.updated_at
+		FROM users u
+		JOIN runner_profiles p ON p.user_id = u.id
+		WHERE u.id = ?`, userID).Scan(
+		&p.UserID, &p.Name, &p.Email, &age, &sex, &weight, &height, &restingHR, &maxHR,
+		&lthr, &vo2max, &thresholdPace, &thresholdPower, &weeklyKm,
+		&trainingLevel, &hrZones, &raceGoal, &raceGoalDate, &goals, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Age = int(age.Int64)
+	p.Sex = sex.String
+	p.Weight = weight.Float64
+	p.Height = height.Float64
+	p.RestingHR = int(restingHR.Int64)
+	p.MaxHR = int(maxHR.Int64)
+	p.LTHR = int(lthr.Int64)
+	p.VO2max = vo2max.Float64
+	p.ThresholdPace = thresholdPace.String
+	p.ThresholdPower = int(thresholdPower.Int64)
+	p.WeeklyKmTarget = weeklyKm.Float64
+	p.TrainingLevel = trainingLevel.String
+	p.RaceGoal = raceGoal.String
+	p.Goals = goals.String
+	p.UpdatedAt = updatedAt.Time
+	if raceGoalDate.Valid {
+		p.RaceGoalDate = raceGoalDate.Time.Format("2006-01-02")
+	}
+
+	p.HRZones = []models.HRZone{}
+	if hrZones.Valid && hrZones.String != "" {
+		if err := json.Unmarshal([]byte(hrZones.String), &p.HRZones); err != nil {
+			log.Printf("Error parsing hr_zones del usuario %d: %v", userID, err)
+		}
+	}
+
+	return &p, nil
+}
+
+// saveRunnerProfile guarda el perfil y, si cambiaron los datos biométricos, registra una
+// entrada en el historial
+func saveRunnerProfile(before, p models.RunnerProfile) error {
+	var hrZones interface{}
+	if len(p.HRZones) > 0 {
+		data, err := json.Marshal(p.HRZones)
+		if err != nil {
+			return err
+		}
+		hrZones = string(data)
+	}
+
+	var raceGoalDate interface{}
+	if p.RaceGoalDate != "" {
+		date, _ := time.Parse("2006-01-02", p.RaceGoalDate)
+		raceGoalDate = date
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		UPDATE runner_profiles
+		SET age = ?, sex = ?, weight = ?, height = ?, resting_hr = ?, max_hr = ?, lthr = ?,
+		    vo2max = ?, threshold_pace = ?, threshold_power = ?, weekly_km_target = ?,
+		    training_level = ?, hr_zones = ?, race_goal = ?, race_goal_date = ?, goals = ?,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ?`,
+		p.Age, nullIfEmpty(p.Sex), p.Weight, p.Height, p.RestingHR, p.MaxHR, p.LTHR,
+		p.VO2max, nullIfEmpty(p.ThresholdPace), p.ThresholdPower, p.WeeklyKmTarget,
+		nullIfEmpty(p.TrainingLevel), hrZones, nullIfEmpty(p.RaceGoal), raceGoalDate, nullIfEmpty(p.Goals),
+		p.UserID); err != nil {
+		return err
+	}
+
+	if services.BiometricsChanged(before, p) {
+		if _, err := tx.Exec(`
+			INSERT INTO runner_profile_history (user_id, weight, resting_hr, max_hr, lthr, vo2max,
+			                                    threshold_pace, threshold_power)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			p.UserID, p.Weight, p.RestingHR, p.MaxHR, p.LTHR, p.VO2max,
+			nullIfEmpty(p.ThresholdPace), p.ThresholdPower); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// withDefaultZones rellena las zonas de FC calculadas si el usuario no ha definido las suyas
+func withDefaultZones(p models.RunnerProfile) models.RunnerProfile {
+	if len(p.HRZones) == 0 {
+		if zones := services.DefaultHRZones(p.LTHR, p.MaxHR); zones != nil {
+			p.HRZones = zones
+		}
+	}
+	return p
+}
@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trainapp/database"
+	"trainapp/models"
+	"trainapp/services"
+)
+
+// raceRequest contiene los campos editables de una carrera; los campos nil no se modifican
+type raceRequest struct {
+	Name          *string  `json:"name"`
+	Date          *string  `json:"date"` // YYYY-MM-DD
+	DistanceKm    *float64 `json:"distance_km"`
+	Priority      *string  `json:"priority"`
+	TargetTime    *string  `json:"target_time"`
+	CourseProfile *string  `json:"course_profile"`
+	Location      *string  `json:"location"`
+	Status        *string  `json:"status"`
+	Notes         *string  `json:"notes"`
+}
+
+// apply copia los campos presentes en la petición sobre race y valida el resultado
+func (req raceRequest) apply(race *models.Race) string {
+	if req.Name != nil {
+		race.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Date != nil {
+		date, err := time.Parse("2006-01-02", *req.Date)
+		if err != nil {
+			return "Fecha inválida (formato YYYY-MM-DD)"
+		}
+		race.Date = date
+	}
+	if req.DistanceKm != nil {
+		race.DistanceKm = *req.DistanceKm
+	}
+	if req.Priority != nil {
+		race.Priority = strings.ToUpper(strings.TrimSpace(*req.Priority))
+	}
+	if req.TargetTime != nil {
+		race.TargetTime = strings.TrimSpace(*req.TargetTime)
+	}
+	if req.CourseProfile != nil {
+		race.CourseProfile = strings.TrimSpace(*req.CourseProfile)
+	}
+	if req.Location != nil {
+		race.Location = strings.TrimSpace(*req.Location)
+	}
+	if req.Status != nil {
+		race.Status = *req.Status
+	}
+	if req.Notes != nil {
+		race.Notes = *req.Notes
+	}
+
+	if race.Name == "" {
+		return "El nombre de la carrera es requerido"
+	}
+	if race.Date.IsZero() {
+		return "La fecha de la carrera es requerida"
+	}
+	if race.DistanceKm <= 0 {
+		return "La distancia debe ser mayor que 0"
+	}
+	if !services.ValidOption(race.Priority, services.RacePriorities) {
+		return "Prioridad inválida (A, B o C)"
+	}
+	if race.CourseProfile != "" && !services.ValidOption(race.CourseProfile, services.RaceCourseProfiles) {
+		return "Perfil de recorrido inválido (flat, rolling, hilly o trail)"
+	}
+	if !services.ValidOption(race.Status, services.RaceStatuses) {
+		return "Estado inválido (upcoming, completed, dns o dnf)"
+	}
+	if race.TargetTime != "" {
+		seconds, err := services.ParseRaceTime(race.TargetTime)
+		if err != nil {
+			return "Tiempo objetivo inválido: " + err.Error()
+		}
+		race.TargetTime = services.FormatRaceTime(seconds)
+	}
+
+	return ""
+}
+
+// RacesHandler maneja GET (listar) y POST (crear) carreras
+func RacesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value("userID").(int)
+
+	switch r.Method {
+	case "GET":
+		// ?when=upcoming|past filtra por fecha
+		races, err := loadUserRaces(userID, r.URL.Query().Get("when"))
+		if err != nil {
+			log.Printf("Error obteniendo carreras: %v", err)
+			httpError(w, r, "Error obteniendo carreras", http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(races)
+	case "POST":
+		var req raceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+			return
+		}
+
+		race := models.Race{UserID: userID, Priority: "B", Status: "upcoming"}
+		if msg := req.apply(&race); msg != "" {
+			httpError(w, r, msg, http.StatusBadRequest)
+			return
+		}
+
+		result, err := database.DB.Exec(`
+			INSERT INTO races (user_id, name, race_date, distance_km, priority, target_time,
+			                   course_profile, location, status, notes)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			userID, race.Name, race.Date, race.DistanceKm, race.Priority, race.TargetTime,
+			race.CourseProfile, race.Location, race.Status, race.Notes)
+		if err != nil {
+			log.Printf("Error creando carrera: %v", err)
+			httpError(w, r, "Error creando carrera", http.StatusInternalServerError)
+			return
+		}
+
+		id, _ := result.LastInsertId()
+		race.ID = int(id)
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(race)
+	default:
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// RaceDetailHandler maneja /api/races/:id, /api/races/:id/result y /api/races/:id/periodization
+func RaceDetailHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := r.Context().Value("userID").(int)
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/races/"), "/")
+	parts := strings.SplitN(path, "/", 2)
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		httpError(w, r, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	race, err := loadRace(userID, id)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Carrera no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "Error obteniendo carrera", http.StatusInternalServerError)
+		return
+	}
+
+	subresource := ""
+	if len(parts) == 2 {
+		subresource = parts[1]
+	}
+
+	switch {
+	case subresource == "result" && r.Method == "PUT":
+		saveRaceResult(w, r, race)
+	case subresource == "periodization" && r.Method == "GET":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"race":   race,
+			"blocks": services.BuildPeriodization(time.Now(), race.Date, race.DistanceKm),
+		})
+	case subresource == "" && r.Method == "GET":
+		json.NewEncoder(w).Encode(race)
+	case subresource == "" && r.Method == "PUT":
+		var req raceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+			return
+		}
+
+		if msg := req.apply(race); msg != "" {
+			httpError(w, r, msg, http.StatusBadRequest)
+			return
+		}
+
+		_, err := database.DB.Exec(`
+			UPDATE races
+			SET name = ?, race_date = ?, distance_km = ?, priority = ?, target_time = ?,
+			    course_profile = ?, location = ?, status = ?, notes = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND user_id = ?`,
+			race.Name, race.Date, race.DistanceKm, race.Priority, race.TargetTime,
+			race.CourseProfile, race.Location, race.Status, race.Notes, id, userID)
+		if err != nil {
+			log.Printf("Error actualizando carrera: %v", err)
+			httpError(w, r, "Error actualizando carrera", http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(race)
+	case subresource == "" && r.Method == "DELETE":
+		if _, err := database.DB.Exec(`UPDATE training_plans SET race_id = NULL WHERE race_id = ? AND user_id = ?`, id, userID); err != nil {
+			httpError(w, r, "Error desvinculando planes", http.StatusInternalServerError)
+			return
+		}
+		if _, err := database.DB.Exec(`DELETE FROM races WHERE id = ? AND user_id = ?`, id, userID); err != nil {
+			httpError(w, r, "Error eliminando carrera", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case subresource != "" && subresource != "result" && subresource != "periodization":
+		httpError(w, r, "Recurso no encontrado", http.StatusNotFound)
+	default:
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// saveRaceResult registra el resultado de una carrera y la vincula al workout correspondiente
+func saveRaceResult(w http.ResponseWriter, r *http.Request, race *models.Race) {
+	var req struct {
+		WorkoutID  *int   `json:"workout_id"`
+		ResultTime string `json:"result_time"` // H:MM:SS
+		Status     string `json:"status"`      // completed (por defecto), dns, dnf
+		Notes      string `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+
+	if req.Status == "" {
+		req.Status = "completed"
+	}
+	if req.Status == "upcoming" || !services.ValidOption(req.Status, services.RaceStatuses) {
+		httpError(w, r, "Estado inválido (completed, dns o dnf)", http.StatusBadRequest)
+		return
+	}
+
+	if req.ResultTime != "" {
+		seconds, err := services.ParseRaceTime(req.ResultTime)
+		if err != nil {
+			httpError(w, r, "Tiempo inválido: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.ResultTime = services.FormatRaceTime(seconds)
+	}
+
+	// El workout debe pertenecer al usuario; si no hay tiempo se toma su duración
+	if req.WorkoutID != nil {
+		var duration int
+		err := database.DB.QueryRow(`
+			SELECT duration FROM workouts WHERE id = ? AND user_id = ?`, *req.WorkoutID, race.UserID).Scan(&duration)
+		if err != nil {
+			httpError(w, r, "Workout no encontrado", http.StatusBadRequest)
+			return
+		}
+		if req.ResultTime == "" && duration > 0 {
+			req.ResultTime = services.FormatRaceTime(duration * 60)
+		}
+
+		database.DB.Exec(`UPDATE workouts SET type = 'race' WHERE id = ?`, *req.WorkoutID)
+	}
+
+	race.Status = req.Status
+	race.ResultTime = req.ResultTime
+	race.WorkoutID = req.WorkoutID
+	if req.Notes != "" {
+		race.Notes = req.Notes
+	}
+
+	_, err := database.DB.Exec(`
+		UPDATE races
+		SET status = ?, result_time = ?, workout_id = ?, notes = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND user_id = ?`,
+		race.Status, race.ResultTime, race.WorkoutID, race.Notes, race.ID, race.UserID)
+	if err != nil {
+		log.Printf("Error guardando resultado: %v", err)
+		httpError(w, r, "Error guardando resultado", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(race)
+}
+
+// raceSelectQuery selecciona las columnas de una carrera
+const raceSelectQuery = `
+	SELECT id, user_id, name, race_date, distance_km, priority, COALESCE(target_time, ''),
+	       COALESCE(course_profile, ''), COALESCE(location, ''), status, COALESCE(result_time, ''),
+	       workout_id, COALESCE(notes, ''), created_at
+	FROM races`
+
+func scanRace(row interface{ Scan(...interface{}) error }) (*models.Race, error) {
+	var race models.Race
+	err := row.Scan(&race.ID, &race.UserID, &race.Name, &race.Date, &race.DistanceKm, &race.Priority,
+		&race.TargetTime, &race.CourseProfile, &race.Location, &race.Status, &race.ResultTime,
+		&race.WorkoutID, &race.Notes, &race.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &race, nil
+}
+
+// loadRace obtiene una carrera del usuario
+func loadRace(userID, raceID int) (*models.Race, error) {
+	return scanRace(database.DB.QueryRow(raceSelectQuery+` WHERE id = ? AND user_id = ?`, raceID, userID))
+}
+
+// loadUserRaces obtiene las carreras del usuario; when puede ser "upcoming", "past" o vacío (todas)
+func loadUserRaces(userID int, when string) ([]models.Race, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	query := raceSelectQuery + ` WHERE user_id = ?`
+	args := []interface{}{userID}
+	switch when {
+	case "upcoming":
+		query += ` AND race_date >= ? AND status = 'upcoming' ORDER BY race_date`
+		args = append(args, today)
+	case "past":
+		query += ` AND (race_date < ? OR status != 'upcoming') ORDER BY race_date DESC`
+		args = append(args, today)
+	default:
+		query += ` ORDER BY race_date`
+	}
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	races := []models.Race{}
+	for rows.Next() {
+		race, err := scanRace(rows)
+		if err != nil {
+			return nil, err
+		}
+		races = append(races, *race)
+	}
+
+	return races, rows.Err()
+}
+
+// nextARace devuelve la próxima carrera de prioridad A del usuario, o nil si no hay
+func nextARace(userID int) (*models.Race, error) {
+	race, err := scanRace(database.DB.QueryRow(raceSelectQuery+`
+		WHERE user_id = ? AND priority = 'A' AND status = 'upcoming' AND race_date >= ?
+		ORDER BY race_date LIMIT 1`, userID, time.Now().UTC().Truncate(24*time.Hour)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return race, err
+}
@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trainapp/database"
+	"trainapp/middleware"
+	"trainapp/services"
+)
+
+// Cada cuánto se actualiza como máximo el último uso de una sesión
+const sessionTouchInterval = time.Minute
+
+// Session es un dispositivo con la sesión iniciada
+type Session struct {
+	ID         int64     `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// AuthResponse representa la respuesta de autenticación: un token de acceso de corta duración
+// y el token de refresco de la sesión para renovarlo. En modo cookie los tokens van en cookies
+// httpOnly y la respuesta lleva en su lugar el token anti-CSRF de la sesión.
+type AuthResponse struct {
+	Token        string      `json:"token,omitempty"`
+	RefreshToken string      `json:"refresh_token,omitempty"`
+	CSRFToken    string      `json:"csrf_token,omitempty"`
+	ExpiresIn    int         `json:"expires_in"` // segundos de validez del token de acceso
+	User         UserProfile `json:"user"`
+}
+
+// cookieMode indica si el cliente (el frontend) pide la sesión en cookies con la cabecera
+// X-Auth-Mode: cookie; el resto de clientes recibe los tokens en la respuesta
+func cookieMode(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("X-Auth-Mode"), "cookie")
+}
+
+// secureCookies indica si las cookies deben marcarse Secure: siempre en producción y cuando la
+// petición llega por HTTPS (también detrás de un proxy)
+func secureCookies(r *http.Request) bool {
+	return services.IsProduction() || r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// setSessionCookies guarda los tokens de la sesión en cookies httpOnly. El de refresco solo se
+// envía a /api/auth.
+func setSessionCookies(w http.ResponseWriter, r *http.Request, token, refreshToken string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.SessionCookie,
+		Value:    token,
+		Path:     "/api",
+		MaxAge:   int(services.AccessTokenTTL().Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.RefreshCookie,
+		Value:    refreshToken,
+		Path:     "/api/auth",
+		MaxAge:   int(services.RefreshTokenTTL().Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// clearSessionCookies borra las cookies de sesión del navegador
+func clearSessionCookies(w http.ResponseWriter, r *http.Request) {
+	for name, path := range map[string]string{middleware.SessionCookie: "/api", middleware.RefreshCookie: "/api/auth"} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Path:     path,
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   secureCookies(r),
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
+
+// clientIP devuelve la IP del cliente
+func clientIP(r *http.Request) string {
+	return middleware.ClientIP(r)
+}
+
+// writeAuthResponse abre una sesión para el usuario en este dispositivo y responde con sus tokens
+func writeAuthResponse(w http.ResponseWriter, r *http.Request, user UserProfile) {
+	refreshToken, hash, err := services.NewRefreshToken()
+	if err != nil {
+		httpError(w, r, "Error generando token", http.StatusInternalServerError)
+		return
+	}
+
+	result, err := database.DB.Exec(`
+		INSERT INTO sessions (user_id, refresh_hash, user_agent, ip, expires_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		user.ID, hash, r.UserAgent(), clientIP(r), time.Now().Add(services.RefreshTokenTTL()))
+	if err != nil {
+		log.Printf("Error creando sesión: %v", err)
+		httpError(w, r, "Error creando sesión", http.StatusInternalServerError)
+		return
+	}
+	sessionID, _ := result.LastInsertId()
+
+	writeTokens(w, r, user, sessionID, refreshToken, cookieMode(r))
+}
+
+// writeTokens responde con un token de acceso nuevo para la sesión y su token de refresco, en
+// el cuerpo o, si cookies, en cookies httpOnly junto al token anti-CSRF
+func writeTokens(w http.ResponseWriter, r *http.Request, user UserProfile, sessionID int64, refreshToken string, cookies bool) {
+	authService := services.GetAuthService()
+	token, err := authService.GenerateToken(user.ID, user.Email, user.Name, sessionID)
+	if err != nil {
+		httpError(w, r, "Error generando token", http.StatusInternalServerError)
+		return
+	}
+
+	response := AuthResponse{
+		ExpiresIn: int(services.AccessTokenTTL().Seconds()),
+		User:      user,
+	}
+	if cookies {
+		setSessionCookies(w, r, token, refreshToken)
+		response.CSRFToken = authService.CSRFToken(sessionID)
+	} else {
+		response.Token = token
+		response.RefreshToken = refreshToken
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// SessionActive indica si la sesión sigue abierta y pertenece al usuario; se usa al validar
+// cada token de acceso y de paso registra el último uso de la sesión
+func SessionActive(sessionID int64, userID int) bool {
+	var expiresAt, lastUsedAt time.Time
+	var revokedAt sql.NullTime
+	err := database.DB.QueryRow(`
+		SELECT expires_at, last_used_at, revoked_at FROM sessions WHERE id = ? AND user_id = ?`,
+		sessionID, userID).Scan(&expiresAt, &lastUsedAt, &revokedAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("⚠️  Error comprobando sesión %d: %v", sessionID, err)
+		}
+		return false
+	}
+
+	now := time.Now()
+	if revokedAt.Valid || now.After(expiresAt) {
+		return false
+	}
+	if now.Sub(lastUsedAt) > sessionTouchInterval {
+		database.DB.Exec("UPDATE sessions SET last_used_at = ? WHERE id = ?", now, sessionID)
+	}
+	return true
+}
+
+// RefreshHandler renueva el token de acceso con el token de refresco de la sesión, del cuerpo o
+// de la cookie de refresco (con el token anti-CSRF). El token de refresco se rota en cada uso; si
+// se presenta uno ya rotado se entiende que lo ha robado alguien y se cierra la sesión.
+func RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+	fromCookie := false
+	if req.RefreshToken == "" {
+		if cookie, err := r.Cookie(middleware.RefreshCookie); err == nil && cookie.Value != "" {
+			req.RefreshToken = cookie.Value
+			fromCookie = true
+		}
+	}
+	if req.RefreshToken == "" {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+	hash := services.HashRefreshToken(req.RefreshToken)
+
+	var sessionID int64
+	var user UserProfile
+	var expiresAt time.Time
+	var revokedAt, verifiedAt sql.NullTime
+	err := database.DB.QueryRow(`
+		SELECT s.id, s.expires_at, s.revoked_at, u.id, u.name, u.email, COALESCE(u.locale, ''), u.email_verified_at, u.role
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.refresh_hash = ?`, hash).Scan(&sessionID, &expiresAt, &revokedAt, &user.ID, &user.Name, &user.Email, &user.Locale, &verifiedAt, &user.Role)
+	user.EmailVerified = verifiedAt.Valid
+	if err == sql.ErrNoRows {
+		// Reutilización de un token ya rotado: se revoca la sesión a la que perteneció
+		if result, err := database.DB.Exec(`
+			UPDATE sessions SET revoked_at = ? WHERE previous_hash = ? AND revoked_at IS NULL`, time.Now(), hash); err == nil {
+			if n, _ := result.RowsAffected(); n > 0 {
+				log.Printf("⚠️  Token de refresco reutilizado: sesión revocada")
+			}
+		}
+		httpError(w, r, "Sesión inválida o expirada", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error buscando sesión: %v", err)
+		httpError(w, r, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+	if revokedAt.Valid || time.Now().After(expiresAt) {
+		httpError(w, r, "Sesión inválida o expirada", http.StatusUnauthorized)
+		return
+	}
+	if fromCookie && !services.GetAuthService().VerifyCSRFToken(sessionID, r.Header.Get(middleware.CSRFHeader)) {
+		httpError(w, r, "Token CSRF inválido", http.StatusForbidden)
+		return
+	}
+
+	refreshToken, newHash, err := services.NewRefreshToken()
+	if err != nil {
+		httpError(w, r, "Error generando token", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	result, err := database.DB.Exec(`
+		UPDATE sessions
+		SET refresh_hash = ?, previous_hash = ?, user_agent = ?, ip = ?, last_used_at = ?, expires_at = ?
+		WHERE id = ? AND refresh_hash = ?`,
+		newHash, hash, r.UserAgent(), clientIP(r), now, now.Add(services.RefreshTokenTTL()), sessionID, hash)
+	if err != nil {
+		log.Printf("Error rotando sesión: %v", err)
+		httpError(w, r, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		// Otra petición rotó el token a la vez
+		httpError(w, r, "Sesión inválida o expirada", http.StatusUnauthorized)
+		return
+	}
+
+	writeTokens(w, r, user, sessionID, refreshToken, fromCookie || cookieMode(r))
+}
+
+// LogoutHandler cierra la sesión del token actual
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+	sessionID, _ := r.Context().Value("sessionID").(int64)
+
+	if _, err := database.DB.Exec(`
+		UPDATE sessions SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL`,
+		time.Now(), sessionID, userID); err != nil {
+		log.Printf("Error cerrando sesión: %v", err)
+		httpError(w, r, "Error cerrando sesión", http.StatusInternalServerError)
+		return
+	}
+
+	clearSessionCookies(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAllHandler cierra todas las sesiones del usuario, también la actual
+func LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	if err := revokeUserSessions(userID); err != nil {
+		log.Printf("Error cerrando sesiones: %v", err)
+		httpError(w, r, "Error cerrando sesión", http.StatusInternalServerError)
+		return
+	}
+
+	clearSessionCookies(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeUserSessions cierra todas las sesiones abiertas del usuario
+func revokeUserSessions(userID int) error {
+	_, err := database.DB.Exec(`
+		UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`, time.Now(), userID)
+	return err
+}
+
+// SessionsHandler lista las sesiones abiertas del usuario, la más reciente primero
+func SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+	currentID, _ := r.Context().Value("sessionID").(int64)
+
+	rows, err := database.DB.Query(`
+		SELECT id, COALESCE(user_agent, ''), COALESCE(ip, ''), created_at, last_used_at, expires_at
+		FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL
+		ORDER BY last_used_at DESC, id DESC`, userID)
+	if err != nil {
+		log.Printf("Error obteniendo sesiones: %v", err)
+		httpError(w, r, "Error obteniendo sesiones", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt); err != nil {
+			log.Printf("Error escaneando sesión: %v", err)
+			continue
+		}
+		if now.After(s.ExpiresAt) {
+			continue
+		}
+		s.Current = s.ID == currentID
+		sessions = append(sessions, s)
+	}
+
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// SessionDetailHandler maneja DELETE /api/auth/sessions/:id: cierra la sesión de otro dispositivo
+func SessionDetailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	id, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/auth/sessions/"), "/"), 10, 64)
+	if err != nil {
+		httpError(w, r, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE sessions SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL`,
+		time.Now(), id, userID)
+	if err != nil {
+		log.Printf("Error cerrando sesión %d: %v", id, err)
+		httpError(w, r, "Error cerrando sesión", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		httpError(w, r, "Sesión no encontrada", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -3,7 +3,7 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
 	"os"
@@ -13,6 +13,25 @@ import (
 	"trainapp/services"
 )
 
+// StravaAuthURLHandler devuelve un enlace firmado de corta duración a StravaAuthHandler, para
+// que el navegador pueda ir a la autorización de Strava sin llevar el token en la URL
+func StravaAuthURLHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+	sessionID, _ := r.Context().Value("sessionID").(int64)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":        services.GetAuthService().SignURL(services.PurposeStravaAuth, "/api/strava/auth", userID, sessionID, services.StravaAuthURLTTL),
+		"expires_in": int(services.StravaAuthURLTTL.Seconds()),
+	})
+}
+
 // StravaAuthHandler redirige al usuario a Strava para autorización
 func StravaAuthHandler(w http.ResponseWriter, r *http.Request) {
 	// Obtener userID del contexto (inyectado por AuthMiddleware)
@@ -20,11 +39,19 @@ func StravaAuthHandler(w http.ResponseWriter, r *http.Request) {
 
 	client := services.GetStravaClient()
 	if client == nil || client.ClientID == "" {
-		http.Error(w, "Strava no está configurado", http.StatusServiceUnavailable)
+		httpError(w, r, "Strava no está configurado", http.StatusServiceUnavailable)
 		return
 	}
 
-	authURL := client.GetAuthorizationURL(userID)
+	// El state va firmado y queda ligado a este navegador (ver oauthStateCookie)
+	state, err := services.GetAuthService().NewOAuthState(services.OAuthStravaConnect, userID)
+	if err != nil {
+		httpError(w, r, "Error generando token", http.StatusInternalServerError)
+		return
+	}
+	setOAuthStateCookie(w, r, state)
+
+	authURL := client.GetAuthorizationURL(state, "")
 	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
 }
 
@@ -34,27 +61,22 @@ func StravaCallbackHandler(w http.ResponseWriter, r *http.Request) {
 
 	code := r.URL.Query().Get("code")
 	if code == "" {
-		http.Error(w, "Código de autorización no proporcionado", http.StatusBadRequest)
+		httpError(w, r, "Código de autorización no proporcionado", http.StatusBadRequest)
 		return
 	}
 
-	// Obtener userID del parámetro state
-	stateParam := r.URL.Query().Get("state")
-	if stateParam == "" {
-		http.Error(w, "State parameter no proporcionado", http.StatusBadRequest)
-		return
-	}
-
-	var userID int
-	if _, err := fmt.Sscanf(stateParam, "%d", &userID); err != nil {
-		http.Error(w, "State parameter inválido", http.StatusBadRequest)
+	// Obtener userID del state firmado que se guardó en este navegador
+	claims, err := checkOAuthState(w, r, services.OAuthStravaConnect)
+	if err != nil {
+		httpError(w, r, "State parameter inválido", http.StatusBadRequest)
 		return
 	}
+	userID := claims.UserID
 
 	client := services.GetStravaClient()
 	tokenResp, err := client.ExchangeToken(code)
 	if err != nil {
-		http.Error(w, "Error obteniendo token: "+err.Error(), http.StatusInternalServerError)
+		httpError(w, r, "Error obteniendo token: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -71,22 +93,18 @@ func StravaCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	`, userID, tokenResp.AccessToken, tokenResp.RefreshToken, tokenResp.ExpiresAt, tokenResp.Athlete.ID)
 
 	if err != nil {
-		http.Error(w, "Error guardando tokens: "+err.Error(), http.StatusInternalServerError)
+		httpError(w, r, "Error guardando tokens: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Redirigir al frontend con éxito
-	baseURL := os.Getenv("BASE_URL")
-	if baseURL == "" {
-		// Intentar construir la URL base desde el request
-		scheme := "https"
-		if r.TLS == nil {
-			scheme = "http"
-		}
-		baseURL = scheme + "://" + r.Host
+	// El atleta conectado sirve también para iniciar sesión con Strava, si no es de otro usuario
+	identity := tokenResp.Identity()
+	if err := linkIdentity(userID, &identity); err != nil && !errors.Is(err, errIdentityTaken) {
+		log.Printf("⚠️  Error vinculando el atleta de Strava del usuario %d: %v", userID, err)
 	}
 
-	redirectURL := baseURL + "/?strava=connected"
+	// Redirigir al frontend con éxito
+	redirectURL := appBaseURL(r) + "/?strava=connected"
 	log.Printf("🔄 Redirigiendo a: %s (BASE_URL env: %s)", redirectURL, os.Getenv("BASE_URL"))
 
 	http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
@@ -110,7 +128,7 @@ func StravaSyncHandler(w http.ResponseWriter, r *http.Request) {
 	`, userID).Scan(&accessToken, &refreshToken, &expiresAt)
 
 	if err != nil {
-		http.Error(w, "No hay conexión con Strava. Por favor, autoriza primero.", http.StatusUnauthorized)
+		httpError(w, r, "No hay conexión con Strava. Por favor, autoriza primero.", http.StatusUnauthorized)
 		return
 	}
 
@@ -121,7 +139,8 @@ func StravaSyncHandler(w http.ResponseWriter, r *http.Request) {
 	if now >= expiresAt {
 		tokenResp, err := client.RefreshAccessToken(refreshToken)
 		if err != nil {
-			http.Error(w, "Error refrescando token: "+err.Error(), http.StatusInternalServerError)
+			recordStravaSyncError(userID, err)
+			httpError(w, r, "Error refrescando token: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 
@@ -137,7 +156,7 @@ func StravaSyncHandler(w http.ResponseWriter, r *http.Request) {
 		`, accessToken, refreshToken, expiresAt, userID)
 
 		if err != nil {
-			http.Error(w, "Error actualizando tokens: "+err.Error(), http.StatusInternalServerError)
+			httpError(w, r, "Error actualizando tokens: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
@@ -170,7 +189,8 @@ func StravaSyncHandler(w http.ResponseWriter, r *http.Request) {
 
 	activities, err := client.GetActivities(accessToken, after, 50)
 	if err != nil {
-		http.Error(w, "Error obteniendo actividades: "+err.Error(), http.StatusInternalServerError)
+		recordStravaSyncError(userID, err)
+		httpError(w, r, "Error obteniendo actividades: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -190,11 +210,18 @@ func StravaSyncHandler(w http.ResponseWriter, r *http.Request) {
 		`, userID, activity.ID).Scan(&existingID)
 
 		if err == nil {
-			// Ya existe, verificar si tiene datos de Strava cacheados
+			// Ya existe, verificar si tiene datos de Strava cacheados y zapatillas vinculadas
 			var hasStravaData sql.NullString
+			var existingGearID sql.NullInt64
 			database.DB.QueryRow(`
-				SELECT strava_data FROM workouts WHERE id = ?
-			`, existingID).Scan(&hasStravaData)
+				SELECT strava_data, gear_id FROM workouts WHERE id = ?
+			`, existingID).Scan(&hasStravaData, &existingGearID)
+
+			if !existingGearID.Valid && activity.GearID != "" {
+				if gearID, err := resolveStravaGear(userID, activity.GearID, nil, activity.StartDate); err == nil && gearID != nil {
+					database.DB.Exec(`UPDATE workouts SET gear_id = ? WHERE id = ?`, *gearID, existingID)
+				}
+			}
 
 			// Si no tiene datos cacheados, actualizar
 			if !hasStravaData.Valid || hasStravaData.String == "" {
@@ -222,26 +249,34 @@ func StravaSyncHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Convertir actividad básica a formato workout
-		workoutData := services.ConvertStravaActivityToWorkout(&activity)
+		workoutData := services.ConvertStravaActivityToWorkout(&activity, requestLocale(r))
 
 		// Serializar datos completos de Strava si los tenemos
 		var stravaDataJSON string
+		var gearDetail map[string]interface{}
 		if activityDetail != nil {
 			stravaBytes, _ := json.Marshal(activityDetail)
 			stravaDataJSON = string(stravaBytes)
+			gearDetail, _ = activityDetail["gear"].(map[string]interface{})
+		}
+
+		// Vincular las zapatillas de Strava (se registran si aún no existen)
+		gearID, err := resolveStravaGear(userID, activity.GearID, gearDetail, activity.StartDate)
+		if err != nil {
+			log.Printf("⚠️  Error vinculando zapatillas %s: %v", activity.GearID, err)
 		}
 
 		// Insertar en la base de datos con datos completos
-		_, err = database.DB.Exec(`
+		result, err := database.DB.Exec(`
 			INSERT INTO workouts (user_id, date, type, distance, duration, avg_pace,
 			                      avg_heart_rate, avg_power, cadence, elevation_gain, calories,
-			                      notes, feeling, strava_activity_id, strava_data)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			                      notes, feeling, strava_activity_id, strava_data, gear_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		`, userID, workoutData["date"], workoutData["type"], workoutData["distance"],
 			workoutData["duration"], workoutData["avg_pace"], workoutData["avg_heart_rate"],
 			workoutData["avg_power"], workoutData["cadence"], workoutData["elevation_gain"],
 			workoutData["calories"], workoutData["notes"], workoutData["feeling"], activity.ID,
-			stravaDataJSON)
+			stravaDataJSON, gearID)
 
 		if err != nil {
 			log.Printf("❌ Error importando actividad %d: %v", activity.ID, err)
@@ -250,12 +285,22 @@ func StravaSyncHandler(w http.ResponseWriter, r *http.Request) {
 
 		imported++
 		log.Printf("✅ Importada actividad %d: %s", activity.ID, workoutData["notes"])
+
+		// Detectar series; los streams solo se descargan para sesiones de intervalos
+		workoutID, _ := result.LastInsertId()
+		var streamsService *services.StravaService
+		if workoutData["type"] == "interval" {
+			streamsService = stravaService
+		}
+		if _, _, err := refreshWorkoutIntervals(int(workoutID), activity.ID, activityDetail, streamsService); err != nil {
+			log.Printf("⚠️  Error detectando series de actividad %d: %v", activity.ID, err)
+		}
 	}
 
 	// Actualizar última sincronización
 	_, err = database.DB.Exec(`
 		UPDATE strava_tokens
-		SET last_sync = CURRENT_TIMESTAMP
+		SET last_sync = CURRENT_TIMESTAMP, last_sync_error = NULL, last_sync_error_at = NULL
 		WHERE user_id = ?
 	`, userID)
 
@@ -264,12 +309,27 @@ func StravaSyncHandler(w http.ResponseWriter, r *http.Request) {
 		"imported": imported,
 		"skipped":  skipped,
 		"total":    len(activities),
-		"message":  fmt.Sprintf("Sincronización completada: %d nuevas, %d ya existentes", imported, skipped),
+		"message":  services.T(requestLocale(r), "Sincronización completada: %d nuevas, %d ya existentes", imported, skipped),
+	}
+
+	// Avisar de zapatillas cerca de su límite tras sumar los nuevos km
+	if alerts, err := gearAlertsForUser(userID, requestLocale(r)); err == nil && len(alerts) > 0 {
+		response["gear_alerts"] = alerts
 	}
 
 	json.NewEncoder(w).Encode(response)
 }
 
+// recordStravaSyncError guarda el último error de sincronización del usuario para el panel de
+// administración
+func recordStravaSyncError(userID int, syncErr error) {
+	if _, err := database.DB.Exec(`
+		UPDATE strava_tokens SET last_sync_error = ?, last_sync_error_at = ? WHERE user_id = ?`,
+		syncErr.Error(), time.Now(), userID); err != nil {
+		log.Printf("⚠️  Error guardando error de sincronización: %v", err)
+	}
+}
+
 // StravaStatusHandler retorna el estado de la conexión con Strava
 func StravaStatusHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"trainapp/database"
+	"trainapp/services"
+)
+
+// Motivo de los intentos de inicio de sesión con un código de 2FA incorrecto
+const loginWrongSecondFactor = "wrong_2fa_code"
+
+// MFAChallenge es la respuesta del login de una cuenta con 2FA: en lugar de la sesión devuelve un
+// token parcial con el que completar el inicio de sesión en /api/auth/2fa/verify
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// TwoFactorStatus es el estado del 2FA de la cuenta
+type TwoFactorStatus struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+}
+
+// twoFactorEnabled indica si el usuario tiene el 2FA activado
+func twoFactorEnabled(userID int) (bool, error) {
+	var count int
+	err := database.DB.QueryRow(`
+		SELECT COUNT(*) FROM user_totp WHERE user_id = ? AND enabled_at IS NOT NULL`, userID).Scan(&count)
+	return count > 0, err
+}
+
+// writeMFAChallenge responde al login de una cuenta con 2FA con el token parcial
+func writeMFAChallenge(w http.ResponseWriter, r *http.Request, userID int, email string) {
+	token, err := services.GetAuthService().GenerateMFAToken(userID, email)
+	if err != nil {
+		httpError(w, r, "Error generando token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MFAChallenge{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int(services.MFATokenTTL.Seconds()),
+	})
+}
+
+// checkSecondFactor comprueba un código de la app de autenticación o un código de recuperación
+// del usuario y lo marca como usado para que no sirva otra vez
+func checkSecondFactor(userID int, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return false, nil
+	}
+
+	// Los códigos de la app son 6 cifras; el resto se prueba como código de recuperación
+	digits := strings.ReplaceAll(code, " ", "")
+	if len(digits) == 6 && strings.Trim(digits, "0123456789") == "" {
+		var secret string
+		var lastStep int64
+		err := database.DB.QueryRow(`
+			SELECT secret, last_step FROM user_totp WHERE user_id = ? AND enabled_at IS NOT NULL`,
+			userID).Scan(&secret, &lastStep)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		step, ok := services.VerifyTOTP(secret, digits, time.Now(), lastStep)
+		if !ok {
+			return false, nil
+		}
+		// Solo una de dos peticiones simultáneas con el mismo código puede guardar su periodo
+		result, err := database.DB.Exec(`
+			UPDATE user_totp SET last_step = ? WHERE user_id = ? AND last_step < ?`, step, userID, step)
+		if err != nil {
+			return false, err
+		}
+		updated, _ := result.RowsAffected()
+		return updated == 1, nil
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE recovery_codes SET used_at = ?
+		WHERE id = (
+			SELECT rc.id FROM recovery_codes rc
+			JOIN user_totp t ON t.user_id = rc.user_id AND t.enabled_at IS NOT NULL
+			WHERE rc.user_id = ? AND rc.code_hash = ? AND rc.used_at IS NULL
+			LIMIT 1
+		)`, time.Now(), userID, services.HashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+	updated, _ := result.RowsAffected()
+	if updated == 1 {
+		log.Printf("🔑 Código de recuperación usado: usuario %d", userID)
+	}
+	return updated == 1, nil
+}
+
+// replaceRecoveryCodes genera códigos de recuperación nuevos para el usuario y anula los anteriores
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	codes, hashes, err := services.NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	for _, hash := range hashes {
+		if _, err := tx.Exec(`
+			INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hash); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// writeRecoveryCodes responde con los códigos de recuperación, que solo se muestran esta vez
+func writeRecoveryCodes(w http.ResponseWriter, codes []string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// TwoFactorHandler devuelve el estado del 2FA del usuario
+func TwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	var status TwoFactorStatus
+	var enabledAt sql.NullTime
+	err := database.DB.QueryRow(`
+		SELECT enabled_at FROM user_totp WHERE user_id = ?`, userID).Scan(&enabledAt)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error obteniendo 2FA: %v", err)
+		httpError(w, r, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+	if enabledAt.Valid {
+		status.Enabled = true
+		status.EnabledAt = &enabledAt.Time
+		database.DB.QueryRow(`
+			SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`,
+			userID).Scan(&status.RecoveryCodesLeft)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// TwoFactorSetupHandler inicia la activación del 2FA con la contraseña actual: genera el secreto
+// y devuelve la URI otpauth:// para mostrarla como código QR. El 2FA no se activa hasta que se
+// confirma con un código de la app.
+func TwoFactorSetupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		ReauthToken     string `json:"reauth_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+	if !checkCurrentPassword(w, r, userID, req.CurrentPassword, req.ReauthToken) {
+		return
+	}
+
+	enabled, err := twoFactorEnabled(userID)
+	if err != nil {
+		log.Printf("Error obteniendo 2FA: %v", err)
+		httpError(w, r, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		httpError(w, r, "El 2FA ya está activado", http.StatusConflict)
+		return
+	}
+
+	user, err := loadUserProfile(userID)
+	if err != nil {
+		httpError(w, r, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	secret, err := services.NewTOTPSecret()
+	if err == nil {
+		// Una configuración anterior sin confirmar se sustituye
+		_, err = database.DB.Exec(`
+			INSERT INTO user_totp (user_id, secret) VALUES (?, ?)
+			ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, last_step = 0, created_at = CURRENT_TIMESTAMP`,
+			userID, secret)
+	}
+	if err != nil {
+		log.Printf("Error configurando 2FA: %v", err)
+		httpError(w, r, "Error configurando el 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":      secret,
+		"otpauth_url": services.TOTPProvisioningURI(user.Email, secret),
+	})
+}
+
+// TwoFactorEnableHandler activa el 2FA con el primer código de la app y devuelve los códigos de
+// recuperación
+func TwoFactorEnableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+
+	var secret string
+	var enabledAt sql.NullTime
+	err := database.DB.QueryRow(`
+		SELECT secret, enabled_at FROM user_totp WHERE user_id = ?`, userID).Scan(&secret, &enabledAt)
+	if err == sql.ErrNoRows {
+		httpError(w, r, "Primero hay que iniciar la configuración del 2FA", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error obteniendo 2FA: %v", err)
+		httpError(w, r, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+	if enabledAt.Valid {
+		httpError(w, r, "El 2FA ya está activado", http.StatusConflict)
+		return
+	}
+
+	step, ok := services.VerifyTOTP(secret, req.Code, time.Now(), 0)
+	if !ok {
+		httpError(w, r, "Código de verificación incorrecto", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		httpError(w, r, "Error configurando el 2FA", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err == nil {
+		_, err = tx.Exec(`
+			UPDATE user_totp SET enabled_at = ?, last_step = ? WHERE user_id = ?`, time.Now(), step, userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error activando 2FA: %v", err)
+		httpError(w, r, "Error configurando el 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	if user, err := loadUserProfile(userID); err == nil {
+		sendNotice(services.TwoFactorEnabledNotice(emailLocale(r, userID), user.Email, user.Name))
+	}
+	log.Printf("🔐 2FA activado: usuario %d", userID)
+
+	writeRecoveryCodes(w, codes)
+}
+
+// checkPasswordAndSecondFactor comprueba la contraseña actual y un código del 2FA activo; si
+// alguno falla responde con el error y devuelve false
+func checkPasswordAndSecondFactor(w http.ResponseWriter, r *http.Request, userID int, password, reauthToken, code string) bool {
+	if !checkCurrentPassword(w, r, userID, password, reauthToken) {
+		return false
+	}
+	enabled, err := twoFactorEnabled(userID)
+	if err == nil && !enabled {
+		httpError(w, r, "El 2FA no está activado", http.StatusConflict)
+		return false
+	}
+	ok := false
+	if err == nil {
+		ok, err = checkSecondFactor(userID, code)
+	}
+	if err != nil {
+		log.Printf("Error comprobando código 2FA: %v", err)
+		httpError(w, r, "Error interno del servidor", http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		httpError(w, r, "Código de verificación incorrecto", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// TwoFactorDisableHandler desactiva el 2FA con la contraseña actual y un código
+func TwoFactorDisableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		ReauthToken     string `json:"reauth_token"`
+		Code            string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+	if !checkPasswordAndSecondFactor(w, r, userID, req.CurrentPassword, req.ReauthToken, req.Code) {
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		httpError(w, r, "Error configurando el 2FA", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM user_totp WHERE user_id = ?", userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error desactivando 2FA: %v", err)
+		httpError(w, r, "Error configurando el 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	if user, err := loadUserProfile(userID); err == nil {
+		sendNotice(services.TwoFactorDisabledNotice(emailLocale(r, userID), user.Email, user.Name))
+	}
+	log.Printf("🔓 2FA desactivado: usuario %d", userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RecoveryCodesHandler genera códigos de recuperación nuevos (con la contraseña actual y un
+// código) y anula los anteriores
+func RecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		ReauthToken     string `json:"reauth_token"`
+		Code            string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+	if !checkPasswordAndSecondFactor(w, r, userID, req.CurrentPassword, req.ReauthToken, req.Code) {
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		httpError(w, r, "Error configurando el 2FA", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error regenerando códigos de recuperación: %v", err)
+		httpError(w, r, "Error configurando el 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	writeRecoveryCodes(w, codes)
+}
+
+// TwoFactorLoginHandler completa el inicio de sesión de una cuenta con 2FA: con el token parcial
+// del login y un código de la app o de recuperación abre la sesión. Los códigos incorrectos cuentan
+// como intentos fallidos para el bloqueo de la cuenta.
+func TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Datos inválidos", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := services.GetAuthService().ValidateMFAToken(req.MFAToken)
+	if err != nil {
+		httpError(w, r, "Token de verificación inválido o caducado", http.StatusUnauthorized)
+		return
+	}
+
+	wait, err := loginLockedFor(claims.Email)
+	if err != nil {
+		log.Printf("⚠️  Error comprobando bloqueo de %s: %v", claims.Email, err)
+	}
+	if wait > 0 {
+		recordLoginAttempt(r, claims.Email, claims.UserID, false, loginLocked)
+		writeLockedOut(w, r, wait)
+		return
+	}
+
+	ok, err := checkSecondFactor(claims.UserID, req.Code)
+	if err != nil {
+		log.Printf("Error comprobando código 2FA: %v", err)
+		httpError(w, r, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		recordLoginAttempt(r, claims.Email, claims.UserID, false, loginWrongSecondFactor)
+		httpError(w, r, "Código de verificación incorrecto", http.StatusUnauthorized)
+		return
+	}
+	if accountDisabled(claims.UserID) {
+		recordLoginAttempt(r, claims.Email, claims.UserID, false, loginDisabled)
+		httpError(w, r, "Cuenta desactivada", http.StatusForbidden)
+		return
+	}
+	recordLoginAttempt(r, claims.Email, claims.UserID, true, "")
+
+	user, err := loadUserProfile(claims.UserID)
+	if err != nil {
+		httpError(w, r, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+
+	// Abrir la sesión y responder con sus tokens
+	writeAuthResponse(w, r, user)
+
+	log.Printf("✅ Login exitoso con 2FA: %s", user.Email)
+}
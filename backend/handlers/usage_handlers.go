@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"trainapp/database"
+	"trainapp/services"
+)
+
+// llmSession prepara una sesión sin contexto del corredor ni herramientas, para las llamadas
+// auxiliares (extracción de datos y recomendaciones); registra el consumo igual que el coach
+func llmSession(r *http.Request) services.CoachSession {
+	return services.CoachSession{
+		Context:       r.Context(),
+		UserID:        r.Context().Value("userID").(int),
+		Locale:        requestLocale(r),
+		Usage:         recordLLMUsage,
+		Conversations: conversationStore{},
+	}
+}
+
+// recordLLMUsage guarda el consumo de una llamada al modelo
+func recordLLMUsage(usage services.LLMUsage) {
+	if _, err := database.DB.Exec(`
+		INSERT INTO llm_usage (user_id, operation, model, prompt_tokens, completion_tokens, total_tokens, latency_ms, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		usage.UserID, usage.Operation, usage.Model, usage.PromptTokens, usage.CompletionTokens,
+		usage.PromptTokens+usage.CompletionTokens, usage.Latency.Milliseconds(), nullIfEmpty(usage.Error)); err != nil {
+		log.Printf("⚠️  Error guardando consumo del modelo (%s): %v", usage.Operation, err)
+	}
+}
+
+// llmUsageToday devuelve los tokens y llamadas al modelo del usuario en el día actual (UTC)
+func llmUsageToday(userID int) (tokens, requests int, err error) {
+	err = database.DB.QueryRow(`
+		SELECT COALESCE(SUM(total_tokens), 0), COUNT(*)
+		FROM llm_usage
+		WHERE user_id = ? AND date(created_at) = date('now')`, userID).Scan(&tokens, &requests)
+	return tokens, requests, err
+}
+
+// checkLLMQuota comprueba la cuota diaria del usuario antes de llamar al coach. Si la ha agotado
+// responde 429 con Retry-After y devuelve false.
+func checkLLMQuota(w http.ResponseWriter, r *http.Request) bool {
+	quota := services.DailyLLMQuota()
+	if quota.DailyTokens == 0 && quota.DailyRequests == 0 {
+		return true
+	}
+
+	userID := r.Context().Value("userID").(int)
+	tokens, requests, err := llmUsageToday(userID)
+	if err != nil {
+		// Un fallo al leer el consumo no debe dejar al corredor sin coach
+		log.Printf("⚠️  Error leyendo consumo del modelo: %v", err)
+		return true
+	}
+	if !quota.Exceeded(tokens, requests) {
+		return true
+	}
+
+	now := time.Now()
+	resetsAt := services.QuotaResetsAt(now)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(resetsAt.Sub(now).Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":     services.T(requestLocale(r), "Has alcanzado el límite diario de uso del coach"),
+		"quota":     quota,
+		"used":      map[string]int{"tokens": tokens, "requests": requests},
+		"resets_at": resetsAt,
+	})
+	return false
+}
+
+// UsageHandler devuelve el consumo del coach del usuario en el día actual y su cuota
+func UsageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	tokens, requests, err := llmUsageToday(userID)
+	if err != nil {
+		log.Printf("Error obteniendo consumo: %v", err)
+		httpError(w, r, "Error obteniendo consumo", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"used":      map[string]int{"tokens": tokens, "requests": requests},
+		"quota":     services.DailyLLMQuota(),
+		"resets_at": services.QuotaResetsAt(time.Now()),
+	})
+}
+
+// usageGroup es el consumo agregado de un usuario, modelo, operación o día
+type usageGroup struct {
+	Key              string  `json:"key"`
+	Requests         int     `json:"requests"`
+	Errors           int     `json:"errors"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	AvgLatencyMs     int     `json:"avg_latency_ms"`
+	EstimatedCostUSD float64 `json:"estimated_cost_usd"`
+}
+
+// add suma una fila de consumo (ya agregada por modelo) al grupo
+func (g *usageGroup) add(row usageGroup, cost float64) {
+	totalLatency := g.AvgLatencyMs*g.Requests + row.AvgLatencyMs*row.Requests
+	g.Requests += row.Requests
+	g.Errors += row.Errors
+	g.PromptTokens += row.PromptTokens
+	g.CompletionTokens += row.CompletionTokens
+	g.TotalTokens += row.TotalTokens
+	if g.Requests > 0 {
+		g.AvgLatencyMs = totalLatency / g.Requests
+	}
+	g.EstimatedCostUSD += cost
+}
+
+// AdminLLMUsageHandler resume el consumo del modelo de todos los usuarios entre dos fechas
+// (?from=YYYY-MM-DD&to=YYYY-MM-DD, por defecto los últimos 30 días; ?user_id= para filtrar),
+// con el coste estimado según los precios configurados
+func AdminLLMUsageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		httpError(w, r, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -29)
+	var err error
+	if value := query.Get("from"); value != "" {
+		if from, err = time.Parse("2006-01-02", value); err != nil {
+			httpError(w, r, "from debe tener formato YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if to, err = time.Parse("2006-01-02", value); err != nil {
+			httpError(w, r, "to debe tener formato YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+	if to.Before(from) {
+		httpError(w, r, "to debe ser posterior a from", http.StatusBadRequest)
+		return
+	}
+
+	where := `date(u.created_at) BETWEEN ? AND ?`
+	params := []interface{}{from.Format("2006-01-02"), to.Format("2006-01-02")}
+	if value := query.Get("user_id"); value != "" {
+		userID, err := strconv.Atoi(value)
+		if err != nil {
+			httpError(w, r, "user_id inválido", http.StatusBadRequest)
+			return
+		}
+		where += ` AND u.user_id = ?`
+		params = append(params, userID)
+	}
+
+	prices, err := services.ModelPrices()
+	if err != nil {
+		log.Printf("⚠️  %v", err)
+		httpError(w, r, "Error obteniendo precios de los modelos", http.StatusInternalServerError)
+		return
+	}
+
+	// Se agrega por usuario, operación, día y modelo; el resto de agrupaciones se calculan aquí
+	// para poder aplicar el precio de cada modelo
+	rows, err := database.DB.Query(`
+		SELECT u.user_id, COALESCE(us.email, ''), u.operation, date(u.created_at), u.model,
+			COUNT(*), SUM(CASE WHEN u.error IS NULL THEN 0 ELSE 1 END),
+			SUM(u.prompt_tokens), SUM(u.completion_tokens), SUM(u.total_tokens),
+			CAST(COALESCE(AVG(u.latency_ms), 0) AS INTEGER)
+		FROM llm_usage u
+		LEFT JOIN users us ON us.id = u.user_id
+		WHERE `+where+`
+		GROUP BY u.user_id, u.operation, date(u.created_at), u.model`, params...)
+	if err != nil {
+		log.Printf("Error obteniendo consumo: %v", err)
+		httpError(w, r, "Error obteniendo consumo", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	total := &usageGroup{Key: "total"}
+	groups := map[string]map[string]*usageGroup{"users": {}, "models": {}, "operations": {}, "days": {}}
+	unpriced := map[string]bool{}
+	for rows.Next() {
+		var userID int
+		var email, operation, day, model string
+		var row usageGroup
+		if err := rows.Scan(&userID, &email, &operation, &day, &model, &row.Requests, &row.Errors,
+			&row.PromptTokens, &row.CompletionTokens, &row.TotalTokens, &row.AvgLatencyMs); err != nil {
+			log.Printf("Error escaneando consumo: %v", err)
+			continue
+		}
+
+		price, ok := prices[model]
+		if !ok {
+			unpriced[model] = true
+		}
+		cost := price.Cost(row.PromptTokens, row.CompletionTokens)
+
+		user := strconv.Itoa(userID)
+		if email != "" {
+			user += " " + email
+		}
+		total.add(row, cost)
+		for kind, key := range map[string]string{"users": user, "models": model, "operations": operation, "days": day} {
+			if groups[kind][key] == nil {
+				groups[kind][key] = &usageGroup{Key: key}
+			}
+			groups[kind][key].add(row, cost)
+		}
+	}
+
+	response := map[string]interface{}{
+		"from":   from.Format("2006-01-02"),
+		"to":     to.Format("2006-01-02"),
+		"total":  total,
+		"prices": prices,
+	}
+	for kind, byKey := range groups {
+		list := make([]*usageGroup, 0, len(byKey))
+		for _, g := range byKey {
+			list = append(list, g)
+		}
+		sortUsageGroups(list, kind == "days")
+		response[kind] = list
+	}
+	if len(unpriced) > 0 {
+		models := make([]string, 0, len(unpriced))
+		for model := range unpriced {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+		response["unpriced_models"] = models
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// sortUsageGroups ordena los días cronológicamente y el resto por tokens consumidos
+func sortUsageGroups(list []*usageGroup, byKey bool) {
+	sort.Slice(list, func(i, j int) bool {
+		if byKey || list[i].TotalTokens == list[j].TotalTokens {
+			return list[i].Key < list[j].Key
+		}
+		return list[i].TotalTokens > list[j].TotalTokens
+	})
+}
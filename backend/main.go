@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
 	"trainapp/database"
 	"trainapp/handlers"
@@ -29,8 +30,39 @@ func main() {
 	defer database.Close()
 
 	// Inicializar servicios
-	services.InitializeAuth(os.Getenv("JWT_SECRET"))
+	if err := services.InitializeAuth(); err != nil {
+		log.Fatal("Error inicializando autenticación:", err)
+	}
+	services.GetAuthService().SessionActive = handlers.SessionActive
+	services.GetAuthService().LookupAPIKey = handlers.LookupAPIKey
+	services.GetAuthService().UserRole = handlers.UserRole
+	if err := handlers.PromoteAdmins(services.AdminEmails()); err != nil {
+		log.Fatal("Error aplicando ADMIN_EMAILS:", err)
+	}
 	services.InitializeStrava()
+	if err := services.InitializeOIDC(); err != nil {
+		log.Fatal("Error configurando OIDC:", err)
+	}
+	if err := handlers.StartJobs(); err != nil {
+		log.Fatal("Error arrancando los trabajos en segundo plano:", err)
+	}
+	if _, err := services.RateLimits(); err != nil {
+		log.Fatal("Error en RATE_LIMITS:", err)
+	}
+	if err := services.InitializeMailer(); err != nil {
+		log.Fatal("Error configurando el envío de emails:", err)
+	}
+
+	// Cargar las plantillas de prompt (PROMPTS_DIR, PROMPT_VERSIONS)
+	prompts, err := services.Prompts()
+	if err != nil {
+		log.Fatal("Error cargando prompts:", err)
+	}
+	for _, name := range prompts.Names() {
+		if p, err := prompts.Active(name); err == nil {
+			log.Printf("📝 Prompt %s", p.ID())
+		}
+	}
 	log.Println("✅ Servicios inicializados")
 
 	// Configurar rutas
@@ -52,31 +84,110 @@ func main() {
 	mux.Handle("/", fs)
 
 	// Auth endpoints (públicos)
-	mux.HandleFunc("/api/auth/register", handlers.RegisterHandler)
-	mux.HandleFunc("/api/auth/login", handlers.LoginHandler)
-	mux.HandleFunc("/api/auth/me", middleware.AuthMiddleware(handlers.MeHandler))
+	mux.HandleFunc("/api/auth/register", middleware.RateLimitMiddleware("auth", handlers.RegisterHandler))
+	mux.HandleFunc("/api/auth/login", middleware.RateLimitMiddleware("auth", handlers.LoginHandler))
+	mux.HandleFunc("/api/auth/me", middleware.APIKeyScopes("", "", middleware.AuthMiddleware(handlers.MeHandler)))
+	mux.HandleFunc("/api/auth/refresh", handlers.RefreshHandler)
+	mux.HandleFunc("/api/auth/logout", middleware.AuthMiddleware(handlers.LogoutHandler))
+	mux.HandleFunc("/api/auth/logout-all", middleware.AuthMiddleware(middleware.DenyImpersonation(handlers.LogoutAllHandler)))
+	mux.HandleFunc("/api/auth/sessions", middleware.AuthMiddleware(middleware.DenyImpersonation(handlers.SessionsHandler)))
+	mux.HandleFunc("/api/auth/sessions/", middleware.AuthMiddleware(middleware.DenyImpersonation(handlers.SessionDetailHandler)))
+	mux.HandleFunc("/api/auth/verify-email", middleware.RateLimitMiddleware("auth", handlers.VerifyEmailHandler))
+	mux.HandleFunc("/api/auth/verify-email/resend", middleware.AuthMiddleware(handlers.ResendVerificationHandler))
+	mux.HandleFunc("/api/auth/password/forgot", middleware.RateLimitMiddleware("auth", handlers.ForgotPasswordHandler))
+	mux.HandleFunc("/api/auth/password/reset", middleware.RateLimitMiddleware("auth", handlers.ResetPasswordHandler))
+	mux.HandleFunc("/api/auth/password/change", middleware.AuthMiddleware(middleware.DenyImpersonation(handlers.ChangePasswordHandler)))
+	mux.HandleFunc("/api/auth/email/change", middleware.AuthMiddleware(middleware.DenyImpersonation(handlers.ChangeEmailHandler)))
+	mux.HandleFunc("/api/auth/reauth", middleware.AuthMiddleware(middleware.DenyImpersonation(handlers.ReauthHandler)))
+	mux.HandleFunc("/api/auth/2fa", middleware.AuthMiddleware(handlers.TwoFactorHandler))
+	mux.HandleFunc("/api/auth/2fa/setup", middleware.AuthMiddleware(middleware.DenyImpersonation(handlers.TwoFactorSetupHandler)))
+	mux.HandleFunc("/api/auth/2fa/enable", middleware.AuthMiddleware(middleware.DenyImpersonation(handlers.TwoFactorEnableHandler)))
+	mux.HandleFunc("/api/auth/2fa/disable", middleware.AuthMiddleware(middleware.DenyImpersonation(handlers.TwoFactorDisableHandler)))
+	mux.HandleFunc("/api/auth/2fa/recovery-codes", middleware.AuthMiddleware(middleware.DenyImpersonation(handlers.RecoveryCodesHandler)))
+	mux.HandleFunc("/api/auth/2fa/verify", middleware.RateLimitMiddleware("auth", handlers.TwoFactorLoginHandler))
+
+	// Inicio de sesión con Strava u OIDC y vinculación de identidades externas (nunca en una sesión de soporte)
+	mux.HandleFunc("/api/auth/oauth/providers", handlers.OAuthProvidersHandler)
+	mux.HandleFunc("/api/auth/oauth/exchange", middleware.RateLimitMiddleware("auth", handlers.OAuthExchangeHandler))
+	mux.HandleFunc("/api/auth/oauth/signup", middleware.RateLimitMiddleware("auth", handlers.OAuthSignupHandler))
+	mux.HandleFunc("/api/auth/oauth/link-url", middleware.AuthMiddleware(middleware.DenyImpersonation(handlers.OAuthLinkURLHandler)))
+	mux.HandleFunc("/api/auth/oauth/link/", middleware.SignedURLMiddleware(services.PurposeOAuthLink, middleware.DenyImpersonation(handlers.OAuthLinkHandler)))
+	mux.HandleFunc("/api/auth/oauth/", middleware.RateLimitMiddleware("auth", handlers.OAuthHandler))
+	mux.HandleFunc("/api/auth/identities", middleware.AuthMiddleware(middleware.DenyImpersonation(handlers.IdentitiesHandler)))
+	mux.HandleFunc("/api/auth/identities/", middleware.AuthMiddleware(middleware.DenyImpersonation(handlers.IdentityDetailHandler)))
+
+	// Claves de API personales: se crean y revocan solo con la sesión del usuario (nunca en una de soporte)
+	mux.HandleFunc("/api/api-keys", middleware.AuthMiddleware(middleware.DenyImpersonation(handlers.APIKeysHandler)))
+	mux.HandleFunc("/api/api-keys/", middleware.AuthMiddleware(middleware.DenyImpersonation(handlers.APIKeyDetailHandler)))
+
+	// Exportación de los datos y borrado de la cuenta (nunca en una sesión de soporte)
+	mux.HandleFunc("/api/account", middleware.AuthMiddleware(middleware.DenyImpersonation(handlers.AccountHandler)))
+	mux.HandleFunc("/api/account/exports", middleware.AuthMiddleware(middleware.DenyImpersonation(handlers.DataExportsHandler)))
+	mux.HandleFunc("/api/account/exports/", middleware.SignedURLMiddleware(services.PurposeDataExport, middleware.DenyImpersonation(handlers.DataExportDetailHandler)))
+
+	// Rutas que admiten claves de API y scopes que necesitan (lectura, escritura)
+	workoutScopes := func(next http.HandlerFunc) http.HandlerFunc {
+		return middleware.APIKeyScopes(services.ScopeWorkoutsRead, services.ScopeWorkoutsWrite, next)
+	}
+	coachScopes := func(next http.HandlerFunc) http.HandlerFunc {
+		return middleware.APIKeyScopes(services.ScopeCoach, services.ScopeCoach, next)
+	}
+	stravaScopes := func(next http.HandlerFunc) http.HandlerFunc {
+		return middleware.APIKeyScopes(services.ScopeStravaSync, services.ScopeStravaSync, next)
+	}
 
 	// API endpoints (protegidos)
-	mux.HandleFunc("/api/workouts", middleware.AuthMiddleware(handlers.WorkoutsHandler))
-	mux.HandleFunc("/api/workouts/", middleware.AuthMiddleware(handlers.WorkoutDetailHandler))
-	mux.HandleFunc("/api/training-plan", middleware.AuthMiddleware(handlers.TrainingPlanHandler))
-	mux.HandleFunc("/api/weekly-plan", middleware.AuthMiddleware(handlers.WeeklyPlanHandler))
-	mux.HandleFunc("/api/workout-analysis", middleware.AuthMiddleware(handlers.WorkoutAnalysisHandler))
-	mux.HandleFunc("/api/workout-analysis-image", middleware.AuthMiddleware(handlers.WorkoutAnalysisImageHandler))
-	mux.HandleFunc("/api/workout-analysis-form", middleware.AuthMiddleware(handlers.WorkoutAnalysisFormHandler))
-	mux.HandleFunc("/api/progress-report", middleware.AuthMiddleware(handlers.ProgressReportHandler))
+	mux.HandleFunc("/api/workouts", workoutScopes(middleware.AuthMiddleware(handlers.WorkoutsHandler)))
+	mux.HandleFunc("/api/workouts/", workoutScopes(middleware.SignedURLMiddleware(services.PurposeWorkoutImage, handlers.WorkoutDetailHandler)))
+	mux.HandleFunc("/api/training-plan", coachScopes(middleware.AuthMiddleware(middleware.RateLimitMiddleware("coach", handlers.TrainingPlanHandler))))
+	mux.HandleFunc("/api/training-plan/proposals", coachScopes(middleware.AuthMiddleware(handlers.PlanProposalsHandler)))
+	mux.HandleFunc("/api/training-plan/proposals/", coachScopes(middleware.AuthMiddleware(handlers.PlanProposalDetailHandler)))
+	mux.HandleFunc("/api/weekly-plan", coachScopes(middleware.AuthMiddleware(middleware.RateLimitMiddleware("coach", handlers.WeeklyPlanHandler))))
+	mux.HandleFunc("/api/workout-analysis", coachScopes(middleware.AuthMiddleware(middleware.RateLimitMiddleware("coach", handlers.WorkoutAnalysisHandler))))
+	mux.HandleFunc("/api/workout-analysis-image", coachScopes(middleware.AuthMiddleware(middleware.RateLimitMiddleware("coach", handlers.WorkoutAnalysisImageHandler))))
+	mux.HandleFunc("/api/workout-analysis-form", coachScopes(middleware.AuthMiddleware(middleware.RateLimitMiddleware("coach", handlers.WorkoutAnalysisFormHandler))))
+	mux.HandleFunc("/api/workout-extractions/", coachScopes(middleware.AuthMiddleware(handlers.WorkoutExtractionDetailHandler)))
+	mux.HandleFunc("/api/analyses", coachScopes(middleware.AuthMiddleware(handlers.AnalysesHandler)))
+	mux.HandleFunc("/api/analyses/", coachScopes(middleware.AuthMiddleware(handlers.AnalysisDetailHandler)))
+	mux.HandleFunc("/api/advice", coachScopes(middleware.AuthMiddleware(handlers.AdviceHandler)))
+	mux.HandleFunc("/api/progress-report", coachScopes(middleware.AuthMiddleware(middleware.RateLimitMiddleware("coach", handlers.ProgressReportHandler))))
+	mux.HandleFunc("/api/progress-reports", coachScopes(middleware.AuthMiddleware(handlers.ProgressReportsHandler)))
+	mux.HandleFunc("/api/progress-reports/", coachScopes(middleware.AuthMiddleware(handlers.ProgressReportDetailHandler)))
 	mux.HandleFunc("/api/user", middleware.AuthMiddleware(handlers.UserHandler))
+	mux.HandleFunc("/api/conversation", middleware.AuthMiddleware(handlers.ConversationHandler))
+	mux.HandleFunc("/api/usage", middleware.AuthMiddleware(handlers.UsageHandler))
+	mux.HandleFunc("/api/admin/llm-usage", middleware.AuthMiddleware(middleware.AdminMiddleware(handlers.AdminLLMUsageHandler)))
+	mux.HandleFunc("/api/admin/login-attempts", middleware.AuthMiddleware(middleware.AdminMiddleware(handlers.AdminLoginAttemptsHandler)))
+	mux.HandleFunc("/api/admin/users", middleware.AuthMiddleware(middleware.AdminMiddleware(handlers.AdminUsersHandler)))
+	mux.HandleFunc("/api/admin/users/", middleware.AuthMiddleware(middleware.AdminMiddleware(handlers.AdminUserDetailHandler)))
+	mux.HandleFunc("/api/admin/audit-log", middleware.AuthMiddleware(middleware.AdminMiddleware(handlers.AdminAuditLogHandler)))
+	mux.HandleFunc("/api/admin/health", middleware.AuthMiddleware(middleware.AdminMiddleware(handlers.AdminHealthHandler)))
+	mux.HandleFunc("/api/profile", middleware.AuthMiddleware(handlers.ProfileHandler))
+	mux.HandleFunc("/api/profile/history", middleware.AuthMiddleware(handlers.ProfileHistoryHandler))
+	mux.HandleFunc("/api/gear", workoutScopes(middleware.AuthMiddleware(handlers.GearHandler)))
+	mux.HandleFunc("/api/gear/", workoutScopes(middleware.AuthMiddleware(handlers.GearDetailHandler)))
+	mux.HandleFunc("/api/races", workoutScopes(middleware.AuthMiddleware(handlers.RacesHandler)))
+	mux.HandleFunc("/api/races/", workoutScopes(middleware.AuthMiddleware(handlers.RaceDetailHandler)))
 
 	// Strava endpoints (protegidos)
-	mux.HandleFunc("/api/strava/auth", middleware.AuthMiddleware(handlers.StravaAuthHandler))
+	mux.HandleFunc("/api/strava/auth", middleware.SignedURLMiddleware(services.PurposeStravaAuth, handlers.StravaAuthHandler))
+	mux.HandleFunc("/api/strava/auth-url", middleware.AuthMiddleware(handlers.StravaAuthURLHandler))
 	mux.HandleFunc("/api/strava/callback", handlers.StravaCallbackHandler) // Callback no requiere auth
-	mux.HandleFunc("/api/strava/sync", middleware.AuthMiddleware(handlers.StravaSyncHandler))
-	mux.HandleFunc("/api/strava/status", middleware.AuthMiddleware(handlers.StravaStatusHandler))
-
-	// Configurar CORS
+	mux.HandleFunc("/api/strava/sync", stravaScopes(middleware.AuthMiddleware(handlers.StravaSyncHandler)))
+	mux.HandleFunc("/api/strava/status", stravaScopes(middleware.AuthMiddleware(handlers.StravaStatusHandler)))
+
+	// Configurar CORS. Con "*" los navegadores no envían las cookies de sesión a otros orígenes;
+	// un frontend servido desde otro origen debe estar en ALLOWED_ORIGINS
+	allowedOrigins := []string{"*"}
+	if value := strings.TrimSpace(os.Getenv("ALLOWED_ORIGINS")); value != "" {
+		allowedOrigins = strings.Split(value, ",")
+		for i := range allowedOrigins {
+			allowedOrigins[i] = strings.TrimSpace(allowedOrigins[i])
+		}
+	}
 	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedOrigins:   allowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"*"},
 		AllowCredentials: true,
 	})
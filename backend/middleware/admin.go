@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+
+	"trainapp/services"
+)
+
+// AdminMiddleware restringe el acceso a los usuarios con el rol de administrador. El rol se
+// consulta en cada petición, así que quitarlo tiene efecto inmediato. Debe ir después de
+// AuthMiddleware.
+func AdminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value("userID").(int)
+		if userID == 0 || !services.GetAuthService().IsAdmin(userID) {
+			http.Error(w, services.T(requestLocale(r), "Acceso restringido a administradores"), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// DenyImpersonation rechaza las peticiones de las sesiones de soporte en las que un administrador
+// usa la cuenta de otro usuario; protege la gestión de la cuenta (contraseña, email, 2FA, claves
+// de API...). Debe ir después de AuthMiddleware.
+func DenyImpersonation(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminID, _ := r.Context().Value("impersonatorID").(int); adminID != 0 {
+			http.Error(w, services.T(requestLocale(r), "No disponible en una sesión de soporte"), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
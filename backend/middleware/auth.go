@@ -2,75 +2,174 @@ package middleware
 
 import (
 	"context"
+	"log"
 	"net/http"
 	"strings"
 
 	"trainapp/services"
 )
 
-// AuthMiddleware verifica que el usuario esté autenticado
+const (
+	// SessionCookie guarda el token de acceso del frontend (httpOnly, no accesible desde JS)
+	SessionCookie = "trainapp_session"
+	// RefreshCookie guarda el token de refresco del frontend; solo se envía a /api/auth
+	RefreshCookie = "trainapp_refresh"
+	// CSRFHeader lleva el token anti-CSRF que exigen las peticiones con cookie que modifican datos
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// AuthMiddleware verifica que el usuario esté autenticado. Los clientes de la API envían el
+// token, o una clave de API en las rutas que las admiten (APIKeyScopes), en la cabecera
+// Authorization; el frontend lo envía en la cookie de sesión. El token
+// nunca se acepta en la URL: para los enlaces que deben llevar la autenticación están las
+// URLs firmadas (SignedURLMiddleware).
 func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var token string
-
-		// Intentar obtener token del header Authorization primero
-		authHeader := r.Header.Get("Authorization")
-		if authHeader != "" {
-			// Formato esperado: "Bearer <token>"
-			parts := strings.Split(authHeader, " ")
-			if len(parts) == 2 && parts[0] == "Bearer" {
-				token = parts[1]
-			}
+		claims, message, code := authenticate(r)
+		if claims == nil {
+			http.Error(w, services.T(requestLocale(r), message), code)
+			return
 		}
 
-		// Si no hay token en header, intentar obtenerlo del query parameter
-		if token == "" {
-			token = r.URL.Query().Get("token")
+		// Las peticiones de las sesiones de soporte quedan en el log
+		if claims.ImpersonatorID != 0 {
+			log.Printf("🕵️  Soporte: administrador %d como usuario %d: %s %s", claims.ImpersonatorID, claims.UserID, r.Method, r.URL.Path)
 		}
 
-		// Si no hay token en ningún lado, rechazar
-		if token == "" {
-			http.Error(w, "No autorizado - Token requerido", http.StatusUnauthorized)
+		// Continuar con el handler
+		next.ServeHTTP(w, r.WithContext(withClaims(r.Context(), claims)))
+	}
+}
+
+// OptionalAuthMiddleware intenta autenticar pero no falla si no hay token
+func OptionalAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if claims, _, _ := authenticate(r); claims != nil {
+			r = r.WithContext(withClaims(r.Context(), claims))
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// SignedURLMiddleware acepta, además de la autenticación normal, las URLs firmadas para purpose.
+// Las URLs firmadas solo sirven para leer (GET) la ruta exacta para la que se firmaron.
+func SignedURLMiddleware(purpose string, next http.HandlerFunc) http.HandlerFunc {
+	authenticated := AuthMiddleware(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !r.URL.Query().Has("sig") {
+			authenticated(w, r)
 			return
 		}
 
-		// Validar token
-		authService := services.GetAuthService()
-		claims, err := authService.ValidateToken(token)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, services.T(requestLocale(r), "Método no permitido"), http.StatusMethodNotAllowed)
+			return
+		}
+		userID, sessionID, err := services.GetAuthService().VerifySignedURL(purpose, r.URL)
 		if err != nil {
-			http.Error(w, "Token inválido o expirado", http.StatusUnauthorized)
+			http.Error(w, services.T(requestLocale(r), "Enlace inválido o caducado"), http.StatusUnauthorized)
 			return
 		}
 
-		// Añadir userID al contexto
-		ctx := context.WithValue(r.Context(), "userID", claims.UserID)
-		ctx = context.WithValue(ctx, "userEmail", claims.Email)
-		ctx = context.WithValue(ctx, "userName", claims.Name)
-
-		// Continuar con el handler
+		ctx := context.WithValue(r.Context(), "userID", userID)
+		ctx = context.WithValue(ctx, "sessionID", sessionID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
 
-// OptionalAuthMiddleware intenta autenticar pero no falla si no hay token
-func OptionalAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// apiKeyScopes son los scopes que APIKeyScopes exige a las claves de API en una ruta
+type apiKeyScopes struct {
+	read, write string
+}
+
+// APIKeyScopes permite usar claves de API personales en la ruta: las peticiones que solo leen
+// necesitan el scope read y las demás el scope write ("" admite cualquier clave). Envuelve a
+// AuthMiddleware; en las rutas sin él las claves de API se rechazan.
+func APIKeyScopes(read, write string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-
-		if authHeader != "" {
-			parts := strings.Split(authHeader, " ")
-			if len(parts) == 2 && parts[0] == "Bearer" {
-				authService := services.GetAuthService()
-				claims, err := authService.ValidateToken(parts[1])
-				if err == nil {
-					ctx := context.WithValue(r.Context(), "userID", claims.UserID)
-					ctx = context.WithValue(ctx, "userEmail", claims.Email)
-					ctx = context.WithValue(ctx, "userName", claims.Name)
-					r = r.WithContext(ctx)
-				}
-			}
+		ctx := context.WithValue(r.Context(), "apiKeyScopes", apiKeyScopes{read: read, write: write})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// authenticate obtiene y valida el token de la cabecera Authorization o de la cookie de sesión.
+// Con cookie, las peticiones que modifican datos deben llevar en CSRFHeader el token anti-CSRF
+// de la sesión. Si no es válido devuelve el mensaje y el código de error.
+func authenticate(r *http.Request) (*services.TokenClaims, string, int) {
+	authService := services.GetAuthService()
+
+	// Formato esperado: "Bearer <token>"
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			return nil, "Token inválido o expirado", http.StatusUnauthorized
+		}
+		if services.IsAPIKey(parts[1]) {
+			return authenticateAPIKey(r, parts[1])
+		}
+		claims, err := authService.ValidateToken(parts[1])
+		if err != nil {
+			return nil, "Token inválido o expirado", http.StatusUnauthorized
 		}
+		return claims, "", 0
+	}
 
-		next.ServeHTTP(w, r)
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, "No autorizado - Token requerido", http.StatusUnauthorized
+	}
+	// Validar token (firma, expiración y que su sesión no se haya cerrado)
+	claims, err := authService.ValidateToken(cookie.Value)
+	if err != nil {
+		return nil, "Token inválido o expirado", http.StatusUnauthorized
 	}
+	if !safeMethod(r.Method) && !authService.VerifyCSRFToken(claims.SessionID, r.Header.Get(CSRFHeader)) {
+		return nil, "Token CSRF inválido", http.StatusForbidden
+	}
+	return claims, "", 0
+}
+
+// authenticateAPIKey valida una clave de API y comprueba que la ruta admita claves y que la
+// clave tenga el scope que exige para el método de la petición
+func authenticateAPIKey(r *http.Request, key string) (*services.TokenClaims, string, int) {
+	apiKey, err := services.GetAuthService().ValidateAPIKey(key, ClientIP(r))
+	if err != nil {
+		return nil, "Clave de API inválida o caducada", http.StatusUnauthorized
+	}
+
+	scopes, ok := r.Context().Value("apiKeyScopes").(apiKeyScopes)
+	if !ok {
+		return nil, "Esta ruta no admite claves de API", http.StatusForbidden
+	}
+	required := scopes.write
+	if safeMethod(r.Method) {
+		required = scopes.read
+	}
+	if required != "" && !apiKey.HasScope(required) {
+		return nil, "La clave de API no tiene permiso para esta operación", http.StatusForbidden
+	}
+
+	// Las claves no tienen sesión: los datos del usuario van sin sessionID
+	return &services.TokenClaims{UserID: apiKey.UserID, Email: apiKey.Email, Name: apiKey.Name}, "", 0
+}
+
+// withClaims añade los datos del token al contexto
+func withClaims(ctx context.Context, claims *services.TokenClaims) context.Context {
+	ctx = context.WithValue(ctx, "userID", claims.UserID)
+	ctx = context.WithValue(ctx, "userEmail", claims.Email)
+	ctx = context.WithValue(ctx, "userName", claims.Name)
+	ctx = context.WithValue(ctx, "impersonatorID", claims.ImpersonatorID)
+	return context.WithValue(ctx, "sessionID", claims.SessionID)
+}
+
+// safeMethod indica si el método no modifica datos
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// requestLocale devuelve el idioma de la cabecera Accept-Language; antes de autenticar no se
+// conoce el idioma guardado por el usuario
+func requestLocale(r *http.Request) string {
+	return services.LocaleFromAcceptLanguage(r.Header.Get("Accept-Language"))
 }
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"trainapp/services"
+)
+
+// Un limitador por grupo de rutas, compartido por todas las rutas del grupo
+var (
+	limitersMu sync.Mutex
+	limiters   = map[string]*services.RateLimiter{}
+)
+
+// rateLimiter devuelve el limitador del grupo con su límite de RATE_LIMITS (sin límite si el
+// grupo no está configurado; main comprueba al arrancar que RATE_LIMITS es válido)
+func rateLimiter(group string) *services.RateLimiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	if limiter, ok := limiters[group]; ok {
+		return limiter
+	}
+	limits, _ := services.RateLimits()
+	limiter := services.NewRateLimiter(limits[group])
+	limiters[group] = limiter
+	return limiter
+}
+
+// RateLimitMiddleware limita las peticiones del grupo de rutas group: por usuario si la petición
+// ya está autenticada (debe ir después de AuthMiddleware) y si no por IP. Al superar el límite
+// responde 429 con Retry-After.
+func RateLimitMiddleware(group string, next http.HandlerFunc) http.HandlerFunc {
+	limiter := rateLimiter(group)
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := "ip:" + ClientIP(r)
+		if userID, ok := r.Context().Value("userID").(int); ok {
+			key = "user:" + strconv.Itoa(userID)
+		}
+
+		if ok, wait := limiter.Allow(key); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, services.T(requestLocale(r), "Demasiadas peticiones. Inténtalo de nuevo más tarde"), http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// ClientIP devuelve la IP del cliente. Detrás de un proxy (TRUST_PROXY=true) es la última de
+// X-Forwarded-For, la que añade el propio proxy; sin proxy no se hace caso de esa cabecera,
+// que el cliente puede inventarse.
+func ClientIP(r *http.Request) string {
+	if strings.EqualFold(os.Getenv("TRUST_PROXY"), "true") {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -1,18 +1,66 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
-// User representa al usuario de la aplicación
+// User representa al usuario de la aplicación (los datos de corredor están en RunnerProfile)
 type User struct {
-	ID           int       `json:"id"`
-	Name         string    `json:"name"`
-	Email        string    `json:"email"`
-	Age          int       `json:"age"`
-	Weight       float64   `json:"weight"`        // en kg
-	Height       float64   `json:"height"`        // en cm
-	FitnessLevel string    `json:"fitness_level"` // beginner, intermediate, advanced
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID            int       `json:"id"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Role          string    `json:"role"`   // user o admin
+	Locale        string    `json:"locale"` // idioma de los mensajes y del coach (es, en); vacío usa Accept-Language
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// RunnerProfile representa el perfil de corredor de un usuario (tabla runner_profiles)
+type RunnerProfile struct {
+	UserID         int       `json:"user_id"`
+	Name           string    `json:"name"`
+	Email          string    `json:"email"`
+	Age            int       `json:"age"`
+	Sex            string    `json:"sex"`    // male, female, other
+	Weight         float64   `json:"weight"` // en kg
+	Height         float64   `json:"height"` // en cm
+	RestingHR      int       `json:"resting_hr"`
+	MaxHR          int       `json:"max_hr"`
+	LTHR           int       `json:"lthr"` // FC en umbral de lactato
+	VO2max         float64   `json:"vo2max"`
+	ThresholdPace  string    `json:"threshold_pace"`  // min/km
+	ThresholdPower int       `json:"threshold_power"` // en watts
+	WeeklyKmTarget float64   `json:"weekly_km_target"`
+	TrainingLevel  string    `json:"training_level"` // beginner, intermediate, advanced, elite
+	HRZones        []HRZone  `json:"hr_zones"`
+	RaceGoal       string    `json:"race_goal"`
+	RaceGoalDate   string    `json:"race_goal_date"` // YYYY-MM-DD
+	Goals          string    `json:"goals"`          // objetivos en texto libre
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// HRZone representa una zona de frecuencia cardíaca
+type HRZone struct {
+	Zone int    `json:"zone"`
+	Name string `json:"name"`
+	Min  int    `json:"min"` // bpm
+	Max  int    `json:"max"` // bpm, 0 = sin límite superior
+}
+
+// BiometricSnapshot representa el estado de los datos biométricos en un momento dado
+type BiometricSnapshot struct {
+	ID             int       `json:"id"`
+	UserID         int       `json:"user_id"`
+	Weight         float64   `json:"weight"`
+	RestingHR      int       `json:"resting_hr"`
+	MaxHR          int       `json:"max_hr"`
+	LTHR           int       `json:"lthr"`
+	VO2max         float64   `json:"vo2max"`
+	ThresholdPace  string    `json:"threshold_pace"`
+	ThresholdPower int       `json:"threshold_power"`
+	RecordedAt     time.Time `json:"recorded_at"`
 }
 
 // Workout representa un entreno individual
@@ -31,36 +79,160 @@ type Workout struct {
 	Calories      int       `json:"calories"`
 	Notes         string    `json:"notes"`
 	Feeling       string    `json:"feeling"` // great, good, ok, tired, exhausted
+	GearID        *int      `json:"gear_id"` // zapatillas usadas (opcional)
 	CreatedAt     time.Time `json:"created_at"`
 }
 
 // TrainingPlan representa un plan de entrenamiento
 type TrainingPlan struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"user_id"`
-	Goal      string    `json:"goal"` // 5k, 10k, half_marathon, marathon, fitness
-	StartDate time.Time `json:"start_date"`
-	EndDate   time.Time `json:"end_date"`
-	Plan      string    `json:"plan"`   // JSON con plan detallado del agente
-	Status    string    `json:"status"` // active, completed, cancelled
-	CreatedAt time.Time `json:"created_at"`
+	ID            int             `json:"id"`
+	UserID        int             `json:"user_id"`
+	Goal          string          `json:"goal"` // 5k, 10k, half_marathon, marathon, fitness
+	StartDate     time.Time       `json:"start_date"`
+	EndDate       time.Time       `json:"end_date"`
+	Plan          string          `json:"plan"`   // JSON con plan detallado del agente
+	Status        string          `json:"status"` // active, completed, cancelled
+	RaceID        *int            `json:"race_id"`
+	Blocks        []TrainingBlock `json:"blocks"` // periodización hacia la carrera objetivo
+	Model         string          `json:"model"`
+	PromptVersion string          `json:"prompt_version"` // plantilla con la que se generó (p. ej. "training-plan/1")
+	CreatedAt     time.Time       `json:"created_at"`
 }
 
-// WorkoutAnalysis representa el análisis de un entreno por el agente
+// PlanChangeProposal representa un cambio del plan propuesto por el coach, pendiente de que el usuario lo acepte
+type PlanChangeProposal struct {
+	ID            int        `json:"id"`
+	UserID        int        `json:"user_id"`
+	PlanID        *int       `json:"plan_id"`
+	Summary       string     `json:"summary"`
+	Changes       string     `json:"changes"`
+	EffectiveDate string     `json:"effective_date"` // YYYY-MM-DD, opcional
+	Status        string     `json:"status"`         // pending, accepted, rejected
+	CreatedAt     time.Time  `json:"created_at"`
+	ResolvedAt    *time.Time `json:"resolved_at"`
+}
+
+// WorkoutImage es una captura adjunta a un workout, guardada sin metadatos
+type WorkoutImage struct {
+	ID           int       `json:"id"`
+	UserID       int       `json:"user_id"`
+	WorkoutID    *int      `json:"workout_id"`
+	ExtractionID *int      `json:"extraction_id"`
+	ContentType  string    `json:"content_type"`
+	Size         int       `json:"size"`
+	URL          string    `json:"url"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// WorkoutAnalysis representa el análisis de un entreno por el agente. Cada nuevo análisis de un
+// workout es una versión; las preguntas de seguimiento se guardan como follow_up del análisis.
 type WorkoutAnalysis struct {
-	ID              int       `json:"id"`
-	WorkoutID       int       `json:"workout_id"`
-	Analysis        string    `json:"analysis"`        // Análisis del agente
-	Recommendations string    `json:"recommendations"` // Recomendaciones
-	CreatedAt       time.Time `json:"created_at"`
+	ID              int               `json:"id"`
+	UserID          int               `json:"user_id"`
+	WorkoutID       *int              `json:"workout_id"`    // nil si el entreno no está guardado
+	ExtractionID    *int              `json:"extraction_id"` // análisis de capturas
+	ParentID        *int              `json:"parent_id"`     // análisis al que responde un seguimiento
+	Kind            string            `json:"kind"`          // analysis, follow_up
+	Source          string            `json:"source"`        // workout, form, image
+	Version         int               `json:"version"`       // versión dentro del workout
+	Question        string            `json:"question,omitempty"`
+	Analysis        string            `json:"analysis"`        // Análisis del agente
+	Recommendations *Recommendations  `json:"recommendations"` // Recomendaciones estructuradas (nil si no se pudieron extraer)
+	Model           string            `json:"model"`
+	PromptVersion   string            `json:"prompt_version"`
+	InputSnapshot   json.RawMessage   `json:"input_snapshot,omitempty"` // datos enviados al modelo
+	FollowUps       []WorkoutAnalysis `json:"follow_ups,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+}
+
+// Recommendations son las recomendaciones estructuradas de un análisis
+type Recommendations struct {
+	Actions  []RecommendedAction `json:"actions"`  // qué hacer en las próximas 24-48 h
+	Recovery []string            `json:"recovery"` // consejos de recuperación
+	Flags    []RiskFlag          `json:"flags"`    // alertas de sobrecarga, lesión...
+}
+
+// RecommendedAction es una acción concreta para las próximas horas
+type RecommendedAction struct {
+	WithinHours int    `json:"within_hours"` // 24 o 48
+	Action      string `json:"action"`
+}
+
+// RiskFlag es una alerta detectada en el análisis
+type RiskFlag struct {
+	Type     string `json:"type"`     // overload, injury_risk, fatigue, illness
+	Severity string `json:"severity"` // low, medium, high
+	Reason   string `json:"reason"`
 }
 
 // ProgressReport representa un informe de progreso
 type ProgressReport struct {
-	ID          int       `json:"id"`
-	UserID      int       `json:"user_id"`
-	PeriodStart time.Time `json:"period_start"`
-	PeriodEnd   time.Time `json:"period_end"`
-	Report      string    `json:"report"` // Informe generado por el agente
-	CreatedAt   time.Time `json:"created_at"`
+	ID            int       `json:"id"`
+	UserID        int       `json:"user_id"`
+	PeriodStart   time.Time `json:"period_start"`
+	PeriodEnd     time.Time `json:"period_end"`
+	Report        string    `json:"report"` // Informe generado por el agente
+	Model         string    `json:"model"`
+	PromptVersion string    `json:"prompt_version"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// WorkoutInterval representa una repetición detectada dentro de un entreno (serie o recuperación)
+type WorkoutInterval struct {
+	ID           int       `json:"id"`
+	WorkoutID    int       `json:"workout_id"`
+	RepIndex     int       `json:"rep_index"`      // orden dentro del entreno, empezando en 1
+	Kind         string    `json:"kind"`           // warmup, work, recovery, cooldown
+	Source       string    `json:"source"`         // laps, streams
+	StartOffset  int       `json:"start_offset"`   // segundos desde el inicio de la actividad
+	Distance     float64   `json:"distance"`       // en metros
+	Duration     int       `json:"duration"`       // en segundos
+	AvgPace      string    `json:"avg_pace"`       // min/km
+	AvgHeartRate int       `json:"avg_heart_rate"` // bpm
+	MaxHeartRate int       `json:"max_heart_rate"` // bpm
+	AvgPower     int       `json:"avg_power"`      // en watts
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Gear representa un par de zapatillas con su kilometraje acumulado
+type Gear struct {
+	ID           int       `json:"id"`
+	UserID       int       `json:"user_id"`
+	Brand        string    `json:"brand"`
+	Model        string    `json:"model"`
+	Nickname     string    `json:"nickname"`
+	StartDate    time.Time `json:"start_date"`     // fecha de estreno
+	RetirementKm float64   `json:"retirement_km"`  // umbral de retirada en km
+	InitialKm    float64   `json:"initial_km"`     // km acumulados antes de registrarlas
+	StravaGearID string    `json:"strava_gear_id"` // id de Strava (p.ej. g27526119)
+	Retired      bool      `json:"retired"`
+	TotalKm      float64   `json:"total_km"` // initial_km + km de los workouts vinculados
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Race representa una carrera del calendario del usuario (próxima o ya disputada)
+type Race struct {
+	ID            int       `json:"id"`
+	UserID        int       `json:"user_id"`
+	Name          string    `json:"name"`
+	Date          time.Time `json:"date"`
+	DistanceKm    float64   `json:"distance_km"`
+	Priority      string    `json:"priority"`       // A (objetivo principal), B, C
+	TargetTime    string    `json:"target_time"`    // H:MM:SS
+	CourseProfile string    `json:"course_profile"` // flat, rolling, hilly, trail
+	Location      string    `json:"location"`
+	Status        string    `json:"status"`      // upcoming, completed, dns, dnf
+	ResultTime    string    `json:"result_time"` // H:MM:SS
+	WorkoutID     *int      `json:"workout_id"`  // workout con el registro de la carrera
+	Notes         string    `json:"notes"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// TrainingBlock representa un bloque de periodización dentro de un plan
+type TrainingBlock struct {
+	Phase     string    `json:"phase"` // base, build, peak, taper
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+	Weeks     int       `json:"weeks"`
+	Focus     string    `json:"focus"`
 }
@@ -0,0 +1,54 @@
+// mockidp arranca un proveedor OpenID Connect de pruebas que aprueba cualquier inicio de sesión
+// con el usuario indicado, para probar en local el inicio de sesión con OIDC.
+//
+// Uso:
+//
+//	go run ./scripts/mockidp -addr :9999 -email ana@example.com
+//
+// y en el backend:
+//
+//	OIDC_ISSUER=http://localhost:9999 OIDC_CLIENT_ID=trainapp OIDC_CLIENT_SECRET=secreto
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	"trainapp/services"
+)
+
+func main() {
+	addr := flag.String("addr", ":9999", "dirección en la que escuchar")
+	issuer := flag.String("issuer", "", "URL pública del proveedor (por defecto http://localhost<addr>)")
+	clientID := flag.String("client-id", "trainapp", "client_id que acepta")
+	clientSecret := flag.String("client-secret", "secreto", "client_secret que acepta")
+	subject := flag.String("sub", "mock-user-1", "identificador (sub) del usuario")
+	email := flag.String("email", "mock@example.com", "email del usuario (vacío: sin email)")
+	verified := flag.Bool("email-verified", true, "si el email está verificado")
+	name := flag.String("name", "Usuario de prueba", "nombre del usuario")
+	flag.Parse()
+
+	if *issuer == "" {
+		host := *addr
+		if strings.HasPrefix(host, ":") {
+			host = "localhost" + host
+		}
+		*issuer = "http://" + host
+	}
+
+	idp := &services.MockIdP{
+		Issuer:       strings.TrimRight(*issuer, "/"),
+		ClientID:     *clientID,
+		ClientSecret: *clientSecret,
+		User: services.MockIdPUser{
+			Subject:       *subject,
+			Email:         *email,
+			EmailVerified: *verified,
+			Name:          *name,
+		},
+	}
+	log.Printf("🔐 Proveedor OIDC de pruebas en %s (usuario %s <%s>)", idp.Issuer, *subject, *email)
+	log.Fatal(http.ListenAndServe(*addr, idp))
+}
@@ -0,0 +1,187 @@
+// prompteval compara dos versiones de una plantilla de prompt: genera el prompt de cada
+// entreno de ejemplo con ambas versiones, obtiene la respuesta del proveedor y muestra las
+// diferencias entre las respuestas.
+//
+// Por defecto compara la versión 1 con la activa (la más alta, contando las de -dir). En
+// scripts/prompteval/ejemplo hay una versión candidata de workout-analysis para probarlo.
+//
+// Uso:
+//
+//	go run ./scripts/prompteval -dir scripts/prompteval/ejemplo
+//	go run ./scripts/prompteval -a 1 -b 2 -dir scripts/prompteval/ejemplo -provider openai -base-url http://localhost:11434/v1 -model llama3.1
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"trainapp/services"
+)
+
+// fixture es un caso de evaluación: los datos con los que se ejecuta la plantilla
+type fixture struct {
+	Name string                 `json:"name"`
+	Data map[string]interface{} `json:"data"`
+}
+
+func main() {
+	name := flag.String("prompt", services.PromptWorkoutAnalysis, "plantilla a evaluar")
+	versionA := flag.Int("a", 1, "versión base")
+	versionB := flag.Int("b", 0, "versión candidata (por defecto, la activa)")
+	dir := flag.String("dir", os.Getenv("PROMPTS_DIR"), "directorio con plantillas adicionales (<nombre>/v<versión>.tmpl)")
+	fixturesPath := flag.String("fixtures", "scripts/prompteval/fixtures.json", "archivo JSON con los casos de evaluación")
+	provider := flag.String("provider", "fake", "proveedor: fake u openai (API de OpenAI o servidor compatible)")
+	baseURL := flag.String("base-url", os.Getenv("OPENAI_BASE_URL"), "URL base de un servidor compatible con OpenAI (p. ej. un modelo local)")
+	model := flag.String("model", services.CoachModel, "modelo a usar con el proveedor openai")
+	locale := flag.String("locale", services.DefaultLocale, "idioma de las plantillas (es, en)")
+	showPrompts := flag.Bool("show-prompts", false, "mostrar también la diferencia entre los prompts generados")
+	flag.Parse()
+
+	registry, err := services.LoadPromptRegistry(*dir, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	templateA, err := registry.Get(*name, *versionA)
+	if err != nil {
+		log.Fatal(err)
+	}
+	templateB, err := registry.Active(*name)
+	if *versionB != 0 {
+		templateB, err = registry.Get(*name, *versionB)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if templateA.Version == templateB.Version {
+		fmt.Fprintf(os.Stderr, "%s es a la vez la versión base y la candidata: añade la candidata con -dir o elige otras con -a y -b\n\n", templateA.ID())
+		flag.Usage()
+		os.Exit(2)
+	}
+	system, err := registry.Active(services.PromptCoachSystem)
+	if err != nil {
+		log.Fatal(err)
+	}
+	systemPrompt, err := system.Render(*locale, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var p services.Provider
+	switch *provider {
+	case "fake":
+		p = services.FakeProvider{}
+	case "openai":
+		p = services.NewOpenAIProvider(*baseURL, os.Getenv("OPENAI_API_KEY"), *model)
+	default:
+		log.Fatalf("proveedor desconocido %q (usa fake u openai)", *provider)
+	}
+
+	content, err := os.ReadFile(*fixturesPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var fixtures []fixture
+	if err := json.Unmarshal(content, &fixtures); err != nil {
+		log.Fatalf("casos de evaluación inválidos: %v", err)
+	}
+
+	fmt.Printf("Comparando %s con %s (%s, %s, sistema %s)\n", templateA.ID(), templateB.ID(), *provider, *locale, system.ID())
+
+	changed := 0
+	for _, f := range fixtures {
+		promptA, err := templateA.Render(*locale, f.Data)
+		if err != nil {
+			log.Fatalf("%s: %v", f.Name, err)
+		}
+		promptB, err := templateB.Render(*locale, f.Data)
+		if err != nil {
+			log.Fatalf("%s: %v", f.Name, err)
+		}
+
+		outputA, err := complete(p, systemPrompt, promptA)
+		if err != nil {
+			log.Fatalf("%s (%s): %v", f.Name, templateA.ID(), err)
+		}
+		outputB, err := complete(p, systemPrompt, promptB)
+		if err != nil {
+			log.Fatalf("%s (%s): %v", f.Name, templateB.ID(), err)
+		}
+
+		fmt.Printf("\n=== %s ===\n", f.Name)
+		if *showPrompts {
+			fmt.Println("--- prompt")
+			printDiff(promptA, promptB)
+			fmt.Println("--- respuesta")
+		}
+		if outputA == outputB {
+			fmt.Println("(sin cambios)")
+			continue
+		}
+		changed++
+		printDiff(outputA, outputB)
+	}
+
+	fmt.Printf("\n%d de %d casos con respuestas distintas\n", changed, len(fixtures))
+}
+
+func complete(p services.Provider, system, prompt string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	return p.Complete(ctx, system, prompt)
+}
+
+// printDiff muestra las líneas de b respecto a a: "-" eliminadas, "+" añadidas y " " comunes
+func printDiff(a, b string) {
+	for _, line := range diffLines(strings.Split(a, "\n"), strings.Split(b, "\n")) {
+		fmt.Println(line)
+	}
+}
+
+// diffLines calcula la diferencia línea a línea con la subsecuencia común más larga
+func diffLines(a, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, "  "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "- "+a[i])
+			i++
+		default:
+			out = append(out, "+ "+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "- "+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+ "+b[j])
+	}
+	return out
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Propósitos de los tokens de un solo uso enviados por email
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenChangeEmail   = "change_email"
+	TokenResetPassword = "reset_password"
+	TokenReauth        = "reauth"
+)
+
+// Validez de los enlaces enviados por email
+const (
+	VerifyEmailTTL   = 48 * time.Hour
+	ChangeEmailTTL   = 24 * time.Hour
+	ResetPasswordTTL = time.Hour
+	ReauthTTL        = 15 * time.Minute
+)
+
+// NormalizeEmail valida un email y lo devuelve sin espacios y en minúsculas. Solo acepta la
+// dirección sola, sin nombre ("Ana <ana@x.com>").
+func NormalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return "", errors.New("email inválido")
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", errors.New("email inválido")
+	}
+	return email, nil
+}
+
+// NewOneTimeToken genera el token de un enlace de un solo uso enviado por email y el hash con el
+// que se guarda
+func NewOneTimeToken() (token, hash string, err error) {
+	return NewRefreshToken()
+}
+
+// HashOneTimeToken devuelve el hash con el que se guarda un token de un solo uso
+func HashOneTimeToken(token string) string {
+	return HashRefreshToken(token)
+}
+
+// VerificationEmail es el email con el enlace para confirmar la dirección de una cuenta nueva
+func VerificationEmail(locale, to, name, link string) Email {
+	return Email{
+		To:      to,
+		Subject: T(locale, "Confirma tu email en TrainApp"),
+		Body: T(locale, "Hola %s,\n\nConfirma tu email abriendo este enlace (caduca en %d horas):\n%s\n\nSi no has creado una cuenta en TrainApp, ignora este mensaje.",
+			name, int(VerifyEmailTTL.Hours()), link),
+	}
+}
+
+// ChangeEmailEmail es el email, enviado a la dirección nueva, para confirmar el cambio de email
+func ChangeEmailEmail(locale, to, name, link string) Email {
+	return Email{
+		To:      to,
+		Subject: T(locale, "Confirma tu nuevo email en TrainApp"),
+		Body: T(locale, "Hola %s,\n\nPara usar esta dirección en tu cuenta de TrainApp abre este enlace (caduca en %d horas):\n%s\n\nSi no lo has pedido, ignora este mensaje.",
+			name, int(ChangeEmailTTL.Hours()), link),
+	}
+}
+
+// ReauthEmail es el email con el código que confirma una operación sensible en una cuenta sin
+// contraseña (creada con un proveedor externo)
+func ReauthEmail(locale, to, name, token string) Email {
+	return Email{
+		To:      to,
+		Subject: T(locale, "Tu código de confirmación de TrainApp"),
+		Body: T(locale, "Hola %s,\n\nPara confirmar la operación que has pedido en tu cuenta de TrainApp usa este código (caduca en %d minutos y solo vale una vez):\n%s\n\nSi no lo has pedido, ignora este mensaje.",
+			name, int(ReauthTTL.Minutes()), token),
+	}
+}
+
+// EmailChangedNotice avisa en la dirección anterior de que el email de la cuenta ha cambiado
+func EmailChangedNotice(locale, to, name, newEmail string) Email {
+	return Email{
+		To:      to,
+		Subject: T(locale, "El email de tu cuenta de TrainApp ha cambiado"),
+		Body: T(locale, "Hola %s,\n\nEl email de tu cuenta de TrainApp es ahora %s.\n\nSi no has sido tú, restablece tu contraseña y escríbenos.",
+			name, newEmail),
+	}
+}
+
+// PasswordResetEmail es el email con el enlace para elegir una contraseña nueva
+func PasswordResetEmail(locale, to, name, link string) Email {
+	return Email{
+		To:      to,
+		Subject: T(locale, "Restablece tu contraseña de TrainApp"),
+		Body: T(locale, "Hola %s,\n\nPara elegir una contraseña nueva abre este enlace (caduca en %d minutos):\n%s\n\nSi no lo has pedido, ignora este mensaje: tu contraseña no cambia.",
+			name, int(ResetPasswordTTL.Minutes()), link),
+	}
+}
+
+// PasswordChangedNotice avisa de que la contraseña de la cuenta ha cambiado
+func PasswordChangedNotice(locale, to, name string) Email {
+	return Email{
+		To:      to,
+		Subject: T(locale, "Tu contraseña de TrainApp ha cambiado"),
+		Body: T(locale, "Hola %s,\n\nLa contraseña de tu cuenta de TrainApp acaba de cambiar y se han cerrado las demás sesiones.\n\nSi no has sido tú, restablece tu contraseña cuanto antes.",
+			name),
+	}
+}
+
+// TwoFactorEnabledNotice avisa de que se ha activado la verificación en dos pasos
+func TwoFactorEnabledNotice(locale, to, name string) Email {
+	return Email{
+		To:      to,
+		Subject: T(locale, "Verificación en dos pasos activada en TrainApp"),
+		Body: T(locale, "Hola %s,\n\nSe ha activado la verificación en dos pasos en tu cuenta de TrainApp. Guarda los códigos de recuperación en un lugar seguro.\n\nSi no has sido tú, restablece tu contraseña cuanto antes.",
+			name),
+	}
+}
+
+// TwoFactorDisabledNotice avisa de que se ha desactivado la verificación en dos pasos
+func TwoFactorDisabledNotice(locale, to, name string) Email {
+	return Email{
+		To:      to,
+		Subject: T(locale, "Verificación en dos pasos desactivada en TrainApp"),
+		Body: T(locale, "Hola %s,\n\nSe ha desactivado la verificación en dos pasos en tu cuenta de TrainApp.\n\nSi no has sido tú, restablece tu contraseña cuanto antes.",
+			name),
+	}
+}
+
+// AccountDeletedNotice confirma que la cuenta y todos sus datos se han borrado
+func AccountDeletedNotice(locale, to, name string) Email {
+	return Email{
+		To:      to,
+		Subject: T(locale, "Tu cuenta de TrainApp se ha borrado"),
+		Body: T(locale, "Hola %s,\n\nTu cuenta de TrainApp y todos sus datos se han borrado, y se ha revocado el acceso a Strava.\n\nSi no has sido tú, ponte en contacto con nosotros cuanto antes.",
+			name),
+	}
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	for input, want := range map[string]string{
+		" Ana@Example.COM ":     "ana@example.com",
+		"ana.lopez+run@x.io":    "ana.lopez+run@x.io",
+		"@":                     "",
+		"ana@":                  "",
+		"ana@localhost":         "",
+		"ana@x.":                "",
+		"Ana <ana@example.com>": "",
+		"ana@example.com\r\nBcc: otro@example.com": "",
+	} {
+		got, err := NormalizeEmail(input)
+		if want == "" {
+			if err == nil {
+				t.Errorf("%q debería rechazarse (%q)", input, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("%q: %q %v, esperado %q", input, got, err, want)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := &FileMailer{Dir: dir, From: "TrainApp <no-reply@localhost>"}
+
+	msg := PasswordResetEmail("en", "ana@example.com", "Ana", "http://localhost/login.html#reset=abc")
+	if err := m.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("se esperaba un .eml, hay %d", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	content := string(data)
+	for _, want := range []string{"To: ana@example.com\r\n", "Subject: Reset your TrainApp password", "#reset=abc", "60 minutes"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("falta %q en el email:\n%s", want, content)
+		}
+	}
+
+	// Un destinatario con saltos de línea permitiría añadir cabeceras
+	if err := m.Send(Email{To: "ana@example.com\r\nBcc: otro@example.com", Subject: "x", Body: "x"}); err == nil {
+		t.Fatal("el destinatario con cabeceras no debe aceptarse")
+	}
+}
+
+func TestOneTimeToken(t *testing.T) {
+	token, hash, err := NewOneTimeToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := NewOneTimeToken()
+	if token == other || hash != HashOneTimeToken(token) || strings.Contains(hash, token) {
+		t.Fatalf("token inesperado: %q %q", token, hash)
+	}
+}
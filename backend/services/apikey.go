@@ -0,0 +1,108 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// APIKeyPrefix encabeza las claves de API personales; distingue una clave de un JWT en la
+// cabecera Authorization
+const APIKeyPrefix = "tk_"
+
+// Scopes de las claves de API
+const (
+	ScopeWorkoutsRead  = "workouts:read"
+	ScopeWorkoutsWrite = "workouts:write"
+	ScopeCoach         = "coach"
+	ScopeStravaSync    = "strava:sync"
+)
+
+// APIKeyScopes son los scopes que se pueden dar a una clave
+var APIKeyScopes = []string{ScopeWorkoutsRead, ScopeWorkoutsWrite, ScopeCoach, ScopeStravaSync}
+
+// APIKey es una clave de API válida con el usuario al que pertenece
+type APIKey struct {
+	ID        int64
+	UserID    int
+	Email     string
+	Name      string // nombre del usuario
+	Scopes    []string
+	ExpiresAt *time.Time
+}
+
+// HasScope indica si la clave tiene el scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAPIKey genera una clave de API, el hash con el que se guarda y el prefijo que se muestra
+// para reconocerla
+func NewAPIKey() (key, hash, prefix string, err error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", "", err
+	}
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	return key, HashAPIKey(key), key[:len(APIKeyPrefix)+6], nil
+}
+
+// IsAPIKey indica si el token de la cabecera Authorization es una clave de API
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// HashAPIKey devuelve el hash con el que se guarda una clave de API
+func HashAPIKey(key string) string {
+	return HashRefreshToken(key)
+}
+
+// NormalizeScopes valida los scopes de una clave y los devuelve ordenados y sin repetir
+func NormalizeScopes(scopes []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		valid := false
+		for _, known := range APIKeyScopes {
+			valid = valid || scope == known
+		}
+		if !valid {
+			return nil, fmt.Errorf("scope desconocido: %q", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	if len(normalized) == 0 {
+		return nil, errors.New("la clave necesita al menos un scope")
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// ValidateAPIKey busca la clave con LookupAPIKey, que anota su uso desde ip, y comprueba que no
+// haya caducado
+func (s *AuthService) ValidateAPIKey(key, ip string) (*APIKey, error) {
+	if !IsAPIKey(key) || s.LookupAPIKey == nil {
+		return nil, errors.New("clave de API inválida")
+	}
+	apiKey, err := s.LookupAPIKey(HashAPIKey(key), ip)
+	if err != nil || apiKey == nil {
+		return nil, errors.New("clave de API inválida")
+	}
+	if apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt) {
+		return nil, errors.New("clave de API caducada")
+	}
+	return apiKey, nil
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeScopes(t *testing.T) {
+	scopes, err := NormalizeScopes([]string{" Coach", "workouts:read", "coach"})
+	if err != nil || strings.Join(scopes, ",") != "coach,workouts:read" {
+		t.Fatalf("scopes inesperados: %v %v", scopes, err)
+	}
+	for _, invalid := range [][]string{nil, {}, {"admin"}, {"workouts:read", ""}} {
+		if _, err := NormalizeScopes(invalid); err == nil {
+			t.Errorf("%q debería rechazarse", invalid)
+		}
+	}
+}
+
+func TestValidateAPIKey(t *testing.T) {
+	s := newTestAuthService(t, "k1=secreto-de-prueba", "")
+
+	key, hash, prefix, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAPIKey(key) || !strings.HasPrefix(key, prefix) || hash != HashAPIKey(key) || strings.Contains(hash, key) {
+		t.Fatalf("clave inesperada: %q %q %q", key, hash, prefix)
+	}
+
+	// Sin LookupAPIKey no se aceptan claves
+	if _, err := s.ValidateAPIKey(key, "127.0.0.1"); err == nil {
+		t.Fatal("sin LookupAPIKey la clave no debe aceptarse")
+	}
+
+	var expiresAt *time.Time
+	var usedFrom string
+	s.LookupAPIKey = func(h, ip string) (*APIKey, error) {
+		if h != hash {
+			return nil, errors.New("no existe")
+		}
+		usedFrom = ip
+		return &APIKey{ID: 1, UserID: 7, Scopes: []string{ScopeWorkoutsRead}, ExpiresAt: expiresAt}, nil
+	}
+
+	apiKey, err := s.ValidateAPIKey(key, "10.0.0.1")
+	if err != nil || apiKey.UserID != 7 || usedFrom != "10.0.0.1" {
+		t.Fatalf("clave válida rechazada: %+v %v", apiKey, err)
+	}
+	if !apiKey.HasScope(ScopeWorkoutsRead) || apiKey.HasScope(ScopeWorkoutsWrite) {
+		t.Fatalf("scopes inesperados: %v", apiKey.Scopes)
+	}
+
+	if _, err := s.ValidateAPIKey(key+"x", ""); err == nil {
+		t.Fatal("una clave desconocida no debe aceptarse")
+	}
+	past := time.Now().Add(-time.Minute)
+	expiresAt = &past
+	if _, err := s.ValidateAPIKey(key, ""); err == nil {
+		t.Fatal("una clave caducada no debe aceptarse")
+	}
+}
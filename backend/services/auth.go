@@ -2,34 +2,135 @@ package services
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	// DefaultAccessTokenTTL es la validez del token de acceso (ACCESS_TOKEN_TTL)
+	DefaultAccessTokenTTL = 15 * time.Minute
+	// DefaultRefreshTokenTTL es la validez del token de refresco desde su último uso (REFRESH_TOKEN_TTL)
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+	// MFATokenTTL es la validez del token parcial para completar el login con el segundo factor
+	MFATokenTTL = 5 * time.Minute
+)
+
+// Propósito del token parcial que devuelve el login de una cuenta con 2FA
+const purposeMFA = "mfa"
+
+// Valores por defecto de los claims iss y aud (JWT_ISSUER, JWT_AUDIENCE)
+const (
+	DefaultJWTIssuer   = "trainapp"
+	DefaultJWTAudience = "trainapp-api"
+)
+
+// Longitud mínima de las claves de firma en producción
+const minJWTSecretLength = 32
+
 // AuthService maneja la autenticación de usuarios
 type AuthService struct {
-	jwtSecret []byte
+	keys      map[string][]byte // claves de firma por kid
+	activeKID string            // clave con la que se firman los tokens nuevos
+	issuer    string
+	audience  string
+
+	// SessionActive indica si la sesión de un token sigue abierta; la asigna quien guarda las
+	// sesiones. Sin ella no se comprueba la revocación.
+	SessionActive func(sessionID int64, userID int) bool
+
+	// LookupAPIKey busca por su hash una clave de API no revocada y anota su uso desde ip; la
+	// asigna quien guarda las claves. Sin ella no se aceptan claves de API.
+	LookupAPIKey func(hash, ip string) (*APIKey, error)
+
+	// UserRole devuelve el rol actual del usuario; la asigna quien guarda los usuarios. Sin ella
+	// nadie es administrador.
+	UserRole func(userID int) (string, error)
 }
 
 var authService *AuthService
 
-// InitializeAuth inicializa el servicio de autenticación
-func InitializeAuth(jwtSecret string) {
-	if jwtSecret == "" {
+// IsProduction indica si la aplicación se ejecuta en producción (APP_ENV=production)
+func IsProduction() bool {
+	return strings.EqualFold(strings.TrimSpace(os.Getenv("APP_ENV")), "production")
+}
+
+// InitializeAuth inicializa el servicio de autenticación con las claves de firma de JWT_KEYS
+// ("kid=secreto,kid2=secreto2") y/o JWT_SECRET (kid "default"). Los tokens nuevos se firman con
+// JWT_ACTIVE_KID y se aceptan los firmados con cualquiera de las claves, para poder rotarlas.
+// En producción falla si no hay claves o son cortas; en desarrollo usa una clave temporal.
+func InitializeAuth() error {
+	s := &AuthService{
+		keys:      map[string][]byte{},
+		activeKID: strings.TrimSpace(os.Getenv("JWT_ACTIVE_KID")),
+		issuer:    DefaultJWTIssuer,
+		audience:  DefaultJWTAudience,
+	}
+	if value := strings.TrimSpace(os.Getenv("JWT_ISSUER")); value != "" {
+		s.issuer = value
+	}
+	if value := strings.TrimSpace(os.Getenv("JWT_AUDIENCE")); value != "" {
+		s.audience = value
+	}
+
+	for _, entry := range strings.Split(os.Getenv("JWT_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, secret, found := strings.Cut(entry, "=")
+		kid = strings.TrimSpace(kid)
+		if !found || kid == "" || secret == "" {
+			return fmt.Errorf("clave inválida en JWT_KEYS (se espera kid=secreto)")
+		}
+		s.keys[kid] = []byte(secret)
+	}
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		if _, exists := s.keys["default"]; !exists {
+			s.keys["default"] = []byte(secret)
+		}
+	}
+
+	if len(s.keys) == 0 {
+		if IsProduction() {
+			return errors.New("JWT_SECRET o JWT_KEYS son obligatorios en producción")
+		}
 		// Generar secret aleatorio si no se proporciona (solo desarrollo)
 		secret := make([]byte, 32)
 		rand.Read(secret)
-		jwtSecret = base64.StdEncoding.EncodeToString(secret)
+		s.keys["default"] = secret
 		fmt.Println("⚠️  JWT_SECRET no configurado, usando secret temporal (no usar en producción)")
 	}
 
-	authService = &AuthService{
-		jwtSecret: []byte(jwtSecret),
+	if IsProduction() {
+		for kid, secret := range s.keys {
+			if len(secret) < minJWTSecretLength {
+				return fmt.Errorf("la clave JWT %q debe tener al menos %d caracteres en producción", kid, minJWTSecretLength)
+			}
+		}
+	}
+
+	if s.activeKID == "" {
+		if len(s.keys) > 1 {
+			return errors.New("con varias claves JWT hay que indicar JWT_ACTIVE_KID")
+		}
+		for kid := range s.keys {
+			s.activeKID = kid
+		}
 	}
+	if _, ok := s.keys[s.activeKID]; !ok {
+		return fmt.Errorf("JWT_ACTIVE_KID %q no está en JWT_KEYS", s.activeKID)
+	}
+
+	authService = s
+	return nil
 }
 
 // GetAuthService retorna la instancia del servicio
@@ -59,46 +160,164 @@ func (s *AuthService) VerifyPassword(password, hash string) bool {
 
 // TokenClaims representa los datos almacenados en el JWT
 type TokenClaims struct {
-	UserID int    `json:"user_id"`
-	Email  string `json:"email"`
-	Name   string `json:"name"`
-	Exp    int64  `json:"exp"`
-	Iat    int64  `json:"iat"`
+	UserID    int    `json:"user_id"`
+	Email     string `json:"email"`
+	Name      string `json:"name"`
+	SessionID int64  `json:"sid"`
+	Issuer    string `json:"iss"`
+	Audience  string `json:"aud"`
+	ID        string `json:"jti"`           // identificador único del token
+	Purpose   string `json:"pur,omitempty"` // vacío en los tokens de acceso; "mfa" en los parciales
+	Exp       int64  `json:"exp"`
+	Nbf       int64  `json:"nbf"`
+	Iat       int64  `json:"iat"`
+
+	// ImpersonatorID es el administrador que usa la cuenta del usuario para darle soporte
+	ImpersonatorID int `json:"imp,omitempty"`
+
+	// Flow y Subject solo van en los tokens de los inicios de sesión con un proveedor externo:
+	// el flujo (proveedor) y el identificador del usuario en el proveedor
+	Flow    string `json:"flw,omitempty"`
+	Subject string `json:"ext,omitempty"`
 }
 
-// GenerateToken genera un JWT token para el usuario
-func (s *AuthService) GenerateToken(userID int, email, name string) (string, error) {
-	now := time.Now()
-	exp := now.Add(24 * time.Hour * 7) // Token válido por 7 días
+// AccessTokenTTL devuelve la validez configurada de los tokens de acceso ("15m", "1h")
+func AccessTokenTTL() time.Duration {
+	if value, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); err == nil && value > 0 {
+		return value
+	}
+	return DefaultAccessTokenTTL
+}
 
-	claims := TokenClaims{
-		UserID: userID,
-		Email:  email,
-		Name:   name,
-		Exp:    exp.Unix(),
-		Iat:    now.Unix(),
+// RefreshTokenTTL devuelve la validez configurada de los tokens de refresco ("720h")
+func RefreshTokenTTL() time.Duration {
+	if value, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); err == nil && value > 0 {
+		return value
 	}
+	return DefaultRefreshTokenTTL
+}
 
-	// Crear JWT simple (header.payload.signature)
-	token, err := s.createJWT(claims)
-	if err != nil {
+// GenerateToken genera un token de acceso de corta duración ligado a una sesión
+func (s *AuthService) GenerateToken(userID int, email, name string, sessionID int64) (string, error) {
+	return s.generateToken(TokenClaims{
+		UserID:    userID,
+		Email:     email,
+		Name:      name,
+		SessionID: sessionID,
+	}, AccessTokenTTL())
+}
+
+// GenerateImpersonationToken genera el token de acceso de una sesión de soporte en la que el
+// administrador adminID usa la cuenta del usuario
+func (s *AuthService) GenerateImpersonationToken(userID int, email, name string, sessionID int64, adminID int) (string, error) {
+	return s.generateToken(TokenClaims{
+		UserID:         userID,
+		Email:          email,
+		Name:           name,
+		SessionID:      sessionID,
+		ImpersonatorID: adminID,
+	}, AccessTokenTTL())
+}
+
+// GenerateMFAToken genera el token parcial que devuelve el login de una cuenta con 2FA: no da
+// acceso a la API, solo sirve para completar el inicio de sesión con el segundo factor
+func (s *AuthService) GenerateMFAToken(userID int, email string) (string, error) {
+	return s.generateToken(TokenClaims{
+		UserID:  userID,
+		Email:   email,
+		Purpose: purposeMFA,
+	}, MFATokenTTL)
+}
+
+// generateToken completa los claims estándar y firma el token
+func (s *AuthService) generateToken(claims TokenClaims, ttl time.Duration) (string, error) {
+	now := time.Now()
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
 		return "", err
 	}
 
-	return token, nil
+	claims.Issuer = s.issuer
+	claims.Audience = s.audience
+	claims.ID = hex.EncodeToString(jti)
+	claims.Exp = now.Add(ttl).Unix()
+	claims.Nbf = now.Unix()
+	claims.Iat = now.Unix()
+
+	// Crear JWT simple (header.payload.signature)
+	return s.createJWT(claims)
 }
 
 // ValidateToken valida y decodifica un JWT token
 func (s *AuthService) ValidateToken(tokenString string) (*TokenClaims, error) {
+	claims, err := s.validateClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	// Los tokens parciales del 2FA no son tokens de acceso
+	if claims.Purpose != "" {
+		return nil, errors.New("token inválido")
+	}
+
+	// Los tokens sin sesión (anteriores a las sesiones) no se pueden revocar: no se aceptan
+	if claims.SessionID == 0 {
+		return nil, errors.New("token sin sesión")
+	}
+	if s.SessionActive != nil && !s.SessionActive(claims.SessionID, claims.UserID) {
+		return nil, errors.New("sesión cerrada")
+	}
+
+	return claims, nil
+}
+
+// ValidateMFAToken valida un token parcial del 2FA
+func (s *AuthService) ValidateMFAToken(tokenString string) (*TokenClaims, error) {
+	claims, err := s.validateClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purposeMFA {
+		return nil, errors.New("token inválido")
+	}
+	return claims, nil
+}
+
+// validateClaims verifica la firma del token, su emisor y destinatario y su periodo de validez
+// (con un margen por desfase de relojes)
+func (s *AuthService) validateClaims(tokenString string) (*TokenClaims, error) {
 	claims, err := s.parseJWT(tokenString)
 	if err != nil {
 		return nil, err
 	}
 
-	// Verificar expiración
-	if time.Now().Unix() > claims.Exp {
+	if claims.Issuer != s.issuer || claims.Audience != s.audience {
+		return nil, errors.New("emisor o destinatario del token inválido")
+	}
+	now := time.Now().Unix()
+	if now > claims.Exp+jwtClockSkew {
 		return nil, errors.New("token expirado")
 	}
-
+	if now < claims.Nbf-jwtClockSkew {
+		return nil, errors.New("token aún no válido")
+	}
 	return claims, nil
 }
+
+// NewRefreshToken genera un token de refresco aleatorio y el hash con el que se guarda
+func NewRefreshToken() (token, hash string, err error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken devuelve el hash SHA-256 con el que se guarda un token de refresco: el token
+// es aleatorio y largo, así que no necesita un hash lento como las contraseñas
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAuthService inicializa el servicio con las claves de JWT_KEYS
+func newTestAuthService(t *testing.T, keys, activeKID string) *AuthService {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("JWT_KEYS", keys)
+	t.Setenv("JWT_ACTIVE_KID", activeKID)
+	if err := InitializeAuth(); err != nil {
+		t.Fatal(err)
+	}
+	return GetAuthService()
+}
+
+func TestAccessTokenSession(t *testing.T) {
+	t.Setenv("ACCESS_TOKEN_TTL", "1m")
+	s := newTestAuthService(t, "k1=secreto-de-prueba", "")
+
+	token, err := s.GenerateToken(7, "a@b.c", "Ana", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := s.ValidateToken(token)
+	if err != nil || claims.SessionID != 42 || claims.Exp-claims.Iat != int64(time.Minute.Seconds()) {
+		t.Fatalf("claims inesperados: %+v %v", claims, err)
+	}
+
+	open := true
+	s.SessionActive = func(sessionID int64, userID int) bool { return open && sessionID == 42 && userID == 7 }
+	if _, err := s.ValidateToken(token); err != nil {
+		t.Fatalf("la sesión abierta debería aceptarse: %v", err)
+	}
+	open = false
+	if _, err := s.ValidateToken(token); err == nil {
+		t.Fatal("un token de una sesión cerrada no debe aceptarse")
+	}
+
+	withoutSession, _ := s.GenerateToken(7, "a@b.c", "Ana", 0)
+	if _, err := s.ValidateToken(withoutSession); err == nil {
+		t.Fatal("un token sin sesión no debe aceptarse")
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	token, hash, err := NewRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := NewRefreshToken()
+	if len(token) < 40 || token == other || hash != HashRefreshToken(token) || hash == token {
+		t.Fatalf("token de refresco inesperado: %q %q", token, hash)
+	}
+}
+
+func TestTokenKeysAndClaims(t *testing.T) {
+	old := newTestAuthService(t, "k1=secreto-antiguo", "")
+	token, err := old.GenerateToken(7, "a@b.c", "Ana", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tras la rotación se firma con la clave nueva y se siguen aceptando los tokens de la antigua
+	rotated := newTestAuthService(t, "k1=secreto-antiguo,k2=secreto-nuevo", "k2")
+	claims, err := rotated.ValidateToken(token)
+	if err != nil || claims.Issuer != DefaultJWTIssuer || claims.Audience != DefaultJWTAudience || claims.ID == "" {
+		t.Fatalf("el token de la clave antigua debería aceptarse: %+v %v", claims, err)
+	}
+	fresh, _ := rotated.GenerateToken(7, "a@b.c", "Ana", 42)
+	if header, _ := base64.RawURLEncoding.DecodeString(strings.Split(fresh, ".")[0]); !strings.Contains(string(header), `"kid":"k2"`) {
+		t.Fatalf("el token nuevo debería firmarse con k2: %s", header)
+	}
+	if again, _ := rotated.GenerateToken(7, "a@b.c", "Ana", 42); again == fresh {
+		t.Fatal("cada token debería tener su propio jti")
+	}
+
+	// Retirada la clave antigua, sus tokens dejan de valer
+	retired := newTestAuthService(t, "k2=secreto-nuevo", "")
+	if _, err := retired.ValidateToken(token); err == nil {
+		t.Fatal("un token con una clave retirada no debe aceptarse")
+	}
+
+	parts := strings.Split(fresh, ".")
+	for name, header := range map[string]string{
+		"alg none":        `{"alg":"none","typ":"JWT","kid":"k2"}`,
+		"alg HS512":       `{"alg":"HS512","typ":"JWT","kid":"k2"}`,
+		"kid desconocido": `{"alg":"HS256","typ":"JWT","kid":"otra"}`,
+	} {
+		forged := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + parts[1] + "." + parts[2]
+		if _, err := retired.ValidateToken(forged); err == nil {
+			t.Errorf("%s: el token no debe aceptarse", name)
+		}
+	}
+	if _, err := retired.ValidateToken(parts[0] + "." + parts[1] + "."); err == nil {
+		t.Error("un token sin firma no debe aceptarse")
+	}
+
+	t.Setenv("JWT_AUDIENCE", "otra-api")
+	other := newTestAuthService(t, "k2=secreto-nuevo", "")
+	if _, err := other.ValidateToken(fresh); err == nil {
+		t.Fatal("un token para otro destinatario no debe aceptarse")
+	}
+}
+
+func TestInitializeAuthProduction(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("JWT_KEYS", "")
+	t.Setenv("JWT_ACTIVE_KID", "")
+
+	t.Setenv("JWT_SECRET", "")
+	if err := InitializeAuth(); err == nil {
+		t.Fatal("en producción no debe arrancar sin clave")
+	}
+	t.Setenv("JWT_SECRET", "corta")
+	if err := InitializeAuth(); err == nil {
+		t.Fatal("en producción no debe aceptar claves cortas")
+	}
+	t.Setenv("JWT_SECRET", strings.Repeat("x", minJWTSecretLength))
+	if err := InitializeAuth(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("JWT_KEYS", "a="+strings.Repeat("y", minJWTSecretLength))
+	if err := InitializeAuth(); err == nil {
+		t.Fatal("con varias claves debe exigirse JWT_ACTIVE_KID")
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	s := newTestAuthService(t, "k1=secreto-de-prueba", "")
+	open := true
+	s.SessionActive = func(sessionID int64, userID int) bool { return open }
+
+	signed := s.SignURL(PurposeWorkoutImage, "/api/workouts/3/images/9", 7, 42, time.Minute)
+	u, _ := url.Parse(signed)
+	if userID, sessionID, err := s.VerifySignedURL(PurposeWorkoutImage, u); err != nil || userID != 7 || sessionID != 42 {
+		t.Fatalf("la URL firmada debería aceptarse: %d %d %v", userID, sessionID, err)
+	}
+	if _, _, err := s.VerifySignedURL(PurposeStravaAuth, u); err == nil {
+		t.Error("la URL firmada no debe servir para otro propósito")
+	}
+
+	other := *u
+	other.Path = "/api/workouts/3/images/10"
+	if _, _, err := s.VerifySignedURL(PurposeWorkoutImage, &other); err == nil {
+		t.Error("la URL firmada no debe servir para otra ruta")
+	}
+	query := u.Query()
+	query.Set("uid", "8")
+	other = *u
+	other.RawQuery = query.Encode()
+	if _, _, err := s.VerifySignedURL(PurposeWorkoutImage, &other); err == nil {
+		t.Error("la URL firmada no debe servir para otro usuario")
+	}
+
+	expired, _ := url.Parse(s.SignURL(PurposeWorkoutImage, "/api/workouts/3/images/9", 7, 42, -time.Second))
+	if _, _, err := s.VerifySignedURL(PurposeWorkoutImage, expired); err == nil {
+		t.Error("una URL firmada caducada no debe aceptarse")
+	}
+	open = false
+	if _, _, err := s.VerifySignedURL(PurposeWorkoutImage, u); err == nil {
+		t.Error("una URL firmada de una sesión cerrada no debe aceptarse")
+	}
+}
+
+func TestCSRFToken(t *testing.T) {
+	s := newTestAuthService(t, "k1=secreto-de-prueba", "")
+	token := s.CSRFToken(42)
+	if !s.VerifyCSRFToken(42, token) || s.VerifyCSRFToken(43, token) || s.VerifyCSRFToken(42, "") {
+		t.Fatal("el token anti-CSRF debe valer solo para su sesión")
+	}
+
+	// Sigue valiendo mientras la clave con que se generó no se retire
+	rotated := newTestAuthService(t, "k1=secreto-de-prueba,k2=otro-secreto", "k2")
+	if !rotated.VerifyCSRFToken(42, token) {
+		t.Fatal("el token anti-CSRF debería aceptarse tras la rotación")
+	}
+}
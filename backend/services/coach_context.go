@@ -0,0 +1,356 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"trainapp/models"
+)
+
+// DefaultContextTokens es el presupuesto por defecto de la ficha del corredor (COACH_CONTEXT_TOKENS)
+const DefaultContextTokens = 1500
+
+// RunnerDossier agrupa los datos del corredor que se envían al coach en cada llamada
+type RunnerDossier struct {
+	Profile        *models.RunnerProfile
+	UpcomingRaces  []models.Race
+	ActivePlan     *models.TrainingPlan
+	Load           LoadMetrics
+	Records        []PersonalRecord
+	RecentWorkouts []models.Workout // más recientes primero
+}
+
+// LoadMetrics resume la carga de entrenamiento reciente
+type LoadMetrics struct {
+	AcuteKm         float64   `json:"acute_km"`          // últimos 7 días
+	AcuteMinutes    int       `json:"acute_minutes"`     // últimos 7 días
+	ChronicWeeklyKm float64   `json:"chronic_weekly_km"` // media semanal de los últimos 28 días
+	ChronicMinutes  float64   `json:"chronic_minutes"`   // media semanal de los últimos 28 días
+	ACWR            float64   `json:"acwr"`              // ratio carga aguda:crónica (por minutos)
+	WeeklyKm        []float64 `json:"weekly_km"`         // últimas 4 semanas, de la más antigua a la actual
+	Sessions7d      int       `json:"sessions_7d"`
+	DaysSinceLast   int       `json:"days_since_last"` // -1 si no hay entrenos
+}
+
+// PersonalRecord representa la mejor marca en una distancia estándar
+type PersonalRecord struct {
+	Distance   string    `json:"distance"`
+	DistanceKm float64   `json:"distance_km"`
+	Time       string    `json:"time"`
+	Seconds    int       `json:"seconds"`
+	Date       time.Time `json:"date"`
+	WorkoutID  int       `json:"workout_id,omitempty"`
+	Source     string    `json:"source"` // race, workout
+}
+
+// Distancias estándar para las mejores marcas
+var recordDistances = []struct {
+	name string
+	km   float64
+}{
+	{"5K", 5},
+	{"10K", 10},
+	{"Media maratón", 21.0975},
+	{"Maratón", 42.195},
+}
+
+// ContextTokenBudget devuelve el presupuesto de tokens configurado para la ficha del corredor
+func ContextTokenBudget() int {
+	if value, err := strconv.Atoi(os.Getenv("COACH_CONTEXT_TOKENS")); err == nil && value > 0 {
+		return value
+	}
+	return DefaultContextTokens
+}
+
+// EstimateTokens aproxima el número de tokens de un texto (~4 caracteres por token)
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// ComputeLoadMetrics calcula la carga aguda (7 días) y crónica (28 días) a partir de los entrenos
+func ComputeLoadMetrics(workouts []models.Workout, now time.Time) LoadMetrics {
+	today := truncateDay(now)
+	metrics := LoadMetrics{WeeklyKm: make([]float64, 4), DaysSinceLast: -1}
+
+	var chronicKm float64
+	var chronicMinutes int
+	var last time.Time
+	for _, w := range workouts {
+		if w.Date.After(last) {
+			last = w.Date
+		}
+
+		days := int(today.Sub(truncateDay(w.Date)).Hours() / 24)
+		if days < 0 || days >= 28 {
+			continue
+		}
+
+		chronicKm += w.Distance
+		chronicMinutes += w.Duration
+		metrics.WeeklyKm[3-days/7] += w.Distance
+		if days < 7 {
+			metrics.AcuteKm += w.Distance
+			metrics.AcuteMinutes += w.Duration
+			metrics.Sessions7d++
+		}
+	}
+
+	metrics.ChronicWeeklyKm = round1(chronicKm / 4)
+	metrics.ChronicMinutes = round1(float64(chronicMinutes) / 4)
+	metrics.AcuteKm = round1(metrics.AcuteKm)
+	for i := range metrics.WeeklyKm {
+		metrics.WeeklyKm[i] = round1(metrics.WeeklyKm[i])
+	}
+	if metrics.ChronicMinutes > 0 {
+		metrics.ACWR = math.Round(float64(metrics.AcuteMinutes)/metrics.ChronicMinutes*100) / 100
+	}
+	if !last.IsZero() {
+		metrics.DaysSinceLast = int(today.Sub(truncateDay(last)).Hours() / 24)
+	}
+
+	return metrics
+}
+
+// ComputePersonalRecords obtiene la mejor marca en cada distancia estándar. Los resultados de
+// carrera son exactos; para los entrenos se usa el tiempo total de los que cubren la distancia
+// con un margen del 5%, escalado a la distancia exacta.
+func ComputePersonalRecords(workouts []models.Workout, races []models.Race) []PersonalRecord {
+	records := []PersonalRecord{}
+
+	for _, d := range recordDistances {
+		var best *PersonalRecord
+
+		for _, race := range races {
+			if race.Status != "completed" || race.ResultTime == "" || math.Abs(race.DistanceKm-d.km) > d.km*0.02 {
+				continue
+			}
+			seconds, err := ParseRaceTime(race.ResultTime)
+			if err != nil {
+				continue
+			}
+			if best == nil || seconds < best.Seconds {
+				best = &PersonalRecord{Seconds: seconds, Date: race.Date, Source: "race"}
+				if race.WorkoutID != nil {
+					best.WorkoutID = *race.WorkoutID
+				}
+			}
+		}
+
+		for _, w := range workouts {
+			if w.Duration <= 0 || w.Distance < d.km || w.Distance > d.km*1.05 {
+				continue
+			}
+			seconds := int(float64(w.Duration*60) * d.km / w.Distance)
+			if best == nil || seconds < best.Seconds {
+				best = &PersonalRecord{Seconds: seconds, Date: w.Date, WorkoutID: w.ID, Source: "workout"}
+			}
+		}
+
+		if best != nil {
+			best.Distance = d.name
+			best.DistanceKm = d.km
+			best.Time = FormatRaceTime(best.Seconds)
+			records = append(records, *best)
+		}
+	}
+
+	return records
+}
+
+// Format genera la ficha del corredor en texto compacto sin superar budget tokens.
+// Las secciones se añaden por prioridad (perfil, objetivos, plan, carga, marcas, entrenos)
+// y los entrenos recientes se recortan hasta que la ficha cabe en el presupuesto.
+func (d RunnerDossier) Format(now time.Time, budget int) string {
+	sections := []string{
+		d.profileSection(),
+		d.goalsSection(now),
+		d.planSection(now),
+		d.loadSection(),
+		d.recordsSection(),
+	}
+
+	dossier := "FICHA DEL CORREDOR (datos actuales de la aplicación)"
+	for _, section := range sections {
+		if section == "" {
+			continue
+		}
+		if EstimateTokens(dossier+"\n\n"+section) > budget {
+			break
+		}
+		dossier += "\n\n" + section
+	}
+
+	if len(d.RecentWorkouts) > 0 {
+		header := "\n\nÚltimos entrenos:"
+		if EstimateTokens(dossier+header) < budget {
+			lines := ""
+			for _, w := range d.RecentWorkouts {
+				line := "\n- " + formatWorkoutLine(w)
+				if EstimateTokens(dossier+header+lines+line) > budget {
+					break
+				}
+				lines += line
+			}
+			if lines != "" {
+				dossier += header + lines
+			}
+		}
+	}
+
+	return dossier
+}
+
+func (d RunnerDossier) profileSection() string {
+	p := d.Profile
+	if p == nil {
+		return ""
+	}
+
+	fields := []string{}
+	add := func(label string, value interface{}, ok bool) {
+		if ok {
+			fields = append(fields, fmt.Sprintf("%s: %v", label, value))
+		}
+	}
+
+	add("Nombre", p.Name, p.Name != "")
+	add("Edad", p.Age, p.Age > 0)
+	add("Sexo", p.Sex, p.Sex != "")
+	add("Peso", fmt.Sprintf("%.1f kg", p.Weight), p.Weight > 0)
+	add("Altura", fmt.Sprintf("%.0f cm", p.Height), p.Height > 0)
+	add("Nivel", p.TrainingLevel, p.TrainingLevel != "")
+	add("VO2max", p.VO2max, p.VO2max > 0)
+	add("FC reposo", p.RestingHR, p.RestingHR > 0)
+	add("FC máx", p.MaxHR, p.MaxHR > 0)
+	add("FC umbral", p.LTHR, p.LTHR > 0)
+	add("Ritmo umbral", p.ThresholdPace+"/km", p.ThresholdPace != "")
+	add("Potencia umbral", fmt.Sprintf("%d W", p.ThresholdPower), p.ThresholdPower > 0)
+	add("Objetivo semanal", fmt.Sprintf("%.0f km", p.WeeklyKmTarget), p.WeeklyKmTarget > 0)
+
+	section := "Perfil: " + strings.Join(fields, "; ")
+
+	zones := p.HRZones
+	if len(zones) == 0 {
+		zones = DefaultHRZones(p.LTHR, p.MaxHR)
+	}
+	if len(zones) > 0 {
+		parts := make([]string, len(zones))
+		for i, z := range zones {
+			if z.Max > 0 {
+				parts[i] = fmt.Sprintf("Z%d %d-%d", z.Zone, z.Min, z.Max)
+			} else {
+				parts[i] = fmt.Sprintf("Z%d ≥%d", z.Zone, z.Min)
+			}
+		}
+		section += "\nZonas FC: " + strings.Join(parts, ", ")
+	}
+
+	return section
+}
+
+func (d RunnerDossier) goalsSection(now time.Time) string {
+	lines := []string{}
+	if d.Profile != nil {
+		if d.Profile.RaceGoal != "" {
+			goal := d.Profile.RaceGoal
+			if d.Profile.RaceGoalDate != "" {
+				goal += " (" + d.Profile.RaceGoalDate + ")"
+			}
+			lines = append(lines, "- Objetivo: "+goal)
+		}
+		if d.Profile.Goals != "" {
+			lines = append(lines, "- "+d.Profile.Goals)
+		}
+	}
+
+	for _, r := range d.UpcomingRaces {
+		line := fmt.Sprintf("- Carrera %s: %s, %s, %.2f km, faltan %d días",
+			r.Priority, r.Name, r.Date.Format("2006-01-02"), r.DistanceKm, int(truncateDay(r.Date).Sub(truncateDay(now)).Hours()/24))
+		if r.TargetTime != "" {
+			line += ", objetivo " + r.TargetTime
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+	return "Objetivos:\n" + strings.Join(lines, "\n")
+}
+
+func (d RunnerDossier) planSection(now time.Time) string {
+	plan := d.ActivePlan
+	if plan == nil {
+		return ""
+	}
+
+	section := fmt.Sprintf("Plan activo: %s (%s → %s)",
+		plan.Goal, plan.StartDate.Format("2006-01-02"), plan.EndDate.Format("2006-01-02"))
+	for _, b := range plan.Blocks {
+		if !now.Before(b.StartDate) && now.Before(b.EndDate) {
+			section += fmt.Sprintf("\nBloque actual: %s hasta %s. %s", strings.ToUpper(b.Phase), b.EndDate.Format("2006-01-02"), b.Focus)
+			break
+		}
+	}
+
+	return section
+}
+
+func (d RunnerDossier) loadSection() string {
+	l := d.Load
+	if l.DaysSinceLast < 0 {
+		return "Carga: sin entrenos en los últimos 28 días"
+	}
+
+	weeks := make([]string, len(l.WeeklyKm))
+	for i, km := range l.WeeklyKm {
+		weeks[i] = fmt.Sprintf("%.1f", km)
+	}
+
+	return fmt.Sprintf("Carga: 7 días %.1f km / %d min en %d sesiones; media 4 semanas %.1f km/sem; ACWR %.2f; km por semana (antigua→actual) %s; último entreno hace %d días",
+		l.AcuteKm, l.AcuteMinutes, l.Sessions7d, l.ChronicWeeklyKm, l.ACWR, strings.Join(weeks, ", "), l.DaysSinceLast)
+}
+
+func (d RunnerDossier) recordsSection() string {
+	if len(d.Records) == 0 {
+		return ""
+	}
+
+	records := append([]PersonalRecord(nil), d.Records...)
+	sort.Slice(records, func(i, j int) bool { return records[i].DistanceKm < records[j].DistanceKm })
+
+	parts := make([]string, len(records))
+	for i, r := range records {
+		source := ""
+		if r.Source == "workout" {
+			source = ", entreno"
+		}
+		parts[i] = fmt.Sprintf("%s %s (%s%s)", r.Distance, r.Time, r.Date.Format("2006-01-02"), source)
+	}
+
+	return "Mejores marcas: " + strings.Join(parts, "; ")
+}
+
+func formatWorkoutLine(w models.Workout) string {
+	line := fmt.Sprintf("%s %s %.1f km %d min", w.Date.Format("2006-01-02"), w.Type, w.Distance, w.Duration)
+	if w.AvgPace != "" {
+		line += " " + w.AvgPace + "/km"
+	}
+	if w.AvgHeartRate > 0 {
+		line += fmt.Sprintf(" FC %d", w.AvgHeartRate)
+	}
+	if w.Feeling != "" {
+		line += " (" + w.Feeling + ")"
+	}
+	return line
+}
+
+func round1(value float64) float64 {
+	return math.Round(value*10) / 10
+}
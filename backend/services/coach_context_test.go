@@ -0,0 +1,64 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"trainapp/models"
+)
+
+func TestComputeLoadMetrics(t *testing.T) {
+	now := time.Date(2025, 11, 20, 18, 0, 0, 0, time.UTC)
+	day := func(daysAgo int) time.Time { return now.AddDate(0, 0, -daysAgo) }
+
+	workouts := []models.Workout{
+		{Date: day(1), Distance: 10, Duration: 50},
+		{Date: day(3), Distance: 8, Duration: 40},
+		{Date: day(10), Distance: 12, Duration: 60},
+		{Date: day(25), Distance: 10, Duration: 50},
+		{Date: day(40), Distance: 30, Duration: 150}, // fuera de la ventana de 28 días
+	}
+
+	load := ComputeLoadMetrics(workouts, now)
+	if load.AcuteKm != 18 || load.AcuteMinutes != 90 || load.Sessions7d != 2 {
+		t.Fatalf("carga aguda inesperada: %+v", load)
+	}
+	if load.ChronicWeeklyKm != 10 || load.ChronicMinutes != 50 {
+		t.Fatalf("carga crónica inesperada: %+v", load)
+	}
+	if load.ACWR != 1.8 {
+		t.Fatalf("ACWR esperado 1.8, obtenido %v", load.ACWR)
+	}
+	if load.WeeklyKm[3] != 18 || load.WeeklyKm[2] != 12 || load.WeeklyKm[0] != 10 {
+		t.Fatalf("km semanales inesperados: %v", load.WeeklyKm)
+	}
+	if load.DaysSinceLast != 1 {
+		t.Fatalf("días desde el último entreno esperado 1, obtenido %d", load.DaysSinceLast)
+	}
+}
+
+func TestDossierRespectsTokenBudget(t *testing.T) {
+	now := time.Date(2025, 11, 20, 0, 0, 0, 0, time.UTC)
+
+	dossier := RunnerDossier{
+		Profile: &models.RunnerProfile{Name: "Ana", Age: 34, Weight: 58, LTHR: 172, MaxHR: 190, TrainingLevel: "advanced"},
+	}
+	for i := 0; i < 200; i++ {
+		dossier.RecentWorkouts = append(dossier.RecentWorkouts, models.Workout{
+			Date: now.AddDate(0, 0, -i), Type: "easy", Distance: 10, Duration: 55, AvgPace: "5:30", AvgHeartRate: 140,
+		})
+	}
+	dossier.Load = ComputeLoadMetrics(dossier.RecentWorkouts, now)
+
+	text := dossier.Format(now, 300)
+	if tokens := EstimateTokens(text); tokens > 300 {
+		t.Fatalf("la ficha ocupa %d tokens, presupuesto 300", tokens)
+	}
+	if !strings.Contains(text, "FC umbral: 172") || !strings.Contains(text, "Zonas FC") {
+		t.Fatalf("falta el perfil en la ficha:\n%s", text)
+	}
+	if !strings.Contains(text, "Últimos entrenos") || strings.Count(text, "\n- ") >= 200 {
+		t.Fatalf("los entrenos deberían recortarse al presupuesto:\n%s", text)
+	}
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/shared"
+)
+
+const (
+	// DefaultMaxToolSteps es el número máximo de rondas de herramientas por respuesta (COACH_MAX_TOOL_STEPS)
+	DefaultMaxToolSteps = 5
+	// Tamaño máximo del resultado de una herramienta que se devuelve al modelo
+	maxToolResultChars = 12000
+)
+
+// CoachTool es una función de Go que el coach puede invocar para consultar datos del usuario
+type CoachTool struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{} // JSON Schema de los argumentos
+	Run         func(args json.RawMessage) (interface{}, error)
+}
+
+// ToolCallAudit registra una invocación de herramienta del coach
+type ToolCallAudit struct {
+	UserID      int
+	Step        int
+	Tool        string
+	Arguments   string
+	Success     bool
+	Error       string
+	ResultChars int
+	Duration    time.Duration
+}
+
+// CoachSession reúne lo que el coach necesita para atender al usuario autenticado:
+// su ficha, las herramientas disponibles y cómo auditar su uso
+type CoachSession struct {
+	Context       context.Context // contexto de la petición; si se cancela se abandonan las llamadas al modelo
+	UserID        int
+	Locale        string // idioma en el que se generan los prompts y responde el coach
+	RunnerContext string
+	Tools         []CoachTool
+	Audit         func(ToolCallAudit)
+	Usage         func(LLMUsage)    // registra el consumo de cada llamada al modelo
+	Conversations ConversationStore // historial del usuario; si es nil se guarda en memoria
+}
+
+// deadline devuelve el contexto de la sesión (uno vacío si no tiene) limitado a LLM_REQUEST_TIMEOUT
+func (s CoachSession) deadline() (context.Context, context.CancelFunc) {
+	ctx := s.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithTimeout(ctx, LLMRequestTimeout())
+}
+
+// conversationStore devuelve dónde se guarda el historial de la sesión
+func (s CoachSession) conversationStore() ConversationStore {
+	if s.Conversations != nil {
+		return s.Conversations
+	}
+	return defaultConversationStore
+}
+
+// MaxToolSteps devuelve el límite de rondas de herramientas configurado
+func MaxToolSteps() int {
+	if value, err := strconv.Atoi(os.Getenv("COACH_MAX_TOOL_STEPS")); err == nil && value >= 0 {
+		return value
+	}
+	return DefaultMaxToolSteps
+}
+
+// toolParams convierte las herramientas de la sesión al formato de la API
+func (s CoachSession) toolParams() []openai.ChatCompletionToolParam {
+	params := make([]openai.ChatCompletionToolParam, 0, len(s.Tools))
+	for _, tool := range s.Tools {
+		params = append(params, openai.ChatCompletionToolParam{
+			Type: openai.F(openai.ChatCompletionToolTypeFunction),
+			Function: openai.F(shared.FunctionDefinitionParam{
+				Name:        openai.F(tool.Name),
+				Description: openai.F(tool.Description),
+				Parameters:  openai.F(shared.FunctionParameters(tool.Parameters)),
+			}),
+		})
+	}
+	return params
+}
+
+// runTool ejecuta la herramienta solicitada por el modelo y devuelve el resultado en JSON.
+// Los errores se devuelven al modelo como {"error": ...} para que pueda corregir la llamada.
+func (s CoachSession) runTool(step int, name, arguments string) string {
+	start := time.Now()
+	audit := ToolCallAudit{UserID: s.UserID, Step: step, Tool: name, Arguments: arguments}
+
+	result, err := s.callTool(name, arguments)
+	var output string
+	if err == nil {
+		data, marshalErr := json.Marshal(result)
+		if marshalErr != nil {
+			err = marshalErr
+		} else {
+			output = string(data)
+		}
+	}
+
+	if err != nil {
+		audit.Error = err.Error()
+		data, _ := json.Marshal(map[string]string{"error": err.Error()})
+		output = string(data)
+	} else {
+		audit.Success = true
+		if len(output) > maxToolResultChars {
+			output = strings.ToValidUTF8(output[:maxToolResultChars], "") + `... [resultado truncado, acota la consulta]`
+		}
+	}
+
+	audit.ResultChars = len(output)
+	audit.Duration = time.Since(start)
+	log.Printf("🔧 Coach (usuario %d, paso %d): %s(%s) ok=%v %dms",
+		s.UserID, step, name, arguments, audit.Success, audit.Duration.Milliseconds())
+	if s.Audit != nil {
+		s.Audit(audit)
+	}
+
+	return output
+}
+
+func (s CoachSession) callTool(name, arguments string) (interface{}, error) {
+	for _, tool := range s.Tools {
+		if tool.Name != name {
+			continue
+		}
+		if arguments == "" {
+			arguments = "{}"
+		}
+		if !json.Valid([]byte(arguments)) {
+			return nil, fmt.Errorf("argumentos JSON inválidos")
+		}
+		return tool.Run(json.RawMessage(arguments))
+	}
+	return nil, fmt.Errorf("herramienta desconocida: %s", name)
+}
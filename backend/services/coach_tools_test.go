@@ -0,0 +1,44 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRunToolAuditsAndReportsErrors(t *testing.T) {
+	var audits []ToolCallAudit
+	session := CoachSession{
+		UserID: 7,
+		Tools: []CoachTool{{
+			Name: "echo",
+			Run: func(args json.RawMessage) (interface{}, error) {
+				var req struct {
+					Text string `json:"text"`
+				}
+				json.Unmarshal(args, &req)
+				return map[string]string{"text": req.Text}, nil
+			},
+		}},
+		Audit: func(a ToolCallAudit) { audits = append(audits, a) },
+	}
+
+	if out := session.runTool(1, "echo", `{"text":"hola"}`); out != `{"text":"hola"}` {
+		t.Fatalf("resultado inesperado: %s", out)
+	}
+	if out := session.runTool(2, "missing", `{}`); !strings.Contains(out, "herramienta desconocida") {
+		t.Fatalf("se esperaba error de herramienta desconocida: %s", out)
+	}
+	if out := session.runTool(3, "echo", `{roto`); !strings.Contains(out, "argumentos JSON inválidos") {
+		t.Fatalf("se esperaba error de argumentos: %s", out)
+	}
+
+	long := session.runTool(4, "echo", `{"text":"`+strings.Repeat("á", maxToolResultChars)+`"}`)
+	if len(long) > maxToolResultChars+100 || !strings.Contains(long, "resultado truncado") {
+		t.Fatalf("el resultado debería truncarse (%d caracteres)", len(long))
+	}
+
+	if len(audits) != 4 || !audits[0].Success || audits[1].Success || audits[2].Success || audits[0].UserID != 7 {
+		t.Fatalf("auditoría inesperada: %+v", audits)
+	}
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHistoryTokens es el presupuesto de tokens del historial de conversación (COACH_HISTORY_TOKENS)
+	DefaultHistoryTokens = 6000
+	// Mensajes más recientes que se conservan siempre literales (las dos últimas preguntas y respuestas)
+	minRecentMessages = 4
+	// Tokens estimados por mensaje además de su contenido (rol y separadores)
+	messageOverheadTokens = 4
+)
+
+// Roles de los mensajes guardados en la conversación
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// ConversationTurn es un mensaje de la conversación con el coach. Solo se guarda el texto: las
+// capturas y las rondas de herramientas se envían en la llamada pero no quedan en el historial.
+type ConversationTurn struct {
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	Tokens    int       `json:"tokens"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Conversation es el historial del corredor con el coach: un resumen de los mensajes antiguos
+// y los mensajes recientes literales
+type Conversation struct {
+	UserID          int                `json:"user_id"`
+	Summary         string             `json:"summary"`
+	Turns           []ConversationTurn `json:"turns"`
+	SummarizedTurns int                `json:"summarized_turns"` // mensajes incluidos en el resumen
+	UpdatedAt       time.Time          `json:"updated_at"`
+}
+
+// ConversationStore guarda la conversación de cada usuario
+type ConversationStore interface {
+	LoadConversation(userID int) (*Conversation, error)
+	SaveConversation(c *Conversation) error
+}
+
+// HistoryTokenBudget devuelve el presupuesto de tokens configurado para el historial
+func HistoryTokenBudget() int {
+	if value, err := strconv.Atoi(os.Getenv("COACH_HISTORY_TOKENS")); err == nil && value > 0 {
+		return value
+	}
+	return DefaultHistoryTokens
+}
+
+// newTurn crea un mensaje con sus tokens estimados
+func newTurn(role, content string) ConversationTurn {
+	return ConversationTurn{
+		Role:      role,
+		Content:   content,
+		Tokens:    EstimateTokens(content) + messageOverheadTokens,
+		CreatedAt: time.Now(),
+	}
+}
+
+// Append añade una pregunta y su respuesta a la conversación
+func (c *Conversation) Append(question, answer string) {
+	c.Turns = append(c.Turns, newTurn(RoleUser, question), newTurn(RoleAssistant, answer))
+}
+
+// Tokens devuelve los tokens estimados del historial (resumen y mensajes)
+func (c *Conversation) Tokens() int {
+	total := 0
+	if c.Summary != "" {
+		total = EstimateTokens(c.Summary) + messageOverheadTokens
+	}
+	for _, turn := range c.Turns {
+		total += turn.Tokens
+	}
+	return total
+}
+
+// Compact mantiene el historial dentro de budget: si lo supera, los mensajes antiguos se
+// integran en el resumen con summarize y se conservan literales los más recientes (al menos
+// minRecentMessages y, después, los que quepan en la mitad del presupuesto). Si el resumen
+// falla los mensajes antiguos se descartan igualmente para no superar la ventana de contexto.
+func (c *Conversation) Compact(budget int, summarize func(summary string, turns []ConversationTurn) (string, error)) error {
+	if c.Tokens() <= budget {
+		return nil
+	}
+
+	// Se recorre el historial por parejas pregunta/respuesta desde el final
+	keep := len(c.Turns)
+	kept := 0
+	for keep >= 2 {
+		pair := c.Turns[keep-2].Tokens + c.Turns[keep-1].Tokens
+		if len(c.Turns)-keep >= minRecentMessages && kept+pair > budget/2 {
+			break
+		}
+		kept += pair
+		keep -= 2
+	}
+	if keep <= 0 {
+		return nil
+	}
+
+	old := c.Turns[:keep]
+	summary, err := summarize(c.Summary, old)
+	if err == nil {
+		c.Summary = summary
+	}
+	c.Turns = append([]ConversationTurn{}, c.Turns[keep:]...)
+	c.SummarizedTurns += keep
+	return err
+}
+
+// transcript convierte los mensajes en texto para el prompt de resumen
+func transcript(turns []ConversationTurn, locale string) string {
+	var b strings.Builder
+	for _, turn := range turns {
+		speaker := T(locale, "Corredor")
+		if turn.Role == RoleAssistant {
+			speaker = T(locale, "Entrenador")
+		}
+		fmt.Fprintf(&b, "%s: %s\n\n", speaker, turn.Content)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// memoryConversationStore guarda las conversaciones en memoria; se usa si la sesión no tiene
+// almacén (scripts y pruebas)
+type memoryConversationStore struct {
+	mu            sync.Mutex
+	conversations map[int]Conversation
+}
+
+var defaultConversationStore = &memoryConversationStore{conversations: map[int]Conversation{}}
+
+func (s *memoryConversationStore) LoadConversation(userID int) (*Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.conversations[userID]
+	if !ok {
+		return &Conversation{UserID: userID}, nil
+	}
+	c.Turns = append([]ConversationTurn{}, c.Turns...)
+	return &c, nil
+}
+
+func (s *memoryConversationStore) SaveConversation(c *Conversation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved := *c
+	saved.Turns = append([]ConversationTurn{}, c.Turns...)
+	s.conversations[c.UserID] = saved
+	return nil
+}
+
+// conversationLocks serializa las llamadas al coach de cada usuario para que dos peticiones
+// simultáneas no se pisen el historial
+var conversationLocks sync.Map
+
+func lockConversation(userID int) func() {
+	value, _ := conversationLocks.LoadOrStore(userID, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
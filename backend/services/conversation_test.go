@@ -0,0 +1,130 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+func conversationWithPairs(n int, size int) *Conversation {
+	c := &Conversation{UserID: 1}
+	for i := 0; i < n; i++ {
+		c.Append(strings.Repeat("p", size), strings.Repeat("r", size))
+	}
+	return c
+}
+
+func TestConversationCompact(t *testing.T) {
+	// 10 parejas de ~104 tokens por mensaje: ~2080 tokens
+	c := conversationWithPairs(10, 400)
+	if err := c.Compact(5000, nil); err != nil || len(c.Turns) != 20 {
+		t.Fatalf("dentro del presupuesto no debe compactarse: %d mensajes, %v", len(c.Turns), err)
+	}
+
+	var summarized []ConversationTurn
+	err := c.Compact(1000, func(summary string, turns []ConversationTurn) (string, error) {
+		summarized = turns
+		return "resumen", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Se conservan los mensajes que caben en la mitad del presupuesto (2 parejas = 416 tokens)
+	if len(c.Turns) != 4 || len(summarized) != 16 || c.SummarizedTurns != 16 || c.Summary != "resumen" {
+		t.Fatalf("compactación inesperada: %d literales, %d resumidos, %d acumulados, resumen %q", len(c.Turns), len(summarized), c.SummarizedTurns, c.Summary)
+	}
+	if c.Turns[0].Role != RoleUser || c.Tokens() > 1000 {
+		t.Fatalf("el historial debe empezar por una pregunta y caber en el presupuesto: %s, %d tokens", c.Turns[0].Role, c.Tokens())
+	}
+
+	// Los mensajes recientes se conservan aunque no quepan en el presupuesto
+	c = conversationWithPairs(3, 4000)
+	c.Compact(1000, func(summary string, turns []ConversationTurn) (string, error) { return "resumen", nil })
+	if len(c.Turns) != minRecentMessages {
+		t.Fatalf("se esperaban %d mensajes recientes, hay %d", minRecentMessages, len(c.Turns))
+	}
+
+	// Si el resumen falla se descartan los mensajes antiguos y se conserva el resumen anterior
+	c = conversationWithPairs(10, 400)
+	c.Summary = "anterior"
+	if err := c.Compact(1000, func(string, []ConversationTurn) (string, error) { return "", errors.New("sin red") }); err == nil {
+		t.Fatal("se esperaba el error del resumen")
+	}
+	if c.Summary != "anterior" || len(c.Turns) != 4 {
+		t.Fatalf("resultado inesperado tras el fallo: %q, %d mensajes", c.Summary, len(c.Turns))
+	}
+}
+
+func TestConverseKeepsHistoryPerUser(t *testing.T) {
+	var mu sync.Mutex
+	var requests [][]map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []map[string]interface{} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		requests = append(requests, body.Messages)
+		n := len(requests)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": "c", "object": "chat.completion", "model": CoachModel,
+			"choices": []map[string]interface{}{{"index": 0, "finish_reason": "stop",
+				"message": map[string]interface{}{"role": "assistant", "content": strings.Repeat("respuesta ", 20*n)}}},
+			"usage": map[string]interface{}{"prompt_tokens": 100, "completion_tokens": 10, "total_tokens": 110},
+		})
+	}))
+	defer server.Close()
+
+	previous := client
+	client = openai.NewClient(option.WithBaseURL(server.URL), option.WithAPIKey("test"), option.WithMaxRetries(0))
+	defer func() { client = previous }()
+	t.Setenv("COACH_HISTORY_TOKENS", "300")
+
+	store := &memoryConversationStore{conversations: map[int]Conversation{}}
+	var usage []LLMUsage
+	session := CoachSession{UserID: 1, Locale: DefaultLocale, Conversations: store, Usage: func(u LLMUsage) { usage = append(usage, u) }}
+
+	for i := 0; i < 4; i++ {
+		if _, err := runAssistant(session, OperationFollowUp, "pregunta"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Otro usuario empieza con el historial vacío
+	other := session
+	other.UserID = 2
+	before := len(requests)
+	if _, err := runAssistant(other, OperationFollowUp, "hola"); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(requests[before]); got != 2 {
+		t.Fatalf("el historial de otro usuario no debe enviarse: %d mensajes", got)
+	}
+
+	c, _ := store.LoadConversation(1)
+	if c.Summary == "" || c.SummarizedTurns == 0 || len(c.Turns)+c.SummarizedTurns != 8 {
+		t.Fatalf("la conversación debería haberse resumido: %+v", c)
+	}
+	summaries := 0
+	for _, u := range usage {
+		if u.Operation == PromptConversationSummary {
+			summaries++
+		}
+		if u.PromptTokens != 100 || u.CompletionTokens != 10 {
+			t.Fatalf("consumo inesperado: %+v", u)
+		}
+	}
+	if summaries == 0 {
+		t.Fatal("el resumen debe registrarse como una llamada más")
+	}
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ExportTTL es el tiempo durante el que se puede descargar una exportación de datos; después se
+// borra el fichero
+const ExportTTL = 7 * 24 * time.Hour
+
+// ExportWriter escribe el ZIP de la exportación de datos de un usuario
+type ExportWriter struct {
+	zip *zip.Writer
+}
+
+// NewExportWriter crea un ExportWriter sobre w; hay que llamar a Close al terminar
+func NewExportWriter(w io.Writer) *ExportWriter {
+	return &ExportWriter{zip: zip.NewWriter(w)}
+}
+
+// WriteJSON guarda v como JSON indentado en name
+func (e *ExportWriter) WriteJSON(name string, v interface{}) error {
+	f, err := e.zip.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// WriteTable guarda una tabla como name.json (una lista de objetos) y name.csv
+func (e *ExportWriter) WriteTable(name string, columns []string, rows [][]interface{}) error {
+	objects := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		objects[i] = map[string]interface{}{}
+		for j, column := range columns {
+			objects[i][column] = row[j]
+		}
+	}
+	if err := e.WriteJSON(name+".json", objects); err != nil {
+		return err
+	}
+
+	f, err := e.zip.Create(name + ".csv")
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write(columns)
+	for _, row := range rows {
+		record := make([]string, len(row))
+		for i, value := range row {
+			record[i] = csvValue(value)
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// WriteFile copia el fichero path en name
+func (e *ExportWriter) WriteFile(name, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	f, err := e.zip.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, src)
+	return err
+}
+
+// Close termina el ZIP
+func (e *ExportWriter) Close() error {
+	return e.zip.Close()
+}
+
+// csvValue convierte un valor leído de la base de datos al texto de una celda CSV
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
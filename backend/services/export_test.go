@@ -0,0 +1,66 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExportWriter(t *testing.T) {
+	image := filepath.Join(t.TempDir(), "captura.png")
+	if err := os.WriteFile(image, []byte("png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	export := NewExportWriter(&buf)
+	date := time.Date(2025, 3, 1, 7, 30, 0, 0, time.UTC)
+	err := export.WriteTable("workouts", []string{"id", "date", "notes", "distance"}, [][]interface{}{
+		{int64(1), date, "Rodaje, suave", 10.5},
+		{int64(2), date, nil, nil},
+	})
+	if err == nil {
+		err = export.WriteJSON("streams/1.json", json.RawMessage(`{"time":[0,1]}`))
+	}
+	if err == nil {
+		err = export.WriteFile("images/captura.png", image)
+	}
+	if err == nil {
+		err = export.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	wantCSV := "id,date,notes,distance\n1,2025-03-01T07:30:00Z,\"Rodaje, suave\",10.5\n2,2025-03-01T07:30:00Z,,\n"
+	if files["workouts.csv"] != wantCSV {
+		t.Errorf("CSV inesperado:\n%s", files["workouts.csv"])
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal([]byte(files["workouts.json"]), &rows); err != nil || len(rows) != 2 || rows[0]["notes"] != "Rodaje, suave" || rows[1]["notes"] != nil {
+		t.Errorf("JSON inesperado: %s %v", files["workouts.json"], err)
+	}
+	if files["images/captura.png"] != "png" || len(files["streams/1.json"]) == 0 {
+		t.Errorf("ficheros inesperados: %v", files)
+	}
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/shared"
+)
+
+// Confianza mínima para dar un campo extraído por bueno sin revisión
+const extractionReviewConfidence = 0.6
+
+// WorkoutTypes son los tipos de entreno válidos
+var WorkoutTypes = []string{"easy", "interval", "tempo", "long_run", "race"}
+
+// WorkoutExtraction contiene los datos de un entreno extraídos de capturas. Los campos que no
+// aparecen en la imagen o no superan la validación quedan a nil.
+type WorkoutExtraction struct {
+	Date            *string            `json:"date"` // YYYY-MM-DD
+	Type            *string            `json:"type"` // easy, interval, tempo, long_run, race
+	Distance        *float64           `json:"distance"`
+	DurationSeconds *int               `json:"duration_seconds"`
+	AvgPace         *string            `json:"avg_pace"` // MM:SS por km
+	AvgHeartRate    *int               `json:"avg_heart_rate"`
+	MaxHeartRate    *int               `json:"max_heart_rate"`
+	AvgPower        *int               `json:"avg_power"`
+	Cadence         *int               `json:"cadence"`
+	ElevationGain   *int               `json:"elevation_gain"`
+	Calories        *int               `json:"calories"`
+	Confidence      map[string]float64 `json:"confidence"`   // 0-1 por campo
+	Issues          map[string]string  `json:"issues"`       // problemas de validación por campo
+	NeedsReview     []string           `json:"needs_review"` // campos a revisar antes de guardar
+}
+
+// extractionField describe un campo del esquema de extracción
+type extractionField struct {
+	name        string
+	types       []string
+	description string
+}
+
+var extractionFields = []extractionField{
+	{"date", []string{"string", "null"}, "Fecha del entreno en formato YYYY-MM-DD"},
+	{"type", []string{"string", "null"}, "Tipo de sesión: easy, interval, tempo, long_run o race"},
+	{"distance", []string{"number", "null"}, "Distancia total en km"},
+	{"duration_seconds", []string{"integer", "null"}, "Duración total en segundos"},
+	{"avg_pace", []string{"string", "null"}, "Ritmo medio en MM:SS por km"},
+	{"avg_heart_rate", []string{"integer", "null"}, "FC media en ppm"},
+	{"max_heart_rate", []string{"integer", "null"}, "FC máxima en ppm"},
+	{"avg_power", []string{"integer", "null"}, "Potencia media en W"},
+	{"cadence", []string{"integer", "null"}, "Cadencia media en pasos por minuto"},
+	{"elevation_gain", []string{"integer", "null"}, "Desnivel positivo en m"},
+	{"calories", []string{"integer", "null"}, "Calorías activas o totales en kcal"},
+}
+
+// extractionSchema construye el JSON Schema estricto de la extracción: un valor nullable por
+// campo y un objeto confidence con la confianza de cada uno
+func extractionSchema() map[string]interface{} {
+	properties := map[string]interface{}{}
+	confidence := map[string]interface{}{}
+	required := []string{}
+	for _, f := range extractionFields {
+		properties[f.name] = map[string]interface{}{"type": f.types, "description": f.description}
+		confidence[f.name] = map[string]interface{}{"type": "number", "description": "Confianza entre 0 y 1; 0 si el dato no aparece"}
+		required = append(required, f.name)
+	}
+
+	properties["confidence"] = map[string]interface{}{
+		"type":                 "object",
+		"properties":           confidence,
+		"required":             required,
+		"additionalProperties": false,
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             append(append([]string{}, required...), "confidence"),
+		"additionalProperties": false,
+	}
+}
+
+// ExtractWorkoutFromImages extrae los datos del entreno de las capturas con una llamada
+// restringida al esquema. No usa ni modifica el historial de conversación.
+func ExtractWorkoutFromImages(session CoachSession, imageURLs []string, notes string) (*WorkoutExtraction, error) {
+	if client == nil {
+		InitializeOpenAI()
+	}
+
+	prompt, err := renderPrompt(PromptWorkoutExtraction, DefaultLocale, map[string]interface{}{"Notes": notes})
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []openai.ChatCompletionContentPartUnionParam{openai.TextPart(prompt)}
+	for _, imageURL := range imageURLs {
+		parts = append(parts, openai.ImagePart(imageURL))
+	}
+
+	ctx, cancel := session.deadline()
+	defer cancel()
+
+	response, err := chatCompletion(ctx, session, PromptWorkoutExtraction, openai.ChatCompletionNewParams{
+		Model: openai.F(CoachModel),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage("Eres un extractor de datos de entrenos de running. Respondes solo con el JSON del esquema."),
+			openai.UserMessageParts(parts...),
+		}),
+		ResponseFormat: openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](shared.ResponseFormatJSONSchemaParam{
+			Type: openai.F(shared.ResponseFormatJSONSchemaTypeJSONSchema),
+			JSONSchema: openai.F(shared.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:   openai.F("workout_extraction"),
+				Schema: openai.F[interface{}](extractionSchema()),
+				Strict: openai.F(true),
+			}),
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Choices) == 0 {
+		return nil, fmt.Errorf("no hay respuesta del modelo")
+	}
+
+	var extraction WorkoutExtraction
+	if err := json.Unmarshal([]byte(response.Choices[0].Message.Content), &extraction); err != nil {
+		return nil, fmt.Errorf("respuesta de extracción inválida: %v", err)
+	}
+
+	ValidateWorkoutExtraction(&extraction, time.Now())
+	return &extraction, nil
+}
+
+// ValidateWorkoutExtraction descarta los valores fuera de rango (dejándolos a nil con su
+// motivo en Issues), completa el ritmo si falta y marca los campos que conviene revisar
+func ValidateWorkoutExtraction(e *WorkoutExtraction, now time.Time) {
+	if e.Confidence == nil {
+		e.Confidence = map[string]float64{}
+	}
+	e.Issues = map[string]string{}
+
+	reject := func(field, reason string) {
+		e.Issues[field] = reason
+		e.Confidence[field] = 0
+	}
+
+	if e.Date != nil {
+		date, err := time.Parse("2006-01-02", *e.Date)
+		switch {
+		case err != nil:
+			reject("date", "formato de fecha inválido")
+			e.Date = nil
+		case date.After(now) || date.Year() < 2000:
+			reject("date", "fecha fuera de rango")
+			e.Date = nil
+		}
+	}
+	if e.Type != nil && !ValidOption(*e.Type, WorkoutTypes) {
+		reject("type", "tipo de entreno desconocido")
+		e.Type = nil
+	}
+	if e.Distance != nil && (*e.Distance < 0.1 || *e.Distance > 300) {
+		reject("distance", "distancia fuera de rango (0.1-300 km)")
+		e.Distance = nil
+	}
+	if e.DurationSeconds != nil && (*e.DurationSeconds < 60 || *e.DurationSeconds > 24*3600) {
+		reject("duration_seconds", "duración fuera de rango (1 min - 24 h)")
+		e.DurationSeconds = nil
+	}
+	if e.AvgPace != nil {
+		if seconds, err := ParsePace(*e.AvgPace); err != nil || seconds < 120 || seconds > 1200 {
+			reject("avg_pace", "ritmo fuera de rango (2:00-20:00 min/km)")
+			e.AvgPace = nil
+		}
+	}
+	rejectInt := func(field string, value **int, min, max int, reason string) {
+		if *value != nil && (**value < min || **value > max) {
+			reject(field, reason)
+			*value = nil
+		}
+	}
+	rejectInt("avg_heart_rate", &e.AvgHeartRate, 30, 230, "FC media fuera de rango (30-230)")
+	rejectInt("max_heart_rate", &e.MaxHeartRate, 30, 240, "FC máxima fuera de rango (30-240)")
+	rejectInt("avg_power", &e.AvgPower, 1, 1000, "potencia fuera de rango (1-1000 W)")
+	rejectInt("cadence", &e.Cadence, 60, 250, "cadencia fuera de rango (60-250 ppm)")
+	rejectInt("elevation_gain", &e.ElevationGain, 0, 10000, "desnivel fuera de rango (0-10000 m)")
+	rejectInt("calories", &e.Calories, 0, 10000, "calorías fuera de rango (0-10000)")
+
+	if e.AvgHeartRate != nil && e.MaxHeartRate != nil && *e.MaxHeartRate < *e.AvgHeartRate {
+		e.Issues["max_heart_rate"] = "la FC máxima es menor que la media"
+	}
+
+	// Comprobar que distancia, duración y ritmo son coherentes, o derivar el ritmo
+	if e.Distance != nil && e.DurationSeconds != nil {
+		computed := float64(*e.DurationSeconds) / *e.Distance
+		if e.AvgPace == nil {
+			pace := formatPaceFromSpeed(*e.Distance * 1000 / float64(*e.DurationSeconds))
+			e.AvgPace = &pace
+			e.Confidence["avg_pace"] = math.Min(e.Confidence["distance"], e.Confidence["duration_seconds"])
+		} else if seconds, _ := ParsePace(*e.AvgPace); math.Abs(float64(seconds)-computed) > computed*0.1 {
+			e.Issues["avg_pace"] = "el ritmo no cuadra con distancia y duración"
+		}
+	}
+
+	e.NeedsReview = []string{}
+	for _, f := range extractionFields {
+		_, hasIssue := e.Issues[f.name]
+		if hasIssue || (e.fieldPresent(f.name) && e.Confidence[f.name] < extractionReviewConfidence) {
+			e.NeedsReview = append(e.NeedsReview, f.name)
+		}
+	}
+}
+
+func (e *WorkoutExtraction) fieldPresent(name string) bool {
+	switch name {
+	case "date":
+		return e.Date != nil
+	case "type":
+		return e.Type != nil
+	case "distance":
+		return e.Distance != nil
+	case "duration_seconds":
+		return e.DurationSeconds != nil
+	case "avg_pace":
+		return e.AvgPace != nil
+	case "avg_heart_rate":
+		return e.AvgHeartRate != nil
+	case "max_heart_rate":
+		return e.MaxHeartRate != nil
+	case "avg_power":
+		return e.AvgPower != nil
+	case "cadence":
+		return e.Cadence != nil
+	case "elevation_gain":
+		return e.ElevationGain != nil
+	case "calories":
+		return e.Calories != nil
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateWorkoutExtraction(t *testing.T) {
+	str := func(s string) *string { return &s }
+	num := func(n int) *int { return &n }
+	distance := 10.0
+
+	e := WorkoutExtraction{
+		Date:            str("2025-11-16"),
+		Type:            str("trail"),
+		Distance:        &distance,
+		DurationSeconds: num(3000),
+		AvgHeartRate:    num(400),
+		Cadence:         num(172),
+		Confidence: map[string]float64{
+			"date": 0.9, "type": 0.8, "distance": 0.95, "duration_seconds": 0.9, "avg_heart_rate": 0.9, "cadence": 0.4,
+		},
+	}
+
+	ValidateWorkoutExtraction(&e, time.Date(2025, 11, 20, 0, 0, 0, 0, time.UTC))
+
+	if e.Type != nil || e.Issues["type"] == "" {
+		t.Fatalf("el tipo desconocido debería descartarse: %+v", e.Issues)
+	}
+	if e.AvgHeartRate != nil || e.Confidence["avg_heart_rate"] != 0 {
+		t.Fatalf("la FC fuera de rango debería descartarse")
+	}
+	if e.AvgPace == nil || *e.AvgPace != "5:00" {
+		t.Fatalf("se esperaba ritmo derivado 5:00, obtenido %v", e.AvgPace)
+	}
+	if e.Date == nil || e.Distance == nil || e.DurationSeconds == nil {
+		t.Fatalf("los campos válidos deben conservarse")
+	}
+
+	review := map[string]bool{}
+	for _, field := range e.NeedsReview {
+		review[field] = true
+	}
+	if !review["type"] || !review["avg_heart_rate"] || !review["cadence"] || review["distance"] {
+		t.Fatalf("campos a revisar inesperados: %v", e.NeedsReview)
+	}
+}
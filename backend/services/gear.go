@@ -0,0 +1,76 @@
+package services
+
+import (
+	"math"
+	"strings"
+
+	"trainapp/models"
+)
+
+const (
+	// DefaultGearRetirementKm es el umbral de retirada si el usuario no indica otro
+	DefaultGearRetirementKm = 700.0
+	// Porcentaje del umbral a partir del cual se avisa de que las zapatillas están cerca del límite
+	gearWarningRatio = 0.9
+)
+
+// GearAlert representa un aviso de kilometraje de unas zapatillas
+type GearAlert struct {
+	GearID       int     `json:"gear_id"`
+	Name         string  `json:"name"`
+	Level        string  `json:"level"` // warning, retire
+	TotalKm      float64 `json:"total_km"`
+	RetirementKm float64 `json:"retirement_km"`
+	RemainingKm  float64 `json:"remaining_km"`
+	Message      string  `json:"message"`
+}
+
+// GearDisplayName devuelve el nombre legible de unas zapatillas
+func GearDisplayName(g models.Gear) string {
+	if g.Nickname != "" {
+		return g.Nickname
+	}
+	return strings.TrimSpace(g.Brand + " " + g.Model)
+}
+
+// CheckGearMileage devuelve un aviso, en el idioma dado, si las zapatillas están cerca o por encima
+// de su umbral de retirada
+func CheckGearMileage(g models.Gear, locale string) *GearAlert {
+	if g.Retired || g.RetirementKm <= 0 {
+		return nil
+	}
+
+	remaining := math.Round((g.RetirementKm-g.TotalKm)*10) / 10
+	alert := &GearAlert{
+		GearID:       g.ID,
+		Name:         GearDisplayName(g),
+		TotalKm:      math.Round(g.TotalKm*10) / 10,
+		RetirementKm: g.RetirementKm,
+		RemainingKm:  remaining,
+	}
+
+	switch {
+	case g.TotalKm >= g.RetirementKm:
+		alert.Level = "retire"
+		alert.Message = T(locale, "%s ha superado su límite de %.0f km (%.1f km). Considera retirarlas.",
+			alert.Name, g.RetirementKm, alert.TotalKm)
+	case g.TotalKm >= g.RetirementKm*gearWarningRatio:
+		alert.Level = "warning"
+		alert.Message = T(locale, "A %s le quedan %.1f km hasta su límite de %.0f km.",
+			alert.Name, remaining, g.RetirementKm)
+	default:
+		return nil
+	}
+
+	return alert
+}
+
+// SplitStravaGearName separa el nombre de Strava ("Nike Pegasus Plus") en marca y modelo. Un
+// nombre de una sola palabra ("Pegasus") es solo el modelo.
+func SplitStravaGearName(name string) (brand, model string) {
+	parts := strings.Fields(name)
+	if len(parts) < 2 {
+		return "", strings.Join(parts, "")
+	}
+	return parts[0], strings.Join(parts[1:], " ")
+}
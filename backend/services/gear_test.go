@@ -0,0 +1,65 @@
+package services
+
+import (
+	"testing"
+
+	"trainapp/models"
+)
+
+func TestCheckGearMileage(t *testing.T) {
+	cases := []struct {
+		name         string
+		totalKm      float64
+		retirementKm float64
+		retired      bool
+		level        string // "" = sin aviso
+		remainingKm  float64
+	}{
+		{"lejos del límite", 400, 700, false, "", 0},
+		{"justo por debajo del 90%", 629.9, 700, false, "", 0},
+		{"al 90%", 630, 700, false, "warning", 70},
+		{"entre el 90% y el límite", 688.24, 700, false, "warning", 11.8},
+		{"en el límite", 700, 700, false, "retire", 0},
+		{"por encima del límite", 742.5, 700, false, "retire", -42.5},
+		{"ya retiradas", 900, 700, true, "", 0},
+		{"sin umbral", 900, 0, false, "", 0},
+		{"umbral negativo", 900, -1, false, "", 0},
+	}
+
+	for _, tc := range cases {
+		g := models.Gear{ID: 3, Brand: "Nike", Model: "Pegasus 41", TotalKm: tc.totalKm, RetirementKm: tc.retirementKm, Retired: tc.retired}
+		alert := CheckGearMileage(g, "es")
+		if tc.level == "" {
+			if alert != nil {
+				t.Errorf("%s: aviso inesperado %+v", tc.name, alert)
+			}
+			continue
+		}
+		if alert == nil {
+			t.Errorf("%s: se esperaba un aviso %s", tc.name, tc.level)
+			continue
+		}
+		if alert.Level != tc.level || alert.RemainingKm != tc.remainingKm || alert.GearID != 3 || alert.Name != "Nike Pegasus 41" || alert.Message == "" {
+			t.Errorf("%s: aviso inesperado %+v", tc.name, alert)
+		}
+	}
+}
+
+func TestSplitStravaGearName(t *testing.T) {
+	cases := map[string][2]string{
+		"Nike Pegasus Plus":   {"Nike", "Pegasus Plus"},
+		"  Hoka   Clifton 9 ": {"Hoka", "Clifton 9"},
+		"Pegasus":             {"", "Pegasus"},
+		" Pegasus ":           {"", "Pegasus"},
+		"":                    {"", ""},
+	}
+	for name, want := range cases {
+		if brand, model := SplitStravaGearName(name); brand != want[0] || model != want[1] {
+			t.Errorf("SplitStravaGearName(%q) = %q, %q; se esperaba %q, %q", name, brand, model, want[0], want[1])
+		}
+	}
+
+	if got := GearDisplayName(models.Gear{Model: "Pegasus"}); got != "Pegasus" {
+		t.Errorf("nombre sin marca: %q", got)
+	}
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Idiomas soportados. Los mensajes y prompts se escriben en español, que es el idioma por defecto,
+// y el catálogo de cada idioma los traduce.
+const (
+	LocaleSpanish = "es"
+	LocaleEnglish = "en"
+	DefaultLocale = LocaleSpanish
+)
+
+// SupportedLocales son los idiomas que acepta el ajuste del usuario
+var SupportedLocales = []string{LocaleSpanish, LocaleEnglish}
+
+// catalogs traduce cada mensaje, identificado por su texto en español, a otros idiomas
+var catalogs = map[string]map[string]string{
+	LocaleEnglish: englishMessages,
+}
+
+// NormalizeLocale devuelve el idioma soportado de una etiqueta ("en-GB" -> "en"), o "" si no lo está
+func NormalizeLocale(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	for _, locale := range SupportedLocales {
+		if tag == locale {
+			return locale
+		}
+	}
+	return ""
+}
+
+// LocaleFromAcceptLanguage elige el idioma soportado con más peso en una cabecera Accept-Language,
+// o DefaultLocale si no hay ninguno
+func LocaleFromAcceptLanguage(header string) string {
+	type candidate struct {
+		locale string
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		locale := NormalizeLocale(tag)
+		if locale == "" {
+			continue
+		}
+		q := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{locale, q})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return DefaultLocale
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].locale
+}
+
+// T traduce un mensaje al idioma dado; si se pasan argumentos, el mensaje es un formato de
+// fmt.Sprintf. Los mensajes sin traducción se devuelven en español. Un mensaje compuesto
+// "prefijo: detalle" (p. ej. un error con su causa) se traduce por partes.
+func T(locale, msg string, args ...interface{}) string {
+	text := translate(locale, msg)
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+func translate(locale, msg string) string {
+	catalog := catalogs[NormalizeLocale(locale)]
+	if catalog == nil {
+		return msg
+	}
+	if text, ok := catalog[msg]; ok {
+		return text
+	}
+	if prefix, detail, found := strings.Cut(msg, ": "); found {
+		if text, ok := catalog[prefix]; ok {
+			return text + ": " + translate(locale, detail)
+		}
+	}
+	return msg
+}
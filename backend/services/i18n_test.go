@@ -0,0 +1,56 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLocaleFromAcceptLanguage(t *testing.T) {
+	cases := map[string]string{
+		"":                        DefaultLocale,
+		"en-GB,en;q=0.9":          LocaleEnglish,
+		"fr-FR,fr;q=0.9,en;q=0.8": LocaleEnglish,
+		"de,es;q=0.5,en;q=0.7":    LocaleEnglish,
+		"es-ES,es;q=0.9,en;q=0.8": LocaleSpanish,
+		"en;q=0,fr":               DefaultLocale,
+		"pt-BR":                   DefaultLocale,
+	}
+	for header, want := range cases {
+		if got := LocaleFromAcceptLanguage(header); got != want {
+			t.Errorf("LocaleFromAcceptLanguage(%q) = %q, se esperaba %q", header, got, want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	if got := T(LocaleEnglish, "Método no permitido"); got != "Method not allowed" {
+		t.Fatalf("traducción inesperada: %q", got)
+	}
+	if got := T(LocaleSpanish, "Método no permitido"); got != "Método no permitido" {
+		t.Fatalf("en español el mensaje no debe cambiar: %q", got)
+	}
+	if got := T(LocaleEnglish, "Máximo %d imágenes por análisis", 4); got != "At most 4 images per analysis" {
+		t.Fatalf("formato inesperado: %q", got)
+	}
+	if got := T(LocaleEnglish, "Error analizando workout: no hay respuesta del modelo"); got != "Error analyzing workout: the model returned no response" {
+		t.Fatalf("mensaje compuesto inesperado: %q", got)
+	}
+	if got := T(LocaleEnglish, "Error generando plan: timeout de red"); got != "Error generating plan: timeout de red" {
+		t.Fatalf("la causa sin traducción debe conservarse: %q", got)
+	}
+	if got := T("fr", "Datos inválidos"); got != "Datos inválidos" {
+		t.Fatalf("un idioma no soportado debe devolver el mensaje original: %q", got)
+	}
+}
+
+func TestCatalogFormatVerbs(t *testing.T) {
+	verbs := regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z]`)
+	for locale, catalog := range catalogs {
+		for key, text := range catalog {
+			if strings.Join(verbs.FindAllString(key, -1), " ") != strings.Join(verbs.FindAllString(text, -1), " ") {
+				t.Errorf("%s: la traducción de %q no tiene los mismos verbos de formato: %q", locale, key, text)
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"strings"
+)
+
+const (
+	// MaxImageBytes es el tamaño máximo de cada captura
+	MaxImageBytes = 5 << 20
+	// MaxImagesPerRequest es el número máximo de capturas por análisis
+	MaxImagesPerRequest = 4
+)
+
+// Formatos de imagen aceptados y su extensión
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// ImageExtension devuelve la extensión de archivo de un tipo de imagen aceptado
+func ImageExtension(contentType string) string {
+	return imageExtensions[contentType]
+}
+
+// ParseImageDataURL decodifica una data URL (data:image/png;base64,...) y devuelve sus bytes
+func ParseImageDataURL(dataURL string) ([]byte, error) {
+	header, payload, found := strings.Cut(dataURL, ",")
+	if !found || !strings.HasPrefix(header, "data:") || !strings.HasSuffix(header, ";base64") {
+		return nil, errors.New("data URL inválida (se espera data:image/...;base64,...)")
+	}
+
+	if base64.StdEncoding.DecodedLen(len(payload)) > MaxImageBytes+3 {
+		return nil, fmt.Errorf("la imagen supera el máximo de %d MB", MaxImageBytes>>20)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errors.New("base64 inválido en la data URL")
+	}
+
+	return data, nil
+}
+
+// ImageDataURL codifica una imagen como data URL para enviarla al proveedor
+func ImageDataURL(contentType string, data []byte) string {
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// SanitizeImage comprueba tamaño y tipo real de la imagen (por su contenido, no por la
+// extensión) y elimina los metadatos EXIF, XMP y de texto, que pueden incluir la ubicación
+func SanitizeImage(data []byte) ([]byte, string, error) {
+	if len(data) == 0 {
+		return nil, "", errors.New("imagen vacía")
+	}
+	if len(data) > MaxImageBytes {
+		return nil, "", fmt.Errorf("la imagen supera el máximo de %d MB", MaxImageBytes>>20)
+	}
+
+	contentType := http.DetectContentType(data)
+	var clean []byte
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		clean, err = stripJPEGMetadata(data)
+	case "image/png":
+		clean, err = stripPNGMetadata(data)
+	default:
+		return nil, "", fmt.Errorf("formato no soportado (%s): usa JPEG o PNG", contentType)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return clean, contentType, nil
+}
+
+// stripJPEGMetadata elimina los segmentos APP1-APP15 (EXIF, XMP, IPTC...) y los comentarios.
+// Se conserva APP0 (JFIF) y el resto de segmentos necesarios para decodificar la imagen.
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("JPEG inválido")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF || i+1 >= len(data) {
+			return nil, errors.New("JPEG inválido")
+		}
+		marker := data[i+1]
+
+		// Relleno entre marcadores
+		if marker == 0xFF {
+			i++
+			continue
+		}
+
+		// Marcadores sin longitud
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write(data[i : i+2])
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return nil, errors.New("JPEG truncado")
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errors.New("JPEG truncado")
+		}
+
+		// A partir del inicio del escaneo (SOS) van los datos comprimidos hasta el final
+		if marker == 0xDA {
+			out.Write(data[i:])
+			return out.Bytes(), nil
+		}
+
+		isMetadata := (marker >= 0xE1 && marker <= 0xEF) || marker == 0xFE
+		if !isMetadata {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+
+	return out.Bytes(), nil
+}
+
+// Chunks PNG de metadatos que se eliminan
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"iTXt": true,
+	"zTXt": true,
+	"tIME": true,
+}
+
+// stripPNGMetadata elimina los chunks EXIF y de texto de un PNG
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	signature := []byte("\x89PNG\r\n\x1a\n")
+	if !bytes.HasPrefix(data, signature) {
+		return nil, errors.New("PNG inválido")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(signature)
+
+	i := len(signature)
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, errors.New("PNG truncado")
+		}
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		chunkType := string(data[i+4 : i+8])
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errors.New("PNG truncado")
+		}
+
+		if crc32.ChecksumIEEE(data[i+4:i+8+length]) != binary.BigEndian.Uint32(data[i+8+length:end]) {
+			return nil, errors.New("PNG corrupto")
+		}
+
+		if !pngMetadataChunks[chunkType] {
+			out.Write(data[i:end])
+		}
+		i = end
+
+		if chunkType == "IEND" {
+			break
+		}
+	}
+
+	return out.Bytes(), nil
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for x := 0; x < 8; x++ {
+		img.Set(x, x, color.RGBA{255, 0, 0, 255})
+	}
+	return img
+}
+
+func TestSanitizeImageJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Insertar un segmento APP1 con EXIF (y una falsa posición GPS) tras el SOI
+	exif := append([]byte("Exif\x00\x00"), []byte("GPS 40.4168N 3.7038W")...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+	data := append(append(append([]byte{}, buf.Bytes()[:2]...), append(segment, exif...)...), buf.Bytes()[2:]...)
+
+	clean, contentType, err := SanitizeImage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "image/jpeg" {
+		t.Fatalf("tipo inesperado %s", contentType)
+	}
+	if bytes.Contains(clean, []byte("Exif")) || bytes.Contains(clean, []byte("GPS")) {
+		t.Fatal("los metadatos EXIF deberían eliminarse")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(clean)); err != nil {
+		t.Fatalf("la imagen limpia debe seguir siendo válida: %v", err)
+	}
+}
+
+func TestSanitizeImagePNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Insertar un chunk tEXt tras la cabecera IHDR (firma 8 + IHDR 25 bytes)
+	text := []byte("Location\x0040.4168,-3.7038")
+	chunk := make([]byte, 8, 12+len(text))
+	binary.BigEndian.PutUint32(chunk, uint32(len(text)))
+	copy(chunk[4:], "tEXt")
+	chunk = append(chunk, text...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	data := append(append(append([]byte{}, buf.Bytes()[:33]...), chunk...), buf.Bytes()[33:]...)
+
+	clean, contentType, err := SanitizeImage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "image/png" {
+		t.Fatalf("tipo inesperado %s", contentType)
+	}
+	if bytes.Contains(clean, []byte("Location")) {
+		t.Fatal("el chunk de texto debería eliminarse")
+	}
+	if _, err := png.Decode(bytes.NewReader(clean)); err != nil {
+		t.Fatalf("la imagen limpia debe seguir siendo válida: %v", err)
+	}
+
+	if _, _, err := SanitizeImage([]byte("GIF89a......")); err == nil {
+		t.Fatal("los formatos no soportados deben rechazarse")
+	}
+}
@@ -0,0 +1,415 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"trainapp/models"
+)
+
+const (
+	// Relación mínima entre la velocidad de las series y la de las recuperaciones
+	// para considerar que un entreno tiene estructura de intervalos
+	minIntervalSpeedRatio = 1.15
+	// Duración mínima (segundos) de un tramo detectado en streams; los más cortos se funden con el anterior
+	minStreamSegmentSeconds = 20
+	// Muestras a cada lado usadas para suavizar la velocidad
+	streamSmoothingRadius = 5
+	// Número mínimo de series de trabajo para dar por buena la detección
+	minWorkReps = 2
+)
+
+// DetectIntervals segmenta un entreno en series y recuperaciones.
+// Usa las vueltas del dispositivo (laps de Strava) si separan claramente trabajo y descanso
+// y, si no, los cambios de ritmo/potencia de los streams. Devuelve las repeticiones y la fuente usada.
+func DetectIntervals(stravaData map[string]interface{}, streams *ActivityStreams) ([]models.WorkoutInterval, string) {
+	if stravaData != nil {
+		if laps, ok := stravaData["laps"].([]interface{}); ok {
+			if reps := DetectIntervalsFromLaps(laps); reps != nil {
+				return reps, "laps"
+			}
+		}
+	}
+
+	if streams != nil {
+		if reps := DetectIntervalsFromStreams(streams); reps != nil {
+			return reps, "streams"
+		}
+	}
+
+	return nil, ""
+}
+
+// DetectIntervalsFromLaps clasifica las vueltas del dispositivo en trabajo/recuperación según su velocidad.
+// Las vueltas rápidas seguidas (autolap dentro de una serie o de un tempo) forman una sola serie.
+// Devuelve nil si las vueltas son homogéneas (p.ej. autolap cada km) o no hay suficientes series
+// separadas por recuperaciones, como en un tempo o un progresivo.
+func DetectIntervalsFromLaps(laps []interface{}) []models.WorkoutInterval {
+	if len(laps) < 3 {
+		return nil
+	}
+
+	reps := make([]models.WorkoutInterval, 0, len(laps))
+	speeds := make([]float64, 0, len(laps))
+	offset := 0
+
+	for _, raw := range laps {
+		lap, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		distance := jsonFloat(lap["distance"])
+		duration := int(jsonFloat(lap["moving_time"]))
+		if duration == 0 {
+			duration = int(jsonFloat(lap["elapsed_time"]))
+		}
+
+		speed := jsonFloat(lap["average_speed"])
+		if speed == 0 && duration > 0 {
+			speed = distance / float64(duration)
+		}
+
+		reps = append(reps, models.WorkoutInterval{
+			Source:       "laps",
+			StartOffset:  offset,
+			Distance:     math.Round(distance),
+			Duration:     duration,
+			AvgPace:      formatPaceFromSpeed(speed),
+			AvgHeartRate: int(math.Round(jsonFloat(lap["average_heartrate"]))),
+			MaxHeartRate: int(math.Round(jsonFloat(lap["max_heartrate"]))),
+			AvgPower:     int(math.Round(jsonFloat(lap["average_watts"]))),
+		})
+		speeds = append(speeds, speed)
+		offset += int(jsonFloat(lap["elapsed_time"]))
+	}
+
+	threshold, ok := splitSpeeds(speeds)
+	if !ok {
+		return nil
+	}
+
+	fast := make([]bool, len(speeds))
+	for i, speed := range speeds {
+		fast[i] = speed >= threshold
+	}
+
+	reps, fast = mergeFastLaps(reps, fast)
+	return labelReps(reps, fast)
+}
+
+// mergeFastLaps funde las vueltas rápidas consecutivas en un solo tramo, como hace la detección
+// por streams: el ritmo sale de la distancia y el tiempo totales, y la FC y la potencia se
+// ponderan por duración
+func mergeFastLaps(reps []models.WorkoutInterval, fast []bool) ([]models.WorkoutInterval, []bool) {
+	merged := make([]models.WorkoutInterval, 0, len(reps))
+	mergedFast := make([]bool, 0, len(fast))
+
+	for i, rep := range reps {
+		n := len(merged)
+		if n == 0 || !fast[i] || !mergedFast[n-1] {
+			merged = append(merged, rep)
+			mergedFast = append(mergedFast, fast[i])
+			continue
+		}
+
+		prev := &merged[n-1]
+		total := prev.Duration + rep.Duration
+		weighted := func(a, b int) int {
+			if total == 0 {
+				return 0
+			}
+			return int(math.Round(float64(a*prev.Duration+b*rep.Duration) / float64(total)))
+		}
+		prev.AvgHeartRate = weighted(prev.AvgHeartRate, rep.AvgHeartRate)
+		prev.AvgPower = weighted(prev.AvgPower, rep.AvgPower)
+		if rep.MaxHeartRate > prev.MaxHeartRate {
+			prev.MaxHeartRate = rep.MaxHeartRate
+		}
+		prev.Distance += rep.Distance
+		prev.Duration = total
+		if total > 0 {
+			prev.AvgPace = formatPaceFromSpeed(prev.Distance / float64(total))
+		}
+	}
+
+	return merged, mergedFast
+}
+
+// DetectIntervalsFromStreams busca cambios de ritmo (o de potencia si no hay velocidad) en los streams
+// y agrupa las muestras en tramos rápidos y lentos de una duración mínima.
+func DetectIntervalsFromStreams(streams *ActivityStreams) []models.WorkoutInterval {
+	n := len(streams.Time)
+	if n < 60 {
+		return nil
+	}
+
+	// La velocidad es la señal principal; la potencia sirve en cinta o con GPS pobre
+	signal := streams.Velocity
+	if len(signal) != n || maxValue(signal) == 0 {
+		signal = streams.Watts
+	}
+	if len(signal) != n {
+		return nil
+	}
+
+	smoothed := smoothSeries(signal, streamSmoothingRadius)
+
+	threshold, ok := splitSpeeds(smoothed)
+	if !ok {
+		return nil
+	}
+
+	// Tramos consecutivos por encima/debajo del umbral: [start, end)
+	type segment struct {
+		start, end int
+		fast       bool
+	}
+
+	var segments []segment
+	for i := 0; i < n; i++ {
+		isFast := smoothed[i] >= threshold
+		if len(segments) > 0 && segments[len(segments)-1].fast == isFast {
+			segments[len(segments)-1].end = i + 1
+			continue
+		}
+		segments = append(segments, segment{start: i, end: i + 1, fast: isFast})
+	}
+
+	// Fundir tramos demasiado cortos (arranques, semáforos, ruido de GPS) con el anterior
+	merged := []segment{}
+	for _, seg := range segments {
+		length := streams.Time[seg.end-1] - streams.Time[seg.start]
+		if len(merged) > 0 && (length < minStreamSegmentSeconds || merged[len(merged)-1].fast == seg.fast) {
+			merged[len(merged)-1].end = seg.end
+			continue
+		}
+		merged = append(merged, seg)
+	}
+
+	reps := make([]models.WorkoutInterval, 0, len(merged))
+	fast := make([]bool, 0, len(merged))
+
+	for _, seg := range merged {
+		// El tramo termina donde empieza el siguiente para no perder distancia ni tiempo
+		last := seg.end
+		if last >= n {
+			last = n - 1
+		}
+
+		duration := int(math.Round(streams.Time[last] - streams.Time[seg.start]))
+		if duration <= 0 {
+			continue
+		}
+
+		rep := models.WorkoutInterval{
+			Source:       "streams",
+			StartOffset:  int(math.Round(streams.Time[seg.start])),
+			Duration:     duration,
+			AvgHeartRate: int(math.Round(meanValue(sliceOf(streams.Heartrate, seg.start, seg.end)))),
+			MaxHeartRate: int(math.Round(maxValue(sliceOf(streams.Heartrate, seg.start, seg.end)))),
+			AvgPower:     int(math.Round(meanValue(sliceOf(streams.Watts, seg.start, seg.end)))),
+		}
+
+		if len(streams.Distance) == n {
+			rep.Distance = math.Round(streams.Distance[last] - streams.Distance[seg.start])
+			rep.AvgPace = formatPaceFromSpeed(rep.Distance / float64(duration))
+		}
+
+		reps = append(reps, rep)
+		fast = append(fast, seg.fast)
+	}
+
+	return labelReps(reps, fast)
+}
+
+// splitSpeeds separa los valores en dos grupos (2-means) y devuelve el umbral entre ambos.
+// ok es false si los grupos no están suficientemente separados para hablar de series.
+func splitSpeeds(values []float64) (float64, bool) {
+	positive := make([]float64, 0, len(values))
+	for _, v := range values {
+		if v > 0 {
+			positive = append(positive, v)
+		}
+	}
+	if len(positive) < 2 {
+		return 0, false
+	}
+
+	sorted := append([]float64(nil), positive...)
+	sort.Float64s(sorted)
+
+	// Partir de los percentiles 10 y 90 para que paradas o picos aislados no arrastren los centros
+	low := sorted[len(sorted)/10]
+	high := sorted[len(sorted)-1-len(sorted)/10]
+
+	for iter := 0; iter < 20; iter++ {
+		threshold := (low + high) / 2
+		var sumLow, sumHigh float64
+		var countLow, countHigh int
+		for _, v := range positive {
+			if v >= threshold {
+				sumHigh += v
+				countHigh++
+			} else {
+				sumLow += v
+				countLow++
+			}
+		}
+		if countLow == 0 || countHigh == 0 {
+			return 0, false
+		}
+		low, high = sumLow/float64(countLow), sumHigh/float64(countHigh)
+	}
+
+	if low <= 0 || high/low < minIntervalSpeedRatio {
+		return 0, false
+	}
+
+	return (low + high) / 2, true
+}
+
+// labelReps asigna el tipo de cada tramo: los lentos antes de la primera serie son calentamiento,
+// los posteriores a la última son vuelta a la calma y el resto recuperaciones. Solo cuentan como
+// series distintas los tramos rápidos separados por uno lento; con menos de minWorkReps devuelve
+// nil.
+func labelReps(reps []models.WorkoutInterval, fast []bool) []models.WorkoutInterval {
+	first, last, work := -1, -1, 0
+	for i, isFast := range fast {
+		if isFast {
+			if first < 0 {
+				first = i
+			}
+			last = i
+			if i == 0 || !fast[i-1] {
+				work++
+			}
+		}
+	}
+
+	if work < minWorkReps {
+		return nil
+	}
+
+	for i := range reps {
+		switch {
+		case fast[i]:
+			reps[i].Kind = "work"
+		case i < first:
+			reps[i].Kind = "warmup"
+		case i > last:
+			reps[i].Kind = "cooldown"
+		default:
+			reps[i].Kind = "recovery"
+		}
+		reps[i].RepIndex = i + 1
+	}
+
+	return reps
+}
+
+// formatPaceFromSpeed convierte una velocidad en m/s a ritmo min/km (MM:SS)
+func formatPaceFromSpeed(speed float64) string {
+	if speed <= 0 {
+		return ""
+	}
+
+	secondsPerKm := int(math.Round(1000 / speed))
+	return fmt.Sprintf("%d:%02d", secondsPerKm/60, secondsPerKm%60)
+}
+
+// smoothSeries aplica una media móvil centrada de radio r
+func smoothSeries(values []float64, r int) []float64 {
+	out := make([]float64, len(values))
+	for i := range values {
+		from, to := i-r, i+r+1
+		if from < 0 {
+			from = 0
+		}
+		if to > len(values) {
+			to = len(values)
+		}
+		out[i] = meanValue(values[from:to])
+	}
+	return out
+}
+
+// sliceOf devuelve values[start:end] o nil si el stream no está disponible
+func sliceOf(values []float64, start, end int) []float64 {
+	if end > len(values) {
+		return nil
+	}
+	return values[start:end]
+}
+
+func meanValue(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func maxValue(values []float64) float64 {
+	var max float64
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+// jsonFloat lee un número de un mapa decodificado desde JSON
+func jsonFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	}
+	return 0
+}
+
+// FormatIntervalsForPrompt resume las repeticiones detectadas en texto para los prompts del coach
+func FormatIntervalsForPrompt(reps []models.WorkoutInterval) string {
+	if len(reps) == 0 {
+		return ""
+	}
+
+	kindNames := map[string]string{
+		"warmup":   "Calentamiento",
+		"work":     "Serie",
+		"recovery": "Recuperación",
+		"cooldown": "Vuelta a la calma",
+	}
+
+	summary := ""
+	workIndex := 0
+	for _, rep := range reps {
+		name := kindNames[rep.Kind]
+		if rep.Kind == "work" {
+			workIndex++
+			name = fmt.Sprintf("Serie %d", workIndex)
+		}
+
+		summary += fmt.Sprintf("\n- %s: %.0f m en %d:%02d", name, rep.Distance, rep.Duration/60, rep.Duration%60)
+		if rep.AvgPace != "" {
+			summary += fmt.Sprintf(", ritmo %s/km", rep.AvgPace)
+		}
+		if rep.AvgHeartRate > 0 {
+			summary += fmt.Sprintf(", FC %d bpm (máx %d)", rep.AvgHeartRate, rep.MaxHeartRate)
+		}
+		if rep.AvgPower > 0 {
+			summary += fmt.Sprintf(", %d W", rep.AvgPower)
+		}
+	}
+
+	return summary
+}
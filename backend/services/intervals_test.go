@@ -0,0 +1,150 @@
+package services
+
+import "testing"
+
+// lap construye una vuelta con el formato que devuelve Strava en "laps"
+func lap(distance, seconds, heartrate float64) interface{} {
+	return map[string]interface{}{
+		"distance":          distance,
+		"moving_time":       seconds,
+		"elapsed_time":      seconds,
+		"average_speed":     distance / seconds,
+		"average_heartrate": heartrate,
+		"max_heartrate":     heartrate + 5,
+	}
+}
+
+func TestDetectIntervalsFromLaps(t *testing.T) {
+	laps := []interface{}{lap(2000, 720, 135)}
+	for i := 0; i < 5; i++ {
+		laps = append(laps, lap(400, 88, 172), lap(200, 90, 150))
+	}
+	laps = append(laps, lap(1500, 560, 140))
+
+	reps, source := DetectIntervals(map[string]interface{}{"laps": laps}, nil)
+	if source != "laps" {
+		t.Fatalf("fuente esperada laps, obtenida %q", source)
+	}
+	if len(reps) != len(laps) {
+		t.Fatalf("se esperaban %d tramos, obtenidos %d", len(laps), len(reps))
+	}
+
+	// La recuperación tras la última serie cuenta como vuelta a la calma
+	kinds := map[string]int{}
+	for _, rep := range reps {
+		kinds[rep.Kind]++
+	}
+	if kinds["work"] != 5 || kinds["recovery"] != 4 || kinds["warmup"] != 1 || kinds["cooldown"] != 2 {
+		t.Fatalf("clasificación inesperada: %v", kinds)
+	}
+
+	if reps[1].AvgPace != "3:40" || reps[1].AvgHeartRate != 172 {
+		t.Errorf("primera serie inesperada: %+v", reps[1])
+	}
+}
+
+func TestDetectIntervalsIgnoresSteadyRuns(t *testing.T) {
+	// Autolap cada km a ritmo constante: no hay series
+	var laps []interface{}
+	for i := 0; i < 10; i++ {
+		laps = append(laps, lap(1000, 300+float64(i%3), 150))
+	}
+
+	if reps := DetectIntervalsFromLaps(laps); reps != nil {
+		t.Fatalf("no se esperaban series en un rodaje continuo, obtenidas %d", len(reps))
+	}
+}
+
+func TestDetectIntervalsFromStreams(t *testing.T) {
+	streams := &ActivityStreams{}
+	distance := 0.0
+
+	// 5 min suave, 4 x (90 s rápido + 90 s suave), 5 min suave; una muestra por segundo
+	add := func(seconds int, speed, heartrate float64) {
+		for i := 0; i < seconds; i++ {
+			streams.Time = append(streams.Time, float64(len(streams.Time)))
+			streams.Distance = append(streams.Distance, distance)
+			streams.Velocity = append(streams.Velocity, speed)
+			streams.Heartrate = append(streams.Heartrate, heartrate)
+			distance += speed
+		}
+	}
+
+	add(300, 2.8, 130)
+	for i := 0; i < 4; i++ {
+		add(90, 4.5, 170)
+		add(90, 2.5, 145)
+	}
+	add(300, 2.8, 135)
+
+	reps, source := DetectIntervals(nil, streams)
+	if source != "streams" {
+		t.Fatalf("fuente esperada streams, obtenida %q", source)
+	}
+
+	work := 0
+	for _, rep := range reps {
+		if rep.Kind != "work" {
+			continue
+		}
+		work++
+		if rep.Duration < 80 || rep.Duration > 100 {
+			t.Errorf("duración de serie fuera de rango: %+v", rep)
+		}
+	}
+	if work != 4 {
+		t.Fatalf("se esperaban 4 series, obtenidas %d (%+v)", work, reps)
+	}
+	if reps[0].Kind != "warmup" || reps[len(reps)-1].Kind != "cooldown" {
+		t.Errorf("calentamiento/vuelta a la calma no detectados: %+v", reps)
+	}
+}
+
+func TestDetectIntervalsIgnoresContinuousRuns(t *testing.T) {
+	// Autolap cada km: velocidades en m/s de cada vuelta
+	autolaps := func(speeds ...float64) []interface{} {
+		laps := make([]interface{}, 0, len(speeds))
+		for _, speed := range speeds {
+			laps = append(laps, lap(1000, 1000/speed, 150))
+		}
+		return laps
+	}
+
+	cases := map[string][]interface{}{
+		"rodaje":     autolaps(3.0, 3.02, 2.98, 3.0, 3.01, 3.0, 2.99, 3.0),
+		"tempo":      autolaps(2.8, 2.8, 3.6, 3.6, 3.6, 3.6, 3.6, 3.6, 2.8, 2.8),
+		"progresivo": autolaps(2.8, 2.9, 3.0, 3.1, 3.2, 3.3, 3.4, 3.5, 3.6, 3.7),
+	}
+	for name, laps := range cases {
+		if reps := DetectIntervalsFromLaps(laps); reps != nil {
+			t.Errorf("%s: no se esperaban series, obtenidos %d tramos", name, len(reps))
+		}
+	}
+}
+
+func TestDetectIntervalsMergesAutolapsInsideReps(t *testing.T) {
+	// 2 x 2 km con autolap cada km y 400 m de recuperación
+	laps := []interface{}{
+		lap(1000, 360, 130),
+		lap(1000, 240, 165), lap(1000, 240, 175),
+		lap(400, 150, 140),
+		lap(1000, 240, 168), lap(1000, 240, 178),
+		lap(1000, 360, 135),
+	}
+
+	reps := DetectIntervalsFromLaps(laps)
+	if len(reps) != 5 {
+		t.Fatalf("se esperaban 5 tramos, obtenidos %d", len(reps))
+	}
+	kinds := ""
+	for _, rep := range reps {
+		kinds += rep.Kind + " "
+	}
+	if kinds != "warmup work recovery work cooldown " {
+		t.Fatalf("clasificación inesperada: %s", kinds)
+	}
+	if work := reps[1]; work.Distance != 2000 || work.Duration != 480 || work.AvgPace != "4:00" ||
+		work.AvgHeartRate != 170 || work.MaxHeartRate != 180 || work.RepIndex != 2 {
+		t.Errorf("serie fundida inesperada: %+v", work)
+	}
+}
@@ -9,15 +9,20 @@ import (
 	"strings"
 )
 
-// createJWT crea un token JWT simple
+// Margen en segundos para exp y nbf por desfase de relojes
+const jwtClockSkew = 30
+
+// jwtHeader es la cabecera de los tokens: solo se emite y se acepta HS256
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// createJWT crea un token JWT firmado con la clave activa
 func (s *AuthService) createJWT(claims TokenClaims) (string, error) {
 	// Header
-	header := map[string]string{
-		"alg": "HS256",
-		"typ": "JWT",
-	}
-
-	headerJSON, err := json.Marshal(header)
+	headerJSON, err := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT", Kid: s.activeKID})
 	if err != nil {
 		return "", err
 	}
@@ -34,26 +39,44 @@ func (s *AuthService) createJWT(claims TokenClaims) (string, error) {
 
 	// Signature
 	message := headerEncoded + "." + claimsEncoded
-	signature := s.sign(message)
+	signature := sign(s.keys[s.activeKID], message)
 
 	// Token completo
-	token := message + "." + signature
+	token := message + "." + base64.RawURLEncoding.EncodeToString(signature)
 
 	return token, nil
 }
 
-// parseJWT parsea y valida un token JWT
+// parseJWT parsea un token JWT y verifica su cabecera y su firma
 func (s *AuthService) parseJWT(tokenString string) (*TokenClaims, error) {
 	parts := strings.Split(tokenString, ".")
 	if len(parts) != 3 {
 		return nil, errors.New("token inválido")
 	}
 
-	// Verificar firma
-	message := parts[0] + "." + parts[1]
-	expectedSignature := s.sign(message)
+	// Verificar cabecera: algoritmo fijo (evita "none" y confusiones de algoritmo) y clave conocida
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("error decodificando cabecera")
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errors.New("error parseando cabecera")
+	}
+	if header.Alg != "HS256" || (header.Typ != "" && header.Typ != "JWT") {
+		return nil, errors.New("algoritmo del token no soportado")
+	}
+	key, ok := s.keys[header.Kid]
+	if !ok {
+		return nil, errors.New("clave del token desconocida")
+	}
 
-	if parts[2] != expectedSignature {
+	// Verificar firma en tiempo constante
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("firma del token inválida")
+	}
+	if !hmac.Equal(signature, sign(key, parts[0]+"."+parts[1])) {
 		return nil, errors.New("firma del token inválida")
 	}
 
@@ -72,8 +95,8 @@ func (s *AuthService) parseJWT(tokenString string) (*TokenClaims, error) {
 }
 
 // sign genera la firma HMAC-SHA256
-func (s *AuthService) sign(message string) string {
-	h := hmac.New(sha256.New, s.jwtSecret)
+func sign(key []byte, message string) []byte {
+	h := hmac.New(sha256.New, key)
 	h.Write([]byte(message))
-	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+	return h.Sum(nil)
 }
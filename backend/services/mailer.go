@@ -0,0 +1,201 @@
+package services
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Tiempo máximo de conexión y de envío al servidor SMTP
+const (
+	smtpDialTimeout = 10 * time.Second
+	smtpSendTimeout = 30 * time.Second
+)
+
+// Email es un mensaje de texto plano para un usuario
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer envía los emails de la aplicación (verificación, recuperación de contraseña)
+type Mailer interface {
+	Send(msg Email) error
+}
+
+var mailer Mailer
+
+// InitializeMailer configura el envío de emails: por SMTP si hay SMTP_HOST y, si no, en
+// ficheros .eml en MAIL_DIR (o solo en el log) para desarrollo local
+func InitializeMailer() error {
+	from := strings.TrimSpace(os.Getenv("MAIL_FROM"))
+	if from == "" {
+		from = "TrainApp <no-reply@localhost>"
+	}
+	if _, err := mail.ParseAddress(from); err != nil {
+		return fmt.Errorf("MAIL_FROM inválido: %w", err)
+	}
+
+	host := strings.TrimSpace(os.Getenv("SMTP_HOST"))
+	if host == "" {
+		if IsProduction() {
+			fmt.Println("⚠️  SMTP_HOST no configurado: los emails no se envían, solo se guardan en MAIL_DIR")
+		}
+		mailer = &FileMailer{Dir: os.Getenv("MAIL_DIR"), From: from}
+		return nil
+	}
+
+	port := strings.TrimSpace(os.Getenv("SMTP_PORT"))
+	if port == "" {
+		port = "587"
+	}
+	mailer = &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}
+	return nil
+}
+
+// GetMailer retorna el servicio de email configurado
+func GetMailer() Mailer {
+	if mailer == nil {
+		mailer = &FileMailer{From: "TrainApp <no-reply@localhost>"}
+	}
+	return mailer
+}
+
+// formatEmail compone el mensaje con sus cabeceras (asunto codificado para los acentos)
+func formatEmail(from string, msg Email) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// validRecipient comprueba que el destinatario es una sola dirección, sin saltos de línea
+func validRecipient(to string) error {
+	if strings.ContainsAny(to, "\r\n") {
+		return errors.New("destinatario inválido")
+	}
+	if _, err := mail.ParseAddress(to); err != nil {
+		return fmt.Errorf("destinatario inválido: %w", err)
+	}
+	return nil
+}
+
+// SMTPMailer envía los emails por SMTP, con STARTTLS si el servidor lo admite (o TLS directo en
+// el puerto 465)
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg Email) error {
+	if err := validRecipient(msg.To); err != nil {
+		return err
+	}
+	from, _ := mail.ParseAddress(m.From)
+	to, _ := mail.ParseAddress(msg.To)
+
+	address := net.JoinHostPort(m.Host, m.Port)
+	tlsConfig := &tls.Config{ServerName: m.Host}
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+	var conn net.Conn
+	var err error
+	if m.Port == "465" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return fmt.Errorf("error conectando con el servidor SMTP: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(smtpSendTimeout))
+
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error conectando con el servidor SMTP: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("error iniciando TLS: %w", err)
+		}
+	}
+	if m.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return fmt.Errorf("error de autenticación SMTP: %w", err)
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(formatEmail(m.From, msg)); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// FileMailer guarda cada email en un fichero .eml de Dir y lo anota en el log, para desarrollo
+// local; sin Dir solo lo anota en el log
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(msg Email) error {
+	if err := validRecipient(msg.To); err != nil {
+		return err
+	}
+	if m.Dir == "" {
+		log.Printf("📧 Email para %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(m.Dir, fmt.Sprintf("%s.eml", time.Now().Format("20060102-150405.000000000")))
+	if err := os.WriteFile(path, formatEmail(m.From, msg), 0o600); err != nil {
+		return err
+	}
+	log.Printf("📧 Email para %s guardado en %s", msg.To, path)
+	return nil
+}
@@ -0,0 +1,294 @@
+package services
+
+// englishMessages es el catálogo en inglés. Las claves son el texto en español tal y como aparece
+// en el código; los mensajes con causa ("Error generando plan: <error>") se traducen por su prefijo.
+var englishMessages = map[string]string{
+	// Generales
+	"Método no permitido":          "Method not allowed",
+	"Datos inválidos":              "Invalid data",
+	"Formulario inválido":          "Invalid form",
+	"Error leyendo petición":       "Error reading request",
+	"Error interno del servidor":   "Internal server error",
+	"Recurso no encontrado":        "Resource not found",
+	"ID inválido":                  "Invalid ID",
+	"Límite inválido":              "Invalid limit",
+	"Idioma no soportado (es, en)": "Unsupported language (es, en)",
+
+	// Autenticación y usuario
+	"No autorizado - Token requerido":                            "Unauthorized - token required",
+	"Token inválido o expirado":                                  "Invalid or expired token",
+	"Credenciales inválidas":                                     "Invalid credentials",
+	"Todos los campos son requeridos":                            "All fields are required",
+	"Email inválido":                                             "Invalid email",
+	"El email ya está registrado":                                "Email is already registered",
+	"Error creando usuario":                                      "Error creating user",
+	"Error generando token":                                      "Error generating token",
+	"Usuario no encontrado":                                      "User not found",
+	"Error actualizando usuario":                                 "Error updating user",
+	"la contraseña debe tener al menos 8 caracteres":             "the password must be at least 8 characters long",
+	"Error creando sesión":                                       "Error creating session",
+	"Sesión inválida o expirada":                                 "Invalid or expired session",
+	"Error cerrando sesión":                                      "Error closing session",
+	"Error obteniendo sesiones":                                  "Error fetching sessions",
+	"Sesión no encontrada":                                       "Session not found",
+	"Demasiados intentos fallidos. Inténtalo de nuevo más tarde": "Too many failed attempts. Try again later",
+	"Demasiadas peticiones. Inténtalo de nuevo más tarde":        "Too many requests. Try again later",
+	"Error obteniendo intentos de inicio de sesión":              "Error fetching login attempts",
+	"limit debe estar entre 1 y 500":                             "limit must be between 1 and 500",
+	"Token CSRF inválido":                                        "Invalid CSRF token",
+	"Enlace inválido o caducado":                                 "Invalid or expired link",
+	"El email ya está verificado":                                "Email is already verified",
+	"Error enviando email":                                       "Error sending email",
+	"El nuevo email es igual al actual":                          "The new email is the same as the current one",
+	"La contraseña actual no es correcta":                        "The current password is incorrect",
+	"Si el email está registrado, recibirás un enlace para restablecer la contraseña":                                                  "If the email is registered, you will receive a link to reset your password",
+	"Te hemos enviado un enlace para confirmar el nuevo email":                                                                         "We have sent you a link to confirm the new email",
+	"Te hemos enviado un código de confirmación por email":                                                                             "We have sent you a confirmation code by email",
+	"Tu cuenta tiene contraseña: confirma la operación con ella":                                                                       "Your account has a password: confirm the operation with it",
+	"El código de confirmación no es válido o ha caducado":                                                                             "The confirmation code is invalid or has expired",
+	"Tu cuenta no tiene contraseña: pide un código de confirmación por email o crea una contraseña con «¿Has olvidado tu contraseña?»": "Your account has no password: request a confirmation code by email or create a password with “Forgot your password?”",
+	"El 2FA ya está activado":                                              "2FA is already enabled",
+	"El 2FA no está activado":                                              "2FA is not enabled",
+	"Primero hay que iniciar la configuración del 2FA":                     "Start the 2FA setup first",
+	"Código de verificación incorrecto":                                    "Incorrect verification code",
+	"Token de verificación inválido o caducado":                            "Invalid or expired verification token",
+	"Error configurando el 2FA":                                            "Error configuring 2FA",
+	"Clave de API inválida o caducada":                                     "Invalid or expired API key",
+	"Esta ruta no admite claves de API":                                    "This route does not accept API keys",
+	"La clave de API no tiene permiso para esta operación":                 "The API key is not allowed to perform this operation",
+	"Scopes inválidos (workouts:read, workouts:write, coach, strava:sync)": "Invalid scopes (workouts:read, workouts:write, coach, strava:sync)",
+	"expires_in_days debe estar entre 0 y 365":                             "expires_in_days must be between 0 and 365",
+	"El nombre es requerido (máximo 100 caracteres)":                       "A name is required (up to 100 characters)",
+	"Has alcanzado el máximo de claves de API":                             "You have reached the maximum number of API keys",
+	"Error obteniendo claves de API":                                       "Error fetching API keys",
+	"Error creando clave de API":                                           "Error creating API key",
+	"Error actualizando clave de API":                                      "Error updating API key",
+	"Error revocando clave de API":                                         "Error revoking API key",
+	"Clave de API no encontrada":                                           "API key not found",
+
+	// Emails
+	"Confirma tu email en TrainApp": "Confirm your email for TrainApp",
+	"Hola %s,\n\nConfirma tu email abriendo este enlace (caduca en %d horas):\n%s\n\nSi no has creado una cuenta en TrainApp, ignora este mensaje.": "Hi %s,\n\nConfirm your email by opening this link (it expires in %d hours):\n%s\n\nIf you did not create a TrainApp account, ignore this message.",
+	"Confirma tu nuevo email en TrainApp": "Confirm your new email for TrainApp",
+	"Hola %s,\n\nPara usar esta dirección en tu cuenta de TrainApp abre este enlace (caduca en %d horas):\n%s\n\nSi no lo has pedido, ignora este mensaje.": "Hi %s,\n\nTo use this address for your TrainApp account open this link (it expires in %d hours):\n%s\n\nIf you did not request it, ignore this message.",
+	"Tu código de confirmación de TrainApp": "Your TrainApp confirmation code",
+	"Hola %s,\n\nPara confirmar la operación que has pedido en tu cuenta de TrainApp usa este código (caduca en %d minutos y solo vale una vez):\n%s\n\nSi no lo has pedido, ignora este mensaje.": "Hi %s,\n\nTo confirm the operation you requested on your TrainApp account use this code (it expires in %d minutes and can only be used once):\n%s\n\nIf you did not request it, ignore this message.",
+	"El email de tu cuenta de TrainApp ha cambiado": "Your TrainApp account email has changed",
+	"Hola %s,\n\nEl email de tu cuenta de TrainApp es ahora %s.\n\nSi no has sido tú, restablece tu contraseña y escríbenos.": "Hi %s,\n\nThe email of your TrainApp account is now %s.\n\nIf this wasn't you, reset your password and contact us.",
+	"Restablece tu contraseña de TrainApp": "Reset your TrainApp password",
+	"Hola %s,\n\nPara elegir una contraseña nueva abre este enlace (caduca en %d minutos):\n%s\n\nSi no lo has pedido, ignora este mensaje: tu contraseña no cambia.": "Hi %s,\n\nTo choose a new password open this link (it expires in %d minutes):\n%s\n\nIf you did not request it, ignore this message: your password stays the same.",
+	"Tu contraseña de TrainApp ha cambiado": "Your TrainApp password has changed",
+	"Hola %s,\n\nLa contraseña de tu cuenta de TrainApp acaba de cambiar y se han cerrado las demás sesiones.\n\nSi no has sido tú, restablece tu contraseña cuanto antes.": "Hi %s,\n\nThe password of your TrainApp account has just changed and your other sessions have been signed out.\n\nIf this wasn't you, reset your password as soon as possible.",
+	"Verificación en dos pasos activada en TrainApp": "Two-step verification enabled on TrainApp",
+	"Hola %s,\n\nSe ha activado la verificación en dos pasos en tu cuenta de TrainApp. Guarda los códigos de recuperación en un lugar seguro.\n\nSi no has sido tú, restablece tu contraseña cuanto antes.": "Hi %s,\n\nTwo-step verification has been enabled on your TrainApp account. Keep your recovery codes somewhere safe.\n\nIf this wasn't you, reset your password as soon as possible.",
+	"Verificación en dos pasos desactivada en TrainApp": "Two-step verification disabled on TrainApp",
+	"Hola %s,\n\nSe ha desactivado la verificación en dos pasos en tu cuenta de TrainApp.\n\nSi no has sido tú, restablece tu contraseña cuanto antes.": "Hi %s,\n\nTwo-step verification has been disabled on your TrainApp account.\n\nIf this wasn't you, reset your password as soon as possible.",
+	"Tu cuenta de TrainApp se ha borrado": "Your TrainApp account has been deleted",
+	"Hola %s,\n\nTu cuenta de TrainApp y todos sus datos se han borrado, y se ha revocado el acceso a Strava.\n\nSi no has sido tú, ponte en contacto con nosotros cuanto antes.": "Hi %s,\n\nYour TrainApp account and all its data have been deleted, and access to Strava has been revoked.\n\nIf this wasn't you, contact us as soon as possible.",
+
+	// Entrenos
+	"Workout no encontrado":        "Workout not found",
+	"ID de workout inválido":       "Invalid workout ID",
+	"Error creando workout":        "Error creating workout",
+	"Error obteniendo workout":     "Error fetching workout",
+	"Error obteniendo workouts":    "Error fetching workouts",
+	"Error detectando series":      "Error detecting intervals",
+	"Error obteniendo series":      "Error fetching intervals",
+	"Error obteniendo historial":   "Error fetching history",
+	"Error guardando resultado":    "Error saving result",
+	"Error desvinculando workouts": "Error unlinking workouts",
+	"Tipo de entreno inválido (easy, interval, tempo, long_run, race)": "Invalid workout type (easy, interval, tempo, long_run, race)",
+	"Fecha inválida (formato YYYY-MM-DD)":                              "Invalid date (format YYYY-MM-DD)",
+	"La fecha no puede ser futura":                                     "The date cannot be in the future",
+	"Entreno del %s":                                                   "Workout on %s",
+
+	// Capturas y extracción
+	"Se requiere al menos una imagen":     "At least one image is required",
+	"Error leyendo imagen":                "Error reading image",
+	"Imagen inválida":                     "Invalid image",
+	"Imagen no encontrada":                "Image not found",
+	"Imagen %d":                           "Image %d",
+	"Error obteniendo imagen":             "Error fetching image",
+	"Error obteniendo imágenes":           "Error fetching images",
+	"La petición supera el tamaño máximo": "The request exceeds the maximum size",
+	"Máximo %d imágenes por análisis":     "At most %d images per analysis",
+	"%s supera el máximo de %d MB":        "%s exceeds the %d MB limit",
+	"Sube las imágenes directamente (multipart o data URL); no se aceptan URLs externas": "Upload the images directly (multipart or data URL); external URLs are not accepted",
+	"imagen vacía":                   "empty image",
+	"JPEG inválido":                  "invalid JPEG",
+	"JPEG truncado":                  "truncated JPEG",
+	"PNG inválido":                   "invalid PNG",
+	"PNG truncado":                   "truncated PNG",
+	"PNG corrupto":                   "corrupt PNG",
+	"base64 inválido en la data URL": "invalid base64 in the data URL",
+	"data URL inválida (se espera data:image/...;base64,...)":          "invalid data URL (expected data:image/...;base64,...)",
+	"Extracción no encontrada":                                         "Extraction not found",
+	"Error obteniendo extracción":                                      "Error fetching extraction",
+	"Falta la distancia: no se pudo extraer de las capturas, indícala": "Distance is missing: it could not be read from the screenshots, please enter it",
+	"Falta la duración: no se pudo extraer de las capturas, indícala":  "Duration is missing: it could not be read from the screenshots, please enter it",
+
+	// Coach, análisis e informes
+	"Error analizando workout":                                        "Error analyzing workout",
+	"Error procesando pregunta":                                       "Error processing question",
+	"Error generando respuesta":                                       "Error generating response",
+	"Error generando plan":                                            "Error generating plan",
+	"Error generando reporte":                                         "Error generating report",
+	"Error guardando plan":                                            "Error saving plan",
+	"Error guardando reporte":                                         "Error saving report",
+	"Error guardando análisis":                                        "Error saving analysis",
+	"Error actualizando plan":                                         "Error updating plan",
+	"Error desvinculando planes":                                      "Error unlinking plans",
+	"Se requiere un objetivo o una carrera":                           "A goal or a race is required",
+	"Análisis no encontrado":                                          "Analysis not found",
+	"ID de análisis inválido":                                         "Invalid analysis ID",
+	"Error obteniendo análisis":                                       "Error fetching analyses",
+	"Error borrando análisis":                                         "Error deleting analysis",
+	"Informe no encontrado":                                           "Report not found",
+	"Error obteniendo informe":                                        "Error fetching report",
+	"Error obteniendo informes":                                       "Error fetching reports",
+	"Error borrando informe":                                          "Error deleting report",
+	"Error obteniendo consejos":                                       "Error fetching advice",
+	"Error obteniendo avisos":                                         "Error fetching alerts",
+	"Fecha de inicio inválida (formato YYYY-MM-DD)":                   "Invalid start date (format YYYY-MM-DD)",
+	"Fecha de fin inválida (formato YYYY-MM-DD, posterior al inicio)": "Invalid end date (format YYYY-MM-DD, after the start)",
+	"error llamando a chat completions":                               "error calling chat completions",
+	"no hay respuesta del modelo":                                     "the model returned no response",
+
+	// Conversación con el coach
+	"Error obteniendo conversación":                        "Error fetching conversation",
+	"Error borrando conversación":                          "Error deleting conversation",
+	"error cargando la conversación":                       "error loading the conversation",
+	"error resumiendo la conversación":                     "error summarising the conversation",
+	"Resumen de la conversación anterior con el corredor:": "Summary of the earlier conversation with the runner:",
+	"[%d capturas adjuntas]":                               "[%d screenshots attached]",
+	"Corredor":                                             "Runner",
+	"Entrenador":                                           "Coach",
+
+	// Consumo del coach
+	"Has alcanzado el límite diario de uso del coach": "You have reached the daily coach usage limit",
+	"Error obteniendo consumo":                        "Error fetching usage",
+	"Error obteniendo precios de los modelos":         "Error fetching model prices",
+	"Acceso restringido a administradores":            "Access restricted to administrators",
+	"from debe tener formato YYYY-MM-DD":              "from must use the format YYYY-MM-DD",
+	"to debe tener formato YYYY-MM-DD":                "to must use the format YYYY-MM-DD",
+	"to debe ser posterior a from":                    "to must be after from",
+	"user_id inválido":                                "Invalid user_id",
+
+	// Exportación y borrado de la cuenta
+	"Error obteniendo exportaciones":    "Error fetching exports",
+	"Ya hay una exportación en curso":   "An export is already in progress",
+	"Error creando la exportación":      "Error creating the export",
+	"Exportación no encontrada":         "Export not found",
+	"La exportación no está disponible": "The export is not available",
+	"Error borrando la cuenta":          "Error deleting the account",
+	"Eres el único administrador: da el rol a otro usuario antes de borrar tu cuenta": "You are the only administrator: give the role to another user before deleting your account",
+
+	// Administración
+	"Cuenta desactivada":                     "Account disabled",
+	"No disponible en una sesión de soporte": "Not available in a support session",
+	"Error obteniendo usuarios":              "Error fetching users",
+	"offset inválido":                        "Invalid offset",
+	"admin_id inválido":                      "Invalid admin_id",
+	"Ruta no encontrada":                     "Route not found",
+	"Rol inválido (user, admin)":             "Invalid role (user, admin)",
+	"No puedes quitarte el rol de administrador ni desactivar tu propia cuenta":       "You cannot remove your own administrator role or disable your own account",
+	"Indica el motivo del acceso (máximo 500 caracteres)":                             "Give the reason for the access (500 characters max)",
+	"No se puede acceder a la cuenta de un administrador ni a una cuenta desactivada": "Administrator and disabled accounts cannot be accessed",
+	"Error obteniendo el registro de administración":                                  "Error fetching the admin audit log",
+
+	// Propuestas de cambio del plan
+	"Propuesta no encontrada":                       "Proposal not found",
+	"La propuesta ya fue resuelta":                  "The proposal has already been resolved",
+	"Error obteniendo propuesta":                    "Error fetching proposal",
+	"Error obteniendo propuestas":                   "Error fetching proposals",
+	"Error actualizando propuesta":                  "Error updating proposal",
+	"Estado inválido (pending, accepted, rejected)": "Invalid status (pending, accepted, rejected)",
+
+	// Perfil
+	"Error obteniendo perfil":                                                   "Error fetching profile",
+	"Error actualizando perfil":                                                 "Error updating profile",
+	"La edad debe estar entre 10 y 100 años":                                    "Age must be between 10 and 100 years",
+	"Sexo inválido (male, female, other)":                                       "Invalid sex (male, female, other)",
+	"El peso debe estar entre 30 y 200 kg":                                      "Weight must be between 30 and 200 kg",
+	"La altura debe estar entre 120 y 230 cm":                                   "Height must be between 120 and 230 cm",
+	"La FC en reposo debe estar entre 30 y 100 ppm":                             "Resting HR must be between 30 and 100 bpm",
+	"La FC máxima debe estar entre 120 y 230 ppm":                               "Max HR must be between 120 and 230 bpm",
+	"La FC umbral debe estar entre 100 y 220 ppm":                               "Threshold HR must be between 100 and 220 bpm",
+	"La FC umbral debe ser menor que la FC máxima":                              "Threshold HR must be lower than max HR",
+	"La FC en reposo debe ser menor que la FC máxima":                           "Resting HR must be lower than max HR",
+	"El VO2max debe estar entre 20 y 90 ml/kg/min":                              "VO2max must be between 20 and 90 ml/kg/min",
+	"La potencia umbral debe estar entre 0 y 800 W":                             "Threshold power must be between 0 and 800 W",
+	"El objetivo semanal debe estar entre 0 y 300 km":                           "The weekly target must be between 0 and 300 km",
+	"Nivel de entrenamiento inválido (beginner, intermediate, advanced, elite)": "Invalid training level (beginner, intermediate, advanced, elite)",
+	"Los objetivos no pueden superar 2000 caracteres":                           "Goals cannot exceed 2000 characters",
+	"Fecha del objetivo inválida (formato YYYY-MM-DD)":                          "Invalid goal date (format YYYY-MM-DD)",
+	"Ritmo umbral inválido":                                                     "Invalid threshold pace",
+	"Zonas de FC inválidas":                                                     "Invalid HR zones",
+	"formato de ritmo inválido (MM:SS)":                                         "invalid pace format (MM:SS)",
+	"el ritmo debe ser mayor que 0":                                             "the pace must be greater than 0",
+	"como máximo 7 zonas de FC":                                                 "at most 7 HR zones",
+	"las zonas de FC deben numerarse de forma consecutiva desde 1":              "HR zones must be numbered consecutively from 1",
+
+	// Zapatillas
+	"Zapatillas no encontradas":                                            "Shoes not found",
+	"Error obteniendo zapatillas":                                          "Error fetching shoes",
+	"Error creando zapatillas":                                             "Error creating shoes",
+	"Error actualizando zapatillas":                                        "Error updating shoes",
+	"Error eliminando zapatillas":                                          "Error deleting shoes",
+	"Error vinculando zapatillas":                                          "Error linking shoes",
+	"El modelo es requerido":                                               "Model is required",
+	"El umbral de retirada debe ser mayor que 0":                           "The retirement threshold must be greater than 0",
+	"Los km iniciales no pueden ser negativos":                             "Initial km cannot be negative",
+	"Fecha de estreno inválida (formato YYYY-MM-DD)":                       "Invalid first-use date (format YYYY-MM-DD)",
+	"%s ha superado su límite de %.0f km (%.1f km). Considera retirarlas.": "%s has passed its %.0f km limit (%.1f km). Consider retiring them.",
+	"A %s le quedan %.1f km hasta su límite de %.0f km.":                   "%s has %.1f km left before its %.0f km limit.",
+
+	// Carreras
+	"Carrera no encontrada":                                       "Race not found",
+	"Error obteniendo carrera":                                    "Error fetching race",
+	"Error obteniendo carreras":                                   "Error fetching races",
+	"Error creando carrera":                                       "Error creating race",
+	"Error actualizando carrera":                                  "Error updating race",
+	"Error eliminando carrera":                                    "Error deleting race",
+	"El nombre de la carrera es requerido":                        "The race name is required",
+	"La fecha de la carrera es requerida":                         "The race date is required",
+	"La distancia debe ser mayor que 0":                           "Distance must be greater than 0",
+	"Prioridad inválida (A, B o C)":                               "Invalid priority (A, B or C)",
+	"Perfil de recorrido inválido (flat, rolling, hilly o trail)": "Invalid course profile (flat, rolling, hilly or trail)",
+	"Estado inválido (upcoming, completed, dns o dnf)":            "Invalid status (upcoming, completed, dns or dnf)",
+	"Estado inválido (completed, dns o dnf)":                      "Invalid status (completed, dns or dnf)",
+	"Tiempo inválido":                                             "Invalid time",
+	"Tiempo objetivo inválido":                                    "Invalid target time",
+	"formato de tiempo inválido (H:MM:SS)":                        "invalid time format (H:MM:SS)",
+	"el tiempo debe ser mayor que 0":                              "the time must be greater than 0",
+	"minutos y segundos deben ser menores que 60":                 "minutes and seconds must be less than 60",
+
+	// Inicio de sesión con proveedores externos
+	"Proveedor no disponible":                      "Provider not available",
+	"El proveedor de identidad no está disponible": "The identity provider is not available",
+	"El inicio de sesión ha caducado o no se empezó en este navegador: inténtalo de nuevo":                       "The sign-in has expired or was not started in this browser: please try again",
+	"Autorización cancelada en el proveedor":                                                                     "Authorization cancelled at the provider",
+	"No se ha podido completar la autorización con el proveedor":                                                 "Could not complete the authorization with the provider",
+	"Esa cuenta del proveedor ya está vinculada a otro usuario":                                                  "That provider account is already linked to another user",
+	"Error vinculando la cuenta":                                                                                 "Error linking the account",
+	"Ya hay una cuenta con ese email: inicia sesión con tu contraseña y vincula el proveedor desde tu perfil":    "There is already an account with that email: sign in with your password and link the provider from your profile",
+	"El código no es válido o ha caducado":                                                                       "The code is invalid or has expired",
+	"El registro ha caducado: vuelve a iniciar sesión con el proveedor":                                          "The sign-up has expired: sign in with the provider again",
+	"Error obteniendo identidades":                                                                               "Error fetching identities",
+	"Identidad no encontrada":                                                                                    "Identity not found",
+	"Es tu única forma de entrar: crea una contraseña con «¿Has olvidado tu contraseña?» antes de desvincularla": "It is your only way to sign in: create a password with “Forgot your password?” before unlinking it",
+	"Error desvinculando la identidad":                                                                           "Error unlinking the identity",
+
+	// Strava
+	"Strava no está configurado":                               "Strava is not configured",
+	"No hay conexión con Strava. Por favor, autoriza primero.": "Not connected to Strava. Please authorize first.",
+	"Código de autorización no proporcionado":                  "Authorization code not provided",
+	"State parameter inválido":                                 "Invalid state parameter",
+	"Error obteniendo token":                                   "Error fetching token",
+	"Error guardando tokens":                                   "Error saving tokens",
+	"Error refrescando token":                                  "Error refreshing token",
+	"Error actualizando tokens":                                "Error updating tokens",
+	"Error obteniendo actividades":                             "Error fetching activities",
+	"Importado desde Strava: %s":                               "Imported from Strava: %s",
+	"Sincronización completada: %d nuevas, %d ya existentes":   "Sync complete: %d new, %d already imported",
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockIdP es un proveedor OpenID Connect mínimo para pruebas y desarrollo local: publica su
+// configuración, aprueba cualquier autorización para User sin pedir nada, comprueba el secreto
+// del cliente y PKCE, y emite ID tokens sin firmar. No debe usarse fuera de local.
+type MockIdP struct {
+	Issuer       string // URL en la que se sirve, sin barra final
+	ClientID     string
+	ClientSecret string
+	User         MockIdPUser
+
+	mu    sync.Mutex
+	codes map[string]mockIdPGrant
+}
+
+// MockIdPUser es el usuario con el que MockIdP aprueba las autorizaciones
+type MockIdPUser struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name,omitempty"`
+}
+
+// mockIdPGrant es una autorización pendiente de canjear
+type mockIdPGrant struct {
+	redirectURI, nonce, challenge string
+	expires                       time.Time
+}
+
+// ServeHTTP atiende el descubrimiento, la autorización, el endpoint de tokens y userinfo
+func (m *MockIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                           m.Issuer,
+			"authorization_endpoint":           m.Issuer + "/authorize",
+			"token_endpoint":                   m.Issuer + "/token",
+			"userinfo_endpoint":                m.Issuer + "/userinfo",
+			"response_types_supported":         []string{"code"},
+			"code_challenge_methods_supported": []string{"S256"},
+		})
+	case "/authorize":
+		m.authorize(w, r)
+	case "/token":
+		m.token(w, r)
+	case "/userinfo":
+		if r.Header.Get("Authorization") != "Bearer mock-access-"+m.User.Subject {
+			http.Error(w, "invalid_token", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.User)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authorize aprueba la autorización y vuelve a redirect_uri con el código y el state
+func (m *MockIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("redirect_uri") == "" || q.Get("client_id") != m.ClientID || q.Get("response_type") != "code" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid_request: se necesita PKCE S256", http.StatusBadRequest)
+		return
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	code := hex.EncodeToString(b)
+	m.mu.Lock()
+	if m.codes == nil {
+		m.codes = map[string]mockIdPGrant{}
+	}
+	m.codes[code] = mockIdPGrant{
+		redirectURI: q.Get("redirect_uri"),
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		expires:     time.Now().Add(time.Minute),
+	}
+	m.mu.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// token canjea un código (una sola vez) por el ID token y un token de acceso para userinfo
+func (m *MockIdP) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != m.ClientID || secret != m.ClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	m.mu.Lock()
+	grant, found := m.codes[code]
+	delete(m.codes, code)
+	m.mu.Unlock()
+	if !found || time.Now().After(grant.expires) || r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("redirect_uri") != grant.redirectURI || PKCEChallenge(r.PostForm.Get("code_verifier")) != grant.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":            m.Issuer,
+		"sub":            m.User.Subject,
+		"aud":            m.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          grant.nonce,
+		"email":          m.User.Email,
+		"email_verified": m.User.EmailVerified,
+		"name":           m.User.Name,
+	}
+	payload, _ := json.Marshal(claims)
+	encode := base64.RawURLEncoding.EncodeToString
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "mock-access-" + m.User.Subject,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     strings.Join([]string{encode([]byte(`{"alg":"none","typ":"JWT"}`)), encode(payload), ""}, "."),
+	})
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// Proveedores de identidad con los que se puede iniciar sesión
+const (
+	ProviderStrava = "strava"
+	ProviderOIDC   = "oidc"
+)
+
+// OAuthStravaConnect es el flujo de conexión de Strava para sincronizar (no inicia sesión)
+const OAuthStravaConnect = "strava_connect"
+
+// Validez de los tokens de los inicios de sesión con un proveedor externo
+const (
+	OAuthStateTTL     = 10 * time.Minute // del inicio del flujo a la vuelta del proveedor
+	OAuthSignupTTL    = 15 * time.Minute // para completar el alta cuando falta el email
+	OAuthLoginCodeTTL = 2 * time.Minute  // código de un solo uso con el que el frontend abre la sesión
+)
+
+// TokenOAuthLogin es el propósito del código de un solo uso que se canjea por la sesión
+const TokenOAuthLogin = "oauth_login"
+
+const (
+	purposeOAuthState  = "oauth_state"
+	purposeOAuthSignup = "oauth_signup"
+)
+
+// ExternalIdentity es la identidad de un usuario en un proveedor externo
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string // identificador estable del usuario en el proveedor (athlete_id, sub)
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// NewOAuthState genera el parámetro state de un flujo OAuth. Va firmado y caduca; userID es el
+// usuario que vincula su cuenta (0 al iniciar sesión). Su ID sirve de nonce en OIDC.
+func (s *AuthService) NewOAuthState(flow string, userID int) (string, error) {
+	return s.generateToken(TokenClaims{
+		UserID:  userID,
+		Flow:    flow,
+		Purpose: purposeOAuthState,
+	}, OAuthStateTTL)
+}
+
+// ValidateOAuthState comprueba el state que devuelve el proveedor para el flujo
+func (s *AuthService) ValidateOAuthState(state, flow string) (*TokenClaims, error) {
+	claims, err := s.validateClaims(state)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purposeOAuthState || claims.Flow != flow {
+		return nil, errors.New("state inválido")
+	}
+	return claims, nil
+}
+
+// PKCEVerifier deriva del state el code_verifier de PKCE, de modo que no hay que guardarlo y
+// solo el servidor puede calcularlo
+func (s *AuthService) PKCEVerifier(state string) string {
+	return base64.RawURLEncoding.EncodeToString(sign(s.keys[s.activeKID], "pkce\n"+state))
+}
+
+// PKCEChallenge devuelve el code_challenge S256 de un code_verifier
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// GenerateOAuthSignupToken genera el token con el que se completa el alta de una identidad
+// externa que no trae email (Strava no lo da)
+func (s *AuthService) GenerateOAuthSignupToken(identity ExternalIdentity) (string, error) {
+	return s.generateToken(TokenClaims{
+		Name:    identity.Name,
+		Flow:    identity.Provider,
+		Subject: identity.Subject,
+		Purpose: purposeOAuthSignup,
+	}, OAuthSignupTTL)
+}
+
+// ValidateOAuthSignupToken valida un token de alta y devuelve la identidad externa
+func (s *AuthService) ValidateOAuthSignupToken(token string) (*ExternalIdentity, error) {
+	claims, err := s.validateClaims(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purposeOAuthSignup || claims.Flow == "" || claims.Subject == "" {
+		return nil, errors.New("token inválido")
+	}
+	return &ExternalIdentity{Provider: claims.Flow, Subject: claims.Subject, Name: claims.Name}, nil
+}
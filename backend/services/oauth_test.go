@@ -0,0 +1,140 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestOAuthState(t *testing.T) {
+	s := newTestAuthService(t, "k1=secreto-de-prueba", "")
+
+	state, err := s.NewOAuthState(ProviderOIDC, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := s.ValidateOAuthState(state, ProviderOIDC)
+	if err != nil || claims.UserID != 7 || claims.ID == "" {
+		t.Fatalf("state inválido: %+v %v", claims, err)
+	}
+	if _, err := s.ValidateOAuthState(state, ProviderStrava); err == nil {
+		t.Fatal("el state de un flujo no debe valer para otro")
+	}
+	if _, err := s.ValidateToken(state); err == nil {
+		t.Fatal("el state no debe valer como token de acceso")
+	}
+
+	verifier := s.PKCEVerifier(state)
+	if verifier == s.PKCEVerifier(state+"x") || len(verifier) < 43 {
+		t.Fatalf("code_verifier inesperado: %q", verifier)
+	}
+	// BASE64URL(SHA256(verifier)) sin relleno
+	if got := PKCEChallenge("verificador-1"); got != "tIuGdIaRL6soz9ua_A73hUVUpHCe70pVE-O3SohHoy4" {
+		t.Fatalf("code_challenge inesperado: %q", got)
+	}
+
+	token, err := s.GenerateOAuthSignupToken(ExternalIdentity{Provider: ProviderStrava, Subject: "123", Name: "Ana"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := s.ValidateOAuthSignupToken(token)
+	if err != nil || identity.Provider != ProviderStrava || identity.Subject != "123" || identity.Name != "Ana" {
+		t.Fatalf("identidad inesperada: %+v %v", identity, err)
+	}
+	if _, err := s.ValidateOAuthSignupToken(state); err == nil {
+		t.Fatal("el state no debe valer como token de alta")
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	idp := &MockIdP{
+		ClientID:     "trainapp",
+		ClientSecret: "secreto",
+		User:         MockIdPUser{Subject: "u-1", Email: "ana@example.com", EmailVerified: true, Name: "Ana"},
+	}
+	server := httptest.NewServer(idp)
+	defer server.Close()
+	idp.Issuer = server.URL
+
+	t.Setenv("OIDC_ISSUER", server.URL+"/")
+	t.Setenv("OIDC_CLIENT_ID", "trainapp")
+	t.Setenv("OIDC_CLIENT_SECRET", "secreto")
+	t.Setenv("OIDC_NAME", "")
+	t.Setenv("OIDC_SCOPES", "")
+	t.Setenv("OIDC_REDIRECT_URI", "")
+	if err := InitializeOIDC(); err != nil {
+		t.Fatal(err)
+	}
+	p := GetOIDCProvider()
+	if p == nil || p.Name != "OpenID Connect" || strings.Join(p.Scopes, " ") != "openid email profile" {
+		t.Fatalf("proveedor inesperado: %+v", p)
+	}
+
+	// authorize no sigue la redirección: devuelve el código y el state que recibiría la app
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	const redirectURI = "http://app.local/api/auth/oauth/oidc/callback"
+	authorize := func(nonce, verifier string) string {
+		t.Helper()
+		authURL, err := p.AuthorizationURL("st", nonce, PKCEChallenge(verifier), redirectURI)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Get(authURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		location, _ := url.Parse(resp.Header.Get("Location"))
+		if resp.StatusCode != http.StatusFound || location.Query().Get("state") != "st" {
+			t.Fatalf("autorización inesperada: %d %s", resp.StatusCode, location)
+		}
+		return location.Query().Get("code")
+	}
+
+	identity, err := p.Exchange(authorize("n1", "verificador-1"), "verificador-1", redirectURI, "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ExternalIdentity{Provider: ProviderOIDC, Subject: "u-1", Email: "ana@example.com", EmailVerified: true, Name: "Ana"}
+	if *identity != want {
+		t.Fatalf("identidad inesperada: %+v", identity)
+	}
+
+	// Nonce de otra autorización, code_verifier incorrecto, código ya usado y secreto incorrecto
+	if _, err := p.Exchange(authorize("n1", "verificador-1"), "verificador-1", redirectURI, "n2"); err == nil {
+		t.Fatal("debe rechazar un nonce distinto")
+	}
+	if _, err := p.Exchange(authorize("n1", "verificador-1"), "verificador-2", redirectURI, "n1"); err == nil {
+		t.Fatal("debe rechazar un code_verifier incorrecto")
+	}
+	code := authorize("n1", "verificador-1")
+	if _, err := p.Exchange(code, "verificador-1", redirectURI, "n1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Exchange(code, "verificador-1", redirectURI, "n1"); err == nil {
+		t.Fatal("un código no debe valer dos veces")
+	}
+	p.ClientSecret = "otro"
+	if _, err := p.Exchange(authorize("n1", "verificador-1"), "verificador-1", redirectURI, "n1"); err == nil {
+		t.Fatal("debe rechazar un secreto de cliente incorrecto")
+	}
+}
+
+func TestOIDCAudience(t *testing.T) {
+	for _, tc := range []struct {
+		aud  string
+		want bool
+	}{
+		{`"trainapp"`, true},
+		{`["otra","trainapp"]`, true},
+		{`"otra"`, false},
+		{`["otra"]`, false},
+	} {
+		c := oidcClaims{Audience: []byte(tc.aud)}
+		if got := c.hasAudience("trainapp"); got != tc.want {
+			t.Errorf("aud %s: %v, se esperaba %v", tc.aud, got, tc.want)
+		}
+	}
+}
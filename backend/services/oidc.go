@@ -0,0 +1,293 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcDiscoveryTTL es cuánto se guarda la configuración publicada por el proveedor
+const oidcDiscoveryTTL = time.Hour
+
+// OIDCProvider es un proveedor OpenID Connect genérico (Google, Microsoft, Keycloak...) con el
+// flujo authorization code + PKCE
+type OIDCProvider struct {
+	Name         string // nombre que se muestra en el botón
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string // vacío: /api/auth/oauth/oidc/callback en la URL pública
+	Scopes       []string
+
+	httpClient *http.Client
+	mu         sync.Mutex
+	discovery  *oidcDiscovery
+	fetchedAt  time.Time
+}
+
+// oidcDiscovery es la parte que se usa de /.well-known/openid-configuration
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// oidcClaims son los claims del ID token y de userinfo que se usan
+type oidcClaims struct {
+	Issuer        string          `json:"iss"`
+	Subject       string          `json:"sub"`
+	Audience      json.RawMessage `json:"aud"` // texto o lista
+	Exp           int64           `json:"exp"`
+	Nonce         string          `json:"nonce"`
+	Email         string          `json:"email"`
+	EmailVerified interface{}     `json:"email_verified"` // algunos proveedores lo envían como texto
+	Name          string          `json:"name"`
+}
+
+// hasAudience indica si el token va dirigido a clientID
+func (c *oidcClaims) hasAudience(clientID string) bool {
+	var single string
+	if json.Unmarshal(c.Audience, &single) == nil {
+		return single == clientID
+	}
+	var list []string
+	if json.Unmarshal(c.Audience, &list) == nil {
+		for _, aud := range list {
+			if aud == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// emailVerified interpreta email_verified, que puede ser booleano o texto
+func (c *oidcClaims) emailVerified() bool {
+	switch v := c.EmailVerified.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+var oidcProvider *OIDCProvider
+
+// InitializeOIDC configura el proveedor OIDC desde OIDC_ISSUER, OIDC_CLIENT_ID,
+// OIDC_CLIENT_SECRET, OIDC_REDIRECT_URI, OIDC_SCOPES y OIDC_NAME. Sin OIDC_ISSUER no hay
+// proveedor.
+func InitializeOIDC() error {
+	oidcProvider = nil
+	issuer := strings.TrimRight(strings.TrimSpace(os.Getenv("OIDC_ISSUER")), "/")
+	if issuer == "" {
+		return nil
+	}
+
+	provider := &OIDCProvider{
+		Name:         strings.TrimSpace(os.Getenv("OIDC_NAME")),
+		Issuer:       issuer,
+		ClientID:     strings.TrimSpace(os.Getenv("OIDC_CLIENT_ID")),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURI:  strings.TrimSpace(os.Getenv("OIDC_REDIRECT_URI")),
+		Scopes:       strings.Fields(strings.ReplaceAll(os.Getenv("OIDC_SCOPES"), ",", " ")),
+	}
+	if provider.ClientID == "" {
+		return errors.New("OIDC_ISSUER necesita OIDC_CLIENT_ID")
+	}
+	if provider.Name == "" {
+		provider.Name = "OpenID Connect"
+	}
+	if len(provider.Scopes) == 0 {
+		provider.Scopes = []string{"openid", "email", "profile"}
+	}
+	oidcProvider = provider
+	fmt.Printf("🔐 Inicio de sesión con %s (%s)\n", provider.Name, provider.Issuer)
+	return nil
+}
+
+// GetOIDCProvider devuelve el proveedor OIDC configurado o nil
+func GetOIDCProvider() *OIDCProvider {
+	return oidcProvider
+}
+
+// client devuelve el cliente HTTP de las llamadas al proveedor
+func (p *OIDCProvider) client() *http.Client {
+	if p.httpClient != nil {
+		return p.httpClient
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// discover obtiene (y guarda durante una hora) la configuración del proveedor
+func (p *OIDCProvider) discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil && time.Since(p.fetchedAt) < oidcDiscoveryTTL {
+		return p.discovery, nil
+	}
+
+	resp, err := p.client().Get(p.Issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo la configuración OIDC: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("configuración OIDC: HTTP %d", resp.StatusCode)
+	}
+
+	var discovery oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, fmt.Errorf("error decodificando la configuración OIDC: %v", err)
+	}
+	if strings.TrimRight(discovery.Issuer, "/") != p.Issuer || discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" {
+		return nil, errors.New("configuración OIDC incompleta o de otro emisor")
+	}
+	p.discovery, p.fetchedAt = &discovery, time.Now()
+	return p.discovery, nil
+}
+
+// AuthorizationURL devuelve la URL del proveedor a la que se envía al usuario
+func (p *OIDCProvider) AuthorizationURL(state, nonce, codeChallenge, redirectURI string) (string, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.ClientID)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("scope", strings.Join(p.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange canjea el código de autorización y devuelve la identidad del ID token. El token llega
+// directamente del endpoint de tokens por TLS, así que según OIDC Core (3.1.3.7) basta con
+// comprobar emisor, destinatario, caducidad y nonce sin verificar su firma.
+func (p *OIDCProvider) Exchange(code, codeVerifier, redirectURI, nonce string) (*ExternalIdentity, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("code", code)
+	data.Set("redirect_uri", redirectURI)
+	data.Set("code_verifier", codeVerifier)
+	req, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error canjeando el código: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("error del proveedor: %s", string(body))
+	}
+
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("error decodificando los tokens: %v", err)
+	}
+
+	claims, err := decodeIDToken(tokens.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(claims.Issuer, "/") != p.Issuer || !claims.hasAudience(p.ClientID) {
+		return nil, errors.New("ID token de otro emisor o destinatario")
+	}
+	if time.Now().Unix() > claims.Exp+jwtClockSkew {
+		return nil, errors.New("ID token caducado")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("nonce del ID token inválido")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("ID token sin sub")
+	}
+
+	// Algunos proveedores solo dan el email en userinfo
+	if claims.Email == "" && discovery.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		if info, err := p.userinfo(discovery.UserinfoEndpoint, tokens.AccessToken); err == nil && info.Subject == claims.Subject {
+			claims.Email, claims.EmailVerified = info.Email, info.EmailVerified
+			if claims.Name == "" {
+				claims.Name = info.Name
+			}
+		}
+	}
+
+	return &ExternalIdentity{
+		Provider:      ProviderOIDC,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.emailVerified(),
+		Name:          claims.Name,
+	}, nil
+}
+
+// userinfo obtiene los claims del endpoint userinfo
+func (p *OIDCProvider) userinfo(endpoint, accessToken string) (*oidcClaims, error) {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("userinfo: HTTP %d", resp.StatusCode)
+	}
+	var claims oidcClaims
+	return &claims, json.NewDecoder(resp.Body).Decode(&claims)
+}
+
+// decodeIDToken decodifica los claims de un ID token (sin verificar la firma, ver Exchange)
+func decodeIDToken(idToken string) (*oidcClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("ID token inválido")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, errors.New("ID token inválido")
+	}
+	var claims oidcClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("ID token inválido")
+	}
+	return &claims, nil
+}
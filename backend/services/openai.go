@@ -3,15 +3,21 @@ package services
 import (
 	"context"
 	"fmt"
+	"log"
 	"os"
+	"strings"
+
+	"trainapp/models"
 
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
 )
 
+// CoachModel es el modelo usado por el coach; se guarda con cada análisis e informe
+const CoachModel = "gpt-5.1"
+
 var client *openai.Client
 var workflowID string
-var conversationHistory []openai.ChatCompletionMessageParamUnion
 
 // InitializeOpenAI inicializa el cliente de OpenAI
 func InitializeOpenAI() {
@@ -25,22 +31,12 @@ func InitializeOpenAI() {
 		panic("OPENAI_ASSISTANT_ID (workflow ID) no está configurada")
 	}
 
-	client = openai.NewClient(option.WithAPIKey(apiKey))
-
-	// Inicializar historial de conversación con contexto del sistema
-	if len(conversationHistory) == 0 {
-		conversationHistory = []openai.ChatCompletionMessageParamUnion{
-			openai.SystemMessage(`Eres un entrenador personal de running experto. Tienes acceso a:
-1. Mi perfil completo de corredor (perfil_corredor.md) con datos biométricos y objetivos
-2. Mis entrenamientos de septiembre, octubre y noviembre 2024
-
-Usa esta información para personalizar tus recomendaciones, análisis y planes de entrenamiento. Mantén el contexto de conversaciones previas para dar seguimiento coherente.`),
-		}
-	}
+	// Los reintentos los gestiona chatCompletion (con modelo de respaldo)
+	client = openai.NewClient(option.WithAPIKey(apiKey), option.WithMaxRetries(0))
 }
 
 // AnalyzeWorkoutWithImages analiza un entreno con capturas de Apple Watch
-func AnalyzeWorkoutWithImages(imageURLs []string, notes string) (string, error) {
+func AnalyzeWorkoutWithImages(session CoachSession, imageURLs []string, notes string) (string, error) {
 	if client == nil {
 		InitializeOpenAI()
 	}
@@ -49,31 +45,9 @@ func AnalyzeWorkoutWithImages(imageURLs []string, notes string) (string, error)
 	var parts []openai.ChatCompletionContentPartUnionParam
 
 	// Añadir texto
-	prompt := `Analiza este entrenamiento a partir de la(s) captura(s) del Apple Watch.`
-	if notes != "" {
-		prompt = fmt.Sprintf(`Analiza este entrenamiento a partir de la(s) captura(s) del Apple Watch.
-
-Notas adicionales: %s
-
-Por favor:
-1. Extrae de la captura: tipo de sesión, distancia, tiempo, ritmo, FC, y cualquier otra métrica visible.
-2. Consulta mi perfil (perfil_corredor) y mi historial reciente (septiembre, octubre, noviembre).
-3. Evalúa si este entreno encaja con mi objetivo y carga reciente.
-4. Identifica posibles riesgos (fatiga, sobrecarga).
-5. Dame recomendaciones concretas para las próximas 24-48 horas.
-
-Sé específico y accionable.`, notes)
-	} else {
-		prompt = `Analiza este entrenamiento a partir de la(s) captura(s) del Apple Watch.
-
-Por favor:
-1. Extrae de la captura: tipo de sesión, distancia, tiempo, ritmo, FC, y cualquier otra métrica visible.
-2. Consulta mi perfil (perfil_corredor) y mi historial reciente (septiembre, octubre, noviembre).
-3. Evalúa si este entreno encaja con mi objetivo y carga reciente.
-4. Identifica posibles riesgos (fatiga, sobrecarga).
-5. Dame recomendaciones concretas para las próximas 24-48 horas.
-
-Sé específico y accionable.`
+	prompt, err := renderPrompt(PromptImageAnalysis, session.Locale, map[string]interface{}{"Notes": notes})
+	if err != nil {
+		return "", err
 	}
 
 	parts = append(parts, openai.TextPart(prompt))
@@ -83,184 +57,246 @@ Sé específico y accionable.`
 		parts = append(parts, openai.ImagePart(imageURL))
 	}
 
-	ctx := context.Background()
-
-	// Añadir mensaje con partes al historial
-	conversationHistory = append(conversationHistory, openai.UserMessageParts(parts...))
+	// Las capturas solo se envían en esta llamada; en el historial queda el texto
+	stored := prompt + "\n\n" + T(session.Locale, "[%d capturas adjuntas]", len(imageURLs))
+	return converse(session, PromptImageAnalysis, openai.UserMessageParts(parts...), stored)
+}
 
-	// Llamar a la API
-	response, err := client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Model:    openai.F("gpt-5.1"),
-		Messages: openai.F(conversationHistory),
-	})
-	if err != nil {
-		return "", fmt.Errorf("error llamando a chat completions: %v", err)
+// CreateWeeklyPlan genera un plan de entrenamiento semanal basado en el contexto previo
+func CreateWeeklyPlan(session CoachSession) (string, error) {
+	if client == nil {
+		InitializeOpenAI()
 	}
 
-	if len(response.Choices) == 0 {
-		return "", fmt.Errorf("no hay respuesta del modelo")
+	prompt, err := renderPrompt(PromptWeeklyPlan, session.Locale, nil)
+	if err != nil {
+		return "", err
 	}
 
-	assistantResponse := response.Choices[0].Message.Content
-
-	// Añadir respuesta al historial
-	conversationHistory = append(conversationHistory, openai.AssistantMessage(assistantResponse))
-
-	return assistantResponse, nil
+	return runAssistant(session, PromptWeeklyPlan, prompt)
 }
 
-// CreateWeeklyPlan genera un plan de entrenamiento semanal basado en el contexto previo
-func CreateWeeklyPlan() (string, error) {
+// CreateTrainingPlan solicita al agente crear un plan de entrenamiento.
+// Si hay carrera objetivo, el plan se organiza en los bloques de periodización calculados hacia atrás desde ella;
+// calendar contiene el resto de carreras previstas para encajarlas en el plan.
+func CreateTrainingPlan(session CoachSession, goal string, race *models.Race, calendar []models.Race, blocks []models.TrainingBlock) (string, error) {
 	if client == nil {
 		InitializeOpenAI()
 	}
 
-	prompt := `Necesito el plan de entrenamiento para esta semana.
-
-Por favor:
-1. Consulta mi perfil (perfil_corredor) y mis entrenamientos recientes (septiembre, octubre, noviembre).
-2. Considera el contexto de nuestras conversaciones previas en este hilo.
-3. Diseña un microciclo de 7 días adaptado a mi nivel, carga reciente y progresión.
-4. Especifica para cada día:
-   - Tipo de entreno (rodaje suave, series, tempo, tirada larga, técnica, descanso)
-   - Distancia o duración aproximada
-   - Ritmos objetivo o zonas de FC
-   - Objetivo específico de la sesión
+	data := map[string]interface{}{"Goal": goal, "Race": race, "Blocks": "", "OtherRaces": []models.Race{}}
+	if race != nil {
+		otherRaces := []models.Race{}
+		for _, r := range calendar {
+			if r.ID != race.ID {
+				otherRaces = append(otherRaces, r)
+			}
+		}
+		data["Blocks"] = FormatBlocksForPrompt(blocks)
+		data["OtherRaces"] = otherRaces
+	}
 
-Estructura el plan de forma clara y accionable para que pueda seguirlo día a día.`
+	prompt, err := renderPrompt(PromptTrainingPlan, session.Locale, data)
+	if err != nil {
+		return "", err
+	}
 
-	return runAssistant(prompt)
+	return runAssistant(session, PromptTrainingPlan, prompt)
 }
 
-// CreateTrainingPlan solicita al agente crear un plan de entrenamiento
-func CreateTrainingPlan(userInfo map[string]interface{}, goal string) (string, error) {
+// AnalyzeWorkout solicita al agente analizar un entreno
+func AnalyzeWorkout(session CoachSession, workoutData map[string]interface{}) (string, error) {
 	if client == nil {
 		InitializeOpenAI()
 	}
 
-	// El agente ya tiene acceso al perfil_corredor.md y los resúmenes mensuales via File Search
-	prompt := fmt.Sprintf(`Necesito un plan de entrenamiento semanal.
-
-Objetivo: %s
-
-Por favor:
-1. Consulta mi perfil (perfil_corredor) y los resúmenes de entrenamientos recientes (septiembre, octubre, noviembre).
-2. Diseña un microciclo de 7 días adaptado a mi nivel y carga reciente.
-3. Especifica para cada día: tipo de entreno, distancia/duración, ritmos objetivo o zonas de FC, y objetivo de la sesión.
+	// Añadir el desglose de series si se detectaron repeticiones
+	intervals := ""
+	if reps, ok := workoutData["intervals"].([]models.WorkoutInterval); ok && len(reps) > 0 {
+		intervals = FormatIntervalsForPrompt(reps)
+	}
 
-Estructura el plan de forma clara y accionable.`, goal)
+	prompt, err := renderPrompt(PromptWorkoutAnalysis, session.Locale, map[string]interface{}{"Workout": workoutData, "Intervals": intervals})
+	if err != nil {
+		return "", err
+	}
 
-	return runAssistant(prompt)
+	return runAssistant(session, PromptWorkoutAnalysis, prompt)
 }
 
-// AnalyzeWorkout solicita al agente analizar un entreno
-func AnalyzeWorkout(workoutData map[string]interface{}) (string, error) {
+// GenerateProgressReport solicita al agente generar un informe de progreso.
+// El coach consulta los entrenos del período con sus herramientas en lugar de recibirlos en el prompt.
+func GenerateProgressReport(session CoachSession, periodStart, periodEnd string) (string, error) {
 	if client == nil {
 		InitializeOpenAI()
 	}
 
-	// Formatear datos del entreno de forma legible
-	prompt := fmt.Sprintf(`Analiza esta sesión de entrenamiento:
-
-📅 Fecha: %v
-🏃 Tipo: %v
-📏 Distancia: %.2f km
-⏱️ Duración: %v minutos
-⚡ Ritmo medio: %v
-❤️ FC media: %v bpm
-💪 Potencia media: %v W
-👣 Cadencia: %v ppm
-⛰️ Desnivel +: %v m
-😊 Sensación: %v
-📝 Notas: %v
-
-Por favor:
-1. Consulta mi perfil (perfil_corredor) y mi historial reciente (septiembre, octubre, noviembre).
-2. Evalúa si este entreno encaja con mi objetivo y carga reciente.
-3. Identifica posibles riesgos (fatiga, sobrecarga).
-4. Dame recomendaciones concretas para las próximas 24-48 horas.
-
-Sé específico y accionable.`,
-		workoutData["date"],
-		workoutData["type"],
-		workoutData["distance"],
-		workoutData["duration"],
-		workoutData["avg_pace"],
-		workoutData["avg_heart_rate"],
-		workoutData["avg_power"],
-		workoutData["cadence"],
-		workoutData["elevation_gain"],
-		workoutData["feeling"],
-		workoutData["notes"])
-
-	return runAssistant(prompt)
+	prompt, err := renderPrompt(PromptProgressReport, session.Locale, map[string]interface{}{
+		"PeriodStart": periodStart,
+		"PeriodEnd":   periodEnd,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return runAssistant(session, PromptProgressReport, prompt)
 }
 
-// GenerateProgressReport solicita al agente generar un informe de progreso
-func GenerateProgressReport(workouts []map[string]interface{}, period string) (string, error) {
+// runAssistant envía un mensaje de texto al coach dentro de la conversación del usuario
+func runAssistant(session CoachSession, operation, message string) (string, error) {
 	if client == nil {
 		InitializeOpenAI()
 	}
 
-	// Formatear entrenamientos del período
-	var workoutsSummary string
-	for _, w := range workouts {
-		workoutsSummary += fmt.Sprintf("\n- %v: %v, %.2f km, %v min, ritmo %v, FC %v bpm",
-			w["date"], w["type"], w["distance"], w["duration"], w["avg_pace"], w["avg_heart_rate"])
-	}
+	return converse(session, operation, openai.UserMessage(message), message)
+}
 
-	prompt := fmt.Sprintf(`Necesito un informe de progreso.
+// converse envía un mensaje al coach con el historial del usuario y guarda la pregunta (como
+// stored) y la respuesta. La ficha del corredor se envía en cada llamada sin guardarse en el
+// historial, y las rondas de herramientas tampoco. Antes de la llamada, si el historial supera
+// su presupuesto de tokens, los mensajes antiguos se integran en el resumen de la conversación.
+func converse(session CoachSession, operation string, userMessage openai.ChatCompletionMessageParamUnion, stored string) (string, error) {
+	defer lockConversation(session.UserID)()
 
-Período analizado: %s
+	store := session.conversationStore()
+	conversation, err := store.LoadConversation(session.UserID)
+	if err != nil {
+		return "", fmt.Errorf("error cargando la conversación: %v", err)
+	}
 
-Entrenamientos del período:%s
+	ctx, cancel := session.deadline()
+	defer cancel()
+
+	budget := HistoryTokenBudget()
+	tokensBefore := conversation.Tokens()
+	if err := conversation.Compact(budget, func(summary string, turns []ConversationTurn) (string, error) {
+		return summarizeConversation(ctx, session, summary, turns)
+	}); err != nil {
+		if ctx.Err() != nil {
+			// La petición se canceló: no se pierde historial por un resumen que no llegó a hacerse
+			return "", fmt.Errorf("error resumiendo la conversación: %w", ctx.Err())
+		}
+		log.Printf("⚠️  Error resumiendo la conversación del usuario %d (se descartan los mensajes antiguos): %v", session.UserID, err)
+	}
+	if conversation.Tokens() != tokensBefore {
+		// El historial compactado se guarda aunque la llamada falle para no repetir el resumen
+		if err := store.SaveConversation(conversation); err != nil {
+			log.Printf("⚠️  Error guardando la conversación del usuario %d: %v", session.UserID, err)
+		}
+	}
 
-Por favor:
-1. Consulta mi perfil (perfil_corredor) y mis resúmenes históricos (septiembre, octubre, noviembre).
-2. Compara estas últimas semanas con el período anterior.
-3. Evalúa: volumen, intensidad, evolución de ritmos y FC, señales de mejora o fatiga.
-4. Propón ajustes de volumen e intensidad para las próximas 2 semanas.
-5. Identifica 2-3 focos clave en los que debo trabajar.
+	// La pregunta solo se añade al historial junto con su respuesta: si la llamada falla la
+	// conversación queda como estaba
+	messages := append(contextMessages(session, conversation), userMessage)
+	assistantResponse, err := completeWithTools(ctx, session, operation, messages)
+	if err != nil {
+		return "", err
+	}
 
-Estructura el informe de forma clara con secciones.`, period, workoutsSummary)
+	conversation.Append(stored, assistantResponse)
+	if err := store.SaveConversation(conversation); err != nil {
+		log.Printf("⚠️  Error guardando la conversación del usuario %d: %v", session.UserID, err)
+	}
 
-	return runAssistant(prompt)
+	return assistantResponse, nil
 }
 
-// runAssistant ejecuta el asistente de OpenAI con un mensaje de texto en el thread persistente
-func runAssistant(message string) (string, error) {
-	if client == nil {
-		InitializeOpenAI()
+// summarizeConversation integra los mensajes en el resumen anterior con una llamada sin
+// herramientas ni historial
+func summarizeConversation(ctx context.Context, session CoachSession, summary string, turns []ConversationTurn) (string, error) {
+	prompt, err := renderPrompt(PromptConversationSummary, session.Locale, map[string]interface{}{
+		"Summary":    summary,
+		"Transcript": transcript(turns, session.Locale),
+	})
+	if err != nil {
+		return "", err
 	}
 
-	ctx := context.Background()
-
-	// Añadir mensaje del usuario al historial
-	conversationHistory = append(conversationHistory, openai.UserMessage(message))
-
-	// Llamar a la API de Chat Completions
-	response, err := client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Model:    openai.F("gpt-5.1"),
-		Messages: openai.F(conversationHistory),
+	response, err := chatCompletion(ctx, session, PromptConversationSummary, openai.ChatCompletionNewParams{
+		Model:    openai.F(CoachModel),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessage(prompt)}),
 	})
 	if err != nil {
-		return "", fmt.Errorf("error llamando a chat completions: %v", err)
+		return "", err
 	}
-
-	if len(response.Choices) == 0 {
+	if len(response.Choices) == 0 || strings.TrimSpace(response.Choices[0].Message.Content) == "" {
 		return "", fmt.Errorf("no hay respuesta del modelo")
 	}
+	return strings.TrimSpace(response.Choices[0].Message.Content), nil
+}
 
-	assistantResponse := response.Choices[0].Message.Content
+// completeWithTools llama al modelo y ejecuta las herramientas que solicite hasta obtener una
+// respuesta. Tras MaxToolSteps rondas se obliga al modelo a responder sin más herramientas.
+func completeWithTools(ctx context.Context, session CoachSession, operation string, messages []openai.ChatCompletionMessageParamUnion) (string, error) {
+	tools := session.toolParams()
+	maxSteps := MaxToolSteps()
 
-	// Añadir respuesta al historial
-	conversationHistory = append(conversationHistory, openai.AssistantMessage(assistantResponse))
+	for step := 0; ; step++ {
+		params := openai.ChatCompletionNewParams{
+			Model:    openai.F(CoachModel),
+			Messages: openai.F(messages),
+		}
+		if len(tools) > 0 {
+			params.Tools = openai.F(tools)
+			if step >= maxSteps {
+				params.ToolChoice = openai.F[openai.ChatCompletionToolChoiceOptionUnionParam](
+					openai.ChatCompletionToolChoiceOptionString(openai.ChatCompletionToolChoiceOptionStringNone))
+			}
+		}
 
-	return assistantResponse, nil
+		response, err := chatCompletion(ctx, session, operation, params)
+		if err != nil {
+			return "", err
+		}
+
+		if len(response.Choices) == 0 {
+			return "", fmt.Errorf("no hay respuesta del modelo")
+		}
+
+		message := response.Choices[0].Message
+		if len(message.ToolCalls) == 0 || step >= maxSteps {
+			return message.Content, nil
+		}
+
+		// Ejecutar las herramientas y devolver los resultados al modelo
+		messages = append(messages, message)
+		for _, call := range message.ToolCalls {
+			result := session.runTool(step+1, call.Function.Name, call.Function.Arguments)
+			messages = append(messages, openai.ToolMessage(call.ID, result))
+		}
+	}
 }
 
-// ContinueConversation permite continuar la conversación con el contexto previo
-func ContinueConversation(message string) (string, error) {
+// ContinueConversation permite continuar la conversación con el contexto previo.
+// Con las herramientas de la sesión el coach puede consultar los datos reales para responder.
+func ContinueConversation(session CoachSession, message string) (string, error) {
 	// Usa la misma función runAssistant que mantiene el historial
-	return runAssistant(message)
+	return runAssistant(session, OperationFollowUp, message)
+}
+
+// contextMessages construye los mensajes previos a la pregunta: el mensaje de sistema en el
+// idioma del corredor, su ficha, el resumen de la conversación y los mensajes recientes
+func contextMessages(session CoachSession, conversation *Conversation) []openai.ChatCompletionMessageParamUnion {
+	messages := make([]openai.ChatCompletionMessageParamUnion, 0, len(conversation.Turns)+3)
+
+	if system, err := renderPrompt(PromptCoachSystem, session.Locale, nil); err == nil {
+		messages = append(messages, openai.SystemMessage(system))
+	} else {
+		log.Printf("⚠️  Error generando el prompt de sistema: %v", err)
+	}
+	if session.RunnerContext != "" {
+		messages = append(messages, openai.SystemMessage(session.RunnerContext))
+	}
+	if conversation.Summary != "" {
+		messages = append(messages, openai.SystemMessage(T(session.Locale, "Resumen de la conversación anterior con el corredor:")+"\n"+conversation.Summary))
+	}
+
+	for _, turn := range conversation.Turns {
+		if turn.Role == RoleAssistant {
+			messages = append(messages, openai.AssistantMessage(turn.Content))
+		} else {
+			messages = append(messages, openai.UserMessage(turn.Content))
+		}
+	}
+	return messages
 }
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"trainapp/models"
+)
+
+// Valores permitidos para los campos del perfil de corredor
+var (
+	TrainingLevels = []string{"beginner", "intermediate", "advanced", "elite"}
+	RunnerSexes    = []string{"male", "female", "other"}
+)
+
+// Porcentajes de la FC umbral (LTHR) que delimitan las zonas, según Friel para carrera
+var hrZoneBounds = []struct {
+	name string
+	min  float64
+}{
+	{"Recuperación", 0},
+	{"Aeróbico", 0.85},
+	{"Tempo", 0.90},
+	{"Umbral", 0.95},
+	{"VO2max", 1.00},
+}
+
+// DefaultHRZones calcula cinco zonas de FC a partir de la FC umbral (o, si no hay,
+// estimándola como el 90% de la FC máxima). Devuelve nil si no hay datos suficientes.
+func DefaultHRZones(lthr, maxHR int) []models.HRZone {
+	if lthr <= 0 && maxHR > 0 {
+		lthr = int(float64(maxHR)*0.9 + 0.5)
+	}
+	if lthr <= 0 {
+		return nil
+	}
+
+	zones := make([]models.HRZone, len(hrZoneBounds))
+	for i, bound := range hrZoneBounds {
+		zones[i] = models.HRZone{
+			Zone: i + 1,
+			Name: bound.name,
+			Min:  int(float64(lthr)*bound.min + 0.5),
+		}
+		if i > 0 {
+			zones[i-1].Max = zones[i].Min - 1
+		}
+	}
+	// Con una FC máxima por debajo de la última zona (datos incoherentes) la zona queda abierta
+	if last := &zones[len(zones)-1]; maxHR >= last.Min {
+		last.Max = maxHR
+	}
+
+	return zones
+}
+
+// ValidateHRZones comprueba que las zonas sean consecutivas, con límites ascendentes y sin
+// solaparse (solo la última puede quedar sin límite superior)
+func ValidateHRZones(zones []models.HRZone) error {
+	if len(zones) == 0 {
+		return nil
+	}
+	if len(zones) > 7 {
+		return errors.New("como máximo 7 zonas de FC")
+	}
+
+	for i, z := range zones {
+		if z.Zone != i+1 {
+			return errors.New("las zonas de FC deben numerarse de forma consecutiva desde 1")
+		}
+		if z.Min < 0 || z.Min > 230 || z.Max < 0 || z.Max > 230 {
+			return fmt.Errorf("zona %d: límites de FC fuera de rango (0-230)", z.Zone)
+		}
+		if z.Max != 0 && z.Max < z.Min {
+			return fmt.Errorf("zona %d: el máximo debe ser mayor que el mínimo", z.Zone)
+		}
+		if i > 0 && z.Min <= zones[i-1].Min {
+			return fmt.Errorf("zona %d: los límites deben ser ascendentes", z.Zone)
+		}
+		if i > 0 && (zones[i-1].Max == 0 || z.Min <= zones[i-1].Max) {
+			return fmt.Errorf("zona %d: se solapa con la zona %d", z.Zone, zones[i-1].Zone)
+		}
+	}
+
+	return nil
+}
+
+// ParsePace convierte un ritmo MM:SS (min/km) a segundos por km
+func ParsePace(value string) (int, error) {
+	var min, sec int
+	if _, err := fmt.Sscanf(value, "%d:%d", &min, &sec); err != nil || min < 0 || sec < 0 || sec >= 60 {
+		return 0, errors.New("formato de ritmo inválido (MM:SS)")
+	}
+	if min*60+sec == 0 {
+		return 0, errors.New("el ritmo debe ser mayor que 0")
+	}
+	return min*60 + sec, nil
+}
+
+// BiometricsChanged indica si cambió algún dato biométrico entre dos versiones del perfil
+func BiometricsChanged(before, after models.RunnerProfile) bool {
+	return before.Weight != after.Weight ||
+		before.RestingHR != after.RestingHR ||
+		before.MaxHR != after.MaxHR ||
+		before.LTHR != after.LTHR ||
+		before.VO2max != after.VO2max ||
+		before.ThresholdPace != after.ThresholdPace ||
+		before.ThresholdPower != after.ThresholdPower
+}
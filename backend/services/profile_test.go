@@ -0,0 +1,137 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	"trainapp/models"
+)
+
+func TestDefaultHRZones(t *testing.T) {
+	bounds := func(zones []models.HRZone) string {
+		s := ""
+		for _, z := range zones {
+			s += fmt.Sprintf("Z%d %d-%d ", z.Zone, z.Min, z.Max)
+		}
+		return s
+	}
+
+	cases := []struct {
+		name        string
+		lthr, maxHR int
+		want        string
+	}{
+		{"con FC umbral", 170, 190, "Z1 0-144 Z2 145-152 Z3 153-161 Z4 162-169 Z5 170-190 "},
+		{"estimada con la FC máxima", 0, 200, "Z1 0-152 Z2 153-161 Z3 162-170 Z4 171-179 Z5 180-200 "},
+		{"sin FC máxima", 170, 0, "Z1 0-144 Z2 145-152 Z3 153-161 Z4 162-169 Z5 170-0 "},
+		{"FC umbral por encima de la máxima", 190, 180, "Z1 0-161 Z2 162-170 Z3 171-180 Z4 181-189 Z5 190-0 "},
+	}
+	for _, tc := range cases {
+		zones := DefaultHRZones(tc.lthr, tc.maxHR)
+		if got := bounds(zones); got != tc.want {
+			t.Errorf("%s: %s, se esperaba %s", tc.name, got, tc.want)
+		}
+		if err := ValidateHRZones(zones); err != nil {
+			t.Errorf("%s: las zonas calculadas no son válidas: %v", tc.name, err)
+		}
+	}
+
+	if zones := DefaultHRZones(0, 0); zones != nil {
+		t.Errorf("sin datos se esperaba nil, obtenidas %v", zones)
+	}
+}
+
+func TestValidateHRZones(t *testing.T) {
+	zone := func(n, min, max int) models.HRZone { return models.HRZone{Zone: n, Min: min, Max: max} }
+
+	valid := map[string][]models.HRZone{
+		"sin zonas":     nil,
+		"consecutivas":  {zone(1, 0, 140), zone(2, 141, 160), zone(3, 161, 0)},
+		"con un hueco":  {zone(1, 100, 140), zone(2, 150, 185)},
+		"una sola zona": {zone(1, 120, 0)},
+	}
+	for name, zones := range valid {
+		if err := ValidateHRZones(zones); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	invalid := map[string][]models.HRZone{
+		"solapadas":                  {zone(1, 100, 150), zone(2, 140, 160)},
+		"compartiendo el límite":     {zone(1, 100, 150), zone(2, 150, 160)},
+		"abierta antes de la última": {zone(1, 100, 0), zone(2, 150, 160)},
+		"desordenadas":               {zone(1, 150, 160), zone(2, 100, 140)},
+		"numeradas fuera de orden":   {zone(2, 100, 140), zone(1, 141, 160)},
+		"numeración con saltos":      {zone(1, 100, 140), zone(3, 141, 160)},
+		"máximo menor que el mínimo": {zone(1, 150, 140)},
+		"fuera de rango":             {zone(1, 100, 240)},
+		"más de siete zonas": {zone(1, 0, 100), zone(2, 101, 110), zone(3, 111, 120), zone(4, 121, 130),
+			zone(5, 131, 140), zone(6, 141, 150), zone(7, 151, 160), zone(8, 161, 0)},
+	}
+	for name, zones := range invalid {
+		if err := ValidateHRZones(zones); err == nil {
+			t.Errorf("%s: se esperaba un error", name)
+		}
+	}
+}
+
+func TestParsePace(t *testing.T) {
+	valid := map[string]int{"4:30": 270, "5:05": 305, "10:00": 600, "0:59": 59}
+	for value, want := range valid {
+		if got, err := ParsePace(value); err != nil || got != want {
+			t.Errorf("ParsePace(%q) = %d, %v; se esperaba %d", value, got, err, want)
+		}
+	}
+
+	for _, value := range []string{"5:75", "5:60", "abc", "", "5", "-1:30", "4:-10", "0:00"} {
+		if got, err := ParsePace(value); err == nil {
+			t.Errorf("ParsePace(%q) = %d; se esperaba un error", value, got)
+		}
+	}
+}
+
+func TestBiometricsChanged(t *testing.T) {
+	before := models.RunnerProfile{
+		Name: "Ana", Age: 34, Weight: 58.5, RestingHR: 48, MaxHR: 190, LTHR: 172, VO2max: 54,
+		ThresholdPace: "4:10", ThresholdPower: 260, Goals: "Bajar de 40 en 10K",
+		HRZones: DefaultHRZones(172, 190),
+	}
+
+	// Solo los datos biométricos generan una entrada en el historial
+	others := []func(p *models.RunnerProfile){
+		func(p *models.RunnerProfile) { p.Name = "Ana María" },
+		func(p *models.RunnerProfile) { p.Age = 35 },
+		func(p *models.RunnerProfile) { p.Height = 165 },
+		func(p *models.RunnerProfile) { p.Goals = "Maratón en otoño" },
+		func(p *models.RunnerProfile) { p.WeeklyKmTarget = 60 },
+		func(p *models.RunnerProfile) { p.TrainingLevel = "advanced" },
+		func(p *models.RunnerProfile) { p.HRZones = nil },
+	}
+	for i, change := range others {
+		after := before
+		change(&after)
+		if BiometricsChanged(before, after) {
+			t.Errorf("cambio %d: no es biométrico y no debe guardarse en el historial", i)
+		}
+	}
+	if BiometricsChanged(before, before) {
+		t.Error("el mismo perfil no debe contar como cambio")
+	}
+
+	biometrics := map[string]func(p *models.RunnerProfile){
+		"peso":            func(p *models.RunnerProfile) { p.Weight = 58 },
+		"FC en reposo":    func(p *models.RunnerProfile) { p.RestingHR = 46 },
+		"FC máxima":       func(p *models.RunnerProfile) { p.MaxHR = 191 },
+		"FC umbral":       func(p *models.RunnerProfile) { p.LTHR = 174 },
+		"VO2max":          func(p *models.RunnerProfile) { p.VO2max = 55.5 },
+		"ritmo umbral":    func(p *models.RunnerProfile) { p.ThresholdPace = "4:05" },
+		"potencia umbral": func(p *models.RunnerProfile) { p.ThresholdPower = 265 },
+	}
+	for name, change := range biometrics {
+		after := before
+		change(&after)
+		if !BiometricsChanged(before, after) {
+			t.Errorf("el cambio de %s debe guardarse en el historial", name)
+		}
+	}
+}
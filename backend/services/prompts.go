@@ -0,0 +1,233 @@
+package services
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Nombres de las plantillas de prompt del coach
+const (
+	PromptCoachSystem         = "coach-system"
+	PromptImageAnalysis       = "image-analysis"
+	PromptWeeklyPlan          = "weekly-plan"
+	PromptTrainingPlan        = "training-plan"
+	PromptWorkoutAnalysis     = "workout-analysis"
+	PromptProgressReport      = "progress-report"
+	PromptWorkoutExtraction   = "workout-extraction"
+	PromptRecommendations     = "recommendations"
+	PromptConversationSummary = "conversation-summary"
+)
+
+// Plantillas incluidas en el binario: prompts/<nombre>/v<versión>.tmpl en español y
+// v<versión>.<idioma>.tmpl con su traducción a otro idioma
+//
+//go:embed prompts/*/*.tmpl
+var embeddedPrompts embed.FS
+
+var promptFilePattern = regexp.MustCompile(`^v([0-9]+)(?:\.([a-z]{2}))?\.tmpl$`)
+
+// PromptTemplate es una versión concreta de un prompt, con su texto en cada idioma
+type PromptTemplate struct {
+	Name    string
+	Version int
+	locales map[string]*template.Template
+}
+
+// ID identifica la versión de la plantilla (p. ej. "workout-analysis/2"); es lo que se guarda
+// con cada análisis, informe y plan generado
+func (p *PromptTemplate) ID() string {
+	return fmt.Sprintf("%s/%d", p.Name, p.Version)
+}
+
+// Render ejecuta la plantilla en el idioma dado (en español si no está traducida) con los datos
+// dados. Falla si la plantilla usa un campo que no existe.
+func (p *PromptTemplate) Render(locale string, data interface{}) (string, error) {
+	tmpl, ok := p.locales[NormalizeLocale(locale)]
+	if !ok {
+		tmpl = p.locales[DefaultLocale]
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("error generando el prompt %s: %v", p.ID(), err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// PromptRegistry guarda todas las versiones de cada plantilla y cuál está activa
+type PromptRegistry struct {
+	templates map[string]map[int]*PromptTemplate
+	active    map[string]int
+}
+
+// LoadPromptRegistry carga las plantillas incluidas en el binario y, si dir no está vacío, las de
+// ese directorio con la misma estructura (pueden añadir versiones nuevas o sustituir las existentes).
+// versions fija la versión activa de cada prompt ("workout-analysis=2,weekly-plan=1"); por defecto
+// está activa la versión más alta.
+func LoadPromptRegistry(dir, versions string) (*PromptRegistry, error) {
+	r := &PromptRegistry{
+		templates: map[string]map[int]*PromptTemplate{},
+		active:    map[string]int{},
+	}
+
+	embedded, err := fs.Sub(embeddedPrompts, "prompts")
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(embedded); err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if err := r.load(os.DirFS(dir)); err != nil {
+			return nil, fmt.Errorf("error cargando prompts de %s: %v", dir, err)
+		}
+	}
+
+	for name, byVersion := range r.templates {
+		for version, p := range byVersion {
+			if _, ok := p.locales[DefaultLocale]; !ok {
+				return nil, fmt.Errorf("falta la plantilla en español de %s (v%d.tmpl)", p.ID(), version)
+			}
+			if version > r.active[name] {
+				r.active[name] = version
+			}
+		}
+	}
+
+	for _, entry := range strings.Split(versions, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, found := strings.Cut(entry, "=")
+		version, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(value), "v"))
+		if !found || err != nil {
+			return nil, fmt.Errorf("versión de prompt inválida %q (se espera nombre=versión)", entry)
+		}
+		name = strings.TrimSpace(name)
+		if _, err := r.Get(name, version); err != nil {
+			return nil, err
+		}
+		r.active[name] = version
+	}
+
+	return r, nil
+}
+
+// load lee las plantillas <nombre>/v<versión>[.<idioma>].tmpl de un sistema de archivos
+func (r *PromptRegistry) load(fsys fs.FS) error {
+	files, err := fs.Glob(fsys, "*/*.tmpl")
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		match := promptFilePattern.FindStringSubmatch(path.Base(file))
+		if match == nil {
+			return fmt.Errorf("nombre de plantilla inválido %s (se espera v<versión>.tmpl o v<versión>.<idioma>.tmpl)", file)
+		}
+		version, _ := strconv.Atoi(match[1])
+		name := path.Dir(file)
+		locale := DefaultLocale
+		if match[2] != "" {
+			if locale = NormalizeLocale(match[2]); locale == "" {
+				return fmt.Errorf("idioma no soportado en la plantilla %s", file)
+			}
+		}
+
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(string(content))
+		if err != nil {
+			return fmt.Errorf("plantilla %s inválida: %v", file, err)
+		}
+
+		if r.templates[name] == nil {
+			r.templates[name] = map[int]*PromptTemplate{}
+		}
+		p := r.templates[name][version]
+		if p == nil {
+			p = &PromptTemplate{Name: name, Version: version, locales: map[string]*template.Template{}}
+			r.templates[name][version] = p
+		}
+		p.locales[locale] = tmpl
+	}
+
+	return nil
+}
+
+// Get devuelve una versión concreta de una plantilla
+func (r *PromptRegistry) Get(name string, version int) (*PromptTemplate, error) {
+	p, ok := r.templates[name][version]
+	if !ok {
+		return nil, fmt.Errorf("no existe la plantilla %s/%d", name, version)
+	}
+	return p, nil
+}
+
+// Active devuelve la versión activa de una plantilla
+func (r *PromptRegistry) Active(name string) (*PromptTemplate, error) {
+	return r.Get(name, r.active[name])
+}
+
+// Names devuelve los nombres de las plantillas cargadas, ordenados
+func (r *PromptRegistry) Names() []string {
+	names := make([]string, 0, len(r.templates))
+	for name := range r.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	prompts     *PromptRegistry
+	promptsErr  error
+	promptsOnce sync.Once
+)
+
+// Prompts devuelve el registro de prompts, cargado la primera vez con PROMPTS_DIR y PROMPT_VERSIONS
+func Prompts() (*PromptRegistry, error) {
+	promptsOnce.Do(func() {
+		prompts, promptsErr = LoadPromptRegistry(os.Getenv("PROMPTS_DIR"), os.Getenv("PROMPT_VERSIONS"))
+	})
+	return prompts, promptsErr
+}
+
+// ActivePromptVersion devuelve el ID de la versión activa de un prompt, o "" si no se pudo cargar
+func ActivePromptVersion(name string) string {
+	registry, err := Prompts()
+	if err != nil {
+		return ""
+	}
+	p, err := registry.Active(name)
+	if err != nil {
+		return ""
+	}
+	return p.ID()
+}
+
+// renderPrompt genera el texto de la versión activa de un prompt en el idioma dado
+func renderPrompt(name, locale string, data interface{}) (string, error) {
+	registry, err := Prompts()
+	if err != nil {
+		return "", err
+	}
+	p, err := registry.Active(name)
+	if err != nil {
+		return "", err
+	}
+	return p.Render(locale, data)
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"trainapp/models"
+)
+
+func TestEmbeddedPromptsRender(t *testing.T) {
+	registry, err := LoadPromptRegistry("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	race := &models.Race{ID: 1, Name: "Maratón de Valencia", Date: time.Date(2025, 12, 7, 0, 0, 0, 0, time.UTC), DistanceKm: 42.195, Priority: "A"}
+	workout := map[string]interface{}{
+		"date": "2025-11-16", "type": "long", "distance": 21.1, "duration": 110, "avg_pace": "5:13",
+		"avg_heart_rate": 150, "avg_power": 230, "cadence": 172, "elevation_gain": 90, "feeling": "bien", "notes": "",
+	}
+	data := map[string]interface{}{
+		PromptCoachSystem:         nil,
+		PromptImageAnalysis:       map[string]interface{}{"Notes": "Tirada con viento"},
+		PromptWeeklyPlan:          nil,
+		PromptTrainingPlan:        map[string]interface{}{"Goal": "marathon", "Race": race, "Blocks": "\n- Base", "OtherRaces": []models.Race{}},
+		PromptWorkoutAnalysis:     map[string]interface{}{"Workout": workout, "Intervals": ""},
+		PromptProgressReport:      map[string]interface{}{"PeriodStart": "2025-10-20", "PeriodEnd": "2025-11-16"},
+		PromptWorkoutExtraction:   map[string]interface{}{"Notes": ""},
+		PromptRecommendations:     map[string]interface{}{"Analysis": "Buen entreno."},
+		PromptConversationSummary: map[string]interface{}{"Summary": "", "Transcript": "Corredor: ¿Qué hago mañana?"},
+	}
+
+	for _, name := range registry.Names() {
+		p, err := registry.Active(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sample, ok := data[name]
+		if !ok {
+			t.Fatalf("falta un caso de prueba para la plantilla %s", name)
+		}
+		for _, locale := range SupportedLocales {
+			text, err := p.Render(locale, sample)
+			if err != nil {
+				t.Fatalf("%s (%s): %v", p.ID(), locale, err)
+			}
+			if text == "" || strings.Contains(text, "<no value>") {
+				t.Fatalf("%s (%s) generó un prompt inválido:\n%s", p.ID(), locale, text)
+			}
+		}
+	}
+
+	p, _ := registry.Active(PromptTrainingPlan)
+	text, _ := p.Render(DefaultLocale, data[PromptTrainingPlan])
+	if !strings.Contains(text, "📏 Distancia: 42.20 km") || !strings.Contains(text, "- Ninguna") || !strings.Contains(text, "sin marca objetivo") {
+		t.Fatalf("plan de carrera inesperado:\n%s", text)
+	}
+
+	p, _ = registry.Active(PromptWorkoutAnalysis)
+	if text, _ := p.Render(LocaleEnglish, data[PromptWorkoutAnalysis]); !strings.HasPrefix(text, "Analyse this training session") {
+		t.Fatalf("se esperaba el prompt en inglés:\n%s", text)
+	}
+	p, _ = registry.Active(PromptWorkoutExtraction)
+	if text, _ := p.Render(LocaleEnglish, data[PromptWorkoutExtraction]); !strings.HasPrefix(text, "Extrae las métricas") {
+		t.Fatalf("sin traducción debe usarse la plantilla en español:\n%s", text)
+	}
+
+	p, _ = registry.Active(PromptImageAnalysis)
+	if text, _ := p.Render(DefaultLocale, map[string]interface{}{"Notes": ""}); strings.Contains(text, "Notas adicionales") {
+		t.Fatalf("sin notas no debe incluirse la sección de notas:\n%s", text)
+	}
+}
+
+func TestPromptRegistryVersions(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, PromptWeeklyPlan), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, PromptWeeklyPlan, "v2.tmpl"), []byte("Plan semanal {{.Week}}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := LoadPromptRegistry(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := registry.Active(PromptWeeklyPlan)
+	if err != nil || p.ID() != "weekly-plan/2" {
+		t.Fatalf("la versión más alta debería estar activa: %v %v", p, err)
+	}
+	if text, err := p.Render(DefaultLocale, map[string]interface{}{"Week": 3}); err != nil || text != "Plan semanal 3" {
+		t.Fatalf("render inesperado: %q %v", text, err)
+	}
+	if _, err := p.Render(DefaultLocale, map[string]interface{}{}); err == nil {
+		t.Fatal("una clave que falta debería dar error")
+	}
+
+	registry, err = LoadPromptRegistry(dir, "weekly-plan=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := registry.Active(PromptWeeklyPlan); p.ID() != "weekly-plan/1" {
+		t.Fatalf("PROMPT_VERSIONS debería fijar la versión 1, activa %s", p.ID())
+	}
+
+	for _, versions := range []string{"weekly-plan=3", "weekly-plan", "desconocido=1"} {
+		if _, err := LoadPromptRegistry(dir, versions); err == nil {
+			t.Fatalf("%q debería ser inválido", versions)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// Provider genera una respuesta a partir de un prompt de sistema y un mensaje, sin historial ni
+// herramientas. Lo usa la evaluación offline de prompts para comparar versiones de plantilla.
+type Provider interface {
+	Complete(ctx context.Context, system, prompt string) (string, error)
+}
+
+// FakeProvider es un proveedor determinista que no llama a ningún modelo: devuelve un resumen
+// del prompt recibido, de modo que la diferencia entre dos respuestas refleja solo la diferencia
+// entre los prompts
+type FakeProvider struct{}
+
+// Complete devuelve la huella del prompt de sistema y las líneas no vacías del mensaje
+func (FakeProvider) Complete(ctx context.Context, system, prompt string) (string, error) {
+	h := fnv.New32a()
+	h.Write([]byte(system))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[fake] sistema %08x\n", h.Sum32())
+	for _, line := range strings.Split(prompt, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			fmt.Fprintf(&b, "> %s\n", line)
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// OpenAIProvider usa la API de chat completions de OpenAI o de cualquier servidor compatible
+// (p. ej. un modelo local) indicando su URL base
+type OpenAIProvider struct {
+	client *openai.Client
+	model  string
+}
+
+// NewOpenAIProvider crea un proveedor compatible con OpenAI. baseURL puede estar vacía para usar la
+// API de OpenAI; apiKey puede estarlo para servidores locales que no la requieren.
+func NewOpenAIProvider(baseURL, apiKey, model string) *OpenAIProvider {
+	if apiKey == "" {
+		apiKey = "local"
+	}
+	opts := []option.RequestOption{option.WithAPIKey(apiKey)}
+	if baseURL != "" {
+		opts = append(opts, option.WithBaseURL(baseURL))
+	}
+	if model == "" {
+		model = CoachModel
+	}
+	return &OpenAIProvider{client: openai.NewClient(opts...), model: model}
+}
+
+// Complete envía el prompt al modelo y devuelve su respuesta
+func (p *OpenAIProvider) Complete(ctx context.Context, system, prompt string) (string, error) {
+	response, err := p.client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+		Model: openai.F(p.model),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(system),
+			openai.UserMessage(prompt),
+		}),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error llamando a chat completions: %v", err)
+	}
+
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("no hay respuesta del modelo")
+	}
+
+	return response.Choices[0].Message.Content, nil
+}
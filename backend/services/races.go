@@ -0,0 +1,160 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"trainapp/models"
+)
+
+// Valores permitidos para los campos de una carrera
+var (
+	RacePriorities     = []string{"A", "B", "C"}
+	RaceCourseProfiles = []string{"flat", "rolling", "hilly", "trail"}
+	RaceStatuses       = []string{"upcoming", "completed", "dns", "dnf"}
+)
+
+// phaseFocus describe el objetivo de cada fase de la periodización
+var phaseFocus = map[string]string{
+	"base":  "Volumen aeróbico en Z1-Z2, técnica y fuerza; subir el km semanal de forma gradual",
+	"build": "Trabajo específico: umbral (Z3-Z4) e intervalos, manteniendo la tirada larga",
+	"peak":  "Sesiones a ritmo objetivo de carrera y máximo volumen específico",
+	"taper": "Reducir el volumen un 30-50% manteniendo la intensidad para llegar fresco",
+}
+
+// ValidOption indica si value está entre las opciones permitidas
+func ValidOption(value string, options []string) bool {
+	for _, option := range options {
+		if value == option {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRaceTime convierte un tiempo H:MM:SS o MM:SS a segundos
+func ParseRaceTime(value string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, errors.New("formato de tiempo inválido (H:MM:SS)")
+	}
+
+	total := 0
+	for i, part := range parts {
+		var n int
+		if _, err := fmt.Sscanf(part, "%d", &n); err != nil || n < 0 {
+			return 0, errors.New("formato de tiempo inválido (H:MM:SS)")
+		}
+		if i > 0 && n >= 60 {
+			return 0, errors.New("minutos y segundos deben ser menores que 60")
+		}
+		total = total*60 + n
+	}
+
+	if total == 0 {
+		return 0, errors.New("el tiempo debe ser mayor que 0")
+	}
+
+	return total, nil
+}
+
+// FormatRaceTime convierte segundos a H:MM:SS
+func FormatRaceTime(seconds int) string {
+	return fmt.Sprintf("%d:%02d:%02d", seconds/3600, (seconds%3600)/60, seconds%60)
+}
+
+// BuildPeriodization divide el tiempo hasta la carrera en bloques base, build, peak y taper,
+// calculados hacia atrás desde el día de la carrera en semanas completas; los días que sobran
+// se suman al primer bloque, normalmente la base. Si no hay tiempo suficiente se recortan
+// primero base y build, manteniendo siempre la puesta a punto. Weeks son las semanas completas
+// de cada bloque (0 si la carrera es en menos de una semana).
+func BuildPeriodization(from, raceDate time.Time, distanceKm float64) []models.TrainingBlock {
+	from = truncateDay(from)
+	raceDate = truncateDay(raceDate)
+	if !raceDate.After(from) {
+		return nil
+	}
+
+	days := int(math.Round(raceDate.Sub(from).Hours() / 24))
+	totalWeeks := days / 7
+	if totalWeeks == 0 {
+		return []models.TrainingBlock{{
+			Phase:     "taper",
+			StartDate: from,
+			EndDate:   raceDate,
+			Focus:     phaseFocus["taper"],
+		}}
+	}
+
+	// Duración de taper y peak según la distancia
+	taper, peak := 1, 2
+	switch {
+	case distanceKm > 21.1:
+		taper, peak = 3, 3
+	case distanceKm > 10:
+		taper, peak = 2, 3
+	}
+
+	if totalWeeks <= taper {
+		taper, peak = totalWeeks, 0
+	} else if totalWeeks < taper+peak {
+		peak = totalWeeks - taper
+	}
+
+	remaining := totalWeeks - taper - peak
+	build := remaining * 2 / 5
+	if remaining >= 2 && build == 0 {
+		build = 1
+	}
+	base := remaining - build
+
+	// Construir hacia atrás desde la carrera: cada fase termina donde empieza la siguiente
+	phases := []struct {
+		name  string
+		weeks int
+	}{{"base", base}, {"build", build}, {"peak", peak}, {"taper", taper}}
+
+	blocks := []models.TrainingBlock{}
+	end := raceDate
+	for i := len(phases) - 1; i >= 0; i-- {
+		phase := phases[i]
+		if phase.weeks == 0 {
+			continue
+		}
+
+		start := end.AddDate(0, 0, -7*phase.weeks)
+		blocks = append([]models.TrainingBlock{{
+			Phase:     phase.name,
+			StartDate: start,
+			EndDate:   end,
+			Weeks:     phase.weeks,
+			Focus:     phaseFocus[phase.name],
+		}}, blocks...)
+		end = start
+	}
+
+	// Los días que no completan una semana van al primer bloque
+	blocks[0].StartDate = from
+	return blocks
+}
+
+// FormatBlocksForPrompt resume los bloques de periodización para los prompts del coach
+func FormatBlocksForPrompt(blocks []models.TrainingBlock) string {
+	summary := ""
+	for _, b := range blocks {
+		length := fmt.Sprintf("%d sem.", b.Weeks)
+		if b.Weeks == 0 {
+			length = fmt.Sprintf("%d días", int(math.Round(b.EndDate.Sub(b.StartDate).Hours()/24)))
+		}
+		summary += fmt.Sprintf("\n- %s (%s, %s → %s): %s",
+			strings.ToUpper(b.Phase), length, b.StartDate.Format("2006-01-02"), b.EndDate.Format("2006-01-02"), b.Focus)
+	}
+	return summary
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
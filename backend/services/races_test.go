@@ -0,0 +1,106 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildPeriodization(t *testing.T) {
+	from := time.Date(2025, 3, 3, 18, 30, 0, 0, time.UTC) // la hora no cuenta
+	day := func(days int) time.Time { return time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC).AddDate(0, 0, days) }
+
+	cases := []struct {
+		name     string
+		days     int
+		distance float64
+		want     string // fase:semanas de cada bloque
+	}{
+		{"5K", 56, 5, "base:3 build:2 peak:2 taper:1"},
+		{"10K", 84, 10, "base:6 build:3 peak:2 taper:1"},
+		{"media", 112, 21.1, "base:7 build:4 peak:3 taper:2"},
+		{"maratón", 140, 42.195, "base:9 build:5 peak:3 taper:3"},
+		{"maratón con días sueltos", 143, 42.195, "base:9 build:5 peak:3 taper:3"},
+		{"maratón sin tiempo para el peak completo", 28, 42.195, "peak:1 taper:3"},
+		{"media con menos semanas que el taper", 12, 21.1, "taper:1"},
+		{"media en dos semanas", 14, 21.1, "taper:2"},
+		{"10K en diez días", 10, 10, "taper:1"},
+		{"5K en menos de una semana", 5, 5, "taper:0"},
+	}
+
+	for _, tc := range cases {
+		race := day(tc.days)
+		blocks := BuildPeriodization(from, race, tc.distance)
+
+		var got []string
+		for _, b := range blocks {
+			got = append(got, b.Phase+":"+strconv.Itoa(b.Weeks))
+		}
+		if strings.Join(got, " ") != tc.want {
+			t.Errorf("%s: bloques %v, se esperaban %s", tc.name, got, tc.want)
+			continue
+		}
+
+		// Los bloques cubren todos los días hasta la carrera sin huecos y, salvo el primero (que
+		// se lleva los días sueltos), duran exactamente sus semanas
+		if !blocks[0].StartDate.Equal(day(0)) || !blocks[len(blocks)-1].EndDate.Equal(race) {
+			t.Errorf("%s: los bloques van de %s a %s", tc.name, blocks[0].StartDate, blocks[len(blocks)-1].EndDate)
+		}
+		for i, b := range blocks {
+			days := int(b.EndDate.Sub(b.StartDate).Hours() / 24)
+			if i > 0 && !b.StartDate.Equal(blocks[i-1].EndDate) {
+				t.Errorf("%s: hueco antes del bloque %s", tc.name, b.Phase)
+			}
+			if days/7 != b.Weeks || (i > 0 && days != 7*b.Weeks) {
+				t.Errorf("%s: el bloque %s dura %d días y dice %d semanas", tc.name, b.Phase, days, b.Weeks)
+			}
+		}
+	}
+
+	// Una carrera hoy o pasada no tiene periodización
+	for _, days := range []int{0, -3} {
+		if blocks := BuildPeriodization(from, day(days), 10); blocks != nil {
+			t.Errorf("carrera a %d días: se esperaba nil, obtenidos %v", days, blocks)
+		}
+	}
+}
+
+func TestFormatBlocksForPrompt(t *testing.T) {
+	from := time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC)
+	summary := FormatBlocksForPrompt(BuildPeriodization(from, from.AddDate(0, 0, 5), 5))
+	if !strings.Contains(summary, "TAPER (5 días, 2025-03-03 → 2025-03-08)") {
+		t.Errorf("resumen inesperado: %s", summary)
+	}
+}
+
+func TestParseRaceTime(t *testing.T) {
+	valid := map[string]int{
+		"3:29:59":  12599,
+		"1:05:00":  3900,
+		"45:30":    2730,
+		" 0:19:59": 1199,
+	}
+	for value, want := range valid {
+		got, err := ParseRaceTime(value)
+		if err != nil || got != want {
+			t.Errorf("ParseRaceTime(%q) = %d, %v; se esperaba %d", value, got, err, want)
+		}
+		if again, _ := ParseRaceTime(FormatRaceTime(got)); again != got {
+			t.Errorf("FormatRaceTime(%d) = %q no se puede volver a leer", got, FormatRaceTime(got))
+		}
+	}
+
+	for _, value := range []string{"", "1:75:00", "1:05:60", "45", "1:2:3:4", "abc", "1:-5:00", "0:00:00"} {
+		if got, err := ParseRaceTime(value); err == nil {
+			t.Errorf("ParseRaceTime(%q) = %d; se esperaba un error", value, got)
+		}
+	}
+
+	if got := FormatRaceTime(12599); got != "3:29:59" {
+		t.Errorf("FormatRaceTime(12599) = %q", got)
+	}
+	if got := FormatRaceTime(1199); got != "0:19:59" {
+		t.Errorf("FormatRaceTime(1199) = %q", got)
+	}
+}
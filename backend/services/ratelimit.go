@@ -0,0 +1,173 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit es el número de peticiones permitidas en una ventana de tiempo ("10/1m"). Se
+// aplica como un cubo de tokens: se admiten ráfagas de Requests peticiones y después una cada
+// Window/Requests.
+type RateLimit struct {
+	Requests int           `json:"requests"`
+	Window   time.Duration `json:"window"`
+}
+
+// Límites por defecto de cada grupo de rutas (RATE_LIMITS): auth por IP y coach por usuario
+var defaultRateLimits = map[string]RateLimit{
+	"auth":  {Requests: 10, Window: time.Minute},
+	"coach": {Requests: 10, Window: time.Minute},
+}
+
+// RateLimits devuelve los límites de cada grupo de rutas. RATE_LIMITS los cambia con el formato
+// "auth=20/1m,coach=5/1m"; "grupo=off" quita el límite de un grupo.
+func RateLimits() (map[string]RateLimit, error) {
+	limits := map[string]RateLimit{}
+	for name, limit := range defaultRateLimits {
+		limits[name] = limit
+	}
+
+	for _, entry := range strings.Split(os.Getenv("RATE_LIMITS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, found := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf("límite inválido en RATE_LIMITS: %q (se espera grupo=peticiones/ventana)", entry)
+		}
+		limit, err := ParseRateLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("límite inválido para %s en RATE_LIMITS: %w", name, err)
+		}
+		limits[name] = limit
+	}
+	return limits, nil
+}
+
+// ParseRateLimit interpreta un límite "peticiones/ventana" ("10/1m"); "off" es sin límite
+func ParseRateLimit(value string) (RateLimit, error) {
+	value = strings.TrimSpace(value)
+	if value == "off" || value == "0" {
+		return RateLimit{}, nil
+	}
+	count, window, found := strings.Cut(value, "/")
+	requests, err := strconv.Atoi(strings.TrimSpace(count))
+	if !found || err != nil || requests < 0 {
+		return RateLimit{}, fmt.Errorf("%q no tiene el formato peticiones/ventana", value)
+	}
+	duration, err := time.ParseDuration(strings.TrimSpace(window))
+	if err != nil || duration <= 0 {
+		return RateLimit{}, fmt.Errorf("ventana inválida en %q", value)
+	}
+	return RateLimit{Requests: requests, Window: duration}, nil
+}
+
+// Disabled indica si el límite no restringe nada
+func (l RateLimit) Disabled() bool {
+	return l.Requests == 0 || l.Window == 0
+}
+
+// bucket es el estado de una clave del limitador
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter limita las peticiones de cada clave (IP o usuario) en memoria. Cada instancia del
+// servidor lleva su propia cuenta.
+type RateLimiter struct {
+	limit     RateLimit
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time // para las pruebas
+}
+
+// NewRateLimiter crea un limitador con el límite indicado
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	return &RateLimiter{limit: limit, buckets: map[string]*bucket{}, now: time.Now}
+}
+
+// Allow consume una petición de key. Si se ha superado el límite devuelve false y cuánto hay
+// que esperar para la siguiente.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l.limit.Disabled() {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	capacity := float64(l.limit.Requests)
+	rate := capacity / l.limit.Window.Seconds() // tokens por segundo
+	l.sweep(now, capacity, rate)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep borra de vez en cuando las claves que ya han recuperado todos sus tokens, para que el
+// mapa no crezca sin límite
+func (l *RateLimiter) sweep(now time.Time, capacity, rate float64) {
+	if now.Sub(l.lastSweep) < l.limit.Window {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= capacity {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+const (
+	// DefaultLoginLockoutThreshold es el número de intentos fallidos seguidos de una cuenta a partir
+	// del cual se bloquea (LOGIN_LOCKOUT_THRESHOLD)
+	DefaultLoginLockoutThreshold = 5
+	// Bloqueo tras el primer fallo por encima del umbral; se duplica con cada fallo siguiente
+	loginLockoutBase = 30 * time.Second
+	// Bloqueo máximo de una cuenta
+	loginLockoutMax = time.Hour
+)
+
+// LoginLockoutThreshold devuelve el número de fallos seguidos que bloquean una cuenta
+func LoginLockoutThreshold() int {
+	if value, err := strconv.Atoi(os.Getenv("LOGIN_LOCKOUT_THRESHOLD")); err == nil && value > 0 {
+		return value
+	}
+	return DefaultLoginLockoutThreshold
+}
+
+// LoginLockout devuelve cuánto se bloquea una cuenta desde su último intento fallido tras
+// failures fallos seguidos: nada hasta el umbral y después un tiempo que se duplica con cada
+// fallo, hasta una hora
+func LoginLockout(failures int) time.Duration {
+	over := failures - LoginLockoutThreshold()
+	if over < 0 {
+		return 0
+	}
+	if over >= 7 { // 30s << 7 ya supera la hora
+		return loginLockoutMax
+	}
+	return min(loginLockoutBase<<over, loginLockoutMax)
+}
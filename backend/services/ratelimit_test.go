@@ -0,0 +1,82 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	l := NewRateLimiter(RateLimit{Requests: 3, Window: time.Minute})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("ip:1"); !ok {
+			t.Fatalf("la petición %d debería admitirse", i+1)
+		}
+	}
+	ok, wait := l.Allow("ip:1")
+	if ok || wait != 20*time.Second {
+		t.Fatalf("la cuarta petición debería rechazarse con 20s de espera: %v %v", ok, wait)
+	}
+	if ok, _ := l.Allow("ip:2"); !ok {
+		t.Fatal("otra clave tiene su propio límite")
+	}
+
+	// Cada 20 segundos se recupera una petición
+	now = now.Add(20 * time.Second)
+	if ok, _ := l.Allow("ip:1"); !ok {
+		t.Fatal("tras la espera debería admitirse una petición")
+	}
+	if ok, _ := l.Allow("ip:1"); ok {
+		t.Fatal("solo se recupera una petición")
+	}
+
+	// Las claves que han recuperado todo se borran
+	now = now.Add(2 * time.Minute)
+	l.Allow("ip:3")
+	if len(l.buckets) != 1 {
+		t.Fatalf("deberían quedar solo las claves activas: %d", len(l.buckets))
+	}
+
+	if ok, _ := NewRateLimiter(RateLimit{}).Allow("x"); !ok {
+		t.Fatal("sin límite se admite todo")
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	t.Setenv("RATE_LIMITS", "auth=20/1m, coach=off, strava=5/1h")
+	limits, err := RateLimits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits["auth"] != (RateLimit{Requests: 20, Window: time.Minute}) || !limits["coach"].Disabled() ||
+		limits["strava"] != (RateLimit{Requests: 5, Window: time.Hour}) {
+		t.Fatalf("límites inesperados: %+v", limits)
+	}
+
+	for _, value := range []string{"auth", "auth=10", "auth=10/x", "auth=-1/1m", "=10/1m"} {
+		t.Setenv("RATE_LIMITS", value)
+		if _, err := RateLimits(); err == nil {
+			t.Errorf("%q debería rechazarse", value)
+		}
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	t.Setenv("LOGIN_LOCKOUT_THRESHOLD", "5")
+	for failures, want := range map[int]time.Duration{
+		0:  0,
+		4:  0,
+		5:  30 * time.Second,
+		6:  time.Minute,
+		8:  4 * time.Minute,
+		11: 32 * time.Minute,
+		12: time.Hour,
+		40: time.Hour,
+	} {
+		if got := LoginLockout(failures); got != want {
+			t.Errorf("%d fallos: %v, esperado %v", failures, got, want)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"trainapp/models"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/shared"
+)
+
+// Tipos de alerta y severidades de las recomendaciones
+var (
+	RiskFlagTypes  = []string{"overload", "injury_risk", "fatigue", "illness"}
+	RiskSeverities = []string{"low", "medium", "high"}
+)
+
+// Las alertas de un análisis siguen vigentes en el feed de consejos durante esta ventana
+const AdviceFlagWindow = 7 * 24 * time.Hour
+
+// Límites de elementos por análisis, para que el feed siga siendo breve
+const (
+	maxRecommendedActions = 5
+	maxRecoveryTips       = 5
+)
+
+// recommendationsSchema es el JSON Schema estricto de las recomendaciones
+func recommendationsSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"actions": map[string]interface{}{
+				"type":        "array",
+				"description": "Acciones concretas para las próximas 24-48 horas",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"within_hours": map[string]interface{}{"type": "integer", "enum": []int{24, 48}},
+						"action":       map[string]interface{}{"type": "string"},
+					},
+					"required":             []string{"within_hours", "action"},
+					"additionalProperties": false,
+				},
+			},
+			"recovery": map[string]interface{}{
+				"type":        "array",
+				"description": "Consejos de recuperación (sueño, nutrición, movilidad...)",
+				"items":       map[string]interface{}{"type": "string"},
+			},
+			"flags": map[string]interface{}{
+				"type":        "array",
+				"description": "Riesgos señalados en el análisis; vacío si no hay ninguno",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"type":     map[string]interface{}{"type": "string", "enum": RiskFlagTypes},
+						"severity": map[string]interface{}{"type": "string", "enum": RiskSeverities},
+						"reason":   map[string]interface{}{"type": "string"},
+					},
+					"required":             []string{"type", "severity", "reason"},
+					"additionalProperties": false,
+				},
+			},
+		},
+		"required":             []string{"actions", "recovery", "flags"},
+		"additionalProperties": false,
+	}
+}
+
+// ExtractRecommendations convierte las recomendaciones de un análisis en texto a su forma
+// estructurada con una llamada restringida al esquema, en el idioma del corredor. No usa ni
+// modifica el historial.
+func ExtractRecommendations(session CoachSession, analysis string) (*models.Recommendations, error) {
+	if client == nil {
+		InitializeOpenAI()
+	}
+
+	prompt, err := renderPrompt(PromptRecommendations, session.Locale, map[string]interface{}{"Analysis": analysis})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := session.deadline()
+	defer cancel()
+
+	response, err := chatCompletion(ctx, session, PromptRecommendations, openai.ChatCompletionNewParams{
+		Model: openai.F(CoachModel),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage("Eres un asistente que estructura recomendaciones de entrenamiento. Respondes solo con el JSON del esquema."),
+			openai.UserMessage(prompt),
+		}),
+		ResponseFormat: openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](shared.ResponseFormatJSONSchemaParam{
+			Type: openai.F(shared.ResponseFormatJSONSchemaTypeJSONSchema),
+			JSONSchema: openai.F(shared.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:   openai.F("workout_recommendations"),
+				Schema: openai.F[interface{}](recommendationsSchema()),
+				Strict: openai.F(true),
+			}),
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Choices) == 0 {
+		return nil, fmt.Errorf("no hay respuesta del modelo")
+	}
+
+	var recommendations models.Recommendations
+	if err := json.Unmarshal([]byte(response.Choices[0].Message.Content), &recommendations); err != nil {
+		return nil, fmt.Errorf("respuesta de recomendaciones inválida: %v", err)
+	}
+
+	NormalizeRecommendations(&recommendations)
+	return &recommendations, nil
+}
+
+// NormalizeRecommendations descarta elementos vacíos o con valores desconocidos y limita su número
+func NormalizeRecommendations(r *models.Recommendations) {
+	actions := []models.RecommendedAction{}
+	for _, a := range r.Actions {
+		a.Action = strings.TrimSpace(a.Action)
+		if a.Action == "" || len(actions) == maxRecommendedActions {
+			continue
+		}
+		if a.WithinHours != 24 {
+			a.WithinHours = 48
+		}
+		actions = append(actions, a)
+	}
+	r.Actions = actions
+
+	recovery := []string{}
+	for _, tip := range r.Recovery {
+		if tip = strings.TrimSpace(tip); tip != "" && len(recovery) < maxRecoveryTips {
+			recovery = append(recovery, tip)
+		}
+	}
+	r.Recovery = recovery
+
+	flags := []models.RiskFlag{}
+	for _, f := range r.Flags {
+		if ValidOption(f.Type, RiskFlagTypes) && ValidOption(f.Severity, RiskSeverities) {
+			f.Reason = strings.TrimSpace(f.Reason)
+			flags = append(flags, f)
+		}
+	}
+	r.Flags = flags
+}
+
+// AdviceSource son las recomendaciones de un análisis que alimentan el feed de consejos
+type AdviceSource struct {
+	AnalysisID      int
+	WorkoutID       *int
+	CreatedAt       time.Time
+	Recommendations models.Recommendations
+}
+
+// AdviceAction es una acción vigente del feed
+type AdviceAction struct {
+	Action     string    `json:"action"`
+	ValidUntil time.Time `json:"valid_until"`
+	AnalysisID int       `json:"analysis_id"`
+	WorkoutID  *int      `json:"workout_id"`
+}
+
+// AdviceFlag es una alerta activa del feed
+type AdviceFlag struct {
+	models.RiskFlag
+	AnalysisID int       `json:"analysis_id"`
+	WorkoutID  *int      `json:"workout_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// AdviceFeed reúne los consejos vigentes del corredor
+type AdviceFeed struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Actions     []AdviceAction `json:"actions"`
+	Recovery    []string       `json:"recovery"`
+	Flags       []AdviceFlag   `json:"flags"`
+}
+
+// BuildAdviceFeed agrega las recomendaciones de los análisis recientes: las acciones que siguen
+// en plazo, los consejos de recuperación del análisis más reciente que los tenga y las alertas de
+// los últimos días (una por tipo, la más grave y reciente), ordenadas por gravedad.
+func BuildAdviceFeed(sources []AdviceSource, now time.Time) AdviceFeed {
+	sorted := append([]AdviceSource{}, sources...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].CreatedAt.After(sorted[j].CreatedAt) })
+
+	feed := AdviceFeed{
+		GeneratedAt: now,
+		Actions:     []AdviceAction{},
+		Recovery:    []string{},
+		Flags:       []AdviceFlag{},
+	}
+
+	flagsByType := map[string]AdviceFlag{}
+	for _, s := range sorted {
+		for _, a := range s.Recommendations.Actions {
+			validUntil := s.CreatedAt.Add(time.Duration(a.WithinHours) * time.Hour)
+			if validUntil.After(now) {
+				feed.Actions = append(feed.Actions, AdviceAction{
+					Action: a.Action, ValidUntil: validUntil, AnalysisID: s.AnalysisID, WorkoutID: s.WorkoutID,
+				})
+			}
+		}
+
+		if len(feed.Recovery) == 0 && now.Sub(s.CreatedAt) <= 48*time.Hour {
+			feed.Recovery = append(feed.Recovery, s.Recommendations.Recovery...)
+		}
+
+		if now.Sub(s.CreatedAt) > AdviceFlagWindow {
+			continue
+		}
+		for _, f := range s.Recommendations.Flags {
+			current, seen := flagsByType[f.Type]
+			if !seen || severityRank(f.Severity) > severityRank(current.Severity) {
+				flagsByType[f.Type] = AdviceFlag{RiskFlag: f, AnalysisID: s.AnalysisID, WorkoutID: s.WorkoutID, CreatedAt: s.CreatedAt}
+			}
+		}
+	}
+
+	sort.SliceStable(feed.Actions, func(i, j int) bool { return feed.Actions[i].ValidUntil.Before(feed.Actions[j].ValidUntil) })
+
+	for _, f := range flagsByType {
+		feed.Flags = append(feed.Flags, f)
+	}
+	sort.Slice(feed.Flags, func(i, j int) bool {
+		if severityRank(feed.Flags[i].Severity) != severityRank(feed.Flags[j].Severity) {
+			return severityRank(feed.Flags[i].Severity) > severityRank(feed.Flags[j].Severity)
+		}
+		return feed.Flags[i].CreatedAt.After(feed.Flags[j].CreatedAt)
+	})
+
+	return feed
+}
+
+func severityRank(severity string) int {
+	for i, s := range RiskSeverities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
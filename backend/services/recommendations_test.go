@@ -0,0 +1,74 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"trainapp/models"
+)
+
+func TestNormalizeRecommendations(t *testing.T) {
+	r := models.Recommendations{
+		Actions:  []models.RecommendedAction{{WithinHours: 24, Action: " Rodaje suave 40' "}, {WithinHours: 12, Action: "Estirar"}, {Action: "  "}},
+		Recovery: []string{"Dormir 8 h", ""},
+		Flags:    []models.RiskFlag{{Type: "overload", Severity: "high"}, {Type: "boredom", Severity: "low"}, {Type: "fatigue", Severity: "extreme"}},
+	}
+
+	NormalizeRecommendations(&r)
+
+	if len(r.Actions) != 2 || r.Actions[0].Action != "Rodaje suave 40'" || r.Actions[1].WithinHours != 48 {
+		t.Fatalf("acciones inesperadas: %+v", r.Actions)
+	}
+	if len(r.Recovery) != 1 {
+		t.Fatalf("consejos inesperados: %v", r.Recovery)
+	}
+	if len(r.Flags) != 1 || r.Flags[0].Type != "overload" {
+		t.Fatalf("alertas inesperadas: %+v", r.Flags)
+	}
+}
+
+func TestBuildAdviceFeed(t *testing.T) {
+	now := time.Date(2025, 11, 20, 12, 0, 0, 0, time.UTC)
+	sources := []AdviceSource{
+		{
+			AnalysisID: 1,
+			CreatedAt:  now.Add(-72 * time.Hour),
+			Recommendations: models.Recommendations{
+				Actions:  []models.RecommendedAction{{WithinHours: 48, Action: "Caducada"}},
+				Recovery: []string{"Antigua"},
+				Flags:    []models.RiskFlag{{Type: "overload", Severity: "high", Reason: "ACWR 1.6"}},
+			},
+		},
+		{
+			AnalysisID: 2,
+			CreatedAt:  now.Add(-30 * time.Hour),
+			Recommendations: models.Recommendations{
+				Actions:  []models.RecommendedAction{{WithinHours: 24, Action: "Vencida"}, {WithinHours: 48, Action: "Descanso activo"}},
+				Recovery: []string{"Hidratación"},
+				Flags:    []models.RiskFlag{{Type: "overload", Severity: "low"}, {Type: "fatigue", Severity: "medium"}},
+			},
+		},
+		{
+			AnalysisID: 3,
+			CreatedAt:  now.Add(-10 * 24 * time.Hour),
+			Recommendations: models.Recommendations{
+				Flags: []models.RiskFlag{{Type: "injury_risk", Severity: "high"}},
+			},
+		},
+	}
+
+	feed := BuildAdviceFeed(sources, now)
+
+	if len(feed.Actions) != 1 || feed.Actions[0].Action != "Descanso activo" || feed.Actions[0].AnalysisID != 2 {
+		t.Fatalf("solo debería quedar la acción vigente: %+v", feed.Actions)
+	}
+	if len(feed.Recovery) != 1 || feed.Recovery[0] != "Hidratación" {
+		t.Fatalf("la recuperación debe venir del análisis más reciente: %v", feed.Recovery)
+	}
+	if len(feed.Flags) != 2 {
+		t.Fatalf("se esperaban 2 alertas (una por tipo, sin las antiguas): %+v", feed.Flags)
+	}
+	if feed.Flags[0].Type != "overload" || feed.Flags[0].Severity != "high" || feed.Flags[0].AnalysisID != 1 {
+		t.Fatalf("la alerta más grave debe ir primero: %+v", feed.Flags[0])
+	}
+}
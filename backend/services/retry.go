@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+const (
+	// DefaultLLMTimeout es el tiempo máximo de cada intento de llamada al modelo (LLM_TIMEOUT)
+	DefaultLLMTimeout = 90 * time.Second
+	// DefaultLLMRequestTimeout es el tiempo máximo de una respuesta del coach, con sus rondas de
+	// herramientas, reintentos y modelo de respaldo (LLM_REQUEST_TIMEOUT)
+	DefaultLLMRequestTimeout = 4 * time.Minute
+	// DefaultLLMMaxRetries es el número de reintentos por modelo ante errores transitorios (LLM_MAX_RETRIES)
+	DefaultLLMMaxRetries = 2
+	// Espera base y máxima entre reintentos
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+)
+
+// LLMTimeout devuelve el tiempo máximo de cada intento; LLM_TIMEOUT acepta duraciones de Go ("45s", "2m")
+func LLMTimeout() time.Duration {
+	if value, err := time.ParseDuration(os.Getenv("LLM_TIMEOUT")); err == nil && value > 0 {
+		return value
+	}
+	return DefaultLLMTimeout
+}
+
+// LLMRequestTimeout devuelve el tiempo máximo de una respuesta completa del coach
+func LLMRequestTimeout() time.Duration {
+	if value, err := time.ParseDuration(os.Getenv("LLM_REQUEST_TIMEOUT")); err == nil && value > 0 {
+		return value
+	}
+	return DefaultLLMRequestTimeout
+}
+
+// LLMMaxRetries devuelve el número de reintentos configurado
+func LLMMaxRetries() int {
+	if value, err := strconv.Atoi(os.Getenv("LLM_MAX_RETRIES")); err == nil && value >= 0 {
+		return value
+	}
+	return DefaultLLMMaxRetries
+}
+
+// FallbackModel devuelve el modelo secundario (LLM_FALLBACK_MODEL) al que se pasa cuando el
+// principal falla, tras reintentar sus errores transitorios; "" si no hay
+func FallbackModel() string {
+	return strings.TrimSpace(os.Getenv("LLM_FALLBACK_MODEL"))
+}
+
+// isTransient indica si un error puede resolverse repitiendo la llamada: límites de uso,
+// errores del servidor, timeouts del intento y fallos de red
+func isTransient(err error) bool {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+			return true
+		}
+		return apiErr.StatusCode >= 500
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retryDelay devuelve la espera antes del reintento attempt (desde 1): exponencial con jitter
+// completo, o la indicada por el proveedor en Retry-After si la hay
+func retryDelay(err error, attempt int) time.Duration {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) && apiErr.Response != nil {
+		if seconds, err := strconv.Atoi(apiErr.Response.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			return min(time.Duration(seconds)*time.Second, retryMaxDelay)
+		}
+	}
+
+	ceiling := min(retryBaseDelay<<(attempt-1), retryMaxDelay)
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// sleepContext espera d o hasta que se cancele ctx
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// fakeChatServer responde a chat completions con el código que devuelva status para cada
+// modelo y número de llamada; Retry-After: 0 evita esperas entre reintentos
+func fakeChatServer(t *testing.T, status func(model string, call int) int) *[]string {
+	var mu sync.Mutex
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		calls = append(calls, body.Model)
+		call := len(calls)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "0")
+		if code := status(body.Model, call); code != http.StatusOK {
+			w.WriteHeader(code)
+			w.Write([]byte(`{"error":{"message":"fallo","type":"server_error"}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": "c", "object": "chat.completion", "model": body.Model,
+			"choices": []map[string]interface{}{{"index": 0, "finish_reason": "stop",
+				"message": map[string]interface{}{"role": "assistant", "content": "ok " + body.Model}}},
+			"usage": map[string]interface{}{"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	previous := client
+	client = openai.NewClient(option.WithBaseURL(server.URL), option.WithAPIKey("test"), option.WithMaxRetries(0))
+	t.Cleanup(func() { client = previous })
+	return &calls
+}
+
+func testParams() openai.ChatCompletionNewParams {
+	return openai.ChatCompletionNewParams{
+		Model:    openai.F(CoachModel),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessage("hola")}),
+	}
+}
+
+func TestChatCompletionRetriesAndFallback(t *testing.T) {
+	t.Setenv("LLM_MAX_RETRIES", "2")
+	t.Setenv("LLM_FALLBACK_MODEL", "modelo-respaldo")
+
+	var usage []LLMUsage
+	session := CoachSession{UserID: 1, Usage: func(u LLMUsage) { usage = append(usage, u) }}
+
+	// Dos errores transitorios y después respuesta del modelo principal
+	calls := fakeChatServer(t, func(model string, call int) int {
+		if call <= 2 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	response, err := chatCompletion(context.Background(), session, OperationFollowUp, testParams())
+	if err != nil || response.Choices[0].Message.Content != "ok "+CoachModel || len(*calls) != 3 {
+		t.Fatalf("se esperaban dos reintentos: %v, %d llamadas", err, len(*calls))
+	}
+	if len(usage) != 3 || usage[0].Error == "" || usage[2].PromptTokens != 10 {
+		t.Fatalf("cada intento debe registrar su consumo: %+v", usage)
+	}
+
+	// El modelo principal agota los reintentos y responde el de respaldo
+	calls = fakeChatServer(t, func(model string, call int) int {
+		if model == CoachModel {
+			return http.StatusTooManyRequests
+		}
+		return http.StatusOK
+	})
+	response, err = chatCompletion(context.Background(), session, OperationFollowUp, testParams())
+	if err != nil || response.Choices[0].Message.Content != "ok modelo-respaldo" {
+		t.Fatalf("se esperaba la respuesta del modelo de respaldo: %v", err)
+	}
+	if strings.Join(*calls, ",") != CoachModel+","+CoachModel+","+CoachModel+",modelo-respaldo" {
+		t.Fatalf("secuencia de modelos inesperada: %v", *calls)
+	}
+
+	// Un error de la petición no se reintenta, pero sí pasa al modelo de respaldo
+	calls = fakeChatServer(t, func(string, int) int { return http.StatusBadRequest })
+	if _, err := chatCompletion(context.Background(), session, OperationFollowUp, testParams()); err == nil ||
+		strings.Join(*calls, ",") != CoachModel+",modelo-respaldo" {
+		t.Fatalf("un 400 no debe reintentarse: %v, llamadas %v", err, *calls)
+	}
+	t.Setenv("LLM_FALLBACK_MODEL", "")
+	calls = fakeChatServer(t, func(string, int) int { return http.StatusBadRequest })
+	if _, err := chatCompletion(context.Background(), session, OperationFollowUp, testParams()); err == nil || len(*calls) != 1 {
+		t.Fatalf("sin respaldo un 400 debe fallar a la primera: %v, %d llamadas", err, len(*calls))
+	}
+}
+
+func TestChatCompletionFallbackAfterNonTransientError(t *testing.T) {
+	t.Setenv("LLM_MAX_RETRIES", "2")
+	t.Setenv("LLM_FALLBACK_MODEL", "modelo-respaldo")
+
+	// Modelo principal retirado o mal escrito
+	calls := fakeChatServer(t, func(model string, call int) int {
+		if model == CoachModel {
+			return http.StatusNotFound
+		}
+		return http.StatusOK
+	})
+	response, err := chatCompletion(context.Background(), CoachSession{}, OperationFollowUp, testParams())
+	if err != nil || response.Choices[0].Message.Content != "ok modelo-respaldo" {
+		t.Fatalf("se esperaba la respuesta del modelo de respaldo: %v", err)
+	}
+	if strings.Join(*calls, ",") != CoachModel+",modelo-respaldo" {
+		t.Fatalf("un 404 no debe reintentarse: %v", *calls)
+	}
+}
+
+func TestChatCompletionKeepsTimeForFallback(t *testing.T) {
+	t.Setenv("LLM_MAX_RETRIES", "5")
+	t.Setenv("LLM_FALLBACK_MODEL", "modelo-respaldo")
+
+	// El principal tarda y falla siempre: sus seis intentos no cabrían en el tiempo de la petición
+	calls := fakeChatServer(t, func(model string, call int) int {
+		if model == CoachModel {
+			time.Sleep(150 * time.Millisecond)
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 600*time.Millisecond)
+	defer cancel()
+	response, err := chatCompletion(ctx, CoachSession{}, OperationFollowUp, testParams())
+	if err != nil || response.Choices[0].Message.Content != "ok modelo-respaldo" {
+		t.Fatalf("el modelo de respaldo debe responder dentro del tiempo de la petición: %v, llamadas %v", err, *calls)
+	}
+}
+
+func TestChatCompletionStopsWhenRequestIsCancelled(t *testing.T) {
+	t.Setenv("LLM_MAX_RETRIES", "5")
+	calls := fakeChatServer(t, func(string, int) int { return http.StatusServiceUnavailable })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session := CoachSession{UserID: 1, Usage: func(LLMUsage) { cancel() }}
+	_, err := chatCompletion(ctx, session, OperationFollowUp, testParams())
+	if !errors.Is(err, context.Canceled) || len(*calls) != 1 {
+		t.Fatalf("la cancelación debe detener los reintentos: %v, %d llamadas", err, len(*calls))
+	}
+
+	// El tiempo máximo de cada intento es un error transitorio
+	t.Setenv("LLM_TIMEOUT", "1ns")
+	t.Setenv("LLM_MAX_RETRIES", "1")
+	if _, err := chatCompletion(context.Background(), CoachSession{}, OperationFollowUp, testParams()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("se esperaba el timeout del intento: %v", err)
+	}
+}
+
+func TestConverseRollsBackOnFailure(t *testing.T) {
+	t.Setenv("LLM_MAX_RETRIES", "0")
+	fakeChatServer(t, func(string, int) int { return http.StatusInternalServerError })
+
+	store := &memoryConversationStore{conversations: map[int]Conversation{}}
+	c := &Conversation{UserID: 3}
+	c.Append("pregunta anterior", "respuesta anterior")
+	store.SaveConversation(c)
+
+	session := CoachSession{UserID: 3, Locale: DefaultLocale, Conversations: store}
+	if _, err := runAssistant(session, OperationFollowUp, "nueva pregunta"); err == nil {
+		t.Fatal("se esperaba el error del modelo")
+	}
+	saved, _ := store.LoadConversation(3)
+	if len(saved.Turns) != 2 || saved.Turns[1].Content != "respuesta anterior" {
+		t.Fatalf("la pregunta sin respuesta no debe quedar en el historial: %+v", saved.Turns)
+	}
+}
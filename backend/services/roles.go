@@ -0,0 +1,38 @@
+package services
+
+import (
+	"os"
+	"strings"
+)
+
+// Roles de las cuentas de usuario
+const (
+	AccountRoleUser  = "user"
+	AccountRoleAdmin = "admin"
+)
+
+// ValidRole indica si el rol existe
+func ValidRole(role string) bool {
+	return role == AccountRoleUser || role == AccountRoleAdmin
+}
+
+// AdminEmails devuelve los emails de ADMIN_EMAILS (separados por comas), que reciben el rol de
+// administrador al arrancar para poder dar de alta al primero
+func AdminEmails() []string {
+	emails := []string{}
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
+
+// IsAdmin indica si el usuario tiene el rol de administrador según UserRole
+func (s *AuthService) IsAdmin(userID int) bool {
+	if s.UserRole == nil {
+		return false
+	}
+	role, err := s.UserRole(userID)
+	return err == nil && role == AccountRoleAdmin
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAdminEmails(t *testing.T) {
+	t.Setenv("ADMIN_EMAILS", " Ana@Example.com, ,b@example.com ")
+	if got := strings.Join(AdminEmails(), ","); got != "ana@example.com,b@example.com" {
+		t.Fatalf("emails inesperados: %q", got)
+	}
+	t.Setenv("ADMIN_EMAILS", "")
+	if got := AdminEmails(); len(got) != 0 {
+		t.Fatalf("sin ADMIN_EMAILS no debe haber administradores: %v", got)
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	s := newTestAuthService(t, "k1=secreto-de-prueba", "")
+
+	// Sin UserRole nadie es administrador
+	if s.IsAdmin(1) {
+		t.Fatal("sin UserRole nadie debe ser administrador")
+	}
+
+	s.UserRole = func(userID int) (string, error) {
+		switch userID {
+		case 1:
+			return AccountRoleAdmin, nil
+		case 2:
+			return AccountRoleUser, nil
+		}
+		return "", errors.New("no existe o está desactivado")
+	}
+	if !s.IsAdmin(1) || s.IsAdmin(2) || s.IsAdmin(3) {
+		t.Fatal("roles inesperados")
+	}
+	if !ValidRole(AccountRoleAdmin) || !ValidRole(AccountRoleUser) || ValidRole("root") {
+		t.Fatal("ValidRole inesperado")
+	}
+}
+
+func TestImpersonationToken(t *testing.T) {
+	s := newTestAuthService(t, "k1=secreto-de-prueba", "")
+
+	token, err := s.GenerateImpersonationToken(7, "a@b.c", "Ana", 42, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := s.ValidateToken(token)
+	if err != nil || claims.UserID != 7 || claims.SessionID != 42 || claims.ImpersonatorID != 1 {
+		t.Fatalf("token de soporte inesperado: %+v %v", claims, err)
+	}
+
+	token, _ = s.GenerateToken(7, "a@b.c", "Ana", 42)
+	if claims, err := s.ValidateToken(token); err != nil || claims.ImpersonatorID != 0 {
+		t.Fatalf("un token normal no debe llevar administrador: %+v %v", claims, err)
+	}
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Propósitos de las URLs firmadas: una URL firmada para un propósito no sirve para otro
+const (
+	PurposeStravaAuth   = "strava-auth"
+	PurposeWorkoutImage = "workout-image"
+	PurposeDataExport   = "data-export"
+	PurposeOAuthLink    = "oauth-link"
+)
+
+// Validez por defecto de las URLs firmadas
+const (
+	StravaAuthURLTTL   = 2 * time.Minute
+	WorkoutImageURLTTL = 15 * time.Minute
+	DataExportURLTTL   = 15 * time.Minute
+	OAuthLinkURLTTL    = 2 * time.Minute
+)
+
+// signedURLMessage es el texto que se firma: propósito, ruta exacta, usuario, sesión y caducidad
+func signedURLMessage(purpose, path string, userID int, sessionID, exp int64) string {
+	return fmt.Sprintf("url\n%s\n%s\n%d\n%d\n%d", purpose, path, userID, sessionID, exp)
+}
+
+// SignURL devuelve path con los parámetros de una URL firmada de corta duración para un único
+// propósito, para los enlaces que tienen que llevar la autenticación (redirecciones y descargas).
+// La URL queda ligada a la sesión: deja de valer si esta se cierra.
+func (s *AuthService) SignURL(purpose, path string, userID int, sessionID int64, ttl time.Duration) string {
+	exp := time.Now().Add(ttl).Unix()
+	signature := sign(s.keys[s.activeKID], signedURLMessage(purpose, path, userID, sessionID, exp))
+
+	query := url.Values{}
+	query.Set("uid", strconv.Itoa(userID))
+	query.Set("sid", strconv.FormatInt(sessionID, 10))
+	query.Set("exp", strconv.FormatInt(exp, 10))
+	query.Set("kid", s.activeKID)
+	query.Set("sig", base64.RawURLEncoding.EncodeToString(signature))
+	return path + "?" + query.Encode()
+}
+
+// VerifySignedURL comprueba una URL firmada para purpose y devuelve su usuario y su sesión
+func (s *AuthService) VerifySignedURL(purpose string, u *url.URL) (userID int, sessionID int64, err error) {
+	query := u.Query()
+	userID, err = strconv.Atoi(query.Get("uid"))
+	if err != nil {
+		return 0, 0, errors.New("URL firmada inválida")
+	}
+	sessionID, err = strconv.ParseInt(query.Get("sid"), 10, 64)
+	if err != nil || sessionID == 0 {
+		return 0, 0, errors.New("URL firmada inválida")
+	}
+	exp, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("URL firmada inválida")
+	}
+	key, ok := s.keys[query.Get("kid")]
+	if !ok {
+		return 0, 0, errors.New("clave de la URL firmada desconocida")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(query.Get("sig"))
+	if err != nil || !hmac.Equal(signature, sign(key, signedURLMessage(purpose, u.Path, userID, sessionID, exp))) {
+		return 0, 0, errors.New("firma de la URL inválida")
+	}
+
+	if time.Now().Unix() > exp {
+		return 0, 0, errors.New("URL firmada caducada")
+	}
+	if s.SessionActive != nil && !s.SessionActive(sessionID, userID) {
+		return 0, 0, errors.New("sesión cerrada")
+	}
+	return userID, sessionID, nil
+}
+
+// CSRFToken devuelve el token anti-CSRF de una sesión. Se deriva de la sesión con la clave de
+// firma, así que no hay que guardarlo y no cambia al renovar el token de acceso.
+func (s *AuthService) CSRFToken(sessionID int64) string {
+	return base64.RawURLEncoding.EncodeToString(sign(s.keys[s.activeKID], fmt.Sprintf("csrf\n%d", sessionID)))
+}
+
+// VerifyCSRFToken comprueba el token anti-CSRF de una sesión con cualquiera de las claves
+func (s *AuthService) VerifyCSRFToken(sessionID int64, token string) bool {
+	signature, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || token == "" {
+		return false
+	}
+	for _, key := range s.keys {
+		if hmac.Equal(signature, sign(key, fmt.Sprintf("csrf\n%d", sessionID))) {
+			return true
+		}
+	}
+	return false
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -50,6 +51,7 @@ type StravaActivity struct {
 	AverageCadence   float64   `json:"average_cadence"` // pasos por minuto
 	AverageWatts     float64   `json:"average_watts"`   // potencia
 	DeviceWatts      bool      `json:"device_watts"`    // indica si tiene medidor de potencia
+	GearID           string    `json:"gear_id"`         // zapatillas usadas (p.ej. g27526119)
 }
 
 // StravaService handles API calls with a specific access token
@@ -93,6 +95,59 @@ func (s *StravaService) GetActivityDetail(activityID int) (map[string]interface{
 	return activityDetail, nil
 }
 
+// ActivityStreams contiene las series temporales de una actividad, alineadas por índice de muestra
+type ActivityStreams struct {
+	Time      []float64 `json:"time"`            // segundos desde el inicio
+	Distance  []float64 `json:"distance"`        // metros acumulados
+	Velocity  []float64 `json:"velocity_smooth"` // m/s
+	Heartrate []float64 `json:"heartrate"`       // bpm
+	Watts     []float64 `json:"watts"`           // potencia
+	Cadence   []float64 `json:"cadence"`         // pasos por minuto (una pierna)
+	Altitude  []float64 `json:"altitude"`        // metros
+}
+
+// GetActivityStreams fetches the time series (streams) of a specific activity
+func (s *StravaService) GetActivityStreams(activityID int) (*ActivityStreams, error) {
+	url := fmt.Sprintf("https://www.strava.com/api/v3/activities/%d/streams?keys=time,distance,velocity_smooth,heartrate,watts,cadence,altitude&key_by_type=true", activityID)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.accessToken))
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching activity streams: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Strava API error: %s (status: %d)", string(body), resp.StatusCode)
+	}
+
+	// Con key_by_type=true cada stream llega como {"<tipo>": {"data": [...]}}
+	var raw map[string]struct {
+		Data []float64 `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+
+	return &ActivityStreams{
+		Time:      raw["time"].Data,
+		Distance:  raw["distance"].Data,
+		Velocity:  raw["velocity_smooth"].Data,
+		Heartrate: raw["heartrate"].Data,
+		Watts:     raw["watts"].Data,
+		Cadence:   raw["cadence"].Data,
+		Altitude:  raw["altitude"].Data,
+	}, nil
+}
+
 var stravaClient *StravaClient
 
 // InitializeStrava inicializa el cliente de Strava
@@ -108,19 +163,32 @@ func InitializeStrava() {
 	}
 }
 
-// GetAuthorizationURL genera la URL para que el usuario autorice la app
-func (s *StravaClient) GetAuthorizationURL(userID int) string {
+// GetAuthorizationURL genera la URL para que el usuario autorice la app. state es el state
+// firmado del flujo (NewOAuthState); redirectURI vacío usa STRAVA_REDIRECT_URI.
+func (s *StravaClient) GetAuthorizationURL(state, redirectURI string) string {
+	if redirectURI == "" {
+		redirectURI = s.RedirectURI
+	}
 	baseURL := "https://www.strava.com/oauth/authorize"
 	params := url.Values{}
 	params.Add("client_id", s.ClientID)
-	params.Add("redirect_uri", s.RedirectURI)
+	params.Add("redirect_uri", redirectURI)
 	params.Add("response_type", "code")
 	params.Add("scope", "activity:read_all,profile:read_all")
-	params.Add("state", fmt.Sprintf("%d", userID)) // Pasar user_id en state
+	params.Add("state", state)
 
 	return fmt.Sprintf("%s?%s", baseURL, params.Encode())
 }
 
+// Identity devuelve la identidad del atleta de Strava con la que se inicia sesión
+func (t *StravaTokenResponse) Identity() ExternalIdentity {
+	return ExternalIdentity{
+		Provider: ProviderStrava,
+		Subject:  strconv.Itoa(t.Athlete.ID),
+		Name:     strings.TrimSpace(t.Athlete.Firstname + " " + t.Athlete.Lastname),
+	}
+}
+
 // ExchangeToken intercambia el código de autorización por tokens de acceso
 func (s *StravaClient) ExchangeToken(code string) (*StravaTokenResponse, error) {
 	tokenURL := "https://www.strava.com/oauth/token"
@@ -179,6 +247,26 @@ func (s *StravaClient) RefreshAccessToken(refreshToken string) (*StravaTokenResp
 	return &tokenResp, nil
 }
 
+// Deauthorize revoca el acceso de la app a la cuenta de Strava; Strava invalida todos los tokens
+func (s *StravaClient) Deauthorize(accessToken string) error {
+	data := url.Values{}
+	data.Set("access_token", accessToken)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.PostForm("https://www.strava.com/oauth/deauthorize", data)
+	if err != nil {
+		return fmt.Errorf("error revocando acceso: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Un token ya revocado devuelve 401: el acceso ya no existe
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("error de Strava: %s", string(body))
+	}
+	return nil
+}
+
 // GetActivities obtiene las actividades del atleta
 func (s *StravaClient) GetActivities(accessToken string, after int64, perPage int) ([]StravaActivity, error) {
 	activitiesURL := "https://www.strava.com/api/v3/athlete/activities"
@@ -250,7 +338,7 @@ func (s *StravaClient) GetActivity(accessToken string, activityID int64) (*Strav
 }
 
 // ConvertToWorkoutData convierte una actividad de Strava a datos de workout
-func ConvertStravaActivityToWorkout(activity *StravaActivity) map[string]interface{} {
+func ConvertStravaActivityToWorkout(activity *StravaActivity, locale string) map[string]interface{} {
 	// Convertir distancia de metros a km
 	distanceKm := activity.Distance / 1000
 
@@ -317,7 +405,7 @@ func ConvertStravaActivityToWorkout(activity *StravaActivity) map[string]interfa
 		"cadence":        int(activity.AverageCadence),
 		"elevation_gain": int(activity.TotalElevation),
 		"calories":       int(activity.Calories),
-		"notes":          fmt.Sprintf("Importado desde Strava: %s", activity.Name),
+		"notes":          T(locale, "Importado desde Strava: %s", activity.Name),
 		"feeling":        "good", // Por defecto
 	}
 }
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Parámetros TOTP (RFC 6238) compatibles con las apps de autenticación habituales
+const (
+	TOTPIssuer = "TrainApp"
+	totpDigits = 6
+	totpPeriod = 30 // segundos
+	totpSkew   = 1  // periodos de margen antes y después por desfase de relojes
+)
+
+// Códigos de recuperación que se generan al activar el 2FA o al regenerarlos
+const RecoveryCodeCount = 10
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret genera el secreto de una app de autenticación, en base32
+func NewTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPProvisioningURI devuelve la URI otpauth:// que se muestra como código QR para dar de alta
+// el secreto en la app de autenticación
+func TOTPProvisioningURI(account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", TOTPIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	return (&url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + TOTPIssuer + ":" + account,
+		RawQuery: query.Encode(),
+	}).String()
+}
+
+// TOTPCode calcula el código del secreto en el instante t
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, t.Unix()/totpPeriod), nil
+}
+
+// VerifyTOTP comprueba un código con el margen de desfase permitido. Solo acepta periodos
+// posteriores a lastStep (el último código usado), para que un código no sirva dos veces;
+// devuelve el periodo del código aceptado para guardarlo como nuevo lastStep.
+func VerifyTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	key, err := decodeTOTPSecret(secret)
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// decodeTOTPSecret decodifica un secreto base32, admitiendo minúsculas y espacios
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(secret), " ", ""))
+	key, err := totpEncoding.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, errors.New("secreto TOTP inválido")
+	}
+	return key, nil
+}
+
+// totpCode es el HOTP (RFC 4226) de la clave para el contador step
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// NewRecoveryCodes genera los códigos de recuperación de un solo uso ("abcde-fghij") y los hashes
+// con los que se guardan
+func NewRecoveryCodes() (codes, hashes []string, err error) {
+	encoding := base32.NewEncoding("abcdefghijkmnpqrstuvwxyz23456789").WithPadding(base32.NoPadding)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := encoding.EncodeToString(raw)[:10]
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode devuelve el hash con el que se guarda un código de recuperación; ignora
+// mayúsculas, espacios y guiones al teclearlo
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code))
+	return HashRefreshToken(code)
+}
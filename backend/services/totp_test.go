@@ -0,0 +1,110 @@
+package services
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// Vectores de la RFC 6238 (SHA1, secreto "12345678901234567890"), con 6 dígitos
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		got, err := TOTPCode(secret, time.Unix(unix, 0))
+		if err != nil || got != want {
+			t.Errorf("t=%d: %q %v, esperado %q", unix, got, err, want)
+		}
+	}
+	if _, err := TOTPCode("no es base32!", time.Now()); err == nil {
+		t.Fatal("un secreto inválido debería dar error")
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+
+	previous, _ := TOTPCode(secret, now.Add(-totpPeriod*time.Second))
+	step, ok := VerifyTOTP(secret, previous, now, 0)
+	if !ok || step != now.Unix()/totpPeriod-1 {
+		t.Fatalf("el código del periodo anterior debería aceptarse: %d %v", step, ok)
+	}
+
+	// Un código ya usado, o uno anterior al último usado, no vuelve a valer
+	if _, ok := VerifyTOTP(secret, previous, now, step); ok {
+		t.Fatal("un código usado no debe aceptarse otra vez")
+	}
+	current, _ := TOTPCode(secret, now)
+	if _, ok := VerifyTOTP(secret, current[:3]+" "+current[3:], now, step); !ok {
+		t.Fatal("el código actual debería aceptarse (con espacios)")
+	}
+
+	old, _ := TOTPCode(secret, now.Add(-2*time.Minute))
+	if _, ok := VerifyTOTP(secret, old, now, 0); ok {
+		t.Fatal("un código fuera del margen no debe aceptarse")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri, err := url.Parse(TOTPProvisioningURI("ana@example.com", "JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := uri.Query()
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/TrainApp:ana@example.com" ||
+		query.Get("secret") != "JBSWY3DPEHPK3PXP" || query.Get("issuer") != "TrainApp" || query.Get("digits") != "6" {
+		t.Fatalf("URI inesperada: %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount || len(hashes) != RecoveryCodeCount {
+		t.Fatalf("se esperaban %d códigos: %v", RecoveryCodeCount, codes)
+	}
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if len(code) != 11 || code[5] != '-' || seen[code] {
+			t.Fatalf("código inesperado: %q", code)
+		}
+		seen[code] = true
+		// Se acepta tecleado en mayúsculas y sin guion
+		if HashRecoveryCode(strings.ToUpper(strings.Replace(code, "-", " ", 1))) != hashes[i] {
+			t.Fatalf("el hash de %q no coincide", code)
+		}
+	}
+}
+
+func TestMFAToken(t *testing.T) {
+	s := newTestAuthService(t, "k1=secreto-de-prueba", "")
+
+	partial, err := s.GenerateMFAToken(7, "a@b.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := s.ValidateMFAToken(partial)
+	if err != nil || claims.UserID != 7 || claims.Exp-claims.Iat != int64(MFATokenTTL.Seconds()) {
+		t.Fatalf("claims inesperados: %+v %v", claims, err)
+	}
+
+	// El token parcial no da acceso a la API y el de acceso no sirve como parcial
+	if _, err := s.ValidateToken(partial); err == nil {
+		t.Fatal("el token parcial no debe aceptarse como token de acceso")
+	}
+	access, _ := s.GenerateToken(7, "a@b.c", "Ana", 42)
+	if _, err := s.ValidateMFAToken(access); err == nil {
+		t.Fatal("el token de acceso no debe aceptarse como token parcial")
+	}
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// LLMUsage es el consumo de una llamada al modelo: se registra una por petición a la API,
+// incluidas las rondas de herramientas
+type LLMUsage struct {
+	UserID           int
+	Operation        string // prompt o tarea que originó la llamada (workout-analysis, follow-up...)
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	Latency          time.Duration
+	Error            string
+}
+
+// Operaciones que no corresponden a una plantilla de prompt
+const OperationFollowUp = "follow-up"
+
+// LLMQuota son los límites diarios por usuario; 0 significa sin límite
+type LLMQuota struct {
+	DailyTokens   int `json:"daily_tokens"`
+	DailyRequests int `json:"daily_requests"`
+}
+
+// DailyLLMQuota devuelve los límites configurados en LLM_DAILY_TOKEN_QUOTA y LLM_DAILY_REQUEST_QUOTA
+func DailyLLMQuota() LLMQuota {
+	quota := LLMQuota{}
+	if value, err := strconv.Atoi(os.Getenv("LLM_DAILY_TOKEN_QUOTA")); err == nil && value > 0 {
+		quota.DailyTokens = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("LLM_DAILY_REQUEST_QUOTA")); err == nil && value > 0 {
+		quota.DailyRequests = value
+	}
+	return quota
+}
+
+// Exceeded indica si el consumo del día alcanza alguno de los límites
+func (q LLMQuota) Exceeded(tokens, requests int) bool {
+	return (q.DailyTokens > 0 && tokens >= q.DailyTokens) || (q.DailyRequests > 0 && requests >= q.DailyRequests)
+}
+
+// QuotaResetsAt devuelve cuándo se reinicia la cuota diaria (medianoche UTC siguiente)
+func QuotaResetsAt(now time.Time) time.Time {
+	return now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// ModelPrice es el precio en USD por millón de tokens de entrada y de salida
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// Precios por defecto; LLM_PRICES los sustituye o añade otros modelos
+var defaultModelPrices = map[string]ModelPrice{
+	CoachModel: {Input: 1.25, Output: 10},
+}
+
+// ModelPrices devuelve los precios por modelo. LLM_PRICES tiene el formato
+// "modelo=entrada:salida,..." en USD por millón de tokens (p. ej. "gpt-5.1=1.25:10").
+func ModelPrices() (map[string]ModelPrice, error) {
+	prices := map[string]ModelPrice{}
+	for model, price := range defaultModelPrices {
+		prices[model] = price
+	}
+
+	for _, entry := range strings.Split(os.Getenv("LLM_PRICES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		model, values, found := strings.Cut(entry, "=")
+		input, output, hasOutput := strings.Cut(values, ":")
+		in, errIn := strconv.ParseFloat(strings.TrimSpace(input), 64)
+		out, errOut := strconv.ParseFloat(strings.TrimSpace(output), 64)
+		if !found || !hasOutput || errIn != nil || errOut != nil || in < 0 || out < 0 {
+			return nil, fmt.Errorf("precio inválido %q en LLM_PRICES (se espera modelo=entrada:salida)", entry)
+		}
+		prices[strings.TrimSpace(model)] = ModelPrice{Input: in, Output: out}
+	}
+
+	return prices, nil
+}
+
+// Cost devuelve el coste estimado en USD de un consumo de tokens
+func (p ModelPrice) Cost(promptTokens, completionTokens int) float64 {
+	return (float64(promptTokens)*p.Input + float64(completionTokens)*p.Output) / 1e6
+}
+
+// chatCompletion llama al modelo con un tiempo máximo por intento derivado del contexto de la
+// sesión, reintenta los errores transitorios con espera exponencial y, si el modelo principal
+// sigue fallando por cualquier motivo, pasa al modelo de respaldo. Registra el consumo de cada
+// intento.
+func chatCompletion(ctx context.Context, session CoachSession, operation string, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	models := []string{string(params.Model.Value)}
+	if fallback := FallbackModel(); fallback != "" && fallback != models[0] {
+		models = append(models, fallback)
+	}
+
+	var err error
+	for i, model := range models {
+		if i > 0 {
+			log.Printf("⚠️  %s: el modelo %s no responde (%v), se usa %s", operation, models[i-1], err, model)
+		}
+		params.Model = openai.F(model)
+
+		modelCtx, cancel := modelContext(ctx, len(models)-i)
+		var response *openai.ChatCompletion
+		response, err = completionWithRetries(modelCtx, session, operation, params)
+		cancel()
+		if err == nil {
+			return response, nil
+		}
+		// La petición del usuario se canceló o agotó su tiempo: no tiene sentido seguir
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("error llamando a chat completions: %w", ctx.Err())
+		}
+	}
+
+	return nil, fmt.Errorf("error llamando a chat completions: %w", err)
+}
+
+// modelContext reparte el tiempo que queda de la petición entre los modelos pendientes, de modo
+// que los reintentos del principal no dejen sin tiempo al de respaldo
+func modelContext(ctx context.Context, pending int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || pending <= 1 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(pending))
+}
+
+// completionWithRetries llama a un modelo y reintenta sus errores transitorios hasta
+// LLM_MAX_RETRIES veces o hasta que se acabe el tiempo de ctx; devuelve el último error
+func completionWithRetries(ctx context.Context, session CoachSession, operation string, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	maxRetries := LLMMaxRetries()
+
+	var err error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 && sleepContext(ctx, retryDelay(err, attempt)) != nil {
+			return nil, err
+		}
+
+		var response *openai.ChatCompletion
+		response, err = completionAttempt(ctx, session, operation, params)
+		if err == nil {
+			return response, nil
+		}
+		if ctx.Err() != nil || !isTransient(err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// completionAttempt hace una llamada al modelo con el tiempo máximo de LLM_TIMEOUT y registra su consumo
+func completionAttempt(ctx context.Context, session CoachSession, operation string, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	ctx, cancel := context.WithTimeout(ctx, LLMTimeout())
+	defer cancel()
+
+	start := time.Now()
+	response, err := client.Chat.Completions.New(ctx, params)
+
+	if session.Usage != nil {
+		usage := LLMUsage{
+			UserID:    session.UserID,
+			Operation: operation,
+			Model:     string(params.Model.Value),
+			Latency:   time.Since(start),
+		}
+		if err != nil {
+			usage.Error = err.Error()
+		} else {
+			usage.PromptTokens = int(response.Usage.PromptTokens)
+			usage.CompletionTokens = int(response.Usage.CompletionTokens)
+		}
+		session.Usage(usage)
+	}
+
+	return response, err
+}
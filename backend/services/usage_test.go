@@ -0,0 +1,53 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLLMQuota(t *testing.T) {
+	t.Setenv("LLM_DAILY_TOKEN_QUOTA", "1000")
+	t.Setenv("LLM_DAILY_REQUEST_QUOTA", "abc")
+
+	quota := DailyLLMQuota()
+	if quota.DailyTokens != 1000 || quota.DailyRequests != 0 {
+		t.Fatalf("cuota inesperada: %+v", quota)
+	}
+	if quota.Exceeded(999, 500) {
+		t.Fatal("sin límite de llamadas solo cuentan los tokens")
+	}
+	if !quota.Exceeded(1000, 0) {
+		t.Fatal("al alcanzar el límite de tokens la cuota debe estar agotada")
+	}
+	if (LLMQuota{}).Exceeded(1e9, 1e9) {
+		t.Fatal("una cuota vacía no tiene límite")
+	}
+
+	now := time.Date(2025, 11, 16, 22, 30, 0, 0, time.FixedZone("CET", 3600))
+	if got := QuotaResetsAt(now); !got.Equal(time.Date(2025, 11, 17, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("reinicio inesperado: %v", got)
+	}
+}
+
+func TestModelPrices(t *testing.T) {
+	t.Setenv("LLM_PRICES", "gpt-4o-mini=0.15:0.6, "+CoachModel+"=2:8")
+
+	prices, err := ModelPrices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prices[CoachModel] != (ModelPrice{Input: 2, Output: 8}) {
+		t.Fatalf("LLM_PRICES debería sustituir el precio por defecto: %+v", prices[CoachModel])
+	}
+	if cost := prices["gpt-4o-mini"].Cost(1_000_000, 500_000); math.Abs(cost-0.45) > 1e-9 {
+		t.Fatalf("coste inesperado: %v", cost)
+	}
+
+	for _, value := range []string{"gpt-4o-mini=0.15", "gpt-4o-mini", "gpt-4o-mini=a:b", "gpt-4o-mini=-1:2"} {
+		t.Setenv("LLM_PRICES", value)
+		if _, err := ModelPrices(); err == nil {
+			t.Fatalf("%q debería ser inválido", value)
+		}
+	}
+}
@@ -1,27 +1,39 @@
 // API Base URL - Automática según el entorno
-const API_URL = window.location.hostname === 'localhost' || window.location.hostname === '127.0.0.1'
+// (en local, si el frontend no lo sirve el backend, debe estar en ALLOWED_ORIGINS para las cookies)
+const API_URL = (window.location.hostname === 'localhost' || window.location.hostname === '127.0.0.1') && window.location.port !== '8080'
     ? 'http://localhost:8080/api'
     : `${window.location.origin}/api`;
 
 // Estado global
 let currentUser = null;
 let allWorkouts = [];
+// Análisis al que se refieren las preguntas de seguimiento
+let formAnalysisId = null;
+let imageAnalysisId = null;
 
-// Helper para obtener headers con autenticación
+// Helper para obtener headers con autenticación. La sesión va en cookies httpOnly; las
+// peticiones que modifican datos llevan además el token anti-CSRF de la sesión.
 function getAuthHeaders() {
-    const token = localStorage.getItem('auth_token');
     return {
         'Content-Type': 'application/json',
-        'Authorization': token ? `Bearer ${token}` : ''
+        'X-CSRF-Token': localStorage.getItem('csrf_token') || ''
     };
 }
 
-// Wrapper para fetch con autenticación automática
-async function fetchAPI(url, options = {}) {
+// Wrapper para fetch con autenticación automática. Si el token de acceso ha caducado se
+// renueva con el token de refresco y se repite la petición una vez.
+async function fetchAPI(url, options = {}, retried = false) {
     const headers = { ...getAuthHeaders(), ...options.headers };
-    const response = await fetch(url, { ...options, headers });
+    // Con FormData el navegador pone el Content-Type multipart con su boundary
+    if (options.body instanceof FormData) {
+        delete headers['Content-Type'];
+    }
+    const response = await fetch(url, { ...options, headers, credentials: 'include' });
     
     if (response.status === 401) {
+        if (!retried && await refreshSession()) {
+            return fetchAPI(url, options, true);
+        }
         logout();
         throw new Error('No autorizado');
     }
@@ -29,19 +41,39 @@ async function fetchAPI(url, options = {}) {
     return response;
 }
 
+// Renovar el token de acceso; varias peticiones a la vez comparten la misma renovación
+let refreshPromise = null;
+function refreshSession() {
+    if (!refreshPromise) {
+        refreshPromise = fetch(`${API_URL}/auth/refresh`, {
+            method: 'POST',
+            headers: getAuthHeaders(),
+            credentials: 'include'
+        })
+            .then(async (response) => {
+                if (!response.ok) return false;
+                const data = await response.json();
+                localStorage.setItem('csrf_token', data.csrf_token);
+                return true;
+            })
+            .catch(() => false)
+            .finally(() => { refreshPromise = null; });
+    }
+    return refreshPromise;
+}
+
 // Verificar autenticación
 function checkAuth() {
-    const token = localStorage.getItem('auth_token');
-    if (!token) {
+    if (!localStorage.getItem('user')) {
         window.location.href = '/login.html';
         return false;
     }
     return true;
 }
 
-// Logout
+// Logout (solo en este navegador; la sesión del servidor se cierra en handleLogout)
 function logout() {
-    localStorage.removeItem('auth_token');
+    localStorage.removeItem('csrf_token');
     localStorage.removeItem('user');
     window.location.href = '/login.html';
 }
@@ -113,11 +145,15 @@ document.addEventListener('click', (e) => {
     }
 });
 
-function handleLogout() {
-    showToast('Cerrando sesión...', 'info');
-    setTimeout(() => {
-        logout();
-    }, 500);
+// Cerrar la sesión en el servidor (all = en todos los dispositivos) y salir
+async function handleLogout(all = false) {
+    showToast(all ? 'Cerrando sesión en todos los dispositivos...' : 'Cerrando sesión...', 'info');
+    try {
+        await fetchAPI(`${API_URL}/auth/${all ? 'logout-all' : 'logout'}`, { method: 'POST' });
+    } catch (error) {
+        console.error('Error cerrando sesión:', error);
+    }
+    logout();
 }
 
 // Inicializar la aplicación
@@ -127,6 +163,7 @@ document.addEventListener('DOMContentLoaded', () => {
     
     loadUser();
     loadWorkouts();
+    loadAdvice();
     setupEventListeners();
     checkStravaStatus();
     
@@ -138,6 +175,16 @@ document.addEventListener('DOMContentLoaded', () => {
         // Limpiar URL
         window.history.replaceState({}, document.title, window.location.pathname);
     }
+
+    // Vuelta de vincular Strava u OIDC para iniciar sesión
+    if (urlParams.has('linked') || urlParams.has('oauth_error')) {
+        if (urlParams.has('linked')) {
+            showToast('Cuenta vinculada: ya puedes usarla para iniciar sesión', 'success');
+        } else {
+            showToast(urlParams.get('oauth_error'), 'error');
+        }
+        window.history.replaceState({}, document.title, window.location.pathname);
+    }
 });
 
 // Setup Event Listeners
@@ -185,23 +232,22 @@ async function analyzeWorkoutFromImages() {
     try {
         showLoading(true);
         
-        // Convertir imágenes a base64
-        const imageURLs = [];
+        // Subir las capturas tal cual; el servidor valida el formato y elimina los metadatos
+        const formData = new FormData();
         for (let file of fileInput.files) {
-            const base64 = await fileToBase64(file);
-            imageURLs.push(base64);
+            formData.append('images', file);
         }
+        formData.append('notes', notes);
         
         const response = await fetchAPI(`${API_URL}/workout-analysis-image`, {
             method: 'POST',
-            body: JSON.stringify({ 
-                image_urls: imageURLs,
-                notes: notes 
-            })
+            body: formData
         });
         
         if (response.ok) {
             const result = await response.json();
+            imageAnalysisId = result.analysis_id || null;
+            loadAdvice();
             const resultDiv = document.getElementById('image-analysis-result');
             
             // Mostrar análisis con markdown y opción de guardar
@@ -209,22 +255,9 @@ async function analyzeWorkoutFromImages() {
                 <div class="analysis-section">
                     <h3>📊 Análisis del Entreno</h3>
                     <div class="markdown-content">${marked.parse(result.analysis)}</div>
+                    ${renderRecommendations(result.recommendations)}
                     
-                    ${result.workout_data ? `
-                        <div style="margin-top: 20px; padding: 15px; background: #e8f5e9; border-radius: 8px;">
-                            <h4>✅ Datos extraídos del entreno</h4>
-                            <div style="display: grid; grid-template-columns: repeat(auto-fit, minmax(150px, 1fr)); gap: 10px; margin-top: 10px;">
-                                ${result.workout_data.type ? `<div><strong>Tipo:</strong> ${translateWorkoutType(result.workout_data.type)}</div>` : ''}
-                                ${result.workout_data.distance ? `<div><strong>Distancia:</strong> ${result.workout_data.distance} km</div>` : ''}
-                                ${result.workout_data.duration ? `<div><strong>Duración:</strong> ${result.workout_data.duration} min</div>` : ''}
-                                ${result.workout_data.avg_pace ? `<div><strong>Ritmo:</strong> ${result.workout_data.avg_pace}</div>` : ''}
-                                ${result.workout_data.avg_heart_rate ? `<div><strong>FC:</strong> ${result.workout_data.avg_heart_rate} bpm</div>` : ''}
-                            </div>
-                            <button onclick="saveExtractedWorkout(${JSON.stringify(result.workout_data).replace(/"/g, '&quot;')})" class="btn btn-primary" style="margin-top: 15px;">
-                                💾 Guardar en el Historial
-                            </button>
-                        </div>
-                    ` : ''}
+                    ${result.workout_data ? renderExtractedWorkout(result.extraction_id, result.workout_data) : ''}
                     
                     <!-- Chat de conversación -->
                     <div class="chat-container" style="margin-top: 20px;">
@@ -251,7 +284,8 @@ async function analyzeWorkoutFromImages() {
             resultDiv.style.display = 'block';
             resultDiv.scrollIntoView({ behavior: 'smooth' });
         } else {
-            showToast('Error al analizar el entreno con imágenes', 'error');
+            const message = await response.text();
+            showToast(message || 'Error al analizar el entreno con imágenes', 'error');
         }
     } catch (error) {
         console.error('Error:', error);
@@ -281,7 +315,8 @@ async function askAboutImageAnalysis() {
             body: JSON.stringify({ 
                 image_urls: [], // Ya no necesitamos las imágenes
                 notes: '',
-                question: question 
+                question: question,
+                analysis_id: imageAnalysisId
             })
         });
         
@@ -305,47 +340,46 @@ async function askAboutImageAnalysis() {
     }
 }
 
-// Guardar workout extraído de la imagen
-async function saveExtractedWorkout(workoutData) {
+// Mostrar los datos extraídos de las capturas, marcando los que conviene revisar
+function renderExtractedWorkout(extractionId, data) {
+    const review = new Set(data.needs_review || []);
+    const field = (key, label, value) => {
+        if (value === null || value === undefined) return '';
+        const warning = review.has(key) ? ` <span title="${(data.issues && data.issues[key]) || 'Confianza baja, revisa el dato'}">⚠️</span>` : '';
+        return `<div><strong>${label}:</strong> ${value}${warning}</div>`;
+    };
+    const duration = data.duration_seconds ? `${Math.floor(data.duration_seconds / 60)}:${String(data.duration_seconds % 60).padStart(2, '0')} min` : null;
+    
+    return `
+        <div style="margin-top: 20px; padding: 15px; background: #e8f5e9; border-radius: 8px;">
+            <h4>✅ Datos extraídos del entreno</h4>
+            <div style="display: grid; grid-template-columns: repeat(auto-fit, minmax(150px, 1fr)); gap: 10px; margin-top: 10px;">
+                ${field('date', 'Fecha', data.date)}
+                ${field('type', 'Tipo', data.type ? translateWorkoutType(data.type) : null)}
+                ${field('distance', 'Distancia', data.distance != null ? `${data.distance} km` : null)}
+                ${field('duration_seconds', 'Duración', duration)}
+                ${field('avg_pace', 'Ritmo', data.avg_pace)}
+                ${field('avg_heart_rate', 'FC', data.avg_heart_rate != null ? `${data.avg_heart_rate} bpm` : null)}
+                ${field('avg_power', 'Potencia', data.avg_power != null ? `${data.avg_power} W` : null)}
+                ${field('cadence', 'Cadencia', data.cadence != null ? `${data.cadence} ppm` : null)}
+                ${field('elevation_gain', 'Desnivel', data.elevation_gain != null ? `${data.elevation_gain} m` : null)}
+            </div>
+            ${review.size > 0 ? '<p style="margin-top: 10px;">⚠️ Revisa los datos marcados antes de guardar.</p>' : ''}
+            <button onclick="saveExtractedWorkout(${extractionId})" class="btn btn-primary" style="margin-top: 15px;">
+                💾 Guardar en el Historial
+            </button>
+        </div>
+    `;
+}
+
+// Guardar como entreno los datos extraídos de la imagen
+async function saveExtractedWorkout(extractionId) {
     try {
         showLoading(true);
         
-        // Procesar fecha: intentar extraerla de workoutData o usar fecha actual
-        let workoutDate = new Date().toISOString();
-        
-        if (workoutData.date) {
-            // Si viene una fecha en formato ISO o timestamp
-            try {
-                workoutDate = new Date(workoutData.date).toISOString();
-            } catch (e) {
-                // Si falla, intentar parsear formatos comunes
-                const dateMatch = workoutData.date.match(/(\d{4})-(\d{2})-(\d{2})/);
-                if (dateMatch) {
-                    workoutDate = new Date(`${dateMatch[0]}T12:00:00`).toISOString();
-                }
-            }
-        }
-        
-        // Preparar el workout con valores por defecto para campos opcionales
-        const workout = {
-            user_id: currentUser ? currentUser.id : 1,
-            date: workoutDate,
-            type: workoutData.type || 'easy',
-            distance: parseFloat(workoutData.distance) || 0,
-            duration: parseInt(workoutData.duration) || 0,
-            avg_pace: workoutData.avg_pace || '',
-            avg_heart_rate: parseInt(workoutData.avg_heart_rate) || 0,
-            avg_power: parseInt(workoutData.avg_power) || 0,
-            cadence: parseInt(workoutData.cadence) || 0,
-            elevation_gain: parseInt(workoutData.elevation_gain) || 0,
-            calories: parseInt(workoutData.calories) || 0,
-            feeling: workoutData.feeling || 'good',
-            notes: workoutData.notes || 'Entreno importado desde captura del Apple Watch'
-        };
-        
-        const response = await fetchAPI(`${API_URL}/workouts`, {
+        const response = await fetchAPI(`${API_URL}/workout-extractions/${extractionId}/save`, {
             method: 'POST',
-            body: JSON.stringify(workout)
+            body: JSON.stringify({})
         });
         
         if (response.ok) {
@@ -356,7 +390,8 @@ async function saveExtractedWorkout(workoutData) {
             showTab('workouts');
             document.querySelector('.tab-btn[onclick*="workouts"]').classList.add('active');
         } else {
-            showToast('Error al guardar el entreno', 'error');
+            const message = await response.text();
+            showToast(message || 'Error al guardar el entreno', 'error');
         }
     } catch (error) {
         console.error('Error:', error);
@@ -366,16 +401,6 @@ async function saveExtractedWorkout(workoutData) {
     }
 }
 
-// Convertir archivo a base64
-function fileToBase64(file) {
-    return new Promise((resolve, reject) => {
-        const reader = new FileReader();
-        reader.readAsDataURL(file);
-        reader.onload = () => resolve(reader.result);
-        reader.onerror = error => reject(error);
-    });
-}
-
 // Generar plan semanal contextual
 async function generateWeeklyPlan() {
     try {
@@ -550,6 +575,77 @@ async function loadWorkouts() {
     }
 }
 
+// Etiquetas de las alertas de las recomendaciones
+const RISK_FLAG_LABELS = {
+    overload: 'Sobrecarga',
+    injury_risk: 'Riesgo de lesión',
+    fatigue: 'Fatiga',
+    illness: 'Enfermedad'
+};
+const RISK_SEVERITY_LABELS = { low: 'baja', medium: 'media', high: 'alta' };
+
+// Mostrar alertas como etiquetas con su gravedad
+function renderRiskFlags(flags) {
+    return (flags || []).map(f => `
+        <div class="risk-flag risk-${f.severity}">
+            ⚠️ <strong>${RISK_FLAG_LABELS[f.type] || f.type}</strong> (gravedad ${RISK_SEVERITY_LABELS[f.severity] || f.severity})${f.reason ? `: ${f.reason}` : ''}
+        </div>
+    `).join('');
+}
+
+// Mostrar las recomendaciones estructuradas de un análisis
+function renderRecommendations(recs) {
+    if (!recs) return '';
+    const actions = recs.actions || [];
+    const recovery = recs.recovery || [];
+    if (actions.length === 0 && recovery.length === 0 && (recs.flags || []).length === 0) return '';
+    
+    return `
+        <div class="recommendations">
+            ${renderRiskFlags(recs.flags)}
+            ${actions.length > 0 ? `
+                <h4>🎯 Próximas horas</h4>
+                <ul>${actions.map(a => `<li><strong>${a.within_hours} h:</strong> ${a.action}</li>`).join('')}</ul>
+            ` : ''}
+            ${recovery.length > 0 ? `
+                <h4>🛌 Recuperación</h4>
+                <ul>${recovery.map(tip => `<li>${tip}</li>`).join('')}</ul>
+            ` : ''}
+        </div>
+    `;
+}
+
+// Cargar los consejos vigentes (acciones, recuperación y alertas de los últimos análisis)
+async function loadAdvice() {
+    const card = document.getElementById('advice-card');
+    if (!card) return;
+    
+    try {
+        const response = await fetchAPI(`${API_URL}/advice`);
+        if (!response.ok) return;
+        const feed = await response.json();
+        
+        if (feed.actions.length === 0 && feed.recovery.length === 0 && feed.flags.length === 0) {
+            card.style.display = 'none';
+            return;
+        }
+        
+        document.getElementById('advice-content').innerHTML = `
+            ${renderRiskFlags(feed.flags)}
+            ${feed.actions.length > 0 ? `
+                <ul>${feed.actions.map(a => `<li>${a.action} <span class="help-text">(hasta ${new Date(a.valid_until).toLocaleString('es-ES', { weekday: 'short', hour: '2-digit', minute: '2-digit' })})</span></li>`).join('')}</ul>
+            ` : ''}
+            ${feed.recovery.length > 0 ? `
+                <h4>🛌 Recuperación</h4>
+                <ul>${feed.recovery.map(tip => `<li>${tip}</li>`).join('')}</ul>
+            ` : ''}
+        `;
+        card.style.display = 'block';
+    } catch (error) {
+        console.error('Error cargando consejos:', error);
+    }
+}
+
 // Actualizar estadísticas del dashboard
 function updateDashboardStats() {
     const totalWorkouts = allWorkouts.length;
@@ -739,6 +835,8 @@ async function handleWorkoutSubmit(e) {
         
         if (response.ok) {
             const result = await response.json();
+            formAnalysisId = result.analysis_id || null;
+            loadAdvice();
             const resultDiv = document.getElementById('workout-result');
             
             // Mostrar análisis con markdown y opción de guardar
@@ -746,6 +844,7 @@ async function handleWorkoutSubmit(e) {
                 <div class="analysis-section">
                     <h3>📊 Análisis del Entreno</h3>
                     <div class="markdown-content">${marked.parse(result.analysis)}</div>
+                    ${renderRecommendations(result.recommendations)}
                     
                     <div style="margin-top: 20px; padding: 15px; background: var(--bg-color); border-radius: 8px;">
                         <h4>✅ ¿Guardar este entreno en el historial?</h4>
@@ -846,7 +945,7 @@ async function askAboutFormAnalysis() {
         // Enviar pregunta al backend
         const response = await fetchAPI(`${API_URL}/workout-analysis-form`, {
             method: 'POST',
-            body: JSON.stringify({ question: question })
+            body: JSON.stringify({ question: question, analysis_id: formAnalysisId })
         });
         
         if (response.ok) {
@@ -882,12 +981,14 @@ async function analyzeWorkout(workoutId) {
         
         if (response.ok) {
             const result = await response.json();
+            loadAdvice();
             
             // Crear contenedor con análisis renderizado en markdown y chat
             analysisDiv.innerHTML = `
                 <div class="analysis-section">
                     <h4>📊 Análisis con IA</h4>
                     <div class="markdown-content">${marked.parse(result.analysis)}</div>
+                    ${renderRecommendations(result.recommendations)}
                     
                     <!-- Chat de conversación sobre el análisis -->
                     <div class="chat-container" style="margin-top: 20px;">
@@ -1347,14 +1448,17 @@ async function checkStravaStatus() {
     }
 }
 
-// Conectar con Strava
-function connectStrava() {
-    const token = localStorage.getItem('auth_token');
-    if (!token) {
+// Conectar con Strava: se pide un enlace firmado de corta duración a la autorización
+async function connectStrava() {
+    try {
+        const response = await fetchAPI(`${API_URL}/strava/auth-url`);
+        if (!response.ok) throw new Error(await response.text());
+        const data = await response.json();
+        window.location.href = new URL(data.url, API_URL).href;
+    } catch (error) {
+        console.error('Error conectando con Strava:', error);
         showToast('Debes iniciar sesión primero', 'error');
-        return;
     }
-    window.location.href = `${API_URL}/strava/auth?token=${encodeURIComponent(token)}`;
 }
 
 // Sincronizar entrenos de Strava
@@ -1,23 +1,36 @@
 // API Base URL
-const API_URL = window.location.hostname === 'localhost' || window.location.hostname === '127.0.0.1'
+// (en local, si el frontend no lo sirve el backend, debe estar en ALLOWED_ORIGINS para las cookies)
+const API_URL = (window.location.hostname === 'localhost' || window.location.hostname === '127.0.0.1') && window.location.port !== '8080'
     ? 'http://localhost:8080/api'
     : `${window.location.origin}/api`;
 
 // Cambio entre formularios
 document.getElementById('show-register')?.addEventListener('click', (e) => {
     e.preventDefault();
-    document.getElementById('login-form').classList.remove('active');
-    document.getElementById('register-form').classList.add('active');
-    hideError();
+    showForm('register-form');
 });
 
 document.getElementById('show-login')?.addEventListener('click', (e) => {
     e.preventDefault();
-    document.getElementById('register-form').classList.remove('active');
-    document.getElementById('login-form').classList.add('active');
-    hideError();
+    showForm('login-form');
+});
+
+document.getElementById('show-forgot')?.addEventListener('click', (e) => {
+    e.preventDefault();
+    showForm('forgot-form');
 });
 
+document.querySelectorAll('.back-to-login').forEach((link) => link.addEventListener('click', (e) => {
+    e.preventDefault();
+    showForm('login-form');
+}));
+
+// Mostrar solo uno de los formularios
+function showForm(id) {
+    document.querySelectorAll('.auth-form').forEach((form) => form.classList.toggle('active', form.id === id));
+    hideError();
+}
+
 // Manejo de login
 document.getElementById('loginForm')?.addEventListener('submit', async (e) => {
     e.preventDefault();
@@ -30,20 +43,25 @@ document.getElementById('loginForm')?.addEventListener('submit', async (e) => {
         const response = await fetch(`${API_URL}/auth/login`, {
             method: 'POST',
             headers: {
-                'Content-Type': 'application/json'
+                'Content-Type': 'application/json',
+                'X-Auth-Mode': 'cookie'
             },
+            credentials: 'include',
             body: JSON.stringify({ email, password })
         });
 
         if (response.ok) {
             const data = await response.json();
-            
-            // Guardar token y usuario en localStorage
-            localStorage.setItem('auth_token', data.token);
-            localStorage.setItem('user', JSON.stringify(data.user));
-            
-            // Redirigir al dashboard
-            window.location.href = '/';
+
+            // Cuenta con 2FA: falta el código
+            if (data.mfa_required) {
+                mfaToken = data.mfa_token;
+                showForm('mfa-form');
+                document.getElementById('mfa-code').focus();
+                return;
+            }
+
+            startSession(data);
         } else {
             const error = await response.text();
             showError(error || 'Error al iniciar sesión');
@@ -54,6 +72,124 @@ document.getElementById('loginForm')?.addEventListener('submit', async (e) => {
     }
 });
 
+// Segundo paso del login con 2FA: el token parcial del login y el código
+let mfaToken = null;
+document.getElementById('mfaForm')?.addEventListener('submit', async (e) => {
+    e.preventDefault();
+    hideError();
+
+    const code = document.getElementById('mfa-code').value;
+
+    try {
+        const response = await fetch(`${API_URL}/auth/2fa/verify`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'X-Auth-Mode': 'cookie'
+            },
+            credentials: 'include',
+            body: JSON.stringify({ mfa_token: mfaToken, code })
+        });
+
+        if (response.ok) {
+            startSession(await response.json());
+        } else {
+            showError(await response.text() || 'Código incorrecto');
+        }
+    } catch (error) {
+        console.error('Error en verificación 2FA:', error);
+        showError('Error de conexión. Por favor, intenta de nuevo.');
+    }
+});
+
+// Botones de inicio de sesión con los proveedores configurados (Strava, OIDC)
+async function loadOAuthProviders() {
+    try {
+        const response = await fetch(`${API_URL}/auth/oauth/providers`);
+        if (!response.ok) return;
+        const { providers } = await response.json();
+        const container = document.getElementById('oauth-providers');
+        providers.forEach((provider) => {
+            const link = document.createElement('a');
+            link.href = `${API_URL}/auth/oauth/${provider.id}/login`;
+            link.className = `btn-oauth ${provider.id}`;
+            link.textContent = `Entrar con ${provider.name}`;
+            container.appendChild(link);
+        });
+        container.style.display = providers.length ? 'block' : 'none';
+    } catch (error) {
+        console.error('Error cargando proveedores:', error);
+    }
+}
+
+// Vuelta del proveedor: canjea el código de un solo uso por la sesión (o el reto del 2FA)
+async function exchangeOAuthCode(code) {
+    try {
+        const response = await fetch(`${API_URL}/auth/oauth/exchange`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'X-Auth-Mode': 'cookie'
+            },
+            credentials: 'include',
+            body: JSON.stringify({ code })
+        });
+
+        if (response.ok) {
+            const data = await response.json();
+            if (data.mfa_required) {
+                mfaToken = data.mfa_token;
+                showForm('mfa-form');
+                document.getElementById('mfa-code').focus();
+                return;
+            }
+            startSession(data);
+        } else {
+            showError(await response.text() || 'Error al iniciar sesión');
+        }
+    } catch (error) {
+        console.error('Error en login con proveedor:', error);
+        showError('Error de conexión. Por favor, intenta de nuevo.');
+    }
+}
+
+// Alta con un proveedor que no da el email: el token de la vuelta del proveedor y el email
+let oauthSignupToken = null;
+document.getElementById('oauthSignupForm')?.addEventListener('submit', async (e) => {
+    e.preventDefault();
+    hideError();
+
+    const email = document.getElementById('oauth-signup-email').value;
+
+    try {
+        const response = await fetch(`${API_URL}/auth/oauth/signup`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'X-Auth-Mode': 'cookie'
+            },
+            credentials: 'include',
+            body: JSON.stringify({ token: oauthSignupToken, email })
+        });
+
+        if (response.ok) {
+            startSession(await response.json());
+        } else {
+            showError(await response.text() || 'Error al registrarse');
+        }
+    } catch (error) {
+        console.error('Error en registro con proveedor:', error);
+        showError('Error de conexión. Por favor, intenta de nuevo.');
+    }
+});
+
+// La sesión queda en cookies httpOnly; se guardan el token anti-CSRF y el usuario y se va al dashboard
+function startSession(data) {
+    localStorage.setItem('csrf_token', data.csrf_token);
+    localStorage.setItem('user', JSON.stringify(data.user));
+    window.location.href = '/';
+}
+
 // Manejo de registro
 document.getElementById('registerForm')?.addEventListener('submit', async (e) => {
     e.preventDefault();
@@ -72,20 +208,15 @@ document.getElementById('registerForm')?.addEventListener('submit', async (e) =>
         const response = await fetch(`${API_URL}/auth/register`, {
             method: 'POST',
             headers: {
-                'Content-Type': 'application/json'
+                'Content-Type': 'application/json',
+                'X-Auth-Mode': 'cookie'
             },
+            credentials: 'include',
             body: JSON.stringify({ name, email, password })
         });
 
         if (response.ok) {
-            const data = await response.json();
-            
-            // Guardar token y usuario en localStorage
-            localStorage.setItem('auth_token', data.token);
-            localStorage.setItem('user', JSON.stringify(data.user));
-            
-            // Redirigir al dashboard
-            window.location.href = '/';
+            startSession(await response.json());
         } else {
             const error = await response.text();
             showError(error || 'Error al registrarse');
@@ -96,7 +227,117 @@ document.getElementById('registerForm')?.addEventListener('submit', async (e) =>
     }
 });
 
+// Recuperar contraseña: el servidor responde igual exista o no la cuenta
+document.getElementById('forgotForm')?.addEventListener('submit', async (e) => {
+    e.preventDefault();
+    hideError();
+
+    const email = document.getElementById('forgot-email').value;
+
+    try {
+        const response = await fetch(`${API_URL}/auth/password/forgot`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ email })
+        });
+        if (response.ok) {
+            const data = await response.json();
+            showForm('login-form');
+            showInfo(data.message);
+        } else {
+            showError(await response.text() || 'Error al enviar el enlace');
+        }
+    } catch (error) {
+        console.error('Error recuperando contraseña:', error);
+        showError('Error de conexión. Por favor, intenta de nuevo.');
+    }
+});
+
+// Nueva contraseña con el token del enlace del email
+let resetToken = null;
+document.getElementById('resetForm')?.addEventListener('submit', async (e) => {
+    e.preventDefault();
+    hideError();
+
+    const password = document.getElementById('reset-password').value;
+
+    try {
+        const response = await fetch(`${API_URL}/auth/password/reset`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ token: resetToken, password })
+        });
+        if (response.ok) {
+            showForm('login-form');
+            showInfo('Contraseña cambiada. Ya puedes iniciar sesión.');
+        } else {
+            showError(await response.text() || 'Error al cambiar la contraseña');
+        }
+    } catch (error) {
+        console.error('Error cambiando contraseña:', error);
+        showError('Error de conexión. Por favor, intenta de nuevo.');
+    }
+});
+
+// Enlaces de los emails: #verify=<token> confirma el email y #reset=<token> abre el
+// formulario de nueva contraseña. La vuelta de un proveedor llega con #oauth=<código>,
+// #oauth_signup=<token> (falta el email) u #oauth_error=<mensaje>. El token se quita de la
+// barra de direcciones.
+async function handleEmailLink() {
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    if (!['verify', 'reset', 'oauth', 'oauth_signup', 'oauth_error'].some((key) => params.has(key))) return false;
+    history.replaceState(null, '', window.location.pathname);
+
+    if (params.has('oauth_error')) {
+        showError(params.get('oauth_error'));
+        return true;
+    }
+    if (params.has('oauth')) {
+        await exchangeOAuthCode(params.get('oauth'));
+        return true;
+    }
+    if (params.has('oauth_signup')) {
+        oauthSignupToken = params.get('oauth_signup');
+        showForm('oauth-signup-form');
+        document.getElementById('oauth-signup-email').focus();
+        return true;
+    }
+
+    if (params.has('reset')) {
+        resetToken = params.get('reset');
+        showForm('reset-form');
+        return true;
+    }
+
+    try {
+        const response = await fetch(`${API_URL}/auth/verify-email`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ token: params.get('verify') })
+        });
+        if (response.ok) {
+            const user = await response.json();
+            if (localStorage.getItem('user')) {
+                localStorage.setItem('user', JSON.stringify(user));
+            }
+            showInfo('Email confirmado.');
+        } else {
+            showError(await response.text() || 'El enlace no es válido');
+        }
+    } catch (error) {
+        console.error('Error verificando email:', error);
+        showError('Error de conexión. Por favor, intenta de nuevo.');
+    }
+    return true;
+}
+
 // Funciones auxiliares
+function showInfo(message) {
+    const infoDiv = document.getElementById('auth-info');
+    infoDiv.textContent = message;
+    infoDiv.style.display = 'block';
+}
+
 function showError(message) {
     const errorDiv = document.getElementById('auth-error');
     errorDiv.textContent = message;
@@ -106,15 +347,18 @@ function showError(message) {
 function hideError() {
     const errorDiv = document.getElementById('auth-error');
     errorDiv.style.display = 'none';
+    document.getElementById('auth-info').style.display = 'none';
 }
 
 // Verificar si ya hay sesión activa
 function checkExistingSession() {
-    const token = localStorage.getItem('auth_token');
-    if (token) {
+    if (localStorage.getItem('user')) {
         // Redirigir al dashboard si ya hay sesión
         window.location.href = '/';
     }
 }
 
-checkExistingSession();
+loadOAuthProviders();
+handleEmailLink().then((handled) => {
+    if (!handled) checkExistingSession();
+});
@@ -1,5 +1,8 @@
 // Workout Detail Page Logic
-const API_URL = 'http://localhost:8080/api';
+// (en local, si el frontend no lo sirve el backend, debe estar en ALLOWED_ORIGINS para las cookies)
+const API_URL = (window.location.hostname === 'localhost' || window.location.hostname === '127.0.0.1') && window.location.port !== '8080'
+    ? 'http://localhost:8080/api'
+    : `${window.location.origin}/api`;
 
 // Get workout ID from URL
 function getWorkoutId() {
@@ -333,6 +336,84 @@ function renderSplitsTable(splits) {
     }).join('');
 }
 
+// Render detected intervals (work/recovery reps)
+function renderIntervals(intervals) {
+    if (!intervals || intervals.length === 0) {
+        document.getElementById('intervals-card').style.display = 'none';
+        return;
+    }
+    
+    document.getElementById('intervals-card').style.display = 'block';
+    
+    const kindLabels = {
+        warmup: 'Calentamiento',
+        recovery: 'Recuperación',
+        cooldown: 'Vuelta a la calma'
+    };
+    
+    let workIndex = 0;
+    document.getElementById('intervals-body').innerHTML = intervals.map(rep => {
+        const isWork = rep.kind === 'work';
+        const label = isWork ? `Serie ${++workIndex}` : (kindLabels[rep.kind] || rep.kind);
+        
+        return `
+            <tr class="${isWork ? 'split-fastest' : ''}">
+                <td>${label}</td>
+                <td>${Math.round(rep.distance)} m</td>
+                <td>${formatDuration(rep.duration)}</td>
+                <td>${rep.avg_pace ? rep.avg_pace + '/km' : '-'}</td>
+                <td>${rep.avg_heart_rate || '-'}</td>
+            </tr>
+        `;
+    }).join('');
+}
+
+// Render coach analysis: latest version with its follow-up questions, plus previous versions
+function renderAnalysis(analysis, history) {
+    if (!analysis) {
+        document.getElementById('analysis-card').style.display = 'none';
+        return;
+    }
+    
+    document.getElementById('analysis-card').style.display = 'block';
+    
+    const followUps = (analysis.follow_ups || []).map(f => `
+        <div class="analysis-follow-up">
+            <p><strong>❓ ${f.question}</strong></p>
+            <div class="markdown-content">${marked.parse(f.analysis)}</div>
+        </div>
+    `).join('');
+    
+    const recs = analysis.recommendations;
+    const flags = recs ? recs.flags.map(f => `<div class="risk-flag risk-${f.severity}">⚠️ ${f.reason || f.type}</div>`).join('') : '';
+    const actions = recs && recs.actions.length > 0
+        ? `<ul>${recs.actions.map(a => `<li><strong>${a.within_hours} h:</strong> ${a.action}</li>`).join('')}</ul>`
+        : '';
+    
+    document.getElementById('analysis-latest').innerHTML = `
+        <p class="analysis-meta">Versión ${analysis.version} · ${formatDate(analysis.created_at)}${analysis.model ? ` · ${analysis.model}` : ''}</p>
+        ${flags}
+        ${actions}
+        <div class="markdown-content">${marked.parse(analysis.analysis)}</div>
+        ${followUps}
+    `;
+    
+    if (!history || history.length === 0) {
+        document.getElementById('analysis-history').innerHTML = '';
+        return;
+    }
+    
+    document.getElementById('analysis-history').innerHTML = `
+        <h3>Versiones anteriores</h3>
+        ${history.map(a => `
+            <details class="analysis-version">
+                <summary>Versión ${a.version} · ${formatDate(a.created_at)}</summary>
+                <div class="markdown-content">${marked.parse(a.analysis)}</div>
+            </details>
+        `).join('')}
+    `;
+}
+
 // Render segments
 function renderSegments(segments) {
     if (!segments || segments.length === 0) {
@@ -401,15 +482,29 @@ function renderAchievements(workout) {
     ).join('');
 }
 
+// Renovar el token de acceso caducado con el token de refresco (en cookie)
+async function refreshSession() {
+    const response = await fetch(`${API_URL}/auth/refresh`, {
+        method: 'POST',
+        headers: { 'X-CSRF-Token': localStorage.getItem('csrf_token') || '' },
+        credentials: 'include'
+    });
+    if (!response.ok) return false;
+    const data = await response.json();
+    localStorage.setItem('csrf_token', data.csrf_token);
+    return true;
+}
+
 // Fetch workout detail
 async function fetchWorkoutDetail(workoutId) {
     try {
-        const token = localStorage.getItem('auth_token');
-        const response = await fetch(`${API_URL}/workouts/${workoutId}/detail`, {
-            headers: {
-                'Authorization': `Bearer ${token}`
-            }
+        const request = () => fetch(`${API_URL}/workouts/${workoutId}/detail`, {
+            credentials: 'include'
         });
+        let response = await request();
+        if (response.status === 401 && await refreshSession()) {
+            response = await request();
+        }
         
         if (!response.ok) {
             throw new Error('Error al cargar el detalle del entreno');
@@ -463,6 +558,9 @@ async function init() {
             renderHRChart(workout.splits_metric);
         }
         
+        renderIntervals(workout.intervals);
+        renderAnalysis(workout.analysis, workout.analysis_history);
+        
         if (workout.segment_efforts) {
             renderSegments(workout.segment_efforts);
         }